@@ -45,6 +45,15 @@ to assist developers in writing, debugging, and understanding code directly from
 
   # Run a single non-interactive prompt with JSON output format
   opencode -p "Explain the use of context in Go" -f json
+
+  # Run a single non-interactive prompt that stops after spending $0.50
+  opencode -p "Fix the failing tests" --max-cost 0.5
+
+  # Propose a plan without changing anything
+  opencode -p "Add a --verbose flag to the CLI" --plan
+
+  # Run a prompt with a user-defined agent
+  opencode -p "Review the last commit" --agent reviewer
   `,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// If the help flag is set, show the help message
@@ -63,6 +72,11 @@ to assist developers in writing, debugging, and understanding code directly from
 		prompt, _ := cmd.Flags().GetString("prompt")
 		outputFormat, _ := cmd.Flags().GetString("output-format")
 		quiet, _ := cmd.Flags().GetBool("quiet")
+		maxCost, _ := cmd.Flags().GetFloat64("max-cost")
+		maxTokens, _ := cmd.Flags().GetInt64("max-tokens")
+		maxIterations, _ := cmd.Flags().GetInt("max-iterations")
+		planMode, _ := cmd.Flags().GetBool("plan")
+		agentName, _ := cmd.Flags().GetString("agent")
 
 		// Validate format option
 		if !format.IsValid(outputFormat) {
@@ -108,8 +122,25 @@ to assist developers in writing, debugging, and understanding code directly from
 		// Initialize MCP tools early for both modes
 		initMCPTools(ctx, app)
 
+		if agentName != "" {
+			if err := app.SwitchAgent(config.AgentName(agentName)); err != nil {
+				return err
+			}
+		}
+		if planMode {
+			if err := app.CoderAgent.SetPlanMode(true); err != nil {
+				return err
+			}
+		}
+
 		// Non-interactive mode
 		if prompt != "" {
+			// Budget flags take precedence over the configured limits
+			config.OverrideBudget(config.Budget{
+				MaxCost:       maxCost,
+				MaxTokens:     maxTokens,
+				MaxIterations: maxIterations,
+			})
 			// Run non-interactive flow using the App method
 			return app.RunNonInteractive(ctx, prompt, outputFormat, quiet)
 		}
@@ -255,6 +286,7 @@ func setupSubscriptions(app *app.App, parentCtx context.Context) (chan tea.Msg,
 	setupSubscriber(ctx, &wg, "logging", logging.Subscribe, ch)
 	setupSubscriber(ctx, &wg, "sessions", app.Sessions.Subscribe, ch)
 	setupSubscriber(ctx, &wg, "messages", app.Messages.Subscribe, ch)
+	setupSubscriber(ctx, &wg, "messageDeltas", app.Messages.SubscribeDeltas, ch)
 	setupSubscriber(ctx, &wg, "permissions", app.Permissions.Subscribe, ch)
 	setupSubscriber(ctx, &wg, "coderAgent", app.CoderAgent.Subscribe, ch)
 
@@ -302,6 +334,14 @@ func init() {
 	// Add quiet flag to hide spinner in non-interactive mode
 	rootCmd.Flags().BoolP("quiet", "q", false, "Hide spinner in non-interactive mode")
 
+	// Add budget flags overriding the configured limits in non-interactive mode
+	rootCmd.Flags().Float64("max-cost", 0, "Maximum cost in USD of a non-interactive run")
+	rootCmd.Flags().Int64("max-tokens", 0, "Maximum number of tokens used by a non-interactive run")
+	rootCmd.Flags().Int("max-iterations", 0, "Maximum number of model calls in a non-interactive run")
+
+	rootCmd.Flags().Bool("plan", false, "Start in plan mode, where the agent proposes a plan without changing anything")
+	rootCmd.Flags().String("agent", "", "Start with a user-defined agent instead of the coder agent")
+
 	// Register custom validation for the format flag
 	rootCmd.RegisterFlagCompletionFunc("output-format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 		return format.SupportedFormats, cobra.ShellCompDirectiveNoFileComp
@@ -77,6 +77,128 @@ func generateSchema() map[string]any {
 		"default":     false,
 	}
 
+	schema["properties"].(map[string]any)["toolConcurrency"] = map[string]any{
+		"type":        "integer",
+		"description": "Maximum number of read-only tool calls from one assistant message that run in parallel",
+		"default":     4,
+		"minimum":     1,
+	}
+
+	schema["properties"].(map[string]any)["autoCompact"] = map[string]any{
+		"type":        "boolean",
+		"description": "Summarize the conversation automatically when it approaches the context window of the model",
+		"default":     true,
+	}
+
+	schema["properties"].(map[string]any)["autoCompactThreshold"] = map[string]any{
+		"type":             "number",
+		"description":      "Fraction of the context window at which the conversation is summarized",
+		"default":          0.95,
+		"exclusiveMinimum": 0,
+		"maximum":          1,
+	}
+
+	schema["properties"].(map[string]any)["autoCompactKeepTurns"] = map[string]any{
+		"type":        "integer",
+		"description": "Number of most recent turns kept as they are when the conversation is summarized",
+		"default":     2,
+		"minimum":     0,
+	}
+
+	schema["properties"].(map[string]any)["emulateTools"] = map[string]any{
+		"type":        "array",
+		"description": "Models without native function calling, whose tool calls are emulated",
+		"items": map[string]any{
+			"type": "string",
+		},
+	}
+
+	schema["properties"].(map[string]any)["cassette"] = map[string]any{
+		"type":        "object",
+		"description": "Record the HTTP traffic of the providers into a cassette file, or replay it from one",
+		"properties": map[string]any{
+			"mode": map[string]any{
+				"type":        "string",
+				"description": "Whether to record the traffic or to replay it instead of using the network",
+				"enum":        []string{"record", "replay"},
+			},
+			"path": map[string]any{
+				"type":        "string",
+				"description": "Cassette file, relative to the working directory",
+			},
+		},
+		"required": []string{"mode", "path"},
+	}
+
+	toolResultRuleSchema := func(description string) map[string]any {
+		return map[string]any{
+			"type":        "object",
+			"description": description,
+			"properties": map[string]any{
+				"maxAge": map[string]any{
+					"type":        "integer",
+					"description": "Number of turns after which the output is elided (0 for no limit)",
+					"minimum":     0,
+				},
+				"maxLength": map[string]any{
+					"type":        "integer",
+					"description": "Length in characters above which the output of an earlier turn is elided (0 for no limit)",
+					"minimum":     0,
+				},
+			},
+		}
+	}
+
+	schema["properties"].(map[string]any)["toolResultPruning"] = map[string]any{
+		"type":        "object",
+		"description": "Elide the outputs of earlier tool calls from the conversation sent to the model",
+		"properties": map[string]any{
+			"enabled": map[string]any{
+				"type":        "boolean",
+				"description": "Enable eliding tool outputs",
+				"default":     true,
+			},
+			"default": toolResultRuleSchema("Rule applied to tools without their own rule"),
+			"tools": map[string]any{
+				"type":                 "object",
+				"description":          "Rules by tool name",
+				"additionalProperties": toolResultRuleSchema("Rule applied to the outputs of the tool"),
+			},
+		},
+	}
+
+	budgetSchema := func(scope string) map[string]any {
+		return map[string]any{
+			"type":        "object",
+			"description": fmt.Sprintf("Limits applied to %s", scope),
+			"properties": map[string]any{
+				"maxCost": map[string]any{
+					"type":        "number",
+					"description": "Maximum cost in USD, 0 for no limit",
+					"minimum":     0,
+				},
+				"maxTokens": map[string]any{
+					"type":        "integer",
+					"description": "Maximum number of input and output tokens, 0 for no limit",
+					"minimum":     0,
+				},
+				"maxIterations": map[string]any{
+					"type":        "integer",
+					"description": "Maximum number of model calls, 0 for no limit",
+					"minimum":     0,
+				},
+			},
+		}
+	}
+	schema["properties"].(map[string]any)["budgets"] = map[string]any{
+		"type":        "object",
+		"description": "Spending limits that stop the agent when they are reached",
+		"properties": map[string]any{
+			"session": budgetSchema("a whole session"),
+			"run":     budgetSchema("the work done in answer to a single prompt"),
+		},
+	}
+
 	schema["properties"].(map[string]any)["contextPaths"] = map[string]any{
 		"type":        "array",
 		"description": "Context paths for the application",
@@ -164,6 +286,13 @@ func generateSchema() map[string]any {
 						"type": "string",
 					},
 				},
+				"readOnlyTools": map[string]any{
+					"type":        "array",
+					"description": "Tools of the server that do not change anything, which are available in plan mode",
+					"items": map[string]any{
+						"type": "string",
+					},
+				},
 			},
 			"required": []string{"command"},
 		},
@@ -186,6 +315,152 @@ func generateSchema() map[string]any {
 					"description": "Whether the provider is disabled",
 					"default":     false,
 				},
+				"retry": map[string]any{
+					"type":        "object",
+					"description": "How failed calls to the provider are retried",
+					"properties": map[string]any{
+						"maxAttempts": map[string]any{
+							"type":        "integer",
+							"description": "How many times a call is tried at most, the first one included",
+							"minimum":     1,
+							"default":     8,
+						},
+						"baseBackoffMs": map[string]any{
+							"type":        "integer",
+							"description": "Wait before the first retry in milliseconds, doubled before each next one",
+							"minimum":     1,
+							"default":     2000,
+						},
+						"maxBackoffMs": map[string]any{
+							"type":        "integer",
+							"description": "Longest wait between retries in milliseconds",
+							"minimum":     1,
+							"default":     60000,
+						},
+						"jitter": map[string]any{
+							"type":        "number",
+							"description": "Fraction of the wait that is added at random",
+							"minimum":     0,
+							"default":     0.2,
+						},
+						"ignoreRetryAfter": map[string]any{
+							"type":        "boolean",
+							"description": "Whether the wait asked for by the provider's Retry-After header is ignored",
+							"default":     false,
+						},
+						"statusCodes": map[string]any{
+							"type":        "array",
+							"description": "HTTP status codes of the calls that are retried",
+							"items": map[string]any{
+								"type": "integer",
+							},
+							"default": []int{429, 500, 503, 529},
+						},
+					},
+				},
+				"baseURL": map[string]any{
+					"type":        "string",
+					"description": "Base URL of the Ollama server or of a custom OpenAI-compatible provider",
+				},
+				"contextWindow": map[string]any{
+					"type":        "integer",
+					"description": "Largest context requested for the Ollama models, 32768 by default",
+					"minimum":     1,
+				},
+				"script": map[string]any{
+					"type":        "string",
+					"description": "JSON or YAML script the __mock provider answers from, relative to the working directory",
+				},
+				"headers": map[string]any{
+					"type":        "object",
+					"description": "HTTP headers sent to a custom provider",
+					"additionalProperties": map[string]any{
+						"type": "string",
+					},
+				},
+				"models": map[string]any{
+					"type":        "array",
+					"description": "Models served by a custom provider",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"id": map[string]any{
+								"type":        "string",
+								"description": "Model ID, used in the agent configurations",
+							},
+							"name": map[string]any{
+								"type":        "string",
+								"description": "Name shown in the TUI (the ID by default)",
+							},
+							"apiModel": map[string]any{
+								"type":        "string",
+								"description": "Model name sent to the API (the ID by default)",
+							},
+							"contextWindow": map[string]any{
+								"type":        "integer",
+								"description": "Context window in tokens",
+								"minimum":     1,
+							},
+							"defaultMaxTokens": map[string]any{
+								"type":        "integer",
+								"description": "Maximum tokens of a response when the agent sets none",
+								"minimum":     1,
+							},
+							"costPer1MIn": map[string]any{
+								"type":        "number",
+								"description": "Cost of one million input tokens",
+								"minimum":     0,
+							},
+							"costPer1MOut": map[string]any{
+								"type":        "number",
+								"description": "Cost of one million output tokens",
+								"minimum":     0,
+							},
+							"costPer1MInCached": map[string]any{
+								"type":        "number",
+								"description": "Cost of one million tokens written to the cache",
+								"minimum":     0,
+							},
+							"costPer1MOutCached": map[string]any{
+								"type":        "number",
+								"description": "Cost of one million tokens read from the cache",
+								"minimum":     0,
+							},
+							"canReason": map[string]any{
+								"type":        "boolean",
+								"description": "Whether the model accepts a reasoning effort",
+								"default":     false,
+							},
+							"emulateTools": map[string]any{
+								"type":        "boolean",
+								"description": "Whether the model lacks native function calling, so that its tool calls are emulated",
+								"default":     false,
+							},
+							"supportsAttachments": map[string]any{
+								"type":        "boolean",
+								"description": "Whether the model accepts images",
+								"default":     false,
+							},
+						},
+						"required": []string{"id", "contextWindow"},
+					},
+				},
+				"rateLimit": map[string]any{
+					"type":        "object",
+					"description": "Client-side limits shared by all the agents using the provider",
+					"properties": map[string]any{
+						"requestsPerMinute": map[string]any{
+							"type":        "integer",
+							"description": "Maximum requests sent per minute",
+							"minimum":     1,
+						},
+						"tokensPerMinute": map[string]any{
+							"type":        "integer",
+							"description": "Maximum tokens used per minute",
+							"minimum":     1,
+						},
+					},
+				},
 			},
 		},
 	}
@@ -200,6 +475,7 @@ func generateSchema() map[string]any {
 		string(models.ProviderBedrock),
 		string(models.ProviderAzure),
 		string(models.ProviderVertexAI),
+		string(models.ProviderOllama),
 	}
 
 	providerSchema["additionalProperties"].(map[string]any)["properties"].(map[string]any)["provider"] = map[string]any{
@@ -229,9 +505,80 @@ func generateSchema() map[string]any {
 				},
 				"reasoningEffort": map[string]any{
 					"type":        "string",
-					"description": "Reasoning effort for models that support it (OpenAI, Anthropic)",
+					"description": "Reasoning effort for models that support it, mapped to the thinking budget of Anthropic and Gemini models",
 					"enum":        []string{"low", "medium", "high"},
 				},
+				"temperature": map[string]any{
+					"type":        "number",
+					"description": "Sampling temperature, up to 1 for Anthropic models and 2 for the others",
+					"minimum":     0,
+					"maximum":     2,
+				},
+				"topP": map[string]any{
+					"type":             "number",
+					"description":      "Nucleus sampling probability mass",
+					"exclusiveMinimum": 0,
+					"maximum":          1,
+				},
+				"stop": map[string]any{
+					"type":        "array",
+					"description": "Sequences that stop the generation",
+					"items": map[string]any{
+						"type": "string",
+					},
+				},
+				"thinking": map[string]any{
+					"type":        "object",
+					"description": "Thinking of the Anthropic and Gemini models that can reason",
+					"properties": map[string]any{
+						"budgetTokens": map[string]any{
+							"type":        "integer",
+							"description": "Tokens thinking can take out of the max tokens, taking precedence over the reasoning effort (at least 1024 for Anthropic)",
+							"minimum":     1,
+						},
+						"includeThoughts": map[string]any{
+							"type":        "boolean",
+							"description": "Stream the thoughts of Gemini models",
+						},
+					},
+				},
+				"description": map[string]any{
+					"type":        "string",
+					"description": "What a user-defined agent is for, shown to the coder agent and in the TUI",
+				},
+				"prompt": map[string]any{
+					"type":        "string",
+					"description": "File with the system prompt of a user-defined agent, relative to the working directory",
+				},
+				"tools": map[string]any{
+					"type":        "array",
+					"description": "Names of the built-in and MCP tools a user-defined agent can use (all the tools of the coder agent if empty)",
+					"items": map[string]any{
+						"type": "string",
+					},
+				},
+				"permissions": map[string]any{
+					"type":        "string",
+					"description": "How the permission requests of a user-defined agent's tools are answered",
+					"enum":        []string{string(config.PermissionAsk), string(config.PermissionAllow), string(config.PermissionDeny)},
+					"default":     string(config.PermissionAsk),
+				},
+				"profiles": map[string]any{
+					"type":        "array",
+					"description": "Profiles the task agent can be launched with by the agent tool, the first one is the default",
+					"items": map[string]any{
+						"type": "string",
+						"enum": []string{string(config.ProfileReadOnly), string(config.ProfileEdit), string(config.ProfileFull)},
+					},
+					"default": []string{string(config.ProfileReadOnly)},
+				},
+				"fallbacks": map[string]any{
+					"type":        "array",
+					"description": "Models tried in order when the model fails with exhausted retries, an authentication error or a conversation that is too long",
+					"items": map[string]any{
+						"type": "string",
+					},
+				},
 			},
 			"required": []string{"model"},
 		},
@@ -243,6 +590,7 @@ func generateSchema() map[string]any {
 		modelEnum = append(modelEnum, string(modelID))
 	}
 	agentSchema["additionalProperties"].(map[string]any)["properties"].(map[string]any)["model"].(map[string]any)["enum"] = modelEnum
+	agentSchema["additionalProperties"].(map[string]any)["properties"].(map[string]any)["fallbacks"].(map[string]any)["items"].(map[string]any)["enum"] = modelEnum
 
 	// Add specific agent properties
 	agentProperties := map[string]any{}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/opencode-ai/opencode/internal/app"
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/db"
+	"github.com/spf13/cobra"
+)
+
+var undoCmd = &cobra.Command{
+	Use:   "undo",
+	Short: "Revert the files changed during an agent turn",
+	Long: `Restore every file the agent changed while answering a prompt to the content it had before.
+Files created during the turn are deleted. A summary of the changes is shown before anything is written,
+and the restored content is recorded in the file history like any other change.`,
+	Example: `
+  # Undo the last turn of the most recent session
+  opencode undo
+
+  # Undo the second turn of a specific session without asking for confirmation
+  opencode undo -s <session-id> -t 2 -y
+  `,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cwd, _ := cmd.Flags().GetString("cwd")
+		sessionID, _ := cmd.Flags().GetString("session")
+		turnNumber, _ := cmd.Flags().GetInt("turn")
+		yes, _ := cmd.Flags().GetBool("yes")
+
+		if cwd != "" {
+			if err := os.Chdir(cwd); err != nil {
+				return fmt.Errorf("failed to change directory: %v", err)
+			}
+		}
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get current working directory: %v", err)
+		}
+		if _, err := config.Load(cwd, false); err != nil {
+			return err
+		}
+
+		conn, err := db.Connect()
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		a, err := app.New(ctx, conn)
+		if err != nil {
+			return err
+		}
+		defer a.Shutdown()
+
+		if sessionID == "" {
+			sessions, err := a.Sessions.List(ctx)
+			if err != nil {
+				return err
+			}
+			if len(sessions) == 0 {
+				return fmt.Errorf("no sessions found")
+			}
+			sessionID = sessions[0].ID
+		}
+
+		turns, err := a.Turns(ctx, sessionID)
+		if err != nil {
+			return err
+		}
+		if len(turns) == 0 {
+			return fmt.Errorf("session %s has no turns", sessionID)
+		}
+		if turnNumber == 0 {
+			turnNumber = len(turns)
+		}
+		if turnNumber < 1 || turnNumber > len(turns) {
+			return fmt.Errorf("turn must be between 1 and %d", len(turns))
+		}
+		turn := turns[turnNumber-1]
+
+		reverts, err := a.PlanUndo(ctx, sessionID, turn)
+		if err != nil {
+			return err
+		}
+
+		prompt, _, _ := strings.Cut(strings.TrimSpace(turn.Prompt.Content().String()), "\n")
+		fmt.Printf("Turn %d of %d: %s\n\n", turnNumber, len(turns), prompt)
+		if len(reverts) == 0 {
+			fmt.Println("No file changes to undo.")
+			return nil
+		}
+		for _, revert := range reverts {
+			action := "restore"
+			if revert.Delete {
+				action = "delete "
+			}
+			fmt.Printf("  %s %s (+%d -%d)\n", action, revert.Path, revert.Additions, revert.Removals)
+		}
+		fmt.Println()
+		for _, revert := range reverts {
+			fmt.Println(revert.Diff)
+		}
+
+		if !yes {
+			fmt.Print("Apply these changes? [y/N] ")
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			if answer != "y" && answer != "yes" {
+				fmt.Println("Aborted.")
+				return nil
+			}
+		}
+
+		if err := a.Undo(ctx, sessionID, reverts); err != nil {
+			return err
+		}
+		fmt.Printf("Reverted %d file(s).\n", len(reverts))
+		return nil
+	},
+}
+
+func init() {
+	undoCmd.Flags().StringP("cwd", "c", "", "Current working directory")
+	undoCmd.Flags().StringP("session", "s", "", "Session to undo a turn of (defaults to the most recent session)")
+	undoCmd.Flags().IntP("turn", "t", 0, "Turn number to undo, starting at 1 (defaults to the last turn)")
+	undoCmd.Flags().BoolP("yes", "y", false, "Apply without asking for confirmation")
+
+	rootCmd.AddCommand(undoCmd)
+}
@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/db"
+	"github.com/opencode-ai/opencode/internal/llm/models"
+	"github.com/opencode-ai/opencode/internal/session"
+	"github.com/opencode-ai/opencode/internal/usage"
+	"github.com/spf13/cobra"
+)
+
+var usageFormats = []string{"text", "json", "csv"}
+
+// usageRow is a summary together with a human readable name for its key.
+type usageRow struct {
+	usage.Summary
+	Name string `json:"name,omitempty"`
+}
+
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Report token usage and cost",
+	Long: `Report the tokens and cost of the provider calls made by OpenCode, grouped by day, session,
+model, provider or agent. Every call is recorded, including the ones made by sub-agents and for
+titles and summaries, and calls of deleted sessions are still counted.`,
+	Example: `
+  # Cost per day
+  opencode usage
+
+  # Cost per model for June 2025
+  opencode usage -g model --from 2025-06-01 --to 2025-06-30
+
+  # Cost per agent as CSV
+  opencode usage -g agent -f csv > usage.csv
+  `,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cwd, _ := cmd.Flags().GetString("cwd")
+		groupBy, _ := cmd.Flags().GetString("group-by")
+		from, _ := cmd.Flags().GetString("from")
+		to, _ := cmd.Flags().GetString("to")
+		outputFormat, _ := cmd.Flags().GetString("output-format")
+
+		if !slices.Contains(usage.GroupByValues, usage.GroupBy(groupBy)) {
+			return fmt.Errorf("invalid grouping: %s (supported: %s)", groupBy, strings.Join(groupByNames(), ", "))
+		}
+		if !slices.Contains(usageFormats, outputFormat) {
+			return fmt.Errorf("invalid format: %s (supported: %s)", outputFormat, strings.Join(usageFormats, ", "))
+		}
+
+		var fromTime, toTime time.Time
+		var err error
+		if from != "" {
+			if fromTime, err = time.ParseInLocation(time.DateOnly, from, time.Local); err != nil {
+				return fmt.Errorf("invalid --from date, expected YYYY-MM-DD: %v", err)
+			}
+		}
+		if to != "" {
+			if toTime, err = time.ParseInLocation(time.DateOnly, to, time.Local); err != nil {
+				return fmt.Errorf("invalid --to date, expected YYYY-MM-DD: %v", err)
+			}
+			// The end date is included
+			toTime = toTime.AddDate(0, 0, 1)
+		}
+
+		if cwd != "" {
+			if err := os.Chdir(cwd); err != nil {
+				return fmt.Errorf("failed to change directory: %v", err)
+			}
+		}
+		cwd, err = os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get current working directory: %v", err)
+		}
+		if _, err := config.Load(cwd, false); err != nil {
+			return err
+		}
+
+		conn, err := db.Connect()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		ctx := context.Background()
+		q := db.New(conn)
+		usages, err := usage.NewService(q).List(ctx, fromTime, toTime)
+		if err != nil {
+			return err
+		}
+		summaries, err := usage.Summarize(usages, usage.GroupBy(groupBy))
+		if err != nil {
+			return err
+		}
+
+		sessions := session.NewService(q, conn)
+		rows := make([]usageRow, len(summaries))
+		for i, summary := range summaries {
+			rows[i] = usageRow{Summary: summary, Name: usageName(ctx, sessions, usage.GroupBy(groupBy), summary.Key)}
+		}
+
+		switch outputFormat {
+		case "json":
+			return writeUsageJSON(os.Stdout, rows, usage.Total(summaries))
+		case "csv":
+			return writeUsageCSV(os.Stdout, groupBy, rows)
+		default:
+			return writeUsageText(os.Stdout, groupBy, rows, usage.Total(summaries))
+		}
+	},
+}
+
+func groupByNames() []string {
+	names := make([]string, len(usage.GroupByValues))
+	for i, g := range usage.GroupByValues {
+		names[i] = string(g)
+	}
+	return names
+}
+
+// usageName returns the session title or model name for a key.
+func usageName(ctx context.Context, sessions session.Service, groupBy usage.GroupBy, key string) string {
+	switch groupBy {
+	case usage.GroupBySession:
+		sess, err := sessions.Get(ctx, key)
+		if err != nil {
+			return "(deleted)"
+		}
+		return sess.Title
+	case usage.GroupByModel:
+		if model, ok := models.SupportedModels[models.ModelID(key)]; ok {
+			return model.Name
+		}
+	}
+	return ""
+}
+
+func writeUsageText(w io.Writer, groupBy string, rows []usageRow, total usage.Summary) error {
+	if len(rows) == 0 {
+		_, err := fmt.Fprintln(w, "No usage recorded.")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tCALLS\tINPUT\tOUTPUT\tCACHE WRITE\tCACHE READ\tCOST\t\n", strings.ToUpper(groupBy))
+	writeLine := func(label string, s usage.Summary) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t$%.4f\t\n",
+			label, s.Calls, s.InputTokens, s.OutputTokens, s.CacheCreationTokens, s.CacheReadTokens, s.Cost)
+	}
+	for _, row := range rows {
+		label := row.Key
+		if row.Name != "" {
+			label = fmt.Sprintf("%s (%s)", row.Name, row.Key)
+		}
+		writeLine(label, row.Summary)
+	}
+	writeLine("Total", total)
+	return tw.Flush()
+}
+
+func writeUsageJSON(w io.Writer, rows []usageRow, total usage.Summary) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Groups []usageRow    `json:"groups"`
+		Total  usage.Summary `json:"total"`
+	}{rows, total})
+}
+
+func writeUsageCSV(w io.Writer, groupBy string, rows []usageRow) error {
+	cw := csv.NewWriter(w)
+	records := [][]string{{groupBy, "name", "calls", "input_tokens", "output_tokens", "cache_creation_tokens", "cache_read_tokens", "cost"}}
+	for _, row := range rows {
+		records = append(records, []string{
+			row.Key,
+			row.Name,
+			strconv.Itoa(row.Calls),
+			strconv.FormatInt(row.InputTokens, 10),
+			strconv.FormatInt(row.OutputTokens, 10),
+			strconv.FormatInt(row.CacheCreationTokens, 10),
+			strconv.FormatInt(row.CacheReadTokens, 10),
+			strconv.FormatFloat(row.Cost, 'f', -1, 64),
+		})
+	}
+	return cw.WriteAll(records)
+}
+
+func init() {
+	usageCmd.Flags().StringP("cwd", "c", "", "Current working directory")
+	usageCmd.Flags().StringP("group-by", "g", string(usage.GroupByDay), "Group by day, session, model, provider or agent")
+	usageCmd.Flags().String("from", "", "First day to include (YYYY-MM-DD)")
+	usageCmd.Flags().String("to", "", "Last day to include (YYYY-MM-DD)")
+	usageCmd.Flags().StringP("output-format", "f", "text", "Output format (text, json, csv)")
+
+	usageCmd.RegisterFlagCompletionFunc("group-by", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return groupByNames(), cobra.ShellCompDirectiveNoFileComp
+	})
+	usageCmd.RegisterFlagCompletionFunc("output-format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return usageFormats, cobra.ShellCompDirectiveNoFileComp
+	})
+
+	rootCmd.AddCommand(usageCmd)
+}
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/opencode-ai/opencode/internal/llm/agent"
+	"github.com/opencode-ai/opencode/internal/session"
+)
+
+// AgentSession is a session of a sub-agent launched by the agent tool.
+type AgentSession struct {
+	Session session.Session
+	// Prompt is the task the agent was launched with.
+	Prompt string
+	// Agent is the user-defined agent that was launched, empty for the
+	// default search agent.
+	Agent string
+	// Calls counts the agent tool calls that ran in the session, including
+	// the ones that continued it.
+	Calls int
+}
+
+// AgentSessions returns the sessions of the sub-agents launched from a
+// session, in the order they were launched.
+func (app *App) AgentSessions(ctx context.Context, sessionID string) ([]AgentSession, error) {
+	msgs, err := app.Messages.List(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var agentSessions []AgentSession
+	index := make(map[string]int)
+	for _, msg := range msgs {
+		for _, call := range msg.ToolCalls() {
+			if call.Name != agent.AgentToolName {
+				continue
+			}
+			var params agent.AgentParams
+			if err := json.Unmarshal([]byte(call.Input), &params); err != nil {
+				continue
+			}
+			taskSessionID := params.SessionID
+			if taskSessionID == "" {
+				taskSessionID = call.ID
+			}
+			if i, ok := index[taskSessionID]; ok {
+				agentSessions[i].Calls++
+				continue
+			}
+			taskSession, err := app.Sessions.Get(ctx, taskSessionID)
+			if err != nil || taskSession.ParentSessionID != sessionID {
+				// The call failed before the session was created
+				continue
+			}
+			prompt, _, _ := strings.Cut(strings.TrimSpace(params.Prompt), "\n")
+			index[taskSessionID] = len(agentSessions)
+			agentSessions = append(agentSessions, AgentSession{
+				Session: taskSession,
+				Prompt:  prompt,
+				Agent:   params.Agent,
+				Calls:   1,
+			})
+		}
+	}
+	return agentSessions, nil
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"maps"
+	"slices"
 	"sync"
 	"time"
 
@@ -14,12 +15,14 @@ import (
 	"github.com/opencode-ai/opencode/internal/format"
 	"github.com/opencode-ai/opencode/internal/history"
 	"github.com/opencode-ai/opencode/internal/llm/agent"
+	"github.com/opencode-ai/opencode/internal/llm/tools"
 	"github.com/opencode-ai/opencode/internal/logging"
 	"github.com/opencode-ai/opencode/internal/lsp"
 	"github.com/opencode-ai/opencode/internal/message"
 	"github.com/opencode-ai/opencode/internal/permission"
 	"github.com/opencode-ai/opencode/internal/session"
 	"github.com/opencode-ai/opencode/internal/tui/theme"
+	"github.com/opencode-ai/opencode/internal/usage"
 )
 
 type App struct {
@@ -27,6 +30,7 @@ type App struct {
 	Messages    message.Service
 	History     history.Service
 	Permissions permission.Service
+	Usage       usage.Service
 
 	CoderAgent agent.Service
 
@@ -41,15 +45,17 @@ type App struct {
 
 func New(ctx context.Context, conn *sql.DB) (*App, error) {
 	q := db.New(conn)
-	sessions := session.NewService(q)
+	sessions := session.NewService(q, conn)
 	messages := message.NewService(q)
 	files := history.NewService(q, conn)
+	usages := usage.NewService(q)
 
 	app := &App{
 		Sessions:    sessions,
 		Messages:    messages,
 		History:     files,
 		Permissions: permission.NewPermissionService(),
+		Usage:       usages,
 		LSPClients:  make(map[string]*lsp.Client),
 	}
 
@@ -64,11 +70,17 @@ func New(ctx context.Context, conn *sql.DB) (*App, error) {
 		config.AgentCoder,
 		app.Sessions,
 		app.Messages,
+		app.Usage,
 		agent.CoderAgentTools(
 			app.Permissions,
 			app.Sessions,
 			app.Messages,
 			app.History,
+			app.Usage,
+			app.LSPClients,
+		),
+		agent.PlanAgentTools(
+			app.Permissions,
 			app.LSPClients,
 		),
 	)
@@ -80,6 +92,21 @@ func New(ctx context.Context, conn *sql.DB) (*App, error) {
 	return app, nil
 }
 
+// SwitchAgent makes the coder agent act as the given agent, either the coder
+// itself or a user-defined agent.
+func (app *App) SwitchAgent(agentName config.AgentName) error {
+	var agentTools []tools.BaseTool
+	switch {
+	case agentName == config.AgentCoder:
+		agentTools = agent.CoderAgentTools(app.Permissions, app.Sessions, app.Messages, app.History, app.Usage, app.LSPClients)
+	case slices.Contains(config.CustomAgents(), agentName):
+		agentTools = agent.CustomAgentTools(agentName, app.Permissions, app.Sessions, app.Messages, app.History, app.Usage, app.LSPClients)
+	default:
+		return fmt.Errorf("unknown agent: %s", agentName)
+	}
+	return app.CoderAgent.SwitchAgent(agentName, agentTools)
+}
+
 // initTheme sets the application theme based on the configuration
 func (app *App) initTheme() {
 	cfg := config.Get()
@@ -128,6 +155,25 @@ func (a *App) RunNonInteractive(ctx context.Context, prompt string, outputFormat
 	// Automatically approve all permission requests for this non-interactive session
 	a.Permissions.AutoApproveSession(sess.ID)
 
+	// Show the progress when the agent compacts the session
+	if spinner != nil {
+		eventsCtx, cancelEvents := context.WithCancel(ctx)
+		defer cancelEvents()
+		events := a.CoderAgent.Subscribe(eventsCtx)
+		go func() {
+			for event := range events {
+				if event.Payload.Type != agent.AgentEventTypeSummarize {
+					continue
+				}
+				if event.Payload.Done {
+					spinner.SetMessage("Thinking...")
+				} else {
+					spinner.SetMessage("Compacting session: " + event.Payload.Progress)
+				}
+			}
+		}()
+	}
+
 	logging.Info("CALLING CODER AGENT RUN", "session_id", sess.ID, "prompt_length", len(prompt))
 	done, err := a.CoderAgent.Run(ctx, sess.ID, prompt)
 	if err != nil {
@@ -165,6 +211,11 @@ func (a *App) RunNonInteractive(ctx context.Context, prompt string, outputFormat
 
 // Shutdown performs a clean shutdown of the application
 func (app *App) Shutdown() {
+	// Save the messages still streaming in
+	if err := app.Messages.Flush(context.Background()); err != nil {
+		logging.Error("Failed to save messages", "error", err)
+	}
+
 	// Cancel all watcher goroutines
 	app.cancelFuncsMutex.Lock()
 	for _, cancel := range app.watcherCancelFuncs {
@@ -0,0 +1,232 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/opencode-ai/opencode/internal/diff"
+	"github.com/opencode-ai/opencode/internal/history"
+	"github.com/opencode-ai/opencode/internal/message"
+)
+
+// Turn is a user prompt together with the agent work that answered it,
+// messages interjected during that work included.
+type Turn struct {
+	Prompt message.Message
+	// Start and End bound the turn in unix seconds. End is zero for the last
+	// turn of the session. They only place the file versions that were not
+	// recorded by a message of the session.
+	Start int64
+	End   int64
+}
+
+// FileRevert describes how undoing a turn changes a single file.
+type FileRevert struct {
+	Path string
+	// Content is what the file is restored to.
+	Content string
+	// Delete is set when the turn created the file, so undo removes it.
+	Delete    bool
+	Diff      string
+	Additions int
+	Removals  int
+}
+
+// Turns returns the turns of a session, oldest first.
+func (app *App) Turns(ctx context.Context, sessionID string) ([]Turn, error) {
+	msgs, err := app.Messages.List(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var turns []Turn
+	for _, msg := range msgs {
+		if !isPrompt(msg) {
+			continue
+		}
+		if len(turns) > 0 {
+			turns[len(turns)-1].End = msg.CreatedAt
+		}
+		turns = append(turns, Turn{
+			Prompt: msg,
+			Start:  msg.CreatedAt,
+		})
+	}
+	return turns, nil
+}
+
+// isPrompt tells whether msg starts a turn.
+func isPrompt(msg message.Message) bool {
+	return msg.Role == message.User && !msg.IsInterjection()
+}
+
+// PlanUndo works out how to restore every file changed during the turn to
+// its content from before the turn. Nothing is written; the result is meant
+// to be shown to the user and then passed to Undo.
+func (app *App) PlanUndo(ctx context.Context, sessionID string, turn Turn) ([]FileRevert, error) {
+	msgs, err := app.Messages.List(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	// The messages of the turn are those from its prompt up to the next one
+	position := make(map[string]int, len(msgs))
+	first, next := -1, len(msgs)
+	for i, msg := range msgs {
+		position[msg.ID] = i
+		if msg.ID == turn.Prompt.ID {
+			first = i
+		} else if first != -1 && next == len(msgs) && isPrompt(msg) {
+			next = i
+		}
+	}
+	if first == -1 {
+		return nil, fmt.Errorf("prompt %s not found in session %s", turn.Prompt.ID, sessionID)
+	}
+	// when tells whether file was recorded before (-1), during (0) or after
+	// (1) the turn.
+	when := func(file history.File) int {
+		if i, ok := position[file.MessageID]; ok {
+			switch {
+			case i < first:
+				return -1
+			case i < next:
+				return 0
+			}
+			return 1
+		}
+		// The versions recorded outside of tool calls, or by messages that
+		// were since removed, are placed by time
+		switch {
+		case file.CreatedAt < turn.Start:
+			return -1
+		case turn.End == 0 || file.CreatedAt < turn.End:
+			return 0
+		}
+		return 1
+	}
+
+	files, err := app.History.ListBySession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(files, func(a, b history.File) int {
+		return cmp.Or(
+			cmp.Compare(a.CreatedAt, b.CreatedAt),
+			cmp.Compare(versionNumber(a.Version), versionNumber(b.Version)),
+		)
+	})
+
+	var (
+		paths  []string
+		before = make(map[string]*history.File)
+		during = make(map[string][]history.File)
+	)
+	for i, file := range files {
+		switch when(file) {
+		case -1:
+			before[file.Path] = &files[i]
+		case 0:
+			if _, ok := during[file.Path]; !ok {
+				paths = append(paths, file.Path)
+			}
+			during[file.Path] = append(during[file.Path], file)
+		}
+	}
+
+	var reverts []FileRevert
+	for _, path := range paths {
+		// Without an earlier version, the first version recorded during the
+		// turn holds the content the tool found on disk, and tells whether
+		// there was a file at all.
+		target, created := before[path], false
+		if target == nil {
+			first := during[path][0]
+			target = &first
+			created = first.Version == history.InitialVersion && first.IsNew
+		}
+
+		current, exists, err := readCurrent(path)
+		if err != nil {
+			return nil, err
+		}
+		if created && !exists {
+			continue
+		}
+		if !created && exists && current == target.Content {
+			continue
+		}
+
+		d, additions, removals := diff.GenerateDiff(current, target.Content, path)
+		reverts = append(reverts, FileRevert{
+			Path:      path,
+			Content:   target.Content,
+			Delete:    created,
+			Diff:      d,
+			Additions: additions,
+			Removals:  removals,
+		})
+	}
+	return reverts, nil
+}
+
+// Undo applies the reverts returned by PlanUndo and records the restored
+// content as new history versions, so the undo can itself be undone.
+func (app *App) Undo(ctx context.Context, sessionID string, reverts []FileRevert) error {
+	msgs, err := app.Messages.List(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	// The restored versions come after everything the session did so far
+	lastMessageID := ""
+	if len(msgs) > 0 {
+		lastMessageID = msgs[len(msgs)-1].ID
+	}
+	for _, revert := range reverts {
+		if revert.Delete {
+			if err := os.Remove(revert.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to delete %s: %w", revert.Path, err)
+			}
+		} else {
+			if err := os.MkdirAll(filepath.Dir(revert.Path), 0o755); err != nil {
+				return fmt.Errorf("failed to create directory for %s: %w", revert.Path, err)
+			}
+			if err := os.WriteFile(revert.Path, []byte(revert.Content), 0o644); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", revert.Path, err)
+			}
+		}
+		if _, err := app.History.CreateVersion(ctx, sessionID, lastMessageID, revert.Path, revert.Content); err != nil {
+			return fmt.Errorf("failed to record history for %s: %w", revert.Path, err)
+		}
+	}
+	return nil
+}
+
+func readCurrent(path string) (string, bool, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return string(content), true, nil
+}
+
+// versionNumber orders history versions recorded within the same second.
+func versionNumber(version string) int {
+	if version == history.InitialVersion {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(version, "v"))
+	if err != nil {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/db"
+	"github.com/opencode-ai/opencode/internal/history"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/opencode-ai/opencode/internal/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndo(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	dir := t.TempDir()
+	// Diffs are shown relative to the working directory
+	_, err := config.Load(dir, false)
+	require.NoError(t, err)
+
+	conn, err := db.ConnectDir(t.TempDir())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx := context.Background()
+	q := db.New(conn)
+	app := &App{
+		Sessions: session.NewService(q, conn),
+		Messages: message.NewService(q),
+		History:  history.NewService(q, conn),
+	}
+	sess, err := app.Sessions.Create(ctx, "undo")
+	require.NoError(t, err)
+
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	say := func(role message.MessageRole, parts ...message.ContentPart) message.Message {
+		msg, err := app.Messages.Create(ctx, sess.ID, message.CreateMessageParams{Role: role, Parts: parts})
+		require.NoError(t, err)
+		return msg
+	}
+	// write records a file version the way the write tool does
+	write := func(msg message.Message, path, content string) {
+		if _, err := app.History.GetByPathAndSession(ctx, path, sess.ID); err != nil {
+			old, readErr := os.ReadFile(path)
+			if os.IsNotExist(readErr) {
+				_, err = app.History.CreateNew(ctx, sess.ID, msg.ID, path)
+			} else {
+				_, err = app.History.Create(ctx, sess.ID, msg.ID, path, string(old))
+			}
+			require.NoError(t, err)
+		}
+		_, err := app.History.CreateVersion(ctx, sess.ID, msg.ID, path, content)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	// All of it happens within the same second
+	say(message.User, message.TextContent{Text: "create a"})
+	write(say(message.Assistant), a, "one")
+	say(message.User, message.TextContent{Text: "change a"})
+	write(say(message.Assistant), a, "two")
+	// The interjection is part of the second turn
+	say(message.User, message.Interjection{}, message.TextContent{Text: "and create b"})
+	write(say(message.Assistant), b, "bee")
+
+	turns, err := app.Turns(ctx, sess.ID)
+	require.NoError(t, err)
+	require.Len(t, turns, 2)
+	assert.Equal(t, "create a", turns[0].Prompt.Content().String())
+	assert.Equal(t, "change a", turns[1].Prompt.Content().String())
+
+	reverts, err := app.PlanUndo(ctx, sess.ID, turns[1])
+	require.NoError(t, err)
+	byPath := map[string]FileRevert{}
+	for _, revert := range reverts {
+		byPath[revert.Path] = revert
+	}
+	require.Len(t, byPath, 2)
+	assert.Equal(t, "one", byPath[a].Content)
+	assert.False(t, byPath[a].Delete)
+	assert.True(t, byPath[b].Delete)
+
+	require.NoError(t, app.Undo(ctx, sess.ID, reverts))
+	content, err := os.ReadFile(a)
+	require.NoError(t, err)
+	assert.Equal(t, "one", string(content))
+	assert.NoFileExists(t, b)
+
+	// Once undone, the turn has nothing left to revert
+	reverts, err = app.PlanUndo(ctx, sess.ID, turns[1])
+	require.NoError(t, err)
+	assert.Empty(t, reverts)
+	reverts, err = app.PlanUndo(ctx, sess.ID, turns[0])
+	require.NoError(t, err)
+	require.Len(t, reverts, 1)
+	assert.Equal(t, a, reverts[0].Path)
+	assert.True(t, reverts[0].Delete)
+}
+
+func TestUndoEmptyFile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	dir := t.TempDir()
+	_, err := config.Load(dir, false)
+	require.NoError(t, err)
+
+	conn, err := db.ConnectDir(t.TempDir())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx := context.Background()
+	q := db.New(conn)
+	app := &App{
+		Sessions: session.NewService(q, conn),
+		Messages: message.NewService(q),
+		History:  history.NewService(q, conn),
+	}
+	sess, err := app.Sessions.Create(ctx, "undo")
+	require.NoError(t, err)
+
+	// The file was there, empty, before the turn edited it
+	path := filepath.Join(dir, "__init__.py")
+	require.NoError(t, os.WriteFile(path, nil, 0o644))
+	_, err = app.Messages.Create(ctx, sess.ID, message.CreateMessageParams{Role: message.User, Parts: []message.ContentPart{message.TextContent{Text: "fill it"}}})
+	require.NoError(t, err)
+	msg, err := app.Messages.Create(ctx, sess.ID, message.CreateMessageParams{Role: message.Assistant})
+	require.NoError(t, err)
+	_, err = app.History.Create(ctx, sess.ID, msg.ID, path, "")
+	require.NoError(t, err)
+	_, err = app.History.CreateVersion(ctx, sess.ID, msg.ID, path, "import os\n")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte("import os\n"), 0o644))
+
+	turns, err := app.Turns(ctx, sess.ID)
+	require.NoError(t, err)
+	require.Len(t, turns, 1)
+	reverts, err := app.PlanUndo(ctx, sess.ID, turns[0])
+	require.NoError(t, err)
+	require.Len(t, reverts, 1)
+	assert.False(t, reverts[0].Delete)
+	assert.Empty(t, reverts[0].Content)
+
+	require.NoError(t, app.Undo(ctx, sess.ID, reverts))
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, content)
+}
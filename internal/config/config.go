@@ -2,13 +2,17 @@
 package config
 
 import (
+	"cmp"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/opencode-ai/opencode/internal/llm/models"
 	"github.com/opencode-ai/opencode/internal/logging"
@@ -32,6 +36,9 @@ type MCPServer struct {
 	Type    MCPType           `json:"type"`
 	URL     string            `json:"url"`
 	Headers map[string]string `json:"headers"`
+	// ReadOnlyTools lists the tools of the server that do not change anything,
+	// which are also available in plan mode.
+	ReadOnlyTools []string `json:"readOnlyTools,omitempty"`
 }
 
 type AgentName string
@@ -43,17 +50,132 @@ const (
 	AgentTitle      AgentName = "title"
 )
 
+// BuiltinAgents are the agents used by OpenCode itself. Any other agent in the
+// configuration is user-defined.
+var BuiltinAgents = []AgentName{AgentCoder, AgentSummarizer, AgentTask, AgentTitle}
+
+// IsBuiltin tells whether the agent is one used by OpenCode itself.
+func (n AgentName) IsBuiltin() bool {
+	return slices.Contains(BuiltinAgents, n)
+}
+
+// PermissionPolicy defines how the permission requests of an agent's tools
+// are answered.
+type PermissionPolicy string
+
+const (
+	// PermissionAsk asks the user, as for the coder agent.
+	PermissionAsk PermissionPolicy = "ask"
+	// PermissionAllow grants every request without asking.
+	PermissionAllow PermissionPolicy = "allow"
+	// PermissionDeny denies every request, so the agent can only use tools
+	// that need no permission.
+	PermissionDeny PermissionPolicy = "deny"
+)
+
+// AgentProfile defines the tools a task agent launched by the agent tool can
+// use.
+type AgentProfile string
+
+const (
+	// ProfileReadOnly only searches and reads the workspace.
+	ProfileReadOnly AgentProfile = "read-only"
+	// ProfileEdit can also edit files and run commands.
+	ProfileEdit AgentProfile = "edit"
+	// ProfileFull has the tools of the coder agent, except for the agent tool.
+	ProfileFull AgentProfile = "full"
+)
+
 // Agent defines configuration for different LLM models and their token limits.
+//
+// User-defined agents can also set a description, which tells when to use
+// them, a file with their system prompt, relative to the working directory,
+// the names of the tools they can use, built-in or MCP, and the policy for
+// the permission requests of those tools. Without a tool list they get the
+// tools of the coder agent.
 type Agent struct {
-	Model           models.ModelID `json:"model"`
-	MaxTokens       int64          `json:"maxTokens"`
-	ReasoningEffort string         `json:"reasoningEffort"` // For openai models low,medium,heigh
+	Model           models.ModelID   `json:"model"`
+	MaxTokens       int64            `json:"maxTokens"`
+	ReasoningEffort string           `json:"reasoningEffort"` // low, medium or high, for models that can reason
+	Temperature     *float64         `json:"temperature,omitempty"`
+	TopP            *float64         `json:"topP,omitempty"`
+	Stop            []string         `json:"stop,omitempty"`
+	Thinking        ThinkingConfig   `json:"thinking,omitzero"`
+	Description     string           `json:"description,omitempty"`
+	Prompt          string           `json:"prompt,omitempty"`
+	Tools           []string         `json:"tools,omitempty"`
+	Permissions     PermissionPolicy `json:"permissions,omitempty"`
+	Profiles        []AgentProfile   `json:"profiles,omitempty"`  // Task agent only, the first one is the default
+	Fallbacks       []models.ModelID `json:"fallbacks,omitempty"` // Tried in order when the model fails
+}
+
+// ThinkingConfig defines how much Anthropic and Gemini models that can
+// reason think before answering.
+type ThinkingConfig struct {
+	// BudgetTokens is the number of tokens thinking can take, out of the max
+	// tokens. It takes precedence over the reasoning effort.
+	BudgetTokens int64 `json:"budgetTokens,omitempty"`
+	// IncludeThoughts streams the thoughts of Gemini models.
+	IncludeThoughts bool `json:"includeThoughts,omitempty"`
 }
 
 // Provider defines configuration for an LLM provider.
 type Provider struct {
-	APIKey   string `json:"apiKey"`
-	Disabled bool   `json:"disabled"`
+	APIKey    string          `json:"apiKey"`
+	Disabled  bool            `json:"disabled"`
+	Retry     RetryConfig     `json:"retry,omitzero"`
+	RateLimit RateLimitConfig `json:"rateLimit,omitzero"`
+
+	// Custom OpenAI-compatible providers only
+	BaseURL string            `json:"baseURL,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Models  []CustomModel     `json:"models,omitempty"`
+
+	// Ollama only: the largest context requested for its models
+	ContextWindow int64 `json:"contextWindow,omitempty"`
+
+	// Mock provider only: the file of the script it answers from
+	Script string `json:"script,omitempty"`
+}
+
+// CustomModel defines a model served by a custom provider.
+type CustomModel struct {
+	ID                  models.ModelID `json:"id"`
+	Name                string         `json:"name,omitempty"`
+	APIModel            string         `json:"apiModel,omitempty"`
+	ContextWindow       int64          `json:"contextWindow"`
+	DefaultMaxTokens    int64          `json:"defaultMaxTokens,omitempty"`
+	CostPer1MIn         float64        `json:"costPer1MIn,omitempty"`
+	CostPer1MOut        float64        `json:"costPer1MOut,omitempty"`
+	CostPer1MInCached   float64        `json:"costPer1MInCached,omitempty"`
+	CostPer1MOutCached  float64        `json:"costPer1MOutCached,omitempty"`
+	CanReason           bool           `json:"canReason,omitempty"`
+	SupportsAttachments bool           `json:"supportsAttachments,omitempty"`
+	EmulateTools        bool           `json:"emulateTools,omitempty"`
+}
+
+// RetryConfig defines how failed calls to a provider are retried. Fields left
+// unset keep their default.
+type RetryConfig struct {
+	MaxAttempts      int      `json:"maxAttempts,omitempty"`
+	BaseBackoffMs    int64    `json:"baseBackoffMs,omitempty"`
+	MaxBackoffMs     int64    `json:"maxBackoffMs,omitempty"`
+	Jitter           *float64 `json:"jitter,omitempty"`
+	IgnoreRetryAfter bool     `json:"ignoreRetryAfter,omitempty"`
+	StatusCodes      []int    `json:"statusCodes,omitempty"`
+}
+
+// RateLimitConfig caps the calls made to a provider by all agents together.
+type RateLimitConfig struct {
+	RequestsPerMinute int   `json:"requestsPerMinute,omitempty"`
+	TokensPerMinute   int64 `json:"tokensPerMinute,omitempty"`
+}
+
+// CassetteConfig makes the providers record their HTTP traffic into a
+// cassette file, or replay it from one instead of the network.
+type CassetteConfig struct {
+	Mode string `json:"mode,omitempty"` // record or replay
+	Path string `json:"path,omitempty"` // Relative to the working directory
 }
 
 // Data defines storage configuration.
@@ -80,6 +202,46 @@ type ShellConfig struct {
 	Args []string `json:"args,omitempty"`
 }
 
+// Budget defines spending limits for the agent. A zero value means no limit.
+type Budget struct {
+	MaxCost       float64 `json:"maxCost,omitempty"`
+	MaxTokens     int64   `json:"maxTokens,omitempty"`
+	MaxIterations int     `json:"maxIterations,omitempty"`
+}
+
+// Budgets defines the limits applied to a whole session and to a single run
+// of the agent, i.e. everything done in answer to one prompt.
+type Budgets struct {
+	Session Budget `json:"session,omitempty"`
+	Run     Budget `json:"run,omitempty"`
+}
+
+// ToolResultRule defines when the output of a tool call is elided from the
+// conversation sent to the model. MaxAge is counted in turns and MaxLength in
+// characters; a zero value means no limit.
+type ToolResultRule struct {
+	MaxAge    int `json:"maxAge,omitempty"`
+	MaxLength int `json:"maxLength,omitempty"`
+}
+
+// ToolResultPruning defines how the outputs of earlier tool calls are elided
+// from the conversation sent to the model. The rule of a tool is looked up in
+// Tools by name, falling back to Default. Outputs of the current turn are
+// always sent as they are.
+type ToolResultPruning struct {
+	Enabled bool                      `json:"enabled"`
+	Default ToolResultRule            `json:"default,omitempty"`
+	Tools   map[string]ToolResultRule `json:"tools,omitempty"`
+}
+
+// Rule returns the rule that applies to the tool with the given name.
+func (p ToolResultPruning) Rule(toolName string) ToolResultRule {
+	if rule, ok := p.Tools[toolName]; ok {
+		return rule
+	}
+	return p.Default
+}
+
 // BehavioralFrameworkConfig defines configuration for the behavioral framework
 type BehavioralFrameworkConfig struct {
 	Enabled                bool                 `json:"enabled"`
@@ -112,19 +274,26 @@ type PerformanceTargets struct {
 
 // Config is the main configuration structure for the application.
 type Config struct {
-	Data                Data                              `json:"data"`
-	WorkingDir          string                            `json:"wd,omitempty"`
-	MCPServers          map[string]MCPServer              `json:"mcpServers,omitempty"`
-	Providers           map[models.ModelProvider]Provider `json:"providers,omitempty"`
-	LSP                 map[string]LSPConfig              `json:"lsp,omitempty"`
-	Agents              map[AgentName]Agent               `json:"agents,omitempty"`
-	BehavioralFramework BehavioralFrameworkConfig         `json:"behavioralFramework,omitempty"`
-	Debug               bool                              `json:"debug,omitempty"`
-	DebugLSP            bool                              `json:"debugLSP,omitempty"`
-	ContextPaths        []string                          `json:"contextPaths,omitempty"`
-	TUI                 TUIConfig                         `json:"tui"`
-	Shell               ShellConfig                       `json:"shell,omitempty"`
-	AutoCompact         bool                              `json:"autoCompact,omitempty"`
+	Data                 Data                              `json:"data"`
+	WorkingDir           string                            `json:"wd,omitempty"`
+	MCPServers           map[string]MCPServer              `json:"mcpServers,omitempty"`
+	Providers            map[models.ModelProvider]Provider `json:"providers,omitempty"`
+	LSP                  map[string]LSPConfig              `json:"lsp,omitempty"`
+	Agents               map[AgentName]Agent               `json:"agents,omitempty"`
+	BehavioralFramework  BehavioralFrameworkConfig         `json:"behavioralFramework,omitempty"`
+	Debug                bool                              `json:"debug,omitempty"`
+	DebugLSP             bool                              `json:"debugLSP,omitempty"`
+	ContextPaths         []string                          `json:"contextPaths,omitempty"`
+	TUI                  TUIConfig                         `json:"tui"`
+	Shell                ShellConfig                       `json:"shell,omitempty"`
+	AutoCompact          bool                              `json:"autoCompact,omitempty"`
+	AutoCompactThreshold float64                           `json:"autoCompactThreshold,omitempty"`
+	AutoCompactKeepTurns int                               `json:"autoCompactKeepTurns,omitempty"`
+	ToolConcurrency      int                               `json:"toolConcurrency,omitempty"`
+	Budgets              Budgets                           `json:"budgets,omitempty"`
+	ToolResultPruning    ToolResultPruning                 `json:"toolResultPruning,omitempty"`
+	EmulateTools         []models.ModelID                  `json:"emulateTools,omitempty"` // Models whose tool calls are emulated
+	Cassette             CassetteConfig                    `json:"cassette,omitzero"`
 }
 
 // Application constants
@@ -134,6 +303,19 @@ const (
 	appName              = "opencode"
 
 	MaxTokensFallbackDefault = 4096
+
+	defaultToolConcurrency = 4
+
+	defaultAutoCompactThreshold = 0.95
+	defaultAutoCompactKeepTurns = 2
+
+	defaultToolResultMaxLength = 10000
+
+	ollamaDiscoveryTimeout = 5 * time.Second
+	// ollamaDefaultContextWindow is the largest context requested for Ollama
+	// models unless configured otherwise, as the whole context of large
+	// models may not fit in the memory of the machine.
+	ollamaDefaultContextWindow = 32768
 )
 
 var defaultContextPaths = []string{
@@ -229,6 +411,13 @@ func Load(workingDir string, debug bool) (*Config, error) {
 		slog.SetDefault(logger)
 	}
 
+	if err := registerCustomProviders(cfg); err != nil {
+		return cfg, fmt.Errorf("config validation failed: %w", err)
+	}
+	registerOllamaModels(cfg)
+	registerMockModel(cfg)
+	applyToolEmulation(cfg)
+
 	// Validate configuration
 	if err := Validate(); err != nil {
 		return cfg, fmt.Errorf("config validation failed: %w", err)
@@ -263,6 +452,11 @@ func setDefaults(debug bool) {
 	viper.SetDefault("contextPaths", defaultContextPaths)
 	viper.SetDefault("tui.theme", "opencode")
 	viper.SetDefault("autoCompact", true)
+	viper.SetDefault("autoCompactThreshold", defaultAutoCompactThreshold)
+	viper.SetDefault("autoCompactKeepTurns", defaultAutoCompactKeepTurns)
+	viper.SetDefault("toolConcurrency", defaultToolConcurrency)
+	viper.SetDefault("toolResultPruning.enabled", true)
+	viper.SetDefault("toolResultPruning.default.maxLength", defaultToolResultMaxLength)
 
 	// Set default shell from environment or fallback to /bin/bash
 	shellPath := os.Getenv("SHELL")
@@ -502,6 +696,286 @@ func applyDefaultValues() {
 	}
 }
 
+// registerCustomProviders adds the models of the custom OpenAI-compatible
+// providers to the supported models.
+func registerCustomProviders(cfg *Config) error {
+	for name, providerCfg := range cfg.Providers {
+		if models.IsBuiltinProvider(name) {
+			if providerCfg.BaseURL != "" && name != models.ProviderOllama || len(providerCfg.Models) > 0 {
+				logging.Warn("baseURL and models are only used for custom providers, ignoring them", "provider", name)
+			}
+			continue
+		}
+		if providerCfg.BaseURL == "" {
+			return fmt.Errorf("custom provider %s has no baseURL", name)
+		}
+		// Self-hosted servers often need no key, and the OpenAI client
+		// would otherwise send the one from OPENAI_API_KEY
+		if providerCfg.APIKey == "" {
+			providerCfg.APIKey = "dummy"
+			cfg.Providers[name] = providerCfg
+		}
+		for _, m := range providerCfg.Models {
+			if m.ID == "" {
+				return fmt.Errorf("custom provider %s has a model without id", name)
+			}
+			if existing, ok := models.SupportedModels[m.ID]; ok && existing.Provider != name {
+				return fmt.Errorf("model %s of custom provider %s is already provided by %s", m.ID, name, existing.Provider)
+			}
+			if m.ContextWindow <= 0 {
+				return fmt.Errorf("model %s of custom provider %s has no contextWindow", m.ID, name)
+			}
+			models.SupportedModels[m.ID] = models.Model{
+				ID:                  m.ID,
+				Name:                cmp.Or(m.Name, string(m.ID)),
+				Provider:            name,
+				APIModel:            cmp.Or(m.APIModel, string(m.ID)),
+				CostPer1MIn:         m.CostPer1MIn,
+				CostPer1MOut:        m.CostPer1MOut,
+				CostPer1MInCached:   m.CostPer1MInCached,
+				CostPer1MOutCached:  m.CostPer1MOutCached,
+				ContextWindow:       m.ContextWindow,
+				DefaultMaxTokens:    cmp.Or(m.DefaultMaxTokens, min(MaxTokensFallbackDefault, m.ContextWindow/2)),
+				CanReason:           m.CanReason,
+				SupportsAttachments: m.SupportsAttachments,
+				ToolsUnsupported:    m.EmulateTools,
+			}
+		}
+	}
+	return nil
+}
+
+// registerOllamaModels adds the models of the Ollama server to the supported
+// models, when the server is configured or OLLAMA_HOST is set.
+func registerOllamaModels(cfg *Config) {
+	providerCfg, configured := cfg.Providers[models.ProviderOllama]
+	host := os.Getenv("OLLAMA_HOST")
+	if !configured && host == "" || providerCfg.Disabled {
+		return
+	}
+	baseURL := providerCfg.BaseURL
+	if baseURL == "" && host != "" {
+		baseURL = host
+		// OLLAMA_HOST is often a bare host and port
+		if !strings.Contains(host, "://") {
+			baseURL = "http://" + host
+		}
+	}
+	baseURL = cmp.Or(baseURL, models.OllamaDefaultURL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), ollamaDiscoveryTimeout)
+	defer cancel()
+	found, err := models.OllamaModels(ctx, baseURL)
+	if err != nil {
+		logging.Warn("Failed to list Ollama models", "url", baseURL, "error", err)
+		return
+	}
+	// Ollama allocates the context it is asked for
+	contextWindow := cmp.Or(providerCfg.ContextWindow, ollamaDefaultContextWindow)
+	for _, model := range found {
+		if model.ContextWindow == 0 || model.ContextWindow > contextWindow {
+			model.ContextWindow = contextWindow
+		}
+		models.SupportedModels[model.ID] = model
+	}
+	providerCfg.BaseURL = baseURL
+	// Ollama needs no key
+	providerCfg.APIKey = cmp.Or(providerCfg.APIKey, "ollama")
+	cfg.Providers[models.ProviderOllama] = providerCfg
+}
+
+// registerMockModel adds the model of the mock provider to the supported
+// models when the provider is configured, so agents can be pointed at a
+// script.
+func registerMockModel(cfg *Config) {
+	providerCfg, configured := cfg.Providers[models.ProviderMock]
+	if !configured || providerCfg.Disabled {
+		return
+	}
+	if providerCfg.Script == "" {
+		logging.Warn("mock provider has no script, ignoring it")
+		return
+	}
+	// Scripts are relative to the working directory
+	if !filepath.IsAbs(providerCfg.Script) {
+		providerCfg.Script = filepath.Join(cfg.WorkingDir, providerCfg.Script)
+	}
+	providerCfg.APIKey = cmp.Or(providerCfg.APIKey, "mock")
+	cfg.Providers[models.ProviderMock] = providerCfg
+	models.SupportedModels[models.MockModel] = models.Model{
+		ID:                  models.MockModel,
+		Name:                "Mock",
+		Provider:            models.ProviderMock,
+		APIModel:            "mock",
+		ContextWindow:       200_000,
+		DefaultMaxTokens:    MaxTokensFallbackDefault,
+		SupportsAttachments: true,
+	}
+}
+
+// applyToolEmulation marks the models listed in emulateTools as unable to
+// call tools natively, so that their tool calls are emulated.
+func applyToolEmulation(cfg *Config) {
+	for _, id := range cfg.EmulateTools {
+		model, ok := models.SupportedModels[id]
+		if !ok {
+			logging.Warn("Unknown model in emulateTools, ignoring it", "model", id)
+			continue
+		}
+		model.ToolsUnsupported = true
+		models.SupportedModels[id] = model
+	}
+}
+
+// defaultOllamaModel returns the Ollama model agents use when no other
+// provider is available, preferring the ones that can call tools.
+func defaultOllamaModel() (models.Model, bool) {
+	var found []models.Model
+	for _, model := range models.SupportedModels {
+		if model.Provider == models.ProviderOllama {
+			found = append(found, model)
+		}
+	}
+	if len(found) == 0 {
+		return models.Model{}, false
+	}
+	slices.SortFunc(found, func(a, b models.Model) int {
+		if a.ToolsUnsupported != b.ToolsUnsupported {
+			if a.ToolsUnsupported {
+				return 1
+			}
+			return -1
+		}
+		return strings.Compare(string(a.ID), string(b.ID))
+	})
+	return found[0], true
+}
+
+// MinThinkingBudget is the smallest thinking budget Anthropic accepts.
+const MinThinkingBudget = 1024
+
+// validateSampling checks the sampling and reasoning parameters of an agent
+// against its model, dropping or fixing those the model does not take.
+func validateSampling(cfg *Config, name AgentName) {
+	agent := cfg.Agents[name]
+	model, ok := models.SupportedModels[agent.Model]
+	if !ok {
+		return
+	}
+	provider := model.Provider
+	isAnthropic := provider == models.ProviderAnthropic || provider == models.ProviderBedrock
+	isGemini := provider == models.ProviderGemini || provider == models.ProviderVertexAI
+
+	// Validate reasoning effort for models that support reasoning
+	if !model.CanReason {
+		if agent.ReasoningEffort != "" || agent.Thinking != (ThinkingConfig{}) {
+			logging.Warn("model doesn't support reasoning but reasoning is configured, ignoring",
+				"agent", name,
+				"model", agent.Model,
+				"reasoning_effort", agent.ReasoningEffort)
+			agent.ReasoningEffort = ""
+			agent.Thinking = ThinkingConfig{}
+		}
+	} else if agent.ReasoningEffort == "" {
+		if provider == models.ProviderOpenAI || provider == models.ProviderLocal {
+			// Set default reasoning effort for models that support it
+			logging.Info("setting default reasoning effort for model that supports reasoning",
+				"agent", name,
+				"model", agent.Model)
+			agent.ReasoningEffort = "medium"
+		}
+	} else {
+		// Check if reasoning effort is valid (low, medium, high)
+		agent.ReasoningEffort = strings.ToLower(agent.ReasoningEffort)
+		if agent.ReasoningEffort != "low" && agent.ReasoningEffort != "medium" && agent.ReasoningEffort != "high" {
+			logging.Warn("invalid reasoning effort, setting to medium",
+				"agent", name,
+				"model", agent.Model,
+				"reasoning_effort", agent.ReasoningEffort)
+			agent.ReasoningEffort = "medium"
+		}
+	}
+
+	// Thinking budgets are for Anthropic and Gemini models
+	if agent.Thinking.BudgetTokens != 0 && !isAnthropic && !isGemini {
+		logging.Warn("thinking budget is only supported by Anthropic and Gemini models, ignoring",
+			"agent", name,
+			"model", agent.Model)
+		agent.Thinking.BudgetTokens = 0
+	} else if agent.Thinking.BudgetTokens < 0 {
+		logging.Warn("invalid thinking budget, ignoring",
+			"agent", name,
+			"model", agent.Model,
+			"budget_tokens", agent.Thinking.BudgetTokens)
+		agent.Thinking.BudgetTokens = 0
+	} else if agent.Thinking.BudgetTokens > 0 {
+		if isAnthropic && agent.Thinking.BudgetTokens < MinThinkingBudget {
+			logging.Warn("thinking budget is under the minimum, adjusting",
+				"agent", name,
+				"model", agent.Model,
+				"budget_tokens", agent.Thinking.BudgetTokens,
+				"minimum", MinThinkingBudget)
+			agent.Thinking.BudgetTokens = MinThinkingBudget
+		}
+		// The budget is part of the max tokens
+		if agent.Thinking.BudgetTokens >= agent.MaxTokens {
+			logging.Warn("thinking budget must be less than max tokens, ignoring",
+				"agent", name,
+				"model", agent.Model,
+				"budget_tokens", agent.Thinking.BudgetTokens,
+				"max_tokens", agent.MaxTokens)
+			agent.Thinking.BudgetTokens = 0
+		}
+	}
+	if agent.Thinking.IncludeThoughts && !isGemini {
+		logging.Warn("including thoughts is only supported by Gemini models, ignoring",
+			"agent", name,
+			"model", agent.Model)
+		agent.Thinking.IncludeThoughts = false
+	}
+
+	// Validate the sampling parameters
+	maxTemperature := 2.0
+	if isAnthropic {
+		maxTemperature = 1
+	}
+	if agent.Temperature != nil && (*agent.Temperature < 0 || *agent.Temperature > maxTemperature) {
+		logging.Warn("temperature out of range, ignoring",
+			"agent", name,
+			"model", agent.Model,
+			"temperature", *agent.Temperature,
+			"max", maxTemperature)
+		agent.Temperature = nil
+	}
+	if agent.TopP != nil && (*agent.TopP <= 0 || *agent.TopP > 1) {
+		logging.Warn("top p out of range, ignoring",
+			"agent", name,
+			"model", agent.Model,
+			"top_p", *agent.TopP)
+		agent.TopP = nil
+	}
+
+	// Thinking Anthropic models and OpenAI reasoning models take neither
+	thinks := isAnthropic && model.CanReason && (agent.ReasoningEffort != "" || agent.Thinking.BudgetTokens > 0)
+	reasons := (provider == models.ProviderOpenAI || provider == models.ProviderAzure) && model.CanReason
+	if (thinks || reasons) && (agent.Temperature != nil || agent.TopP != nil) {
+		logging.Warn("model doesn't support temperature or top p while reasoning, ignoring",
+			"agent", name,
+			"model", agent.Model)
+		agent.Temperature = nil
+		agent.TopP = nil
+	}
+	// OpenAI reasoning models do not take stop sequences either
+	if reasons && len(agent.Stop) > 0 {
+		logging.Warn("model doesn't support stop sequences while reasoning, ignoring",
+			"agent", name,
+			"model", agent.Model)
+		agent.Stop = nil
+	}
+
+	cfg.Agents[name] = agent
+}
+
 // It validates model IDs and providers, ensuring they are supported.
 func validateAgent(cfg *Config, name AgentName, agent Agent) error {
 	// Check if model exists
@@ -593,49 +1067,109 @@ func validateAgent(cfg *Config, name AgentName, agent Agent) error {
 		cfg.Agents[name] = updatedAgent
 	}
 
-	// Validate reasoning effort for models that support reasoning
-	if model.CanReason && provider == models.ProviderOpenAI || provider == models.ProviderLocal {
-		if agent.ReasoningEffort == "" {
-			// Set default reasoning effort for models that support it
-			logging.Info("setting default reasoning effort for model that supports reasoning",
-				"agent", name,
-				"model", agent.Model)
+	validateSampling(cfg, name)
 
-			// Update the agent with default reasoning effort
-			updatedAgent := cfg.Agents[name]
-			updatedAgent.ReasoningEffort = "medium"
-			cfg.Agents[name] = updatedAgent
-		} else {
-			// Check if reasoning effort is valid (low, medium, high)
-			effort := strings.ToLower(agent.ReasoningEffort)
-			if effort != "low" && effort != "medium" && effort != "high" {
-				logging.Warn("invalid reasoning effort, setting to medium",
-					"agent", name,
-					"model", agent.Model,
-					"reasoning_effort", agent.ReasoningEffort)
-
-				// Update the agent with valid reasoning effort
-				updatedAgent := cfg.Agents[name]
-				updatedAgent.ReasoningEffort = "medium"
-				cfg.Agents[name] = updatedAgent
+	return nil
+}
+
+// validateFallbacks drops the fallback models of the agent that cannot be
+// used, as well as the ones that repeat an earlier model.
+func validateFallbacks(cfg *Config, name AgentName) {
+	agent := cfg.Agents[name]
+	if len(agent.Fallbacks) == 0 {
+		return
+	}
+	seen := []models.ModelID{agent.Model}
+	var fallbacks []models.ModelID
+	for _, modelID := range agent.Fallbacks {
+		model, ok := models.SupportedModels[modelID]
+		switch {
+		case !ok:
+			logging.Warn("unsupported fallback model configured, ignoring", "agent", name, "model", modelID)
+			continue
+		case slices.Contains(seen, modelID):
+			logging.Warn("fallback model repeats an earlier model, ignoring", "agent", name, "model", modelID)
+			continue
+		}
+		providerCfg, ok := cfg.Providers[model.Provider]
+		if !ok {
+			if apiKey := getProviderAPIKey(model.Provider); apiKey != "" {
+				providerCfg = Provider{APIKey: apiKey}
+				cfg.Providers[model.Provider] = providerCfg
+				logging.Info("added provider from environment", "provider", model.Provider)
 			}
 		}
-	} else if !model.CanReason && agent.ReasoningEffort != "" {
-		// Model doesn't support reasoning but reasoning effort is set
-		logging.Warn("model doesn't support reasoning but reasoning effort is set, ignoring",
-			"agent", name,
-			"model", agent.Model,
-			"reasoning_effort", agent.ReasoningEffort)
-
-		// Update the agent to remove reasoning effort
-		updatedAgent := cfg.Agents[name]
-		updatedAgent.ReasoningEffort = ""
-		cfg.Agents[name] = updatedAgent
+		if providerCfg.Disabled || providerCfg.APIKey == "" {
+			logging.Warn("provider of fallback model is disabled or has no API key, ignoring",
+				"agent", name,
+				"model", modelID,
+				"provider", model.Provider)
+			continue
+		}
+		seen = append(seen, modelID)
+		fallbacks = append(fallbacks, modelID)
 	}
+	agent.Fallbacks = fallbacks
+	cfg.Agents[name] = agent
+}
 
+// validateCustomAgent checks the settings that only user-defined agents have.
+func validateCustomAgent(cfg *Config, name AgentName, agent Agent) error {
+	if agent.Prompt != "" {
+		if _, err := os.Stat(agentPromptPath(cfg, agent)); err != nil {
+			return fmt.Errorf("invalid prompt file for agent %s: %w", name, err)
+		}
+	}
+	switch agent.Permissions {
+	case "", PermissionAsk, PermissionAllow, PermissionDeny:
+	default:
+		return fmt.Errorf("invalid permission policy for agent %s: %s (supported: ask, allow, deny)", name, agent.Permissions)
+	}
 	return nil
 }
 
+func agentPromptPath(cfg *Config, agent Agent) string {
+	if filepath.IsAbs(agent.Prompt) {
+		return agent.Prompt
+	}
+	return filepath.Join(cfg.WorkingDir, agent.Prompt)
+}
+
+// AgentPrompt returns the content of the system prompt file of the agent, or
+// an empty string if it has none.
+func AgentPrompt(name AgentName) (string, error) {
+	agent, ok := cfg.Agents[name]
+	if !ok || agent.Prompt == "" {
+		return "", nil
+	}
+	content, err := os.ReadFile(agentPromptPath(cfg, agent))
+	if err != nil {
+		return "", fmt.Errorf("failed to read prompt file of agent %s: %w", name, err)
+	}
+	return string(content), nil
+}
+
+// TaskProfiles returns the profiles the task agent can be launched with, the
+// default one first.
+func TaskProfiles() []AgentProfile {
+	if cfg != nil && len(cfg.Agents[AgentTask].Profiles) > 0 {
+		return cfg.Agents[AgentTask].Profiles
+	}
+	return []AgentProfile{ProfileReadOnly}
+}
+
+// CustomAgents returns the names of the user-defined agents, sorted.
+func CustomAgents() []AgentName {
+	var names []AgentName
+	for name := range cfg.Agents {
+		if !name.IsBuiltin() {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
 // Validate checks if the configuration is valid and applies defaults where needed.
 func Validate() error {
 	if cfg == nil {
@@ -647,6 +1181,19 @@ func Validate() error {
 		if err := validateAgent(cfg, name, agent); err != nil {
 			return err
 		}
+		validateFallbacks(cfg, name)
+		if !name.IsBuiltin() {
+			if err := validateCustomAgent(cfg, name, agent); err != nil {
+				return err
+			}
+		}
+		for _, profile := range agent.Profiles {
+			switch profile {
+			case ProfileReadOnly, ProfileEdit, ProfileFull:
+			default:
+				return fmt.Errorf("invalid profile for agent %s: %s (supported: read-only, edit, full)", name, profile)
+			}
+		}
 	}
 
 	// Validate providers
@@ -659,6 +1206,25 @@ func Validate() error {
 		}
 	}
 
+	// Validate automatic compaction
+	if cfg.AutoCompactThreshold <= 0 || cfg.AutoCompactThreshold > 1 {
+		logging.Warn("invalid auto compact threshold, using the default",
+			"threshold", cfg.AutoCompactThreshold,
+			"default", defaultAutoCompactThreshold)
+		cfg.AutoCompactThreshold = defaultAutoCompactThreshold
+	}
+
+	// Validate the cassette
+	switch cfg.Cassette.Mode {
+	case "":
+	case "record", "replay":
+		if cfg.Cassette.Path == "" {
+			return fmt.Errorf("cassette has no path")
+		}
+	default:
+		return fmt.Errorf("invalid cassette mode: %s (supported: record, replay)", cfg.Cassette.Mode)
+	}
+
 	// Validate LSP configurations
 	for language, lspConfig := range cfg.LSP {
 		if lspConfig.Command == "" && !lspConfig.Disabled {
@@ -706,10 +1272,10 @@ func setDefaultModelForAgent(agent AgentName) bool {
 			maxTokens = 80
 		}
 
-		cfg.Agents[agent] = Agent{
+		setAgentModel(agent, Agent{
 			Model:     models.CopilotGPT4o,
 			MaxTokens: maxTokens,
-		}
+		})
 		return true
 	}
 	// Check providers in order of preference
@@ -718,10 +1284,10 @@ func setDefaultModelForAgent(agent AgentName) bool {
 		if agent == AgentTitle {
 			maxTokens = 80
 		}
-		cfg.Agents[agent] = Agent{
+		setAgentModel(agent, Agent{
 			Model:     models.Claude37Sonnet,
 			MaxTokens: maxTokens,
-		}
+		})
 		return true
 	}
 
@@ -745,11 +1311,11 @@ func setDefaultModelForAgent(agent AgentName) bool {
 			reasoningEffort = "medium"
 		}
 
-		cfg.Agents[agent] = Agent{
+		setAgentModel(agent, Agent{
 			Model:           model,
 			MaxTokens:       maxTokens,
 			ReasoningEffort: reasoningEffort,
-		}
+		})
 		return true
 	}
 
@@ -773,11 +1339,11 @@ func setDefaultModelForAgent(agent AgentName) bool {
 			reasoningEffort = "medium"
 		}
 
-		cfg.Agents[agent] = Agent{
+		setAgentModel(agent, Agent{
 			Model:           model,
 			MaxTokens:       maxTokens,
 			ReasoningEffort: reasoningEffort,
-		}
+		})
 		return true
 	}
 
@@ -792,10 +1358,10 @@ func setDefaultModelForAgent(agent AgentName) bool {
 			model = models.Gemini25
 		}
 
-		cfg.Agents[agent] = Agent{
+		setAgentModel(agent, Agent{
 			Model:     model,
 			MaxTokens: maxTokens,
-		}
+		})
 		return true
 	}
 
@@ -805,10 +1371,10 @@ func setDefaultModelForAgent(agent AgentName) bool {
 			maxTokens = 80
 		}
 
-		cfg.Agents[agent] = Agent{
+		setAgentModel(agent, Agent{
 			Model:     models.QWENQwq,
 			MaxTokens: maxTokens,
-		}
+		})
 		return true
 	}
 
@@ -818,11 +1384,11 @@ func setDefaultModelForAgent(agent AgentName) bool {
 			maxTokens = 80
 		}
 
-		cfg.Agents[agent] = Agent{
+		setAgentModel(agent, Agent{
 			Model:           models.BedrockClaude37Sonnet,
 			MaxTokens:       maxTokens,
 			ReasoningEffort: "medium", // Claude models support reasoning
-		}
+		})
 		return true
 	}
 
@@ -837,16 +1403,39 @@ func setDefaultModelForAgent(agent AgentName) bool {
 			model = models.VertexAIGemini25
 		}
 
-		cfg.Agents[agent] = Agent{
+		setAgentModel(agent, Agent{
 			Model:     model,
 			MaxTokens: maxTokens,
+		})
+		return true
+	}
+
+	if model, ok := defaultOllamaModel(); ok {
+		maxTokens := model.DefaultMaxTokens
+		if agent == AgentTitle {
+			maxTokens = 80
 		}
+
+		setAgentModel(agent, Agent{
+			Model:     model.ID,
+			MaxTokens: maxTokens,
+		})
 		return true
 	}
 
 	return false
 }
 
+// setAgentModel sets the model settings of the agent, keeping the rest of its
+// configuration.
+func setAgentModel(name AgentName, model Agent) {
+	agent := cfg.Agents[name]
+	agent.Model = model.Model
+	agent.MaxTokens = model.MaxTokens
+	agent.ReasoningEffort = model.ReasoningEffort
+	cfg.Agents[name] = agent
+}
+
 func updateCfgFile(updateCfg func(config *Config)) error {
 	if cfg == nil {
 		return fmt.Errorf("config not loaded")
@@ -899,6 +1488,25 @@ func Get() *Config {
 	return cfg
 }
 
+// OverrideBudget replaces the session and run limits with the non-zero limits
+// of budget for the lifetime of the process. The config file is not changed.
+func OverrideBudget(budget Budget) {
+	if cfg == nil {
+		return
+	}
+	for _, b := range []*Budget{&cfg.Budgets.Session, &cfg.Budgets.Run} {
+		if budget.MaxCost > 0 {
+			b.MaxCost = budget.MaxCost
+		}
+		if budget.MaxTokens > 0 {
+			b.MaxTokens = budget.MaxTokens
+		}
+		if budget.MaxIterations > 0 {
+			b.MaxIterations = budget.MaxIterations
+		}
+	}
+}
+
 // WorkingDirectory returns the current working directory from the configuration.
 func WorkingDirectory() string {
 	if cfg == nil {
@@ -924,11 +1532,9 @@ func UpdateAgentModel(agentName AgentName, modelID models.ModelID) error {
 		maxTokens = model.DefaultMaxTokens
 	}
 
-	newAgentCfg := Agent{
-		Model:           modelID,
-		MaxTokens:       maxTokens,
-		ReasoningEffort: existingAgentCfg.ReasoningEffort,
-	}
+	newAgentCfg := existingAgentCfg
+	newAgentCfg.Model = modelID
+	newAgentCfg.MaxTokens = maxTokens
 	cfg.Agents[agentName] = newAgentCfg
 
 	if err := validateAgent(cfg, agentName, newAgentCfg); err != nil {
@@ -941,7 +1547,13 @@ func UpdateAgentModel(agentName AgentName, modelID models.ModelID) error {
 		if config.Agents == nil {
 			config.Agents = make(map[AgentName]Agent)
 		}
-		config.Agents[agentName] = newAgentCfg
+		// Only the model settings change, the rest of the agent may come
+		// from the local configuration
+		agentCfg := config.Agents[agentName]
+		agentCfg.Model = newAgentCfg.Model
+		agentCfg.MaxTokens = newAgentCfg.MaxTokens
+		agentCfg.ReasoningEffort = newAgentCfg.ReasoningEffort
+		config.Agents[agentName] = agentCfg
 	})
 }
 
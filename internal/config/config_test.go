@@ -0,0 +1,65 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencode-ai/opencode/internal/llm/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateCustomAgent(t *testing.T) {
+	workingDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(workingDir, "reviewer.md"), []byte("Review the changes."), 0o644))
+	cfg := &Config{WorkingDir: workingDir}
+
+	tests := []struct {
+		name  string
+		agent Agent
+		err   string
+	}{
+		{name: "defaults", agent: Agent{}},
+		{name: "relative prompt", agent: Agent{Prompt: "reviewer.md", Permissions: PermissionDeny}},
+		{name: "absolute prompt", agent: Agent{Prompt: filepath.Join(workingDir, "reviewer.md")}},
+		{name: "missing prompt", agent: Agent{Prompt: "missing.md"}, err: "invalid prompt file for agent reviewer"},
+		{name: "unknown policy", agent: Agent{Permissions: "sometimes"}, err: "invalid permission policy for agent reviewer: sometimes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCustomAgent(cfg, "reviewer", tt.agent)
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestValidateSamplingStop(t *testing.T) {
+	topP := 0.9
+	tests := []struct {
+		name  string
+		model models.ModelID
+		want  Agent
+	}{
+		{name: "chat model", model: models.GPT41, want: Agent{Model: models.GPT41, TopP: &topP, Stop: []string{"END"}}},
+		{name: "reasoning model", model: models.O3Mini, want: Agent{Model: models.O3Mini, ReasoningEffort: "medium"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Agents: map[AgentName]Agent{AgentCoder: {Model: tt.model, TopP: &topP, Stop: []string{"END"}}}}
+			validateSampling(cfg, AgentCoder)
+			assert.Equal(t, tt.want, cfg.Agents[AgentCoder])
+		})
+	}
+}
+
+func TestIsBuiltin(t *testing.T) {
+	for _, name := range BuiltinAgents {
+		assert.True(t, name.IsBuiltin(), name)
+	}
+	assert.False(t, AgentName("reviewer").IsBuiltin())
+}
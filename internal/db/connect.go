@@ -20,6 +20,12 @@ func Connect() (*sql.DB, error) {
 	if dataDir == "" {
 		return nil, fmt.Errorf("data.dir is not set")
 	}
+	return ConnectDir(dataDir)
+}
+
+// ConnectDir opens the database in dataDir, creating it and applying the
+// migrations as needed.
+func ConnectDir(dataDir string) (*sql.DB, error) {
 	if err := os.MkdirAll(dataDir, 0o700); err != nil {
 		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
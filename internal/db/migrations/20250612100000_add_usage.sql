@@ -0,0 +1,51 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Usage has one row per provider call. Rows are kept when their session is
+-- deleted so that reports still include what was spent in it.
+CREATE TABLE IF NOT EXISTS usage (
+    id TEXT PRIMARY KEY,
+    session_id TEXT NOT NULL,
+    message_id TEXT,
+    model TEXT NOT NULL,
+    provider TEXT NOT NULL,
+    agent TEXT NOT NULL,
+    input_tokens INTEGER NOT NULL DEFAULT 0 CHECK (input_tokens >= 0),
+    output_tokens INTEGER NOT NULL DEFAULT 0 CHECK (output_tokens >= 0),
+    cache_creation_tokens INTEGER NOT NULL DEFAULT 0 CHECK (cache_creation_tokens >= 0),
+    cache_read_tokens INTEGER NOT NULL DEFAULT 0 CHECK (cache_read_tokens >= 0),
+    cost REAL NOT NULL DEFAULT 0.0 CHECK (cost >= 0.0),
+    created_at INTEGER NOT NULL  -- Unix timestamp in seconds
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_session_id ON usage (session_id);
+CREATE INDEX IF NOT EXISTS idx_usage_created_at ON usage (created_at);
+
+-- The tokens of the last call, i.e. how much of the context window is in use.
+-- Until now prompt_tokens and completion_tokens held that value.
+ALTER TABLE sessions ADD COLUMN context_tokens INTEGER NOT NULL DEFAULT 0 CHECK (context_tokens >= 0);
+UPDATE sessions SET context_tokens = prompt_tokens + completion_tokens;
+
+-- Session totals are derived from the usage rows. Sub-agent sessions also add
+-- to their parent, forks do not.
+CREATE TRIGGER IF NOT EXISTS update_session_usage_on_insert
+AFTER INSERT ON usage
+BEGIN
+UPDATE sessions SET
+    prompt_tokens = prompt_tokens + new.input_tokens + new.cache_creation_tokens + new.cache_read_tokens,
+    completion_tokens = completion_tokens + new.output_tokens,
+    cost = cost + new.cost
+WHERE id = new.session_id
+    OR id = (
+        SELECT parent_session_id
+        FROM sessions
+        WHERE id = new.session_id AND fork_message_id IS NULL
+    );
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS update_session_usage_on_insert;
+ALTER TABLE sessions DROP COLUMN context_tokens;
+DROP TABLE IF EXISTS usage;
+-- +goose StatementEnd
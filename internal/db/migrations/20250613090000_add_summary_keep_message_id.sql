@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The first message after the summarized part that is kept verbatim.
+ALTER TABLE sessions ADD COLUMN summary_keep_message_id TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE sessions DROP COLUMN summary_keep_message_id;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The message whose tool calls recorded the version, so that versions are
+-- told apart by turn rather than by their second-resolution timestamps.
+ALTER TABLE files ADD COLUMN message_id TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE files DROP COLUMN message_id;
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The agent that runs a sub-agent session.
+ALTER TABLE sessions ADD COLUMN agent TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE sessions DROP COLUMN agent;
+-- +goose StatementEnd
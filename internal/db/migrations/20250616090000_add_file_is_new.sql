@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Set on the initial version of a file that did not exist before the tool
+-- call that recorded it, so that undo deletes it rather than empty it.
+ALTER TABLE files ADD COLUMN is_new BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE files DROP COLUMN is_new;
+-- +goose StatementEnd
@@ -9,13 +9,15 @@ import (
 )
 
 type File struct {
-	ID        string `json:"id"`
-	SessionID string `json:"session_id"`
-	Path      string `json:"path"`
-	Content   string `json:"content"`
-	Version   string `json:"version"`
-	CreatedAt int64  `json:"created_at"`
-	UpdatedAt int64  `json:"updated_at"`
+	ID        string         `json:"id"`
+	SessionID string         `json:"session_id"`
+	Path      string         `json:"path"`
+	Content   string         `json:"content"`
+	Version   string         `json:"version"`
+	CreatedAt int64          `json:"created_at"`
+	UpdatedAt int64          `json:"updated_at"`
+	MessageID sql.NullString `json:"message_id"`
+	IsNew     bool           `json:"is_new"`
 }
 
 type Message struct {
@@ -30,14 +32,33 @@ type Message struct {
 }
 
 type Session struct {
-	ID               string         `json:"id"`
-	ParentSessionID  sql.NullString `json:"parent_session_id"`
-	Title            string         `json:"title"`
-	MessageCount     int64          `json:"message_count"`
-	PromptTokens     int64          `json:"prompt_tokens"`
-	CompletionTokens int64          `json:"completion_tokens"`
-	Cost             float64        `json:"cost"`
-	UpdatedAt        int64          `json:"updated_at"`
-	CreatedAt        int64          `json:"created_at"`
-	SummaryMessageID sql.NullString `json:"summary_message_id"`
+	ID                   string         `json:"id"`
+	ParentSessionID      sql.NullString `json:"parent_session_id"`
+	Title                string         `json:"title"`
+	MessageCount         int64          `json:"message_count"`
+	PromptTokens         int64          `json:"prompt_tokens"`
+	CompletionTokens     int64          `json:"completion_tokens"`
+	Cost                 float64        `json:"cost"`
+	UpdatedAt            int64          `json:"updated_at"`
+	CreatedAt            int64          `json:"created_at"`
+	SummaryMessageID     sql.NullString `json:"summary_message_id"`
+	ForkMessageID        sql.NullString `json:"fork_message_id"`
+	ContextTokens        int64          `json:"context_tokens"`
+	SummaryKeepMessageID sql.NullString `json:"summary_keep_message_id"`
+	Agent                sql.NullString `json:"agent"`
+}
+
+type Usage struct {
+	ID                  string         `json:"id"`
+	SessionID           string         `json:"session_id"`
+	MessageID           sql.NullString `json:"message_id"`
+	Model               string         `json:"model"`
+	Provider            string         `json:"provider"`
+	Agent               string         `json:"agent"`
+	InputTokens         int64          `json:"input_tokens"`
+	OutputTokens        int64          `json:"output_tokens"`
+	CacheCreationTokens int64          `json:"cache_creation_tokens"`
+	CacheReadTokens     int64          `json:"cache_read_tokens"`
+	Cost                float64        `json:"cost"`
+	CreatedAt           int64          `json:"created_at"`
 }
@@ -20,6 +20,8 @@ INSERT INTO sessions (
     completion_tokens,
     cost,
     summary_message_id,
+    fork_message_id,
+    agent,
     updated_at,
     created_at
 ) VALUES (
@@ -31,9 +33,11 @@ INSERT INTO sessions (
     ?,
     ?,
     null,
+    ?,
+    ?,
     strftime('%s', 'now'),
     strftime('%s', 'now')
-) RETURNING id, parent_session_id, title, message_count, prompt_tokens, completion_tokens, cost, updated_at, created_at, summary_message_id
+) RETURNING id, parent_session_id, title, message_count, prompt_tokens, completion_tokens, cost, updated_at, created_at, summary_message_id, fork_message_id, context_tokens, summary_keep_message_id, agent
 `
 
 type CreateSessionParams struct {
@@ -44,6 +48,8 @@ type CreateSessionParams struct {
 	PromptTokens     int64          `json:"prompt_tokens"`
 	CompletionTokens int64          `json:"completion_tokens"`
 	Cost             float64        `json:"cost"`
+	ForkMessageID    sql.NullString `json:"fork_message_id"`
+	Agent            sql.NullString `json:"agent"`
 }
 
 func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error) {
@@ -55,6 +61,8 @@ func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (S
 		arg.PromptTokens,
 		arg.CompletionTokens,
 		arg.Cost,
+		arg.ForkMessageID,
+		arg.Agent,
 	)
 	var i Session
 	err := row.Scan(
@@ -68,6 +76,10 @@ func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (S
 		&i.UpdatedAt,
 		&i.CreatedAt,
 		&i.SummaryMessageID,
+		&i.ForkMessageID,
+		&i.ContextTokens,
+		&i.SummaryKeepMessageID,
+		&i.Agent,
 	)
 	return i, err
 }
@@ -83,7 +95,7 @@ func (q *Queries) DeleteSession(ctx context.Context, id string) error {
 }
 
 const getSessionByID = `-- name: GetSessionByID :one
-SELECT id, parent_session_id, title, message_count, prompt_tokens, completion_tokens, cost, updated_at, created_at, summary_message_id
+SELECT id, parent_session_id, title, message_count, prompt_tokens, completion_tokens, cost, updated_at, created_at, summary_message_id, fork_message_id, context_tokens, summary_keep_message_id, agent
 FROM sessions
 WHERE id = ? LIMIT 1
 `
@@ -102,14 +114,18 @@ func (q *Queries) GetSessionByID(ctx context.Context, id string) (Session, error
 		&i.UpdatedAt,
 		&i.CreatedAt,
 		&i.SummaryMessageID,
+		&i.ForkMessageID,
+		&i.ContextTokens,
+		&i.SummaryKeepMessageID,
+		&i.Agent,
 	)
 	return i, err
 }
 
 const listSessions = `-- name: ListSessions :many
-SELECT id, parent_session_id, title, message_count, prompt_tokens, completion_tokens, cost, updated_at, created_at, summary_message_id
+SELECT id, parent_session_id, title, message_count, prompt_tokens, completion_tokens, cost, updated_at, created_at, summary_message_id, fork_message_id, context_tokens, summary_keep_message_id, agent
 FROM sessions
-WHERE parent_session_id is NULL
+WHERE parent_session_id is NULL OR fork_message_id is NOT NULL
 ORDER BY created_at DESC
 `
 
@@ -133,6 +149,10 @@ func (q *Queries) ListSessions(ctx context.Context) ([]Session, error) {
 			&i.UpdatedAt,
 			&i.CreatedAt,
 			&i.SummaryMessageID,
+			&i.ForkMessageID,
+			&i.ContextTokens,
+			&i.SummaryKeepMessageID,
+			&i.Agent,
 		); err != nil {
 			return nil, err
 		}
@@ -151,30 +171,27 @@ const updateSession = `-- name: UpdateSession :one
 UPDATE sessions
 SET
     title = ?,
-    prompt_tokens = ?,
-    completion_tokens = ?,
     summary_message_id = ?,
-    cost = ?
+    summary_keep_message_id = ?,
+    context_tokens = ?
 WHERE id = ?
-RETURNING id, parent_session_id, title, message_count, prompt_tokens, completion_tokens, cost, updated_at, created_at, summary_message_id
+RETURNING id, parent_session_id, title, message_count, prompt_tokens, completion_tokens, cost, updated_at, created_at, summary_message_id, fork_message_id, context_tokens, summary_keep_message_id, agent
 `
 
 type UpdateSessionParams struct {
-	Title            string         `json:"title"`
-	PromptTokens     int64          `json:"prompt_tokens"`
-	CompletionTokens int64          `json:"completion_tokens"`
-	SummaryMessageID sql.NullString `json:"summary_message_id"`
-	Cost             float64        `json:"cost"`
-	ID               string         `json:"id"`
+	Title                string         `json:"title"`
+	SummaryMessageID     sql.NullString `json:"summary_message_id"`
+	SummaryKeepMessageID sql.NullString `json:"summary_keep_message_id"`
+	ContextTokens        int64          `json:"context_tokens"`
+	ID                   string         `json:"id"`
 }
 
 func (q *Queries) UpdateSession(ctx context.Context, arg UpdateSessionParams) (Session, error) {
 	row := q.queryRow(ctx, q.updateSessionStmt, updateSession,
 		arg.Title,
-		arg.PromptTokens,
-		arg.CompletionTokens,
 		arg.SummaryMessageID,
-		arg.Cost,
+		arg.SummaryKeepMessageID,
+		arg.ContextTokens,
 		arg.ID,
 	)
 	var i Session
@@ -189,6 +206,10 @@ func (q *Queries) UpdateSession(ctx context.Context, arg UpdateSessionParams) (S
 		&i.UpdatedAt,
 		&i.CreatedAt,
 		&i.SummaryMessageID,
+		&i.ForkMessageID,
+		&i.ContextTokens,
+		&i.SummaryKeepMessageID,
+		&i.Agent,
 	)
 	return i, err
 }
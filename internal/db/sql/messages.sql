@@ -7,7 +7,22 @@ WHERE id = ? LIMIT 1;
 SELECT *
 FROM messages
 WHERE session_id = ?
-ORDER BY created_at ASC;
+ORDER BY created_at ASC, rowid ASC;
+
+-- name: CopyMessage :one
+INSERT INTO messages (
+    id,
+    session_id,
+    role,
+    parts,
+    model,
+    created_at,
+    updated_at,
+    finished_at
+) VALUES (
+    ?, ?, ?, ?, ?, ?, ?, ?
+)
+RETURNING *;
 
 -- name: CreateMessage :one
 INSERT INTO messages (
@@ -28,6 +43,7 @@ UPDATE messages
 SET
     parts = ?,
     finished_at = ?,
+    model = ?,
     updated_at = strftime('%s', 'now')
 WHERE id = ?;
 
@@ -8,6 +8,8 @@ INSERT INTO sessions (
     completion_tokens,
     cost,
     summary_message_id,
+    fork_message_id,
+    agent,
     updated_at,
     created_at
 ) VALUES (
@@ -19,6 +21,8 @@ INSERT INTO sessions (
     ?,
     ?,
     null,
+    ?,
+    ?,
     strftime('%s', 'now'),
     strftime('%s', 'now')
 ) RETURNING *;
@@ -31,17 +35,16 @@ WHERE id = ? LIMIT 1;
 -- name: ListSessions :many
 SELECT *
 FROM sessions
-WHERE parent_session_id is NULL
+WHERE parent_session_id is NULL OR fork_message_id is NOT NULL
 ORDER BY created_at DESC;
 
 -- name: UpdateSession :one
 UPDATE sessions
 SET
     title = ?,
-    prompt_tokens = ?,
-    completion_tokens = ?,
     summary_message_id = ?,
-    cost = ?
+    summary_keep_message_id = ?,
+    context_tokens = ?
 WHERE id = ?
 RETURNING *;
 
@@ -0,0 +1,30 @@
+-- name: CreateUsage :one
+INSERT INTO usage (
+    id,
+    session_id,
+    message_id,
+    model,
+    provider,
+    agent,
+    input_tokens,
+    output_tokens,
+    cache_creation_tokens,
+    cache_read_tokens,
+    cost,
+    created_at
+) VALUES (
+    ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, strftime('%s', 'now')
+)
+RETURNING *;
+
+-- name: ListUsageBySession :many
+SELECT *
+FROM usage
+WHERE session_id = ?
+ORDER BY created_at ASC;
+
+-- name: ListUsage :many
+SELECT *
+FROM usage
+WHERE created_at >= sqlc.arg(from_time) AND created_at < sqlc.arg(to_time)
+ORDER BY created_at ASC;
@@ -41,6 +41,9 @@ func (m spinnerModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case quitMsg:
 		m.quitting = true
 		return m, tea.Quit
+	case messageMsg:
+		m.message = string(msg)
+		return m, nil
 	default:
 		return m, nil
 	}
@@ -56,6 +59,9 @@ func (m spinnerModel) View() string {
 // quitMsg is sent when we want to quit the spinner
 type quitMsg struct{}
 
+// messageMsg is sent to change the message shown next to the spinner
+type messageMsg string
+
 // NewSpinner creates a new spinner with the given message
 func NewSpinner(message string) *Spinner {
 	s := spinner.New()
@@ -100,3 +106,8 @@ func (s *Spinner) Stop() {
 	s.cancel()
 	<-s.done
 }
+
+// SetMessage changes the message shown next to the spinner
+func (s *Spinner) SetMessage(message string) {
+	s.prog.Send(messageMsg(message))
+}
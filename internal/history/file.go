@@ -20,17 +20,27 @@ const (
 type File struct {
 	ID        string
 	SessionID string
+	// MessageID is the message whose tool calls recorded the version, if
+	// any. It tells which turn the version belongs to, as versions recorded
+	// within the same second cannot be told apart by their timestamps.
+	MessageID string
 	Path      string
 	Content   string
 	Version   string
+	// IsNew is set on the initial version of a file that did not exist
+	// before the tool call that recorded it.
+	IsNew     bool
 	CreatedAt int64
 	UpdatedAt int64
 }
 
 type Service interface {
 	pubsub.Suscriber[File]
-	Create(ctx context.Context, sessionID, path, content string) (File, error)
-	CreateVersion(ctx context.Context, sessionID, path, content string) (File, error)
+	Create(ctx context.Context, sessionID, messageID, path, content string) (File, error)
+	// CreateNew records the empty initial version of a file that is about
+	// to be created.
+	CreateNew(ctx context.Context, sessionID, messageID, path string) (File, error)
+	CreateVersion(ctx context.Context, sessionID, messageID, path, content string) (File, error)
 	Get(ctx context.Context, id string) (File, error)
 	GetByPathAndSession(ctx context.Context, path, sessionID string) (File, error)
 	ListBySession(ctx context.Context, sessionID string) ([]File, error)
@@ -54,11 +64,15 @@ func NewService(q *db.Queries, db *sql.DB) Service {
 	}
 }
 
-func (s *service) Create(ctx context.Context, sessionID, path, content string) (File, error) {
-	return s.createWithVersion(ctx, sessionID, path, content, InitialVersion)
+func (s *service) Create(ctx context.Context, sessionID, messageID, path, content string) (File, error) {
+	return s.createWithVersion(ctx, sessionID, messageID, path, content, InitialVersion, false)
 }
 
-func (s *service) CreateVersion(ctx context.Context, sessionID, path, content string) (File, error) {
+func (s *service) CreateNew(ctx context.Context, sessionID, messageID, path string) (File, error) {
+	return s.createWithVersion(ctx, sessionID, messageID, path, "", InitialVersion, true)
+}
+
+func (s *service) CreateVersion(ctx context.Context, sessionID, messageID, path, content string) (File, error) {
 	// Get the latest version for this path
 	files, err := s.q.ListFilesByPath(ctx, path)
 	if err != nil {
@@ -67,7 +81,7 @@ func (s *service) CreateVersion(ctx context.Context, sessionID, path, content st
 
 	if len(files) == 0 {
 		// No previous versions, create initial
-		return s.Create(ctx, sessionID, path, content)
+		return s.Create(ctx, sessionID, messageID, path, content)
 	}
 
 	// Get the latest version
@@ -91,10 +105,10 @@ func (s *service) CreateVersion(ctx context.Context, sessionID, path, content st
 		nextVersion = fmt.Sprintf("v%d", latestFile.CreatedAt)
 	}
 
-	return s.createWithVersion(ctx, sessionID, path, content, nextVersion)
+	return s.createWithVersion(ctx, sessionID, messageID, path, content, nextVersion, false)
 }
 
-func (s *service) createWithVersion(ctx context.Context, sessionID, path, content, version string) (File, error) {
+func (s *service) createWithVersion(ctx context.Context, sessionID, messageID, path, content, version string, isNew bool) (File, error) {
 	// Maximum number of retries for transaction conflicts
 	const maxRetries = 3
 	var file File
@@ -118,6 +132,8 @@ func (s *service) createWithVersion(ctx context.Context, sessionID, path, conten
 			Path:      path,
 			Content:   content,
 			Version:   version,
+			MessageID: sql.NullString{String: messageID, Valid: messageID != ""},
+			IsNew:     isNew,
 		})
 		if txErr != nil {
 			// Rollback the transaction
@@ -243,10 +259,55 @@ func (s *service) fromDBItem(item db.File) File {
 	return File{
 		ID:        item.ID,
 		SessionID: item.SessionID,
+		MessageID: item.MessageID.String,
 		Path:      item.Path,
 		Content:   item.Content,
 		Version:   item.Version,
+		IsNew:     item.IsNew,
 		CreatedAt: item.CreatedAt,
 		UpdatedAt: item.UpdatedAt,
 	}
 }
+
+// sessionService keeps the files of every session in a single session.
+type sessionService struct {
+	Service
+	sessionID string
+	messageID string
+}
+
+func (s *sessionService) Create(ctx context.Context, _, _, path, content string) (File, error) {
+	return s.Service.Create(ctx, s.sessionID, s.messageID, path, content)
+}
+
+func (s *sessionService) CreateNew(ctx context.Context, _, _, path string) (File, error) {
+	return s.Service.CreateNew(ctx, s.sessionID, s.messageID, path)
+}
+
+func (s *sessionService) CreateVersion(ctx context.Context, _, _, path, content string) (File, error) {
+	return s.Service.CreateVersion(ctx, s.sessionID, s.messageID, path, content)
+}
+
+func (s *sessionService) GetByPathAndSession(ctx context.Context, path, _ string) (File, error) {
+	return s.Service.GetByPathAndSession(ctx, path, s.sessionID)
+}
+
+func (s *sessionService) ListBySession(ctx context.Context, _ string) ([]File, error) {
+	return s.Service.ListBySession(ctx, s.sessionID)
+}
+
+func (s *sessionService) ListLatestSessionFiles(ctx context.Context, _ string) ([]File, error) {
+	return s.Service.ListLatestSessionFiles(ctx, s.sessionID)
+}
+
+// InSession returns a service that records the file changes of any session in
+// sessionID, as made by messageID. Sub-agents use it so their changes show up
+// in the history of the session that launched them, as part of the turn of
+// the call that launched them.
+func InSession(s Service, sessionID, messageID string) Service {
+	return &sessionService{
+		Service:   s,
+		sessionID: sessionID,
+		messageID: messageID,
+	}
+}
@@ -1,43 +1,121 @@
 package agent
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/history"
 	"github.com/opencode-ai/opencode/internal/llm/tools"
 	"github.com/opencode-ai/opencode/internal/lsp"
 	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/opencode-ai/opencode/internal/permission"
 	"github.com/opencode-ai/opencode/internal/session"
+	"github.com/opencode-ai/opencode/internal/usage"
 )
 
 type agentTool struct {
-	sessions   session.Service
-	messages   message.Service
-	lspClients map[string]*lsp.Client
+	permissions permission.Service
+	sessions    session.Service
+	messages    message.Service
+	history     history.Service
+	usage       usage.Service
+	lspClients  map[string]*lsp.Client
 }
 
 const (
 	AgentToolName = "agent"
 )
 
+var profileDescriptions = map[config.AgentProfile]string{
+	config.ProfileReadOnly: "searches and reads files, can not modify anything",
+	config.ProfileEdit:     "can also edit and write files and run bash commands",
+	config.ProfileFull:     "has all your tools, except for launching agents",
+}
+
 type AgentParams struct {
-	Prompt string `json:"prompt"`
+	Prompt    string `json:"prompt"`
+	Agent     string `json:"agent,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	Profile   string `json:"profile,omitempty"`
+}
+
+type AgentResponseMetadata struct {
+	SessionID string `json:"session_id"`
+	Agent     string `json:"agent"`
+	Profile   string `json:"profile,omitempty"`
 }
 
 func (b *agentTool) Info() tools.ToolInfo {
-	return tools.ToolInfo{
+	profiles := config.TaskProfiles()
+	readOnly := len(profiles) == 1 && profiles[0] == config.ProfileReadOnly
+	intro := "Launch a new agent that has access to the following tools: GlobTool, GrepTool, LS, View."
+	concurrency := "Launch multiple agents concurrently whenever possible, to maximize performance; to do that, use a single message with multiple tool uses"
+	if !readOnly {
+		intro = fmt.Sprintf("Launch a new agent, with the tools of the profile it is launched with (see note 5): by default it %s.", profileDescriptions[profiles[0]])
+		concurrency = "Agents that can modify files run one at a time, after the tool calls before them are done."
+		if slices.Contains(profiles, config.ProfileReadOnly) {
+			concurrency = "Launch multiple read-only agents concurrently whenever possible, to maximize performance; to do that, use a single message with multiple tool uses. " + concurrency
+		}
+	}
+	info := tools.ToolInfo{
 		Name:        AgentToolName,
-		Description: "Launch a new agent that has access to the following tools: GlobTool, GrepTool, LS, View. When you are searching for a keyword or file and are not confident that you will find the right match on the first try, use the Agent tool to perform the search for you. For example:\n\n- If you are searching for a keyword like \"config\" or \"logger\", or for questions like \"which file does X?\", the Agent tool is strongly recommended\n- If you want to read a specific file path, use the View or GlobTool tool instead of the Agent tool, to find the match more quickly\n- If you are searching for a specific class definition like \"class Foo\", use the GlobTool tool instead, to find the match more quickly\n\nUsage notes:\n1. Launch multiple agents concurrently whenever possible, to maximize performance; to do that, use a single message with multiple tool uses\n2. When the agent is done, it will return a single message back to you. The result returned by the agent is not visible to the user. To show the user the result, you should send a text message back to the user with a concise summary of the result.\n3. Each agent invocation is stateless. You will not be able to send additional messages to the agent, nor will the agent be able to communicate with you outside of its final report. Therefore, your prompt should contain a highly detailed task description for the agent to perform autonomously and you should specify exactly what information the agent should return back to you in its final and only message to you.\n4. The agent's outputs should generally be trusted\n5. IMPORTANT: The agent can not use Bash, Replace, Edit, so can not modify files. If you want to use these tools, use them directly instead of going through the agent.",
+		Description: intro + " When you are searching for a keyword or file and are not confident that you will find the right match on the first try, use the Agent tool to perform the search for you. For example:\n\n- If you are searching for a keyword like \"config\" or \"logger\", or for questions like \"which file does X?\", the Agent tool is strongly recommended\n- If you want to read a specific file path, use the View or GlobTool tool instead of the Agent tool, to find the match more quickly\n- If you are searching for a specific class definition like \"class Foo\", use the GlobTool tool instead, to find the match more quickly\n\nUsage notes:\n1. " + concurrency + "\n2. When the agent is done, it will return a single message back to you. The result returned by the agent is not visible to the user. To show the user the result, you should send a text message back to the user with a concise summary of the result.\n3. Each agent runs in its own session, whose ID is given at the end of its report. The agent can not communicate with you outside of its final report, so your prompt should contain a highly detailed task description for the agent to perform autonomously and you should specify exactly what information the agent should return back to you. To send follow-up instructions to an agent that is done, call the Agent tool again with its session_id: the agent continues its session with everything it has already seen and done, which is much faster than launching a new agent for a related task.\n4. The agent's outputs should generally be trusted",
 		Parameters: map[string]any{
 			"prompt": map[string]any{
 				"type":        "string",
 				"description": "The task for the agent to perform",
 			},
+			"session_id": map[string]any{
+				"type":        "string",
+				"description": "The session ID of an earlier agent to send the prompt to, leave empty to launch a new agent",
+			},
 		},
 		Required: []string{"prompt"},
 	}
+
+	if readOnly {
+		info.Description += "\n5. IMPORTANT: The agent can not use Bash, Replace, Edit, so can not modify files. If you want to use these tools, use them directly instead of going through the agent."
+	} else {
+		info.Description += fmt.Sprintf("\n5. The profile decides which tools the agent has, %s by default:", profiles[0])
+		names := make([]string, len(profiles))
+		for i, profile := range profiles {
+			names[i] = string(profile)
+			info.Description += fmt.Sprintf("\n- %s: %s", profile, profileDescriptions[profile])
+		}
+		info.Description += "\nGive agents that can modify files a self-contained change to make, tell them which files they may touch, and ask them to report every file they changed. Their changes are recorded in your session."
+		info.Parameters["profile"] = map[string]any{
+			"type":        "string",
+			"description": "The tools of the agent",
+			"enum":        names,
+		}
+	}
+
+	customAgents := config.CustomAgents()
+	if len(customAgents) == 0 {
+		return info
+	}
+	var description strings.Builder
+	description.WriteString("\n\nSpecialized agents can also be launched by setting agent to their name. They have their own instructions and tools, which may include tools that modify files, and should be used for the tasks they are meant for:")
+	names := make([]string, len(customAgents))
+	for i, name := range customAgents {
+		names[i] = string(name)
+		fmt.Fprintf(&description, "\n- %s", name)
+		if agentDescription := config.Get().Agents[name].Description; agentDescription != "" {
+			fmt.Fprintf(&description, ": %s", agentDescription)
+		}
+	}
+	info.Description += description.String()
+	info.Parameters["agent"] = map[string]any{
+		"type":        "string",
+		"description": "The specialized agent to launch, leave empty for the default task agent",
+		"enum":        names,
+	}
+	return info
 }
 
 func (b *agentTool) Run(ctx context.Context, call tools.ToolCall) (tools.ToolResponse, error) {
@@ -54,17 +132,73 @@ func (b *agentTool) Run(ctx context.Context, call tools.ToolCall) (tools.ToolRes
 		return tools.ToolResponse{}, fmt.Errorf("session_id and message_id are required")
 	}
 
-	agent, err := NewAgent(config.AgentTask, b.sessions, b.messages, TaskAgentTools(b.lspClients))
+	agentName := config.AgentTask
+	profile := config.AgentProfile(params.Profile)
+	if params.Agent != "" {
+		agentName = config.AgentName(params.Agent)
+		if !slices.Contains(config.CustomAgents(), agentName) {
+			return tools.NewTextErrorResponse(fmt.Sprintf("unknown agent: %s", params.Agent)), nil
+		}
+	}
+
+	var taskSession session.Session
+	if params.SessionID != "" {
+		var err error
+		taskSession, err = b.sessions.Get(ctx, params.SessionID)
+		if err != nil || taskSession.ParentSessionID != sessionID || taskSession.ForkMessageID != "" {
+			return tools.NewTextErrorResponse(fmt.Sprintf("unknown agent session: %s", params.SessionID)), nil
+		}
+		// The session is continued by the agent that started it. The sessions
+		// from before agents were recorded were all run by the task agent.
+		sessionAgent := cmp.Or(taskSession.Agent, config.AgentTask)
+		if params.Agent != "" && agentName != sessionAgent {
+			return tools.NewTextErrorResponse(fmt.Sprintf("session %s is run by the %s agent, not %s", params.SessionID, sessionAgent, params.Agent)), nil
+		}
+		agentName = sessionAgent
+		if agentName != config.AgentTask && !slices.Contains(config.CustomAgents(), agentName) {
+			return tools.NewTextErrorResponse(fmt.Sprintf("the agent of session %s is no longer configured: %s", params.SessionID, agentName)), nil
+		}
+		if profile == "" {
+			if profile, err = b.sessionProfile(ctx, sessionID, taskSession.ID); err != nil {
+				return tools.ToolResponse{}, err
+			}
+		}
+	}
+
+	// The permission requests of the sub-agent say which agent is asking, and
+	// its file changes are recorded in the parent session
+	fileHistory := history.InSession(b.history, sessionID, messageID)
+	var agentTools []tools.BaseTool
+	if agentName == config.AgentTask {
+		if profile == "" {
+			profile = config.TaskProfiles()[0]
+		}
+		if !slices.Contains(config.TaskProfiles(), profile) {
+			return tools.NewTextErrorResponse(fmt.Sprintf("profile not available: %s", profile)), nil
+		}
+		permissions := permission.ForSubAgent(b.permissions, sessionID, fmt.Sprintf("%s (%s)", agentName, profile))
+		agentTools = ProfileAgentTools(profile, permissions, b.sessions, b.messages, fileHistory, b.usage, b.lspClients)
+	} else {
+		profile = ""
+		permissions := permission.ForSubAgent(b.permissions, sessionID, string(agentName))
+		agentTools = withoutAgentTool(
+			CustomAgentTools(agentName, permissions, b.sessions, b.messages, fileHistory, b.usage, b.lspClients),
+		)
+	}
+
+	agent, err := NewAgent(agentName, b.sessions, b.messages, b.usage, agentTools, nil)
 	if err != nil {
 		return tools.ToolResponse{}, fmt.Errorf("error creating agent: %s", err)
 	}
 
-	session, err := b.sessions.CreateTaskSession(ctx, call.ID, sessionID, "New Agent Session")
-	if err != nil {
-		return tools.ToolResponse{}, fmt.Errorf("error creating session: %s", err)
+	if taskSession.ID == "" {
+		taskSession, err = b.sessions.CreateTaskSession(ctx, call.ID, sessionID, agentName, "New Agent Session")
+		if err != nil {
+			return tools.ToolResponse{}, fmt.Errorf("error creating session: %s", err)
+		}
 	}
 
-	done, err := agent.Run(ctx, session.ID, params.Prompt)
+	done, err := agent.Run(ctx, taskSession.ID, params.Prompt)
 	if err != nil {
 		return tools.ToolResponse{}, fmt.Errorf("error generating agent: %s", err)
 	}
@@ -78,32 +212,63 @@ func (b *agentTool) Run(ctx context.Context, call tools.ToolCall) (tools.ToolRes
 		return tools.NewTextErrorResponse("no response"), nil
 	}
 
-	updatedSession, err := b.sessions.Get(ctx, session.ID)
-	if err != nil {
-		return tools.ToolResponse{}, fmt.Errorf("error getting session: %s", err)
-	}
+	// The usage of the sub-agent is already part of the parent's totals, saving
+	// publishes them.
 	parentSession, err := b.sessions.Get(ctx, sessionID)
 	if err != nil {
 		return tools.ToolResponse{}, fmt.Errorf("error getting parent session: %s", err)
 	}
 
-	parentSession.Cost += updatedSession.Cost
-
 	_, err = b.sessions.Save(ctx, parentSession)
 	if err != nil {
 		return tools.ToolResponse{}, fmt.Errorf("error saving parent session: %s", err)
 	}
-	return tools.NewTextResponse(response.Content().String()), nil
+	content := fmt.Sprintf("%s\n\n<agent_session_id>%s</agent_session_id>", response.Content().String(), taskSession.ID)
+	return tools.WithResponseMetadata(
+		tools.NewTextResponse(content),
+		AgentResponseMetadata{
+			SessionID: taskSession.ID,
+			Agent:     string(agentName),
+			Profile:   string(profile),
+		},
+	), nil
+}
+
+// sessionProfile returns the profile the task agent of an earlier session was
+// launched with, from the result of the call that launched it.
+func (b *agentTool) sessionProfile(ctx context.Context, sessionID, taskSessionID string) (config.AgentProfile, error) {
+	msgs, err := b.messages.List(ctx, sessionID)
+	if err != nil {
+		return "", fmt.Errorf("error listing messages: %s", err)
+	}
+	for _, msg := range msgs {
+		for _, result := range msg.ToolResults() {
+			if result.Name != AgentToolName || result.Metadata == "" {
+				continue
+			}
+			var metadata AgentResponseMetadata
+			if json.Unmarshal([]byte(result.Metadata), &metadata) == nil && metadata.SessionID == taskSessionID && metadata.Profile != "" {
+				return config.AgentProfile(metadata.Profile), nil
+			}
+		}
+	}
+	return "", nil
 }
 
 func NewAgentTool(
+	Permissions permission.Service,
 	Sessions session.Service,
 	Messages message.Service,
+	History history.Service,
+	Usage usage.Service,
 	LspClients map[string]*lsp.Client,
 ) tools.BaseTool {
 	return &agentTool{
-		sessions:   Sessions,
-		messages:   Messages,
-		lspClients: LspClients,
+		permissions: Permissions,
+		sessions:    Sessions,
+		messages:    Messages,
+		history:     History,
+		usage:       Usage,
+		lspClients:  LspClients,
 	}
 }
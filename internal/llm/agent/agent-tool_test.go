@@ -0,0 +1,186 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/db"
+	"github.com/opencode-ai/opencode/internal/history"
+	"github.com/opencode-ai/opencode/internal/llm/tools"
+	"github.com/opencode-ai/opencode/internal/lsp"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/opencode-ai/opencode/internal/permission"
+	"github.com/opencode-ai/opencode/internal/session"
+	"github.com/opencode-ai/opencode/internal/usage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// agentToolTest runs the agent tool in a parent session, with every agent
+// answering "Done."
+type agentToolTest struct {
+	t        *testing.T
+	ctx      context.Context
+	sessions session.Service
+	messages message.Service
+	tool     tools.BaseTool
+	parentID string
+}
+
+func newAgentToolTest(t *testing.T) *agentToolTest {
+	loadMockConfig(t, `
+responses:
+  - content: Done.
+`)
+	conn, err := db.ConnectDir(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	q := db.New(conn)
+	sessions := session.NewService(q, conn)
+	messages := message.NewService(q)
+	files := history.NewService(q, conn)
+	usages := usage.NewService(q)
+	ctx := context.Background()
+	parent, err := sessions.Create(ctx, "parent")
+	require.NoError(t, err)
+	return &agentToolTest{
+		t:        t,
+		ctx:      ctx,
+		sessions: sessions,
+		messages: messages,
+		tool:     NewAgentTool(permission.NewPermissionService(), sessions, messages, files, usages, map[string]*lsp.Client{}),
+		parentID: parent.ID,
+	}
+}
+
+// call runs the tool the way the parent agent does: from an assistant message
+// whose tool results are saved once the call is done.
+func (tt *agentToolTest) call(callID string, params AgentParams) (tools.ToolResponse, AgentResponseMetadata) {
+	input, err := json.Marshal(params)
+	require.NoError(tt.t, err)
+	msg, err := tt.messages.Create(tt.ctx, tt.parentID, message.CreateMessageParams{
+		Role:  message.Assistant,
+		Parts: []message.ContentPart{message.ToolCall{ID: callID, Name: AgentToolName, Input: string(input), Finished: true}},
+	})
+	require.NoError(tt.t, err)
+
+	ctx := context.WithValue(tt.ctx, tools.SessionIDContextKey, tt.parentID)
+	ctx = context.WithValue(ctx, tools.MessageIDContextKey, msg.ID)
+	response, err := tt.tool.Run(ctx, tools.ToolCall{ID: callID, Name: AgentToolName, Input: string(input)})
+	require.NoError(tt.t, err)
+
+	_, err = tt.messages.Create(tt.ctx, tt.parentID, message.CreateMessageParams{
+		Role: message.Tool,
+		Parts: []message.ContentPart{message.ToolResult{
+			ToolCallID: callID,
+			Name:       AgentToolName,
+			Content:    response.Content,
+			Metadata:   response.Metadata,
+			IsError:    response.IsError,
+		}},
+	})
+	require.NoError(tt.t, err)
+
+	var metadata AgentResponseMetadata
+	if response.Metadata != "" {
+		require.NoError(tt.t, json.Unmarshal([]byte(response.Metadata), &metadata))
+	}
+	return response, metadata
+}
+
+func TestAgentToolSessionAgent(t *testing.T) {
+	tt := newAgentToolTest(t)
+
+	response, metadata := tt.call("call_review", AgentParams{Prompt: "review", Agent: "reviewer"})
+	require.False(t, response.IsError, response.Content)
+	assert.Equal(t, "reviewer", metadata.Agent)
+	review, err := tt.sessions.Get(tt.ctx, "call_review")
+	require.NoError(t, err)
+	assert.Equal(t, config.AgentName("reviewer"), review.Agent)
+
+	response, _ = tt.call("call_task", AgentParams{Prompt: "search"})
+	require.False(t, response.IsError, response.Content)
+	task, err := tt.sessions.Get(tt.ctx, "call_task")
+	require.NoError(t, err)
+	assert.Equal(t, config.AgentTask, task.Agent)
+
+	// A session is continued by the agent that started it
+	response, metadata = tt.call("call_review_2", AgentParams{Prompt: "again", SessionID: "call_review"})
+	require.False(t, response.IsError, response.Content)
+	assert.Equal(t, "reviewer", metadata.Agent)
+	assert.Equal(t, "call_review", metadata.SessionID)
+
+	response, _ = tt.call("call_task_2", AgentParams{Prompt: "again", SessionID: "call_task", Agent: "reviewer"})
+	assert.True(t, response.IsError)
+	assert.Contains(t, response.Content, "is run by the task agent")
+}
+
+// setTaskProfiles sets the profiles of the task agent of the loaded config for
+// the duration of the test.
+func setTaskProfiles(t *testing.T, profiles ...config.AgentProfile) {
+	cfg := config.Get()
+	previous := cfg.Agents[config.AgentTask]
+	task := previous
+	task.Profiles = profiles
+	cfg.Agents[config.AgentTask] = task
+	t.Cleanup(func() { cfg.Agents[config.AgentTask] = previous })
+}
+
+func TestAgentToolSessionProfile(t *testing.T) {
+	tt := newAgentToolTest(t)
+	setTaskProfiles(t, config.ProfileReadOnly, config.ProfileEdit)
+
+	response, metadata := tt.call("call_search", AgentParams{Prompt: "search"})
+	require.False(t, response.IsError, response.Content)
+	assert.Equal(t, string(config.ProfileReadOnly), metadata.Profile)
+
+	response, metadata = tt.call("call_edit", AgentParams{Prompt: "edit", Profile: string(config.ProfileEdit)})
+	require.False(t, response.IsError, response.Content)
+	assert.Equal(t, string(config.ProfileEdit), metadata.Profile)
+
+	// A session is continued with the profile it was launched with, found in
+	// the result of the call that launched it
+	response, metadata = tt.call("call_edit_2", AgentParams{Prompt: "again", SessionID: "call_edit"})
+	require.False(t, response.IsError, response.Content)
+	assert.Equal(t, "call_edit", metadata.SessionID)
+	assert.Equal(t, string(config.ProfileEdit), metadata.Profile)
+
+	response, metadata = tt.call("call_search_2", AgentParams{Prompt: "again", SessionID: "call_search"})
+	require.False(t, response.IsError, response.Content)
+	assert.Equal(t, string(config.ProfileReadOnly), metadata.Profile)
+
+	// unless another one is asked for
+	response, metadata = tt.call("call_search_3", AgentParams{Prompt: "edit now", SessionID: "call_search", Profile: string(config.ProfileEdit)})
+	require.False(t, response.IsError, response.Content)
+	assert.Equal(t, string(config.ProfileEdit), metadata.Profile)
+
+	response, _ = tt.call("call_full", AgentParams{Prompt: "everything", Profile: string(config.ProfileFull)})
+	assert.True(t, response.IsError)
+	assert.Contains(t, response.Content, "profile not available: full")
+}
+
+func TestAgentToolInfo(t *testing.T) {
+	tt := newAgentToolTest(t)
+
+	description := tt.tool.Info().Description
+	assert.Contains(t, description, "has access to the following tools: GlobTool, GrepTool, LS, View")
+	assert.Contains(t, description, "1. Launch multiple agents concurrently")
+	assert.Contains(t, description, "can not modify files")
+
+	// Agents that can modify files are not said to be search-only, nor to
+	// run side by side
+	setTaskProfiles(t, config.ProfileReadOnly, config.ProfileEdit)
+	description = tt.tool.Info().Description
+	assert.NotContains(t, description, "following tools")
+	assert.Contains(t, description, "by default it searches and reads files")
+	assert.Contains(t, description, "1. Launch multiple read-only agents concurrently")
+	assert.Contains(t, description, "Agents that can modify files run one at a time")
+
+	setTaskProfiles(t, config.ProfileEdit)
+	description = tt.tool.Info().Description
+	assert.Contains(t, description, "by default it can also edit and write files")
+	assert.NotContains(t, description, "concurrently")
+}
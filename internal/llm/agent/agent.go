@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -19,12 +20,14 @@ import (
 	"github.com/opencode-ai/opencode/internal/permission"
 	"github.com/opencode-ai/opencode/internal/pubsub"
 	"github.com/opencode-ai/opencode/internal/session"
+	"github.com/opencode-ai/opencode/internal/usage"
 )
 
 // Common errors
 var (
 	ErrRequestCancelled = errors.New("request cancelled by user")
 	ErrSessionBusy      = errors.New("session is currently processing another request")
+	ErrSessionIdle      = errors.New("session is not processing a request")
 )
 
 type AgentEventType string
@@ -33,6 +36,8 @@ const (
 	AgentEventTypeError     AgentEventType = "error"
 	AgentEventTypeResponse  AgentEventType = "response"
 	AgentEventTypeSummarize AgentEventType = "summarize"
+	// AgentEventTypeQueue reports that the prompt queue of SessionID changed.
+	AgentEventTypeQueue AgentEventType = "queue"
 )
 
 type AgentEvent struct {
@@ -40,7 +45,7 @@ type AgentEvent struct {
 	Message message.Message
 	Error   error
 
-	// When summarizing
+	// When summarizing or the queue changed
 	SessionID string
 	Progress  string
 	Done      bool
@@ -50,34 +55,76 @@ type Service interface {
 	pubsub.Suscriber[AgentEvent]
 	Model() models.Model
 	Run(ctx context.Context, sessionID string, content string, attachments ...message.Attachment) (<-chan AgentEvent, error)
+	RunFrom(ctx context.Context, sessionID, messageID string, content string, attachments ...message.Attachment) (<-chan AgentEvent, error)
+	// Cancel stops the current run of the session. The queued prompts are
+	// kept and the next one runs; call ClearQueue first to drop them.
 	Cancel(sessionID string)
+	// Enqueue runs the prompt, or queues it while the session is busy.
+	Enqueue(ctx context.Context, sessionID string, content string, attachments ...message.Attachment) (bool, error)
+	Queue(sessionID string) []QueuedPrompt
+	// QueueHeld tells whether the queued prompts wait after a failed run.
+	// They run again once another prompt is sent.
+	QueueHeld(sessionID string) bool
+	UpdateQueued(sessionID, promptID, content string, attachments ...message.Attachment) bool
+	RemoveQueued(sessionID, promptID string)
+	ClearQueue(sessionID string)
+	// Interject sends a user message to the current run of the session,
+	// with the results of the tool calls in progress. It fails with
+	// ErrSessionIdle when the session is not running.
+	Interject(ctx context.Context, sessionID string, content string, attachments ...message.Attachment) error
 	IsSessionBusy(sessionID string) bool
 	IsBusy() bool
 	Update(agentName config.AgentName, modelID models.ModelID) (models.Model, error)
 	Summarize(ctx context.Context, sessionID string) error
+	// SetPlanMode switches plan mode on or off. In plan mode the agent only
+	// gets tools that do not change anything and is asked to propose a plan.
+	SetPlanMode(enabled bool) error
+	PlanMode() bool
+	// SwitchAgent makes the agent act as another one, with the model, prompt
+	// and the given tools of that agent.
+	SwitchAgent(agentName config.AgentName, agentTools []tools.BaseTool) error
+	AgentName() config.AgentName
 }
 
 type agent struct {
 	*pubsub.Broker[AgentEvent]
+	name     config.AgentName
 	sessions session.Service
 	messages message.Service
+	usage    usage.Service
 
 	tools    []tools.BaseTool
 	provider provider.Provider
 
+	// agentTools and planTools are the tools used outside and in plan mode,
+	// one of which is tools. Agents without planTools have no plan mode.
+	agentTools []tools.BaseTool
+	planTools  []tools.BaseTool
+	planMode   bool
+
 	titleProvider     provider.Provider
 	summarizeProvider provider.Provider
 
 	behavioralFramework *behavioral.BehavioralFramework
 
+	// activeRequests holds the *activeRun of each running session, and the
+	// cancel function of each summary being made.
 	activeRequests sync.Map
+
+	// queues holds the prompts waiting for each session and interjections
+	// the messages sent to their current run, guarded by queueMu.
+	queueMu       sync.Mutex
+	queues        map[string][]QueuedPrompt
+	interjections map[string][]QueuedPrompt
 }
 
 func NewAgent(
 	agentName config.AgentName,
 	sessions session.Service,
 	messages message.Service,
+	usage usage.Service,
 	agentTools []tools.BaseTool,
+	planTools []tools.BaseTool,
 ) (Service, error) {
 	agentProvider, err := createAgentProvider(agentName)
 	if err != nil {
@@ -108,10 +155,14 @@ func NewAgent(
 
 	agent := &agent{
 		Broker:              pubsub.NewBroker[AgentEvent](),
+		name:                agentName,
 		provider:            agentProvider,
 		messages:            messages,
 		sessions:            sessions,
+		usage:               usage,
 		tools:               agentTools,
+		agentTools:          agentTools,
+		planTools:           planTools,
 		titleProvider:       titleProvider,
 		summarizeProvider:   summarizeProvider,
 		behavioralFramework: behavioral.NewBehavioralFramework(behavioralConfig),
@@ -304,6 +355,8 @@ func (a *agent) createEnhancedProvider(behavioralPrompt string) (provider.Provid
 		provider.WithSystemMessage(enhancedSystemPrompt),
 		provider.WithMaxTokens(50000), // Use the same max tokens as configured
 	}
+	opts = append(opts, retryOptions(currentModel.Provider, providerCfg)...)
+	opts = append(opts, provider.WithSampling(agentSampling(cfg.Agents[a.name], currentModel.ID)))
 
 	enhancedProvider, err := provider.NewProvider(currentModel.Provider, opts...)
 	if err != nil {
@@ -451,10 +504,7 @@ func (a *agent) generateFrameworkStatusIndicator(metadata behavioral.ProcessingM
 		sections = "Sections 1-24 (Complete Framework)"
 	}
 
-	problemTypeStr := string(metadata.ProblemType)
-	if problemTypeStr == "" {
-		problemTypeStr = "General"
-	}
+	problemTypeStr := behavioral.GetProblemTypeName(metadata.ProblemType)
 
 	return fmt.Sprintf(`FRAMEWORK STATUS: The "Practical Prompt Engineering Framework v1.6.0" is now ACTIVE and applying mandatory behavioral directives.
 
@@ -592,10 +642,10 @@ func (a *agent) Model() models.Model {
 
 func (a *agent) Cancel(sessionID string) {
 	// Cancel regular requests
-	if cancelFunc, exists := a.activeRequests.LoadAndDelete(sessionID); exists {
-		if cancel, ok := cancelFunc.(context.CancelFunc); ok {
+	if run, exists := a.activeRequests.LoadAndDelete(sessionID); exists {
+		if run, ok := run.(*activeRun); ok {
 			logging.InfoPersist(fmt.Sprintf("Request cancellation initiated for session: %s", sessionID))
-			cancel()
+			run.cancel()
 		}
 	}
 
@@ -611,13 +661,8 @@ func (a *agent) Cancel(sessionID string) {
 func (a *agent) IsBusy() bool {
 	busy := false
 	a.activeRequests.Range(func(key, value interface{}) bool {
-		if cancelFunc, ok := value.(context.CancelFunc); ok {
-			if cancelFunc != nil {
-				busy = true
-				return false // Stop iterating
-			}
-		}
-		return true // Continue iterating
+		busy = true
+		return false // Stop iterating
 	})
 	return busy
 }
@@ -653,6 +698,9 @@ func (a *agent) generateTitle(ctx context.Context, sessionID string, content str
 	if err != nil {
 		return err
 	}
+	if err := a.recordUsage(ctx, sessionID, "", config.AgentTitle, answeredBy(a.titleProvider, response), response.Usage); err != nil {
+		return err
+	}
 
 	title := strings.TrimSpace(strings.ReplaceAll(response.Content, "\n", " "))
 	if title == "" {
@@ -672,33 +720,56 @@ func (a *agent) err(err error) AgentEvent {
 }
 
 func (a *agent) Run(ctx context.Context, sessionID string, content string, attachments ...message.Attachment) (<-chan AgentEvent, error) {
+	return a.run(ctx, sessionID, "", content, attachments)
+}
+
+// RunFrom rewinds the session to the user message messageID and runs content
+// in its place. The message and everything after it are removed first.
+func (a *agent) RunFrom(ctx context.Context, sessionID, messageID string, content string, attachments ...message.Attachment) (<-chan AgentEvent, error) {
+	return a.run(ctx, sessionID, messageID, content, attachments)
+}
+
+// activeRun is the claim of a run on its session. Claims are compared by
+// pointer, so a run that was cancelled and replaced by another one does not
+// release the claim of the new run when it finishes.
+type activeRun struct {
+	cancel context.CancelFunc
+}
+
+// run claims the session and runs content in it, after rewinding the session
+// to rewindTo if set. The session is claimed before anything is removed, so a
+// run that cannot start leaves the history as it was.
+func (a *agent) run(ctx context.Context, sessionID, rewindTo, content string, attachments []message.Attachment) (<-chan AgentEvent, error) {
 	logging.Info("AGENT RUN CALLED", "session_id", sessionID, "content_length", len(content))
+	genCtx, cancel := context.WithCancel(ctx)
+	claim := &activeRun{cancel: cancel}
+	if _, busy := a.activeRequests.LoadOrStore(sessionID, claim); busy {
+		cancel()
+		return nil, ErrSessionBusy
+	}
+	if rewindTo != "" {
+		if err := a.rewind(ctx, sessionID, rewindTo); err != nil {
+			a.activeRequests.CompareAndDelete(sessionID, claim)
+			cancel()
+			return nil, err
+		}
+	}
+
 	if !a.provider.Model().SupportsAttachments && attachments != nil {
 		attachments = nil
 	}
 	events := make(chan AgentEvent)
-	if a.IsSessionBusy(sessionID) {
-		return nil, ErrSessionBusy
-	}
-
-	genCtx, cancel := context.WithCancel(ctx)
-
-	a.activeRequests.Store(sessionID, cancel)
 	go func() {
 		logging.Debug("Request started", "sessionID", sessionID)
 		defer logging.RecoverPanic("agent.Run", func() {
 			events <- a.err(fmt.Errorf("panic while running the agent"))
 		})
-		var attachmentParts []message.ContentPart
-		for _, attachment := range attachments {
-			attachmentParts = append(attachmentParts, message.BinaryContent{Path: attachment.FilePath, MIMEType: attachment.MimeType, Data: attachment.Content})
-		}
-		result := a.processGeneration(genCtx, sessionID, content, attachmentParts)
+		result := a.processGeneration(genCtx, sessionID, content, attachmentParts(attachments))
 		if result.Error != nil && !errors.Is(result.Error, ErrRequestCancelled) && !errors.Is(result.Error, context.Canceled) {
 			logging.ErrorPersist(result.Error.Error())
 		}
 		logging.Debug("Request completed", "sessionID", sessionID)
-		a.activeRequests.Delete(sessionID)
+		a.runFinished(sessionID, claim, result)
 		cancel()
 		a.Publish(pubsub.CreatedEvent, result)
 		events <- result
@@ -707,6 +778,37 @@ func (a *agent) Run(ctx context.Context, sessionID string, content string, attac
 	return events, nil
 }
 
+func (a *agent) rewind(ctx context.Context, sessionID, messageID string) error {
+	msg, err := a.messages.Get(ctx, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to get message: %w", err)
+	}
+	if msg.SessionID != sessionID || msg.Role != message.User {
+		return fmt.Errorf("message %s is not a user message of session %s", messageID, sessionID)
+	}
+	session, err := a.sessions.Get(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	if session.SummaryMessageID != "" {
+		msgs, err := a.messages.List(ctx, sessionID)
+		if err != nil {
+			return fmt.Errorf("failed to list messages: %w", err)
+		}
+		idx := slices.IndexFunc(msgs, func(m message.Message) bool { return m.ID == messageID })
+		summaryIdx := slices.IndexFunc(msgs, func(m message.Message) bool { return m.ID == session.SummaryMessageID })
+		// Drop the summary if it is removed with the rest of the messages
+		if idx != -1 && summaryIdx >= idx {
+			session.SummaryMessageID = ""
+			session.SummaryKeepMessageID = ""
+			if _, err := a.sessions.Save(ctx, session); err != nil {
+				return fmt.Errorf("failed to save session: %w", err)
+			}
+		}
+	}
+	return a.messages.Rewind(ctx, sessionID, messageID)
+}
+
 func (a *agent) processGeneration(ctx context.Context, sessionID, content string, attachmentParts []message.ContentPart) AgentEvent {
 	logging.Info("PROCESS GENERATION CALLED", "session_id", sessionID, "content_length", len(content))
 	cfg := config.Get()
@@ -730,19 +832,14 @@ func (a *agent) processGeneration(ctx context.Context, sessionID, content string
 	if err != nil {
 		return a.err(fmt.Errorf("failed to get session: %w", err))
 	}
-	if session.SummaryMessageID != "" {
-		summaryMsgInex := -1
-		for i, msg := range msgs {
-			if msg.ID == session.SummaryMessageID {
-				summaryMsgInex = i
-				break
-			}
-		}
-		if summaryMsgInex != -1 {
-			msgs = msgs[summaryMsgInex:]
-			msgs[0].Role = message.User
-		}
+	budget := newBudgetTracker(session, msgs)
+	budget.parent = budgetFromContext(ctx)
+	if err := budget.check(); err != nil {
+		return a.err(err)
 	}
+	// Sub-agents launched by the tools of the run count toward its budget
+	ctx = withBudget(ctx, budget)
+	msgs = buildHistory(msgs, session)
 
 	userMsg, err := a.createUserMessage(ctx, sessionID, content, attachmentParts)
 	if err != nil {
@@ -761,6 +858,10 @@ func (a *agent) processGeneration(ctx context.Context, sessionID, content string
 
 	// Remove test directive - implement proper behavioral framework integration
 
+	// injectDirectives is kept to inject the directives again when the history
+	// is rebuilt after compacting the session.
+	injectDirectives := func(history []message.Message) []message.Message { return history }
+
 	// Check if behavioral framework is available
 	if a.behavioralFramework == nil {
 		logging.ErrorPersist("CRITICAL: Behavioral framework is nil - framework not initialized properly")
@@ -788,14 +889,17 @@ func (a *agent) processGeneration(ctx context.Context, sessionID, content string
 			"processing_time_ms", behavioralProcessingTime.Milliseconds(),
 			"complexity_level", behavioralResult.Metadata.ComplexityLevel,
 			"tool_intensity", behavioralResult.Metadata.ToolIntensity,
-			"pattern_type", string(behavioralResult.Metadata.ProblemType),
+			"pattern_type", behavioral.GetProblemTypeName(behavioralResult.Metadata.ProblemType),
 			"expected_improvement", fmt.Sprintf("%.1f%%", behavioralResult.QualityMetrics.ExpectedImprovement*100),
 			"quality_score_target", fmt.Sprintf("%.1f%%", behavioralResult.QualityMetrics.QualityScore*100),
 			"fallback", behavioralResult.Fallback)
 
 		// MANDATORY BEHAVIORAL DIRECTIVE INJECTION
 		if behavioralResult.BehavioralPrompt != "" {
-			msgHistory = a.injectBehavioralDirectives(msgHistory, behavioralResult.BehavioralPrompt, behavioralResult.Metadata)
+			injectDirectives = func(history []message.Message) []message.Message {
+				return a.injectBehavioralDirectives(history, behavioralResult.BehavioralPrompt, behavioralResult.Metadata)
+			}
+			msgHistory = injectDirectives(msgHistory)
 			logging.Info("Behavioral Directives Injected Successfully",
 				"directive_length", len(behavioralResult.BehavioralPrompt),
 				"enhancement_type", behavioral.GetEnhancementName(behavioralResult.Enhancement))
@@ -804,6 +908,12 @@ func (a *agent) processGeneration(ctx context.Context, sessionID, content string
 		}
 	}
 
+	// newMsgs are the messages added since the previous call of the run
+	var newMsgs []message.Message
+	// compactionFailed stops the session from being compacted again during
+	// the run once it failed, so a failing summarizer is not called before
+	// every call of the model.
+	compactionFailed := false
 	for {
 		// Check for cancellation before each iteration
 		select {
@@ -812,7 +922,32 @@ func (a *agent) processGeneration(ctx context.Context, sessionID, content string
 		default:
 			// Continue processing
 		}
-		agentMessage, toolResults, err := a.streamAndHandleEvents(ctx, sessionID, msgHistory)
+		if !compactionFailed && a.needsCompaction(msgHistory, budget.lastTokens, newMsgs) {
+			if err := a.summarize(ctx, sessionID, budget); err != nil {
+				if errors.Is(err, context.Canceled) {
+					return a.err(ErrRequestCancelled)
+				}
+				// The model may still take the whole history
+				compactionFailed = true
+				logging.Warn("Failed to compact session, continuing with the whole history", "session_id", sessionID, "error", err)
+				a.Publish(pubsub.CreatedEvent, AgentEvent{
+					Type:      AgentEventTypeSummarize,
+					SessionID: sessionID,
+					Error:     fmt.Errorf("failed to compact session: %w", err),
+					Done:      true,
+				})
+			} else {
+				if err := budget.check(); err != nil {
+					return a.err(err)
+				}
+				msgHistory, err = a.history(ctx, sessionID)
+				if err != nil {
+					return a.err(err)
+				}
+				msgHistory = injectDirectives(msgHistory)
+			}
+		}
+		agentMessage, toolResults, err := a.streamAndHandleEvents(ctx, sessionID, msgHistory, budget)
 		if err != nil {
 			if errors.Is(err, context.Canceled) {
 				agentMessage.AddFinish(message.FinishReasonCanceled)
@@ -829,8 +964,25 @@ func (a *agent) processGeneration(ctx context.Context, sessionID, content string
 			logging.Info("Result", "message", agentMessage.FinishReason(), "toolResults", toolResults)
 		}
 		if (agentMessage.FinishReason() == message.FinishReasonToolUse) && toolResults != nil {
+			if err := budget.check(); err != nil {
+				a.finishMessage(context.Background(), &agentMessage, message.FinishReasonBudgetExceeded)
+				return AgentEvent{
+					Type:    AgentEventTypeError,
+					Message: agentMessage,
+					Error:   err,
+					Done:    true,
+				}
+			}
 			// We are not done, we need to respond with the tool response
 			msgHistory = append(msgHistory, agentMessage, *toolResults)
+			newMsgs = []message.Message{*toolResults}
+			// Messages the user sent meanwhile follow the tool results
+			interjected, err := a.takeInterjections(ctx, sessionID)
+			if err != nil {
+				return a.err(err)
+			}
+			msgHistory = append(msgHistory, interjected...)
+			newMsgs = append(newMsgs, interjected...)
 			continue
 		}
 		return AgentEvent{
@@ -841,6 +993,19 @@ func (a *agent) processGeneration(ctx context.Context, sessionID, content string
 	}
 }
 
+// history returns the conversation of the session as it is sent to the model.
+func (a *agent) history(ctx context.Context, sessionID string) ([]message.Message, error) {
+	msgs, err := a.messages.List(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	session, err := a.sessions.Get(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	return buildHistory(msgs, session), nil
+}
+
 func (a *agent) createUserMessage(ctx context.Context, sessionID, content string, attachmentParts []message.ContentPart) (message.Message, error) {
 	parts := []message.ContentPart{message.TextContent{Text: content}}
 	parts = append(parts, attachmentParts...)
@@ -850,9 +1015,9 @@ func (a *agent) createUserMessage(ctx context.Context, sessionID, content string
 	})
 }
 
-func (a *agent) streamAndHandleEvents(ctx context.Context, sessionID string, msgHistory []message.Message) (message.Message, *message.Message, error) {
+func (a *agent) streamAndHandleEvents(ctx context.Context, sessionID string, msgHistory []message.Message, budget *budgetTracker) (message.Message, *message.Message, error) {
 	ctx = context.WithValue(ctx, tools.SessionIDContextKey, sessionID)
-	eventChan := a.provider.StreamResponse(ctx, msgHistory, a.tools)
+	eventChan := a.provider.StreamResponse(ctx, pruneToolResults(msgHistory), a.tools)
 
 	assistantMsg, err := a.messages.Create(ctx, sessionID, message.CreateMessageParams{
 		Role:  message.Assistant,
@@ -868,8 +1033,8 @@ func (a *agent) streamAndHandleEvents(ctx context.Context, sessionID string, msg
 
 	// Process each event in the stream.
 	for event := range eventChan {
-		if processErr := a.processEvent(ctx, sessionID, &assistantMsg, event); processErr != nil {
-			a.finishMessage(ctx, &assistantMsg, message.FinishReasonCanceled)
+		if processErr := a.processEvent(ctx, sessionID, &assistantMsg, event, budget); processErr != nil {
+			a.finishMessage(context.Background(), &assistantMsg, message.FinishReasonCanceled)
 			return assistantMsg, nil, processErr
 		}
 		if ctx.Err() != nil {
@@ -878,78 +1043,13 @@ func (a *agent) streamAndHandleEvents(ctx context.Context, sessionID string, msg
 		}
 	}
 
-	toolResults := make([]message.ToolResult, len(assistantMsg.ToolCalls()))
-	toolCalls := assistantMsg.ToolCalls()
-	for i, toolCall := range toolCalls {
-		select {
-		case <-ctx.Done():
-			a.finishMessage(context.Background(), &assistantMsg, message.FinishReasonCanceled)
-			// Make all future tool calls cancelled
-			for j := i; j < len(toolCalls); j++ {
-				toolResults[j] = message.ToolResult{
-					ToolCallID: toolCalls[j].ID,
-					Content:    "Tool execution canceled by user",
-					IsError:    true,
-				}
-			}
-			goto out
-		default:
-			// Continue processing
-			var tool tools.BaseTool
-			for _, availableTool := range a.tools {
-				if availableTool.Info().Name == toolCall.Name {
-					tool = availableTool
-					break
-				}
-				// Monkey patch for Copilot Sonnet-4 tool repetition obfuscation
-				// if strings.HasPrefix(toolCall.Name, availableTool.Info().Name) &&
-				// 	strings.HasPrefix(toolCall.Name, availableTool.Info().Name+availableTool.Info().Name) {
-				// 	tool = availableTool
-				// 	break
-				// }
-			}
-
-			// Tool not found
-			if tool == nil {
-				toolResults[i] = message.ToolResult{
-					ToolCallID: toolCall.ID,
-					Content:    fmt.Sprintf("Tool not found: %s", toolCall.Name),
-					IsError:    true,
-				}
-				continue
-			}
-			toolResult, toolErr := tool.Run(ctx, tools.ToolCall{
-				ID:    toolCall.ID,
-				Name:  toolCall.Name,
-				Input: toolCall.Input,
-			})
-			if toolErr != nil {
-				if errors.Is(toolErr, permission.ErrorPermissionDenied) {
-					toolResults[i] = message.ToolResult{
-						ToolCallID: toolCall.ID,
-						Content:    "Permission denied",
-						IsError:    true,
-					}
-					for j := i + 1; j < len(toolCalls); j++ {
-						toolResults[j] = message.ToolResult{
-							ToolCallID: toolCalls[j].ID,
-							Content:    "Tool execution canceled by user",
-							IsError:    true,
-						}
-					}
-					a.finishMessage(ctx, &assistantMsg, message.FinishReasonPermissionDenied)
-					break
-				}
-			}
-			toolResults[i] = message.ToolResult{
-				ToolCallID: toolCall.ID,
-				Content:    toolResult.Content,
-				Metadata:   toolResult.Metadata,
-				IsError:    toolResult.IsError,
-			}
-		}
+	toolResults, toolErr := a.runToolCalls(ctx, assistantMsg.ToolCalls())
+	switch {
+	case errors.Is(toolErr, permission.ErrorPermissionDenied):
+		a.finishMessage(ctx, &assistantMsg, message.FinishReasonPermissionDenied)
+	case toolErr != nil:
+		a.finishMessage(context.Background(), &assistantMsg, message.FinishReasonCanceled)
 	}
-out:
 	if len(toolResults) == 0 {
 		return assistantMsg, nil, nil
 	}
@@ -973,7 +1073,9 @@ func (a *agent) finishMessage(ctx context.Context, msg *message.Message, finishR
 	_ = a.messages.Update(ctx, *msg)
 }
 
-func (a *agent) processEvent(ctx context.Context, sessionID string, assistantMsg *message.Message, event provider.ProviderEvent) error {
+// processEvent applies event to assistantMsg and saves it. Streamed text is
+// appended as deltas, which are saved in batches.
+func (a *agent) processEvent(ctx context.Context, sessionID string, assistantMsg *message.Message, event provider.ProviderEvent, budget *budgetTracker) error {
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
@@ -983,23 +1085,14 @@ func (a *agent) processEvent(ctx context.Context, sessionID string, assistantMsg
 
 	switch event.Type {
 	case provider.EventThinkingDelta:
-		assistantMsg.AppendReasoningContent(event.Content)
-		return a.messages.Update(ctx, *assistantMsg)
+		return a.messages.AppendDelta(ctx, assistantMsg, message.Delta{Kind: message.DeltaReasoning, Text: event.Thinking})
 	case provider.EventContentDelta:
-		assistantMsg.AppendContent(event.Content)
-		return a.messages.Update(ctx, *assistantMsg)
+		return a.messages.AppendDelta(ctx, assistantMsg, message.Delta{Kind: message.DeltaContent, Text: event.Content})
 	case provider.EventToolUseStart:
 		assistantMsg.AddToolCall(*event.ToolCall)
 		return a.messages.Update(ctx, *assistantMsg)
-	// TODO: see how to handle this
-	// case provider.EventToolUseDelta:
-	// 	tm := time.Unix(assistantMsg.UpdatedAt, 0)
-	// 	assistantMsg.AppendToolCallInput(event.ToolCall.ID, event.ToolCall.Input)
-	// 	if time.Since(tm) > 1000*time.Millisecond {
-	// 		err := a.messages.Update(ctx, *assistantMsg)
-	// 		assistantMsg.UpdatedAt = time.Now().Unix()
-	// 		return err
-	// 	}
+	case provider.EventToolUseDelta:
+		return a.messages.AppendDelta(ctx, assistantMsg, message.Delta{Kind: message.DeltaToolInput, ToolCallID: event.ToolCall.ID, Text: event.ToolCall.Input})
 	case provider.EventToolUseStop:
 		assistantMsg.FinishToolCall(event.ToolCall.ID)
 		return a.messages.Update(ctx, *assistantMsg)
@@ -1010,32 +1103,49 @@ func (a *agent) processEvent(ctx context.Context, sessionID string, assistantMsg
 		}
 		logging.ErrorPersist(event.Error.Error())
 		return event.Error
+	case provider.EventWarning:
+		if event.Wait > 0 {
+			logging.WarnPersist(event.Warning, logging.PersistTimeArg, event.Wait)
+		} else {
+			logging.WarnPersist(event.Warning)
+		}
 	case provider.EventComplete:
+		model := answeredBy(a.provider, event.Response)
+		assistantMsg.Model = model.ID
 		assistantMsg.SetToolCalls(event.Response.ToolCalls)
 		assistantMsg.AddFinish(event.Response.FinishReason)
 		if err := a.messages.Update(ctx, *assistantMsg); err != nil {
 			return fmt.Errorf("failed to update message: %w", err)
 		}
-		return a.TrackUsage(ctx, sessionID, a.provider.Model(), event.Response.Usage)
+		budget.add(model, event.Response.Usage)
+		return a.TrackUsage(ctx, sessionID, assistantMsg.ID, model, event.Response.Usage)
 	}
 
 	return nil
 }
 
-func (a *agent) TrackUsage(ctx context.Context, sessionID string, model models.Model, usage provider.TokenUsage) error {
+// answeredBy returns the model that wrote the response, which is not the model
+// of p when p fell back to another one.
+func answeredBy(p provider.Provider, response *provider.ProviderResponse) models.Model {
+	if response.Model.ID != "" {
+		return response.Model
+	}
+	return p.Model()
+}
+
+// TrackUsage records a call made by the agent for messageID in the usage
+// ledger, which adds it to the session totals, and stores the size of the
+// conversation it was made with.
+func (a *agent) TrackUsage(ctx context.Context, sessionID, messageID string, model models.Model, tokenUsage provider.TokenUsage) error {
+	if err := a.recordUsage(ctx, sessionID, messageID, a.name, model, tokenUsage); err != nil {
+		return err
+	}
 	sess, err := a.sessions.Get(ctx, sessionID)
 	if err != nil {
 		return fmt.Errorf("failed to get session: %w", err)
 	}
 
-	cost := model.CostPer1MInCached/1e6*float64(usage.CacheCreationTokens) +
-		model.CostPer1MOutCached/1e6*float64(usage.CacheReadTokens) +
-		model.CostPer1MIn/1e6*float64(usage.InputTokens) +
-		model.CostPer1MOut/1e6*float64(usage.OutputTokens)
-
-	sess.Cost += cost
-	sess.CompletionTokens = usage.OutputTokens + usage.CacheReadTokens
-	sess.PromptTokens = usage.InputTokens + usage.CacheCreationTokens
+	sess.ContextTokens = tokenUsage.InputTokens + tokenUsage.CacheCreationTokens + tokenUsage.CacheReadTokens + tokenUsage.OutputTokens
 
 	_, err = a.sessions.Save(ctx, sess)
 	if err != nil {
@@ -1044,6 +1154,31 @@ func (a *agent) TrackUsage(ctx context.Context, sessionID string, model models.M
 	return nil
 }
 
+func (a *agent) recordUsage(ctx context.Context, sessionID, messageID string, agentName config.AgentName, model models.Model, tokenUsage provider.TokenUsage) error {
+	_, err := a.usage.Create(ctx, usage.CreateUsageParams{
+		SessionID:           sessionID,
+		MessageID:           messageID,
+		Model:               model,
+		Agent:               agentName,
+		InputTokens:         tokenUsage.InputTokens,
+		OutputTokens:        tokenUsage.OutputTokens,
+		CacheCreationTokens: tokenUsage.CacheCreationTokens,
+		CacheReadTokens:     tokenUsage.CacheReadTokens,
+		Cost:                usageCost(model, tokenUsage),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record usage: %w", err)
+	}
+	return nil
+}
+
+func usageCost(model models.Model, tokenUsage provider.TokenUsage) float64 {
+	return model.CostPer1MInCached/1e6*float64(tokenUsage.CacheCreationTokens) +
+		model.CostPer1MOutCached/1e6*float64(tokenUsage.CacheReadTokens) +
+		model.CostPer1MIn/1e6*float64(tokenUsage.InputTokens) +
+		model.CostPer1MOut/1e6*float64(tokenUsage.OutputTokens)
+}
+
 func (a *agent) Update(agentName config.AgentName, modelID models.ModelID) (models.Model, error) {
 	if a.IsBusy() {
 		return models.Model{}, fmt.Errorf("cannot change model while processing requests")
@@ -1053,7 +1188,7 @@ func (a *agent) Update(agentName config.AgentName, modelID models.ModelID) (mode
 		return models.Model{}, fmt.Errorf("failed to update config: %w", err)
 	}
 
-	provider, err := createAgentProvider(agentName)
+	provider, err := a.createProvider(a.planMode)
 	if err != nil {
 		return models.Model{}, fmt.Errorf("failed to create provider for model %s: %w", modelID, err)
 	}
@@ -1063,6 +1198,66 @@ func (a *agent) Update(agentName config.AgentName, modelID models.ModelID) (mode
 	return a.provider.Model(), nil
 }
 
+func (a *agent) SetPlanMode(enabled bool) error {
+	if enabled == a.planMode {
+		return nil
+	}
+	if enabled && a.planTools == nil {
+		return fmt.Errorf("the %s agent has no plan mode", a.name)
+	}
+	if a.IsBusy() {
+		return fmt.Errorf("cannot change mode while processing requests")
+	}
+
+	provider, err := a.createProvider(enabled)
+	if err != nil {
+		return err
+	}
+	a.provider = provider
+	a.planMode = enabled
+	a.tools = a.agentTools
+	if enabled {
+		a.tools = a.planTools
+	}
+	return nil
+}
+
+func (a *agent) PlanMode() bool {
+	return a.planMode
+}
+
+func (a *agent) SwitchAgent(agentName config.AgentName, agentTools []tools.BaseTool) error {
+	if a.IsBusy() {
+		return fmt.Errorf("cannot change agent while processing requests")
+	}
+	previousName := a.name
+	a.name = agentName
+	provider, err := a.createProvider(a.planMode)
+	if err != nil {
+		a.name = previousName
+		return err
+	}
+	a.provider = provider
+	a.agentTools = agentTools
+	if !a.planMode {
+		a.tools = agentTools
+	}
+	return nil
+}
+
+func (a *agent) AgentName() config.AgentName {
+	return a.name
+}
+
+// createProvider creates the provider of the agent, with the planning system
+// prompt in plan mode.
+func (a *agent) createProvider(planMode bool) (provider.Provider, error) {
+	if !planMode {
+		return createAgentProvider(a.name)
+	}
+	return newAgentProvider(a.name, prompt.GetPlanPrompt)
+}
+
 func (a *agent) Summarize(ctx context.Context, sessionID string) error {
 	if a.summarizeProvider == nil {
 		return fmt.Errorf("summarize provider not available")
@@ -1082,167 +1277,50 @@ func (a *agent) Summarize(ctx context.Context, sessionID string) error {
 	go func() {
 		defer a.activeRequests.Delete(sessionID + "-summarize")
 		defer cancel()
-		event := AgentEvent{
-			Type:     AgentEventTypeSummarize,
-			Progress: "Starting summarization...",
-		}
-
-		a.Publish(pubsub.CreatedEvent, event)
-		// Get all messages from the session
-		msgs, err := a.messages.List(summarizeCtx, sessionID)
-		if err != nil {
-			event = AgentEvent{
-				Type:  AgentEventTypeError,
-				Error: fmt.Errorf("failed to list messages: %w", err),
-				Done:  true,
-			}
-			a.Publish(pubsub.CreatedEvent, event)
-			return
-		}
-		summarizeCtx = context.WithValue(summarizeCtx, tools.SessionIDContextKey, sessionID)
-
-		if len(msgs) == 0 {
-			event = AgentEvent{
-				Type:  AgentEventTypeError,
-				Error: fmt.Errorf("no messages to summarize"),
-				Done:  true,
-			}
-			a.Publish(pubsub.CreatedEvent, event)
-			return
-		}
-
-		event = AgentEvent{
-			Type:     AgentEventTypeSummarize,
-			Progress: "Analyzing conversation...",
-		}
-		a.Publish(pubsub.CreatedEvent, event)
-
-		// Add a system message to guide the summarization
-		summarizePrompt := "Provide a detailed but concise summary of our conversation above. Focus on information that would be helpful for continuing the conversation, including what we did, what we're doing, which files we're working on, and what we're going to do next."
-
-		// Create a new message with the summarize prompt
-		promptMsg := message.Message{
-			Role:  message.User,
-			Parts: []message.ContentPart{message.TextContent{Text: summarizePrompt}},
-		}
-
-		// Append the prompt to the messages
-		msgsWithPrompt := append(msgs, promptMsg)
-
-		event = AgentEvent{
-			Type:     AgentEventTypeSummarize,
-			Progress: "Generating summary...",
-		}
-
-		a.Publish(pubsub.CreatedEvent, event)
-
-		// Send the messages to the summarize provider
-		response, err := a.summarizeProvider.SendMessages(
-			summarizeCtx,
-			msgsWithPrompt,
-			make([]tools.BaseTool, 0),
-		)
-		if err != nil {
-			event = AgentEvent{
-				Type:  AgentEventTypeError,
-				Error: fmt.Errorf("failed to summarize: %w", err),
-				Done:  true,
-			}
-			a.Publish(pubsub.CreatedEvent, event)
-			return
-		}
-
-		summary := strings.TrimSpace(response.Content)
-		if summary == "" {
-			event = AgentEvent{
-				Type:  AgentEventTypeError,
-				Error: fmt.Errorf("empty summary returned"),
-				Done:  true,
-			}
-			a.Publish(pubsub.CreatedEvent, event)
-			return
-		}
-		event = AgentEvent{
-			Type:     AgentEventTypeSummarize,
-			Progress: "Creating new session...",
-		}
-
-		a.Publish(pubsub.CreatedEvent, event)
-		oldSession, err := a.sessions.Get(summarizeCtx, sessionID)
-		if err != nil {
-			event = AgentEvent{
-				Type:  AgentEventTypeError,
-				Error: fmt.Errorf("failed to get session: %w", err),
-				Done:  true,
-			}
-
-			a.Publish(pubsub.CreatedEvent, event)
-			return
-		}
-		// Create a message in the new session with the summary
-		msg, err := a.messages.Create(summarizeCtx, oldSession.ID, message.CreateMessageParams{
-			Role: message.Assistant,
-			Parts: []message.ContentPart{
-				message.TextContent{Text: summary},
-				message.Finish{
-					Reason: message.FinishReasonEndTurn,
-					Time:   time.Now().Unix(),
-				},
-			},
-			Model: a.summarizeProvider.Model().ID,
-		})
-		if err != nil {
-			event = AgentEvent{
-				Type:  AgentEventTypeError,
-				Error: fmt.Errorf("failed to create summary message: %w", err),
-				Done:  true,
-			}
-
-			a.Publish(pubsub.CreatedEvent, event)
-			return
-		}
-		oldSession.SummaryMessageID = msg.ID
-		oldSession.CompletionTokens = response.Usage.OutputTokens
-		oldSession.PromptTokens = 0
-		model := a.summarizeProvider.Model()
-		usage := response.Usage
-		cost := model.CostPer1MInCached/1e6*float64(usage.CacheCreationTokens) +
-			model.CostPer1MOutCached/1e6*float64(usage.CacheReadTokens) +
-			model.CostPer1MIn/1e6*float64(usage.InputTokens) +
-			model.CostPer1MOut/1e6*float64(usage.OutputTokens)
-		oldSession.Cost += cost
-		_, err = a.sessions.Save(summarizeCtx, oldSession)
-		if err != nil {
-			event = AgentEvent{
+		if err := a.summarize(summarizeCtx, sessionID, nil); err != nil {
+			a.Publish(pubsub.CreatedEvent, AgentEvent{
 				Type:  AgentEventTypeError,
-				Error: fmt.Errorf("failed to save session: %w", err),
+				Error: err,
 				Done:  true,
-			}
-			a.Publish(pubsub.CreatedEvent, event)
-		}
-
-		event = AgentEvent{
-			Type:      AgentEventTypeSummarize,
-			SessionID: oldSession.ID,
-			Progress:  "Summary complete",
-			Done:      true,
+			})
 		}
-		a.Publish(pubsub.CreatedEvent, event)
-		// Send final success event with the new session ID
 	}()
 
 	return nil
 }
 
 func createAgentProvider(agentName config.AgentName) (provider.Provider, error) {
+	return newAgentProvider(agentName, func(p models.ModelProvider) string {
+		return prompt.GetAgentPrompt(agentName, p)
+	})
+}
+
+// newAgentProvider creates the provider for the model of the agent, with the
+// system prompt returned by systemPrompt for the provider of that model. When
+// the agent has fallback models, the provider falls back to them in order.
+func newAgentProvider(agentName config.AgentName, systemPrompt func(models.ModelProvider) string) (provider.Provider, error) {
 	cfg := config.Get()
 	agentConfig, ok := cfg.Agents[agentName]
 	if !ok {
 		return nil, fmt.Errorf("agent %s not found", agentName)
 	}
-	model, ok := models.SupportedModels[agentConfig.Model]
+	var providers []provider.Provider
+	for _, modelID := range append([]models.ModelID{agentConfig.Model}, agentConfig.Fallbacks...) {
+		modelProvider, err := newModelProvider(agentName, agentConfig, modelID, systemPrompt)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, modelProvider)
+	}
+	return provider.NewFallbackProvider(providers...), nil
+}
+
+// newModelProvider creates the provider for one of the models of the agent.
+func newModelProvider(agentName config.AgentName, agentConfig config.Agent, modelID models.ModelID, systemPrompt func(models.ModelProvider) string) (provider.Provider, error) {
+	cfg := config.Get()
+	model, ok := models.SupportedModels[modelID]
 	if !ok {
-		return nil, fmt.Errorf("model %s not supported", agentConfig.Model)
+		return nil, fmt.Errorf("model %s not supported", modelID)
 	}
 
 	providerCfg, ok := cfg.Providers[model.Provider]
@@ -1253,23 +1331,19 @@ func createAgentProvider(agentName config.AgentName) (provider.Provider, error)
 		return nil, fmt.Errorf("provider %s is not enabled", model.Provider)
 	}
 	maxTokens := model.DefaultMaxTokens
-	if agentConfig.MaxTokens > 0 {
+	// The max tokens set for the agent are meant for its main model
+	if agentConfig.MaxTokens > 0 && modelID == agentConfig.Model {
 		maxTokens = agentConfig.MaxTokens
 	}
 	opts := []provider.ProviderClientOption{
 		provider.WithAPIKey(providerCfg.APIKey),
 		provider.WithModel(model),
-		provider.WithSystemMessage(prompt.GetAgentPrompt(agentName, model.Provider)),
+		provider.WithSystemMessage(systemPrompt(model.Provider)),
 		provider.WithMaxTokens(maxTokens),
 	}
-	if model.Provider == models.ProviderOpenAI || model.Provider == models.ProviderLocal && model.CanReason {
-		opts = append(
-			opts,
-			provider.WithOpenAIOptions(
-				provider.WithReasoningEffort(agentConfig.ReasoningEffort),
-			),
-		)
-	} else if model.Provider == models.ProviderAnthropic && model.CanReason && agentName == config.AgentCoder {
+	opts = append(opts, retryOptions(model.Provider, providerCfg)...)
+	opts = append(opts, provider.WithSampling(agentSampling(agentConfig, modelID)))
+	if model.Provider == models.ProviderAnthropic && model.CanReason && agentName == config.AgentCoder {
 		opts = append(
 			opts,
 			provider.WithAnthropicOptions(
@@ -1287,3 +1361,43 @@ func createAgentProvider(agentName config.AgentName) (provider.Provider, error)
 
 	return agentProvider, nil
 }
+
+// agentSampling returns the sampling parameters of the agent for one of its
+// models. They are validated against its main model, so its fallbacks only
+// get the reasoning effort, which each client maps to what its model takes.
+func agentSampling(agentConfig config.Agent, modelID models.ModelID) provider.Sampling {
+	sampling := provider.Sampling{ReasoningEffort: agentConfig.ReasoningEffort}
+	if modelID != agentConfig.Model {
+		return sampling
+	}
+	sampling.Temperature = agentConfig.Temperature
+	sampling.TopP = agentConfig.TopP
+	sampling.Stop = agentConfig.Stop
+	sampling.BudgetTokens = agentConfig.Thinking.BudgetTokens
+	sampling.IncludeThoughts = agentConfig.Thinking.IncludeThoughts
+	return sampling
+}
+
+// retryOptions returns the options applying the retry policy and the rate
+// limits configured for providerName.
+func retryOptions(providerName models.ModelProvider, providerCfg config.Provider) []provider.ProviderClientOption {
+	retry := providerCfg.Retry
+	policy := provider.RetryPolicy{
+		MaxAttempts: retry.MaxAttempts,
+		BaseBackoff: time.Duration(retry.BaseBackoffMs) * time.Millisecond,
+		MaxBackoff:  time.Duration(retry.MaxBackoffMs) * time.Millisecond,
+		Jitter:      provider.DefaultRetryPolicy().Jitter,
+		RetryAfter:  !retry.IgnoreRetryAfter,
+		StatusCodes: retry.StatusCodes,
+	}
+	if retry.Jitter != nil {
+		policy.Jitter = *retry.Jitter
+	}
+	return []provider.ProviderClientOption{
+		provider.WithRetryPolicy(policy),
+		provider.WithRateLimit(providerName, provider.RateLimit{
+			RequestsPerMinute: providerCfg.RateLimit.RequestsPerMinute,
+			TokensPerMinute:   providerCfg.RateLimit.TokensPerMinute,
+		}),
+	}
+}
@@ -0,0 +1,244 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/db"
+	"github.com/opencode-ai/opencode/internal/history"
+	"github.com/opencode-ai/opencode/internal/llm/models"
+	"github.com/opencode-ai/opencode/internal/lsp"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/opencode-ai/opencode/internal/permission"
+	"github.com/opencode-ai/opencode/internal/pubsub"
+	"github.com/opencode-ai/opencode/internal/session"
+	"github.com/opencode-ai/opencode/internal/usage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAgentRunWithMockProvider runs the coder agent against a scripted model
+// in a temporary workspace, through its tools and permission requests.
+func TestAgentRunWithMockProvider(t *testing.T) {
+	workspace := loadMockConfig(t, `
+responses:
+  - system: "generate a short title"
+    content: Hello file
+  - match: "create hello.txt"
+    content: I will create it.
+    toolCalls:
+      - name: write
+        input: {file_path: hello.txt, content: "hello\n"}
+    usage: {inputTokens: 100, outputTokens: 20}
+  - match: "successfully"
+    deltas: ["Created ", "hello.txt."]
+    usage: {inputTokens: 150, outputTokens: 5}
+`)
+	conn, err := db.Connect()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx := context.Background()
+	q := db.New(conn)
+	sessions := session.NewService(q, conn)
+	messages := message.NewService(q)
+	files := history.NewService(q, conn)
+	usages := usage.NewService(q)
+	permissions := permission.NewPermissionService()
+	lspClients := map[string]*lsp.Client{}
+
+	coder, err := NewAgent(
+		config.AgentCoder,
+		sessions,
+		messages,
+		usages,
+		CoderAgentTools(permissions, sessions, messages, files, usages, lspClients),
+		PlanAgentTools(permissions, lspClients),
+	)
+	require.NoError(t, err)
+
+	// Grant the permission requests as the user would
+	requests := permissions.Subscribe(ctx)
+	granted := make(chan permission.PermissionRequest, 1)
+	go func() {
+		for event := range requests {
+			permissions.Grant(event.Payload)
+			granted <- event.Payload
+		}
+	}()
+
+	sess, err := sessions.Create(ctx, "New session")
+	require.NoError(t, err)
+	events, err := coder.Run(ctx, sess.ID, "create hello.txt")
+	require.NoError(t, err)
+	result := <-events
+	require.NoError(t, result.Error)
+	assert.Equal(t, "Created hello.txt.", result.Message.Content().String())
+
+	request := <-granted
+	assert.Equal(t, "write", request.ToolName)
+	content, err := os.ReadFile(filepath.Join(workspace, "hello.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", string(content))
+
+	msgs, err := messages.List(ctx, sess.ID)
+	require.NoError(t, err)
+	roles := make([]message.MessageRole, 0, len(msgs))
+	for _, msg := range msgs {
+		roles = append(roles, msg.Role)
+	}
+	assert.Equal(t, []message.MessageRole{message.User, message.Assistant, message.Tool, message.Assistant}, roles)
+	assert.Equal(t, "I will create it.", msgs[1].Content().String())
+	assert.False(t, msgs[2].ToolResults()[0].IsError)
+
+	sess, err = sessions.Get(ctx, sess.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(250), sess.PromptTokens)
+	assert.Equal(t, int64(25), sess.CompletionTokens)
+}
+
+func TestRunFromFailureKeepsHistory(t *testing.T) {
+	conn, err := db.ConnectDir(t.TempDir())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx := context.Background()
+	q := db.New(conn)
+	sessions := session.NewService(q, conn)
+	messages := message.NewService(q)
+	a := &agent{Broker: pubsub.NewBroker[AgentEvent](), sessions: sessions, messages: messages}
+
+	sess, err := sessions.Create(ctx, "session")
+	require.NoError(t, err)
+	var ids []string
+	for _, role := range []message.MessageRole{message.User, message.Assistant, message.User, message.Assistant} {
+		msg, err := messages.Create(ctx, sess.ID, message.CreateMessageParams{Role: role, Parts: []message.ContentPart{message.TextContent{Text: string(role)}}})
+		require.NoError(t, err)
+		ids = append(ids, msg.ID)
+	}
+	assertHistory := func() {
+		t.Helper()
+		msgs, err := messages.List(ctx, sess.ID)
+		require.NoError(t, err)
+		assert.Len(t, msgs, len(ids))
+	}
+
+	// The session is claimed by another run
+	_, cancel := context.WithCancel(ctx)
+	defer cancel()
+	a.activeRequests.Store(sess.ID, &activeRun{cancel: cancel})
+	_, err = a.RunFrom(ctx, sess.ID, ids[2], "again")
+	assert.ErrorIs(t, err, ErrSessionBusy)
+	assertHistory()
+	a.activeRequests.Delete(sess.ID)
+
+	// The rewind fails and releases the session
+	_, err = a.RunFrom(ctx, sess.ID, ids[1], "again")
+	assert.ErrorContains(t, err, "is not a user message")
+	assertHistory()
+	assert.False(t, a.IsSessionBusy(sess.ID))
+}
+
+func TestRunAfterCancel(t *testing.T) {
+	a, sessions, messages := newMockCoder(t, `
+responses:
+  - system: "generate a short title"
+    content: Cancel
+  - match: "^first$"
+    deltas: ["o", "n", "e"]
+    delayMs: 200
+  - match: "^second$"
+    deltas: ["t", "w", "o"]
+    delayMs: 200
+`)
+	ctx := context.Background()
+	sess, err := sessions.Create(ctx, "cancel")
+	require.NoError(t, err)
+
+	first, err := a.Run(ctx, sess.ID, "first")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return len(texts(t, messages, sess.ID, message.Assistant)) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	a.Cancel(sess.ID)
+	// The second run claims the session before the first one is done
+	second, err := a.Run(ctx, sess.ID, "second")
+	require.NoError(t, err)
+	result := <-first
+	assert.ErrorIs(t, result.Error, ErrRequestCancelled)
+
+	// and keeps it once the first one is done
+	assert.True(t, a.IsSessionBusy(sess.ID))
+	_, err = a.Run(ctx, sess.ID, "third")
+	assert.ErrorIs(t, err, ErrSessionBusy)
+	a.Cancel(sess.ID)
+	result = <-second
+	assert.ErrorIs(t, result.Error, ErrRequestCancelled)
+	assert.False(t, a.IsSessionBusy(sess.ID))
+}
+
+// loadMockConfig loads the config of a temporary workspace, in which every
+// agent runs on the mock provider following script, and returns the
+// workspace. The reviewer agent is a custom agent.
+func loadMockConfig(t *testing.T, script string) string {
+	t.Helper()
+	workspace := t.TempDir()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	require.NoError(t, os.WriteFile(filepath.Join(workspace, "script.yaml"), []byte(script), 0o644))
+	agents := map[string]any{}
+	for _, name := range []config.AgentName{config.AgentCoder, config.AgentTitle, config.AgentSummarizer, config.AgentTask, "reviewer"} {
+		agents[string(name)] = map[string]any{"model": "__mock"}
+	}
+	cfgData, err := json.Marshal(map[string]any{
+		"data":      map[string]any{"directory": filepath.Join(workspace, ".opencode")},
+		"providers": map[string]any{"__mock": map[string]any{"script": "script.yaml"}},
+		"agents":    agents,
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(workspace, ".opencode.json"), cfgData, 0o644))
+
+	cfg, err := config.Load(workspace, false)
+	require.NoError(t, err)
+	// The config is loaded once per process, point it at this workspace
+	cfg.WorkingDir = workspace
+	cfg.Data.Directory = filepath.Join(workspace, ".opencode")
+	mockCfg := cfg.Providers[models.ProviderMock]
+	mockCfg.Script = filepath.Join(workspace, "script.yaml")
+	cfg.Providers[models.ProviderMock] = mockCfg
+	return workspace
+}
+
+// newMockCoder creates a coder agent that answers from script, with the
+// services of a temporary database.
+func newMockCoder(t *testing.T, script string) (*agent, session.Service, message.Service) {
+	t.Helper()
+	loadMockConfig(t, script)
+	conn, err := db.ConnectDir(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	q := db.New(conn)
+	sessions := session.NewService(q, conn)
+	messages := message.NewService(q)
+	files := history.NewService(q, conn)
+	usages := usage.NewService(q)
+	permissions := permission.NewPermissionService()
+	lspClients := map[string]*lsp.Client{}
+	coder, err := NewAgent(
+		config.AgentCoder,
+		sessions,
+		messages,
+		usages,
+		CoderAgentTools(permissions, sessions, messages, files, usages, lspClients),
+		nil,
+	)
+	require.NoError(t, err)
+	return coder.(*agent), sessions, messages
+}
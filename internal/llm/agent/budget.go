@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/llm/models"
+	"github.com/opencode-ai/opencode/internal/llm/provider"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/opencode-ai/opencode/internal/session"
+)
+
+// ErrBudgetExceeded is returned when a run stops because it reached one of
+// the configured budgets.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+type budgetUsage struct {
+	cost       float64
+	tokens     int64
+	iterations int
+}
+
+// budgetTracker keeps count of what a run and the session it belongs to have
+// spent so far and checks it against the configured budgets. The calls that
+// compact the session count like the others. The title of a new session is
+// generated on the side of its first run: it is left out of that run and
+// counts toward the session from the next run on.
+//
+// The run of a sub-agent launched by the agent tool has a parent: the run
+// that launched it. What the sub-agent spends counts toward the parent as
+// well, and the sub-agent stops once the parent reaches its budgets.
+type budgetTracker struct {
+	// mu guards session and run, which the sub-agents of the run update
+	// side by side.
+	mu      sync.Mutex
+	limits  config.Budgets
+	session budgetUsage
+	run     budgetUsage
+	parent  *budgetTracker
+	// lastTokens is the size of the conversation as of the last call of the
+	// run, as reported by the provider.
+	lastTokens int64
+}
+
+type budgetContextKey struct{}
+
+// withBudget returns a context carrying the budget of the run, for the
+// sub-agents launched with it.
+func withBudget(ctx context.Context, b *budgetTracker) context.Context {
+	return context.WithValue(ctx, budgetContextKey{}, b)
+}
+
+// budgetFromContext returns the budget of the run that launched the current
+// one, or nil.
+func budgetFromContext(ctx context.Context) *budgetTracker {
+	b, _ := ctx.Value(budgetContextKey{}).(*budgetTracker)
+	return b
+}
+
+// newBudgetTracker starts tracking a run of sess, msgs being the messages of
+// the session before the run. The parent of the run is set by the caller.
+func newBudgetTracker(sess session.Session, msgs []message.Message) *budgetTracker {
+	b := &budgetTracker{
+		session: budgetUsage{
+			cost:   sess.Cost,
+			tokens: sess.PromptTokens + sess.CompletionTokens,
+		},
+	}
+	if cfg := config.Get(); cfg != nil {
+		b.limits = cfg.Budgets
+	}
+	for _, msg := range msgs {
+		if msg.Role == message.Assistant {
+			b.session.iterations++
+		}
+	}
+	return b
+}
+
+// add records a single model call of the conversation.
+func (b *budgetTracker) add(model models.Model, usage provider.TokenUsage) {
+	b.lastTokens = b.spend(model, usage)
+}
+
+// spend records a single model call and returns the tokens it took. Unlike
+// add, the size of the conversation is left as is, for the calls that are not
+// part of it.
+func (b *budgetTracker) spend(model models.Model, usage provider.TokenUsage) int64 {
+	cost := usageCost(model, usage)
+	tokens := usage.InputTokens + usage.OutputTokens + usage.CacheCreationTokens + usage.CacheReadTokens
+	for t := b; t != nil; t = t.parent {
+		t.mu.Lock()
+		for _, u := range []*budgetUsage{&t.session, &t.run} {
+			u.cost += cost
+			u.tokens += tokens
+			u.iterations++
+		}
+		t.mu.Unlock()
+	}
+	return tokens
+}
+
+// check returns an error wrapping ErrBudgetExceeded when another model call
+// would go over one of the budgets, of the run or of the runs that launched
+// it.
+func (b *budgetTracker) check() error {
+	prefix := ""
+	for t := b; t != nil; t = t.parent {
+		t.mu.Lock()
+		err := checkBudget(prefix+"session", t.limits.Session, t.session)
+		if err == nil {
+			err = checkBudget(prefix+"run", t.limits.Run, t.run)
+		}
+		t.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		prefix = "parent " + prefix
+	}
+	return nil
+}
+
+func checkBudget(scope string, limit config.Budget, used budgetUsage) error {
+	switch {
+	case limit.MaxCost > 0 && used.cost >= limit.MaxCost:
+		return fmt.Errorf("%w: %s cost of $%.2f reached the limit of $%.2f", ErrBudgetExceeded, scope, used.cost, limit.MaxCost)
+	case limit.MaxTokens > 0 && used.tokens >= limit.MaxTokens:
+		return fmt.Errorf("%w: %s used %d tokens, the limit is %d", ErrBudgetExceeded, scope, used.tokens, limit.MaxTokens)
+	case limit.MaxIterations > 0 && used.iterations >= limit.MaxIterations:
+		return fmt.Errorf("%w: %s made %d model calls, the limit is %d", ErrBudgetExceeded, scope, used.iterations, limit.MaxIterations)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/llm/models"
+	"github.com/opencode-ai/opencode/internal/llm/provider"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/opencode-ai/opencode/internal/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setBudgets sets the budgets of the loaded config for the duration of the
+// test.
+func setBudgets(t *testing.T, budgets config.Budgets) {
+	cfg := config.Get()
+	previous := cfg.Budgets
+	cfg.Budgets = budgets
+	t.Cleanup(func() { cfg.Budgets = previous })
+}
+
+func TestBudgetTracker(t *testing.T) {
+	// A dollar per million input or output tokens
+	model := models.Model{CostPer1MIn: 1, CostPer1MOut: 1}
+	call := provider.TokenUsage{InputTokens: 400_000, OutputTokens: 100_000}
+
+	tests := []struct {
+		name    string
+		limits  config.Budgets
+		session budgetUsage
+		calls   int
+		want    string
+	}{
+		{name: "no limits", calls: 10},
+		{name: "under the limits", limits: config.Budgets{Run: config.Budget{MaxCost: 2, MaxTokens: 2_000_000, MaxIterations: 3}}, calls: 2},
+		{name: "run cost", limits: config.Budgets{Run: config.Budget{MaxCost: 0.9}}, calls: 2, want: "run cost of $1.00 reached the limit of $0.90"},
+		{name: "run tokens", limits: config.Budgets{Run: config.Budget{MaxTokens: 1_500_000}}, calls: 3, want: "run used 1500000 tokens, the limit is 1500000"},
+		{name: "run iterations", limits: config.Budgets{Run: config.Budget{MaxIterations: 2}}, calls: 2, want: "run made 2 model calls, the limit is 2"},
+		{
+			name:    "session cost",
+			limits:  config.Budgets{Session: config.Budget{MaxCost: 5}},
+			session: budgetUsage{cost: 4.5},
+			calls:   1,
+			want:    "session cost of $5.00 reached the limit of $5.00",
+		},
+		{
+			name:    "session iterations",
+			limits:  config.Budgets{Session: config.Budget{MaxIterations: 10}, Run: config.Budget{MaxIterations: 5}},
+			session: budgetUsage{iterations: 9},
+			calls:   1,
+			want:    "session made 10 model calls, the limit is 10",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &budgetTracker{limits: tt.limits, session: tt.session}
+			for range tt.calls {
+				require.NoError(t, b.check())
+				b.add(model, call)
+			}
+			err := b.check()
+			if tt.want == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrBudgetExceeded)
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
+
+func TestBudgetTrackerSpend(t *testing.T) {
+	b := &budgetTracker{}
+	b.add(models.Model{}, provider.TokenUsage{InputTokens: 100, OutputTokens: 10})
+	// Compacting does not change the size of the conversation
+	b.spend(models.Model{}, provider.TokenUsage{InputTokens: 1000, OutputTokens: 20})
+	assert.Equal(t, int64(110), b.lastTokens)
+	assert.Equal(t, budgetUsage{tokens: 1130, iterations: 2}, b.run)
+	assert.Equal(t, budgetUsage{tokens: 1130, iterations: 2}, b.session)
+}
+
+func TestNewBudgetTracker(t *testing.T) {
+	loadMockConfig(t, "responses: []")
+	limits := config.Budgets{Session: config.Budget{MaxCost: 5}}
+	setBudgets(t, limits)
+
+	sess := session.Session{Cost: 1.5, PromptTokens: 1000, CompletionTokens: 200}
+	msgs := []message.Message{{Role: message.User}, {Role: message.Assistant}, {Role: message.Tool}, {Role: message.Assistant}}
+	b := newBudgetTracker(sess, msgs)
+	assert.Equal(t, limits, b.limits)
+	assert.Equal(t, budgetUsage{cost: 1.5, tokens: 1200, iterations: 2}, b.session)
+	assert.Equal(t, budgetUsage{}, b.run)
+}
+
+func TestRunBudget(t *testing.T) {
+	a, sessions, messages := newMockCoder(t, `
+responses:
+  - system: "generate a short title"
+    content: Loop
+  - toolCalls: [{name: ls, input: {path: "."}}]
+  - toolCalls: [{name: ls, input: {path: "."}}]
+  - toolCalls: [{name: ls, input: {path: "."}}]
+  - content: Done.
+`)
+	setBudgets(t, config.Budgets{Run: config.Budget{MaxIterations: 2}})
+
+	ctx := context.Background()
+	sess, err := sessions.Create(ctx, "budget")
+	require.NoError(t, err)
+	events, err := a.Run(ctx, sess.ID, "loop")
+	require.NoError(t, err)
+	result := <-events
+	assert.ErrorIs(t, result.Error, ErrBudgetExceeded)
+	assert.Equal(t, message.FinishReasonBudgetExceeded, result.Message.FinishReason())
+
+	msgs, err := messages.List(ctx, sess.ID)
+	require.NoError(t, err)
+	var calls int
+	for _, msg := range msgs {
+		if msg.Role == message.Assistant {
+			calls++
+		}
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestRunBudgetCountsCompaction(t *testing.T) {
+	a, sessions, _ := newMockCoder(t, `
+responses:
+  - system: "generate a short title"
+    content: Tree
+  - match: "show the tree"
+    toolCalls: [{name: ls, input: {path: "."}}]
+    usage: {inputTokens: 120000, outputTokens: 10}
+  - system: "tasked with summarizing"
+    content: The user asked to see the tree.
+    usage: {inputTokens: 1000, outputTokens: 20}
+  - match: "asked to see the tree"
+    content: Done after the summary.
+`)
+	setAutoCompactThreshold(t, 0.5)
+	// The first call fits, the compaction call does not
+	setBudgets(t, config.Budgets{Run: config.Budget{MaxTokens: 121_000}})
+
+	ctx := context.Background()
+	sess, err := sessions.Create(ctx, "budget")
+	require.NoError(t, err)
+	events, err := a.Run(ctx, sess.ID, "show the tree")
+	require.NoError(t, err)
+	result := <-events
+	assert.ErrorIs(t, result.Error, ErrBudgetExceeded)
+	assert.ErrorContains(t, result.Error, "run used 121030 tokens")
+}
+
+func TestRunBudgetCountsSubAgents(t *testing.T) {
+	a, sessions, messages := newMockCoder(t, `
+responses:
+  - system: "generate a short title"
+    content: Delegate
+  - match: "^delegate$"
+    toolCalls: [{name: agent, input: {prompt: "search twice"}}]
+    usage: {inputTokens: 100, outputTokens: 10}
+  - match: "^search twice$"
+    toolCalls: [{name: ls, input: {path: "."}}]
+    usage: {inputTokens: 450}
+  - toolCalls: [{name: ls, input: {path: "."}}]
+    usage: {inputTokens: 450}
+  - content: Done.
+`)
+	// The sub-agent stays under its own run budget, not under the one of
+	// the run that launched it
+	setBudgets(t, config.Budgets{Run: config.Budget{MaxTokens: 1000}})
+
+	ctx := context.Background()
+	sess, err := sessions.Create(ctx, "budget")
+	require.NoError(t, err)
+	events, err := a.Run(ctx, sess.ID, "delegate")
+	require.NoError(t, err)
+	result := <-events
+	assert.ErrorIs(t, result.Error, ErrBudgetExceeded)
+	assert.ErrorContains(t, result.Error, "run used 1010 tokens")
+
+	msgs, err := messages.List(ctx, sess.ID)
+	require.NoError(t, err)
+	require.Len(t, msgs, 3)
+	results := msgs[2].ToolResults()
+	require.Len(t, results, 1)
+	assert.True(t, results[0].IsError)
+	assert.Contains(t, results[0].Content, "parent run used 1010 tokens, the limit is 1000")
+
+	task, err := sessions.Get(ctx, msgs[1].ToolCalls()[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(900), task.PromptTokens)
+}
@@ -0,0 +1,214 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/llm/tools"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/opencode-ai/opencode/internal/pubsub"
+	"github.com/opencode-ai/opencode/internal/session"
+)
+
+// buildHistory returns the conversation sent to the model for the messages
+// of sess. Once the session is compacted it starts with the summary, as a user
+// message, followed by the messages kept verbatim and the ones after the
+// summary.
+func buildHistory(msgs []message.Message, sess session.Session) []message.Message {
+	if sess.SummaryMessageID == "" {
+		return msgs
+	}
+	summaryIdx := slices.IndexFunc(msgs, func(m message.Message) bool { return m.ID == sess.SummaryMessageID })
+	if summaryIdx == -1 {
+		return msgs
+	}
+	summary := msgs[summaryIdx]
+	summary.Role = message.User
+	history := []message.Message{summary}
+	if sess.SummaryKeepMessageID != "" {
+		keepIdx := slices.IndexFunc(msgs[:summaryIdx], func(m message.Message) bool { return m.ID == sess.SummaryKeepMessageID })
+		if keepIdx != -1 {
+			history = append(history, msgs[keepIdx:summaryIdx]...)
+		}
+	}
+	return append(history, msgs[summaryIdx+1:]...)
+}
+
+// autoCompactLimit returns the prompt size at which the session is compacted
+// before calling the model, or 0 when automatic compaction is off.
+func (a *agent) autoCompactLimit() int64 {
+	cfg := config.Get()
+	if cfg == nil || !cfg.AutoCompact || a.summarizeProvider == nil {
+		return 0
+	}
+	return int64(float64(a.provider.Model().ContextWindow) * cfg.AutoCompactThreshold)
+}
+
+// needsCompaction tells whether the next prompt, built from msgHistory, is
+// close enough to the context window to compact the session first. The size
+// reported for the previous call of the run, lastTokens, is preferred over
+// the estimate when it is larger, as estimates are only approximate.
+func (a *agent) needsCompaction(msgHistory []message.Message, lastTokens int64, newMsgs []message.Message) bool {
+	limit := a.autoCompactLimit()
+	if limit <= 0 || len(msgHistory) < 2 {
+		return false
+	}
+	tokens := message.EstimateTokens(pruneToolResults(msgHistory))
+	if lastTokens > 0 {
+		tokens = max(tokens, lastTokens+message.EstimateTokens(newMsgs))
+	}
+	return tokens >= limit
+}
+
+// keepFrom returns the index of the first message of msgs that is kept
+// verbatim when compacting, or len(msgs) when everything is summarized. Only
+// whole turns after the current summary are kept, and only as long as they
+// take up at most half of the automatic compaction limit, otherwise the
+// session would have to be compacted again right away.
+func (a *agent) keepFrom(msgs []message.Message, sess session.Session) int {
+	keepTurns := 0
+	if cfg := config.Get(); cfg != nil {
+		keepTurns = cfg.AutoCompactKeepTurns
+	}
+	start := 0
+	if sess.SummaryMessageID != "" {
+		start = slices.IndexFunc(msgs, func(m message.Message) bool { return m.ID == sess.SummaryMessageID }) + 1
+	}
+
+	var turns []int
+	for i := max(start, 1); i < len(msgs); i++ {
+		// Interjected messages are part of the turn they were sent in
+		if msgs[i].Role == message.User && !msgs[i].IsInterjection() {
+			turns = append(turns, i)
+		}
+	}
+	keepTurns = min(keepTurns, len(turns))
+
+	limit := a.autoCompactLimit()
+	if limit <= 0 {
+		limit = a.provider.Model().ContextWindow
+	}
+	for ; keepTurns > 0; keepTurns-- {
+		idx := turns[len(turns)-keepTurns]
+		if limit <= 0 || message.EstimateTokens(msgs[idx:]) <= limit/2 {
+			return idx
+		}
+	}
+	return len(msgs)
+}
+
+// summarize compacts the session: the conversation, except for the last turns
+// kept verbatim, is replaced with a summary written by the summarizer. The
+// progress is published as summarize events. The call is recorded in budget
+// when compacting during a run.
+func (a *agent) summarize(ctx context.Context, sessionID string, budget *budgetTracker) error {
+	a.Publish(pubsub.CreatedEvent, AgentEvent{
+		Type:     AgentEventTypeSummarize,
+		Progress: "Starting summarization...",
+	})
+
+	msgs, err := a.messages.List(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to list messages: %w", err)
+	}
+	if len(msgs) == 0 {
+		return fmt.Errorf("no messages to summarize")
+	}
+	sess, err := a.sessions.Get(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	ctx = context.WithValue(ctx, tools.SessionIDContextKey, sessionID)
+
+	keepIdx := a.keepFrom(msgs, sess)
+	summarized := buildHistory(msgs[:keepIdx], sess)
+	kept := msgs[keepIdx:]
+
+	a.Publish(pubsub.CreatedEvent, AgentEvent{
+		Type:     AgentEventTypeSummarize,
+		Progress: "Analyzing conversation...",
+	})
+
+	// Add a system message to guide the summarization
+	summarizePrompt := "Provide a detailed but concise summary of our conversation above. Focus on information that would be helpful for continuing the conversation, including what we did, what we're doing, which files we're working on, and what we're going to do next."
+	if len(kept) > 0 {
+		summarizePrompt += " The most recent messages will be kept as they are, so focus on what came before them."
+	}
+	promptMsg := message.Message{
+		Role:  message.User,
+		Parts: []message.ContentPart{message.TextContent{Text: summarizePrompt}},
+	}
+
+	a.Publish(pubsub.CreatedEvent, AgentEvent{
+		Type:     AgentEventTypeSummarize,
+		Progress: "Generating summary...",
+	})
+
+	response, err := a.summarizeProvider.SendMessages(
+		ctx,
+		append(slices.Clip(pruneToolResults(summarized)), promptMsg),
+		make([]tools.BaseTool, 0),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to summarize: %w", err)
+	}
+
+	summary := strings.TrimSpace(response.Content)
+	if summary == "" {
+		return fmt.Errorf("empty summary returned")
+	}
+
+	a.Publish(pubsub.CreatedEvent, AgentEvent{
+		Type:     AgentEventTypeSummarize,
+		Progress: "Creating new session...",
+	})
+
+	msg, err := a.messages.Create(ctx, sessionID, message.CreateMessageParams{
+		Role: message.Assistant,
+		Parts: []message.ContentPart{
+			message.TextContent{Text: summary},
+			message.Finish{
+				Reason: message.FinishReasonEndTurn,
+				Time:   time.Now().Unix(),
+			},
+		},
+		Model: answeredBy(a.summarizeProvider, response).ID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create summary message: %w", err)
+	}
+	if err := a.recordUsage(ctx, sessionID, msg.ID, config.AgentSummarizer, answeredBy(a.summarizeProvider, response), response.Usage); err != nil {
+		return err
+	}
+	if budget != nil {
+		budget.spend(answeredBy(a.summarizeProvider, response), response.Usage)
+	}
+
+	// The session may have been updated while the summary was generated
+	sess, err = a.sessions.Get(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	sess.SummaryMessageID = msg.ID
+	sess.SummaryKeepMessageID = ""
+	if len(kept) > 0 {
+		sess.SummaryKeepMessageID = kept[0].ID
+	}
+	// From now on the conversation starts with the summary
+	sess.ContextTokens = response.Usage.OutputTokens + message.EstimateTokens(kept)
+	if _, err := a.sessions.Save(ctx, sess); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+
+	a.Publish(pubsub.CreatedEvent, AgentEvent{
+		Type:      AgentEventTypeSummarize,
+		SessionID: sessionID,
+		Progress:  "Summary complete",
+		Done:      true,
+	})
+	return nil
+}
@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/llm/models"
+	"github.com/opencode-ai/opencode/internal/llm/provider"
+	"github.com/opencode-ai/opencode/internal/llm/tools"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/opencode-ai/opencode/internal/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubProvider is a provider that only has a model.
+type stubProvider struct {
+	model models.Model
+}
+
+func (p stubProvider) SendMessages(context.Context, []message.Message, []tools.BaseTool) (*provider.ProviderResponse, error) {
+	return &provider.ProviderResponse{}, nil
+}
+
+func (p stubProvider) StreamResponse(context.Context, []message.Message, []tools.BaseTool) <-chan provider.ProviderEvent {
+	events := make(chan provider.ProviderEvent)
+	close(events)
+	return events
+}
+
+func (p stubProvider) Model() models.Model {
+	return p.model
+}
+
+// setAutoCompactThreshold sets the compaction threshold of the loaded config
+// for the duration of the test.
+func setAutoCompactThreshold(t *testing.T, threshold float64) {
+	cfg := config.Get()
+	previous := cfg.AutoCompactThreshold
+	cfg.AutoCompactThreshold = threshold
+	t.Cleanup(func() { cfg.AutoCompactThreshold = previous })
+}
+
+func TestNeedsCompaction(t *testing.T) {
+	loadMockConfig(t, "responses: []")
+	setAutoCompactThreshold(t, 0.5)
+
+	p := stubProvider{models.Model{ContextWindow: 1000}}
+	a := &agent{provider: p, summarizeProvider: p}
+	text := func(tokens int) message.Message {
+		return message.Message{Role: message.User, Parts: []message.ContentPart{message.TextContent{Text: strings.Repeat("abcd", tokens)}}}
+	}
+
+	tests := []struct {
+		name       string
+		history    []message.Message
+		lastTokens int64
+		newMsgs    []message.Message
+		want       bool
+	}{
+		{name: "below the threshold", history: []message.Message{text(200), text(200)}, want: false},
+		{name: "estimate over the threshold", history: []message.Message{text(300), text(300)}, want: true},
+		{name: "reported size over the threshold", history: []message.Message{text(10), text(10)}, lastTokens: 450, newMsgs: []message.Message{text(60)}, want: true},
+		{name: "reported size below the threshold", history: []message.Message{text(10), text(10)}, lastTokens: 400, newMsgs: []message.Message{text(60)}, want: false},
+		{name: "single message", history: []message.Message{text(900)}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, a.needsCompaction(tt.history, tt.lastTokens, tt.newMsgs))
+		})
+	}
+
+	cfg := config.Get()
+	cfg.AutoCompact = false
+	defer func() { cfg.AutoCompact = true }()
+	assert.False(t, a.needsCompaction([]message.Message{text(900), text(900)}, 0, nil))
+}
+
+// runCompaction runs a prompt whose first call reports 120k of the 200k
+// tokens of the mock model, with the compaction threshold at half the
+// context window, and returns the result and the session.
+func runCompaction(t *testing.T, summarizer string) (AgentEvent, session.Session, []message.Message) {
+	coder, sessions, messages := newMockCoder(t, `
+responses:
+  - system: "generate a short title"
+    content: Tree
+  - match: "show the tree"
+    toolCalls:
+      - name: ls
+        input: {path: "."}
+    usage: {inputTokens: 120000, outputTokens: 10}
+`+summarizer+`
+  - match: "asked to see the tree"
+    content: Done after the summary.
+  - match: "script.yaml"
+    content: Done without a summary.
+`)
+	setAutoCompactThreshold(t, 0.5)
+
+	ctx := context.Background()
+	sess, err := sessions.Create(ctx, "compaction")
+	require.NoError(t, err)
+	events, err := coder.Run(ctx, sess.ID, "show the tree")
+	require.NoError(t, err)
+	result := <-events
+
+	sess, err = sessions.Get(ctx, sess.ID)
+	require.NoError(t, err)
+	msgs, err := messages.List(ctx, sess.ID)
+	require.NoError(t, err)
+	return result, sess, msgs
+}
+
+func TestAutoCompaction(t *testing.T) {
+	result, sess, msgs := runCompaction(t, `
+  - system: "tasked with summarizing"
+    content: The user asked to see the tree.
+    usage: {inputTokens: 1000, outputTokens: 20}
+`)
+	require.NoError(t, result.Error)
+	assert.Equal(t, "Done after the summary.", result.Message.Content().String())
+
+	// The summary replaces the history sent to the model from then on
+	require.NotEmpty(t, sess.SummaryMessageID)
+	roles := make([]message.MessageRole, 0, len(msgs))
+	for _, msg := range msgs {
+		roles = append(roles, msg.Role)
+	}
+	assert.Equal(t, []message.MessageRole{message.User, message.Assistant, message.Tool, message.Assistant, message.Assistant}, roles)
+	assert.Equal(t, sess.SummaryMessageID, msgs[3].ID)
+	assert.Equal(t, "The user asked to see the tree.", msgs[3].Content().String())
+	assert.Empty(t, sess.SummaryKeepMessageID)
+	built := buildHistory(msgs, sess)
+	require.Len(t, built, 2)
+	assert.Equal(t, message.User, built[0].Role)
+	assert.Equal(t, msgs[3].ID, built[0].ID)
+}
+
+func TestAutoCompactionFailure(t *testing.T) {
+	result, sess, msgs := runCompaction(t, `
+  - system: "tasked with summarizing"
+    error: invalid request
+    status: 400
+`)
+	// The run goes on with the whole history
+	require.NoError(t, result.Error)
+	assert.Equal(t, "Done without a summary.", result.Message.Content().String())
+	assert.Empty(t, sess.SummaryMessageID)
+	assert.Len(t, msgs, 4)
+}
+
+func TestKeepFromInterjection(t *testing.T) {
+	loadMockConfig(t, "responses: []")
+	cfg := config.Get()
+	previous := cfg.AutoCompactKeepTurns
+	cfg.AutoCompactKeepTurns = 1
+	t.Cleanup(func() { cfg.AutoCompactKeepTurns = previous })
+
+	p := stubProvider{models.Model{ContextWindow: 200000}}
+	a := &agent{provider: p, summarizeProvider: p}
+	text := func(role message.MessageRole, parts ...message.ContentPart) message.Message {
+		return message.Message{Role: role, Parts: append(parts, message.TextContent{Text: string(role)})}
+	}
+	msgs := []message.Message{
+		text(message.User),
+		text(message.Assistant),
+		text(message.User),
+		text(message.Assistant),
+		text(message.Tool),
+		// The last turn starts with its prompt, not with the interjection
+		text(message.User, message.Interjection{}),
+		text(message.Assistant),
+	}
+	assert.Equal(t, 2, a.keepFrom(msgs, session.Session{}))
+}
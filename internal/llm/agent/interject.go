@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/opencode-ai/opencode/internal/message"
+)
+
+// Interject adds a user message to the current run of the session without
+// cancelling it. The message is sent to the model with the results of the
+// tool calls in progress. When the run ends before that, it runs as the next
+// prompt of the session instead.
+func (a *agent) Interject(ctx context.Context, sessionID string, content string, attachments ...message.Attachment) error {
+	a.queueMu.Lock()
+	defer a.queueMu.Unlock()
+	if !a.IsSessionBusy(sessionID) {
+		return ErrSessionIdle
+	}
+	if !a.provider.Model().SupportsAttachments {
+		attachments = nil
+	}
+	if a.interjections == nil {
+		a.interjections = make(map[string][]QueuedPrompt)
+	}
+	a.interjections[sessionID] = append(a.interjections[sessionID], QueuedPrompt{
+		ID:           uuid.New().String(),
+		Content:      content,
+		Attachments:  attachments,
+		Interjection: true,
+		ctx:          ctx,
+	})
+	a.publishQueue(sessionID)
+	return nil
+}
+
+// takeInterjections stores the messages interjected in the session as user
+// messages marked as interjections and returns them, in the order they were
+// sent.
+func (a *agent) takeInterjections(ctx context.Context, sessionID string) ([]message.Message, error) {
+	a.queueMu.Lock()
+	pending := a.interjections[sessionID]
+	delete(a.interjections, sessionID)
+	a.queueMu.Unlock()
+	if len(pending) > 0 {
+		a.publishQueue(sessionID)
+	}
+
+	var msgs []message.Message
+	for _, p := range pending {
+		parts := append([]message.ContentPart{message.Interjection{}}, attachmentParts(p.Attachments)...)
+		msg, err := a.createUserMessage(ctx, sessionID, p.Content, parts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create user message: %w", err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// queueInterjections moves the messages interjected too late for the run that
+// just finished to the front of the queue. The caller holds queueMu.
+func (a *agent) queueInterjections(sessionID string) {
+	pending := a.interjections[sessionID]
+	if len(pending) == 0 {
+		return
+	}
+	delete(a.interjections, sessionID)
+	for i := range pending {
+		pending[i].Interjection = false
+	}
+	if a.queues == nil {
+		a.queues = make(map[string][]QueuedPrompt)
+	}
+	a.queues[sessionID] = append(slices.Clip(pending), a.queues[sessionID]...)
+}
+
+func attachmentParts(attachments []message.Attachment) []message.ContentPart {
+	var parts []message.ContentPart
+	for _, attachment := range attachments {
+		parts = append(parts, message.BinaryContent{Path: attachment.FilePath, MIMEType: attachment.MimeType, Data: attachment.Content})
+	}
+	return parts
+}
@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterject(t *testing.T) {
+	a, sessions, messages := newMockCoder(t, `
+responses:
+  - system: "generate a short title"
+    content: Steer
+  - match: "^first$"
+    toolCalls: [{name: ls, input: {path: "."}}]
+    delayMs: 200
+  - match: "^steer$"
+    content: steered
+`)
+	ctx := context.Background()
+	sess, err := sessions.Create(ctx, "interject")
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, a.Interject(ctx, sess.ID, "steer"), ErrSessionIdle)
+	events, err := a.Run(ctx, sess.ID, "first")
+	require.NoError(t, err)
+	require.NoError(t, a.Interject(ctx, sess.ID, "steer"))
+
+	// The message shows with the queue until the run reads it
+	queue := a.Queue(sess.ID)
+	require.Len(t, queue, 1)
+	assert.Equal(t, "steer", queue[0].Content)
+	assert.True(t, queue[0].Interjection)
+	assert.False(t, a.QueueHeld(sess.ID))
+
+	result := <-events
+	require.NoError(t, result.Error)
+	assert.Equal(t, "steered", result.Message.Content().String())
+	assert.Empty(t, a.Queue(sess.ID))
+
+	// It follows the tool results, as a user message of the same run
+	msgs, err := messages.List(ctx, sess.ID)
+	require.NoError(t, err)
+	roles := make([]message.MessageRole, 0, len(msgs))
+	for _, msg := range msgs {
+		roles = append(roles, msg.Role)
+	}
+	assert.Equal(t, []message.MessageRole{message.User, message.Assistant, message.Tool, message.User, message.Assistant}, roles)
+	assert.Equal(t, "steer", msgs[3].Content().String())
+	assert.True(t, msgs[3].IsInterjection())
+}
+
+func TestInterjectAfterRun(t *testing.T) {
+	a, sessions, messages := newMockCoder(t, `
+responses:
+  - system: "generate a short title"
+    content: Steer
+  - match: "^first$"
+    content: one
+    delayMs: 200
+  - match: "^late$"
+    content: two
+`)
+	ctx := context.Background()
+	sess, err := sessions.Create(ctx, "interject")
+	require.NoError(t, err)
+
+	_, err = a.Enqueue(ctx, sess.ID, "first")
+	require.NoError(t, err)
+	require.NoError(t, a.Interject(ctx, sess.ID, "late"))
+
+	// The run ends without tool calls, the message is sent as the next prompt
+	waitIdle(t, a, sess.ID)
+	assert.Equal(t, []string{"first", "late"}, texts(t, messages, sess.ID, message.User))
+	assert.Equal(t, []string{"one", "two"}, texts(t, messages, sess.ID, message.Assistant))
+}
+
+func TestInterjectUpdateAndRemove(t *testing.T) {
+	a, sessions, messages := newMockCoder(t, `
+responses:
+  - system: "generate a short title"
+    content: Steer
+  - match: "^first$"
+    toolCalls: [{name: ls, input: {path: "."}}]
+    delayMs: 200
+  - match: "^edited$"
+    content: edited
+`)
+	ctx := context.Background()
+	sess, err := sessions.Create(ctx, "interject")
+	require.NoError(t, err)
+
+	events, err := a.Run(ctx, sess.ID, "first")
+	require.NoError(t, err)
+	require.NoError(t, a.Interject(ctx, sess.ID, "draft"))
+	require.NoError(t, a.Interject(ctx, sess.ID, "dropped"))
+	queue := a.Queue(sess.ID)
+	require.Len(t, queue, 2)
+	assert.True(t, a.UpdateQueued(sess.ID, queue[0].ID, "edited"))
+	a.RemoveQueued(sess.ID, queue[1].ID)
+
+	result := <-events
+	require.NoError(t, result.Error)
+	assert.Equal(t, "edited", result.Message.Content().String())
+	assert.Equal(t, []string{"first", "edited"}, texts(t, messages, sess.ID, message.User))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
 
 	"github.com/opencode-ai/opencode/internal/config"
 	"github.com/opencode-ai/opencode/internal/llm/tools"
@@ -41,6 +42,12 @@ func (b *mcpTool) Info() tools.ToolInfo {
 	}
 }
 
+// readOnly tells whether the tool is configured as one that does not change
+// anything.
+func (b *mcpTool) readOnly() bool {
+	return slices.Contains(b.mcpConfig.ReadOnlyTools, b.tool.Name)
+}
+
 func runTool(ctx context.Context, c MCPClient, toolName string, input string) (tools.ToolResponse, error) {
 	defer c.Close()
 	initRequest := mcp.InitializeRequest{}
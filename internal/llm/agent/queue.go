@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/opencode-ai/opencode/internal/logging"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/opencode-ai/opencode/internal/pubsub"
+)
+
+// QueuedPrompt is a prompt sent while its session was busy. It is run once
+// the runs before it are done.
+type QueuedPrompt struct {
+	ID          string
+	Content     string
+	Attachments []message.Attachment
+	// Interjection is set while the prompt waits to be sent to the current
+	// run of the session, rather than run after it.
+	Interjection bool
+
+	ctx context.Context
+}
+
+// Enqueue runs the prompt right away when the session is idle, otherwise it
+// is queued and run after the current run finishes. It reports whether the
+// prompt was queued.
+func (a *agent) Enqueue(ctx context.Context, sessionID string, content string, attachments ...message.Attachment) (bool, error) {
+	a.queueMu.Lock()
+	defer a.queueMu.Unlock()
+	if !a.IsSessionBusy(sessionID) && len(a.queues[sessionID]) == 0 {
+		_, err := a.Run(ctx, sessionID, content, attachments...)
+		return false, err
+	}
+	if a.queues == nil {
+		a.queues = make(map[string][]QueuedPrompt)
+	}
+	a.queues[sessionID] = append(a.queues[sessionID], QueuedPrompt{
+		ID:          uuid.New().String(),
+		Content:     content,
+		Attachments: attachments,
+		ctx:         ctx,
+	})
+	// The queue was held after a failed run
+	if !a.IsSessionBusy(sessionID) {
+		a.runQueued(sessionID)
+	}
+	a.publishQueue(sessionID)
+	return true, nil
+}
+
+// Queue returns the prompts waiting for the session, in the order they run:
+// the messages interjected in the current run first.
+func (a *agent) Queue(sessionID string) []QueuedPrompt {
+	a.queueMu.Lock()
+	defer a.queueMu.Unlock()
+	return append(slices.Clone(a.interjections[sessionID]), a.queues[sessionID]...)
+}
+
+// QueueHeld tells whether the prompts queued for the session are held after a
+// failed run.
+func (a *agent) QueueHeld(sessionID string) bool {
+	a.queueMu.Lock()
+	defer a.queueMu.Unlock()
+	return len(a.queues[sessionID]) > 0 && !a.IsSessionBusy(sessionID)
+}
+
+// UpdateQueued replaces the content of a queued prompt, or of a message not
+// yet sent to the current run. It returns false when the prompt is no longer
+// waiting.
+func (a *agent) UpdateQueued(sessionID, promptID, content string, attachments ...message.Attachment) bool {
+	a.queueMu.Lock()
+	defer a.queueMu.Unlock()
+	for _, prompts := range []map[string][]QueuedPrompt{a.interjections, a.queues} {
+		idx := slices.IndexFunc(prompts[sessionID], func(p QueuedPrompt) bool { return p.ID == promptID })
+		if idx == -1 {
+			continue
+		}
+		prompts[sessionID][idx].Content = content
+		prompts[sessionID][idx].Attachments = attachments
+		a.publishQueue(sessionID)
+		return true
+	}
+	return false
+}
+
+// RemoveQueued drops a prompt from the queue, or a message not yet sent to the
+// current run.
+func (a *agent) RemoveQueued(sessionID, promptID string) {
+	a.queueMu.Lock()
+	defer a.queueMu.Unlock()
+	for _, prompts := range []map[string][]QueuedPrompt{a.interjections, a.queues} {
+		remaining := slices.DeleteFunc(prompts[sessionID], func(p QueuedPrompt) bool { return p.ID == promptID })
+		if len(remaining) == 0 {
+			delete(prompts, sessionID)
+		} else {
+			prompts[sessionID] = remaining
+		}
+	}
+	a.publishQueue(sessionID)
+}
+
+// ClearQueue drops every prompt queued for the session, and the messages
+// interjected in its current run.
+func (a *agent) ClearQueue(sessionID string) {
+	a.queueMu.Lock()
+	defer a.queueMu.Unlock()
+	delete(a.queues, sessionID)
+	delete(a.interjections, sessionID)
+	a.publishQueue(sessionID)
+}
+
+// runFinished is called when a run of the session is done. The next queued
+// prompt is run, unless the run failed: the queue is then held until the
+// session runs again, so the same error does not fail every queued prompt.
+// Messages interjected too late for the run are queued first. A run that was
+// cancelled and replaced by another one leaves the session and its queue to
+// the new run.
+func (a *agent) runFinished(sessionID string, claim *activeRun, result AgentEvent) {
+	a.queueMu.Lock()
+	defer a.queueMu.Unlock()
+	if !a.activeRequests.CompareAndDelete(sessionID, claim) {
+		_, replaced := a.activeRequests.Load(sessionID)
+		if replaced {
+			return
+		}
+	}
+	if len(a.interjections[sessionID]) > 0 {
+		a.queueInterjections(sessionID)
+		a.publishQueue(sessionID)
+	}
+	if result.Error != nil && !errors.Is(result.Error, ErrRequestCancelled) && !errors.Is(result.Error, context.Canceled) {
+		if len(a.queues[sessionID]) > 0 {
+			a.publishQueue(sessionID)
+		}
+		return
+	}
+	a.runQueued(sessionID)
+}
+
+// runQueued runs the first prompt queued for the session. The caller holds
+// queueMu.
+func (a *agent) runQueued(sessionID string) {
+	queue := a.queues[sessionID]
+	if len(queue) == 0 {
+		return
+	}
+	next := queue[0]
+	events, err := a.Run(next.ctx, sessionID, next.Content, next.Attachments...)
+	if err != nil {
+		// The session was started outside of the queue, the prompt runs
+		// after that run instead
+		if !errors.Is(err, ErrSessionBusy) {
+			logging.ErrorPersist(fmt.Sprintf("failed to run queued prompt: %v", err))
+		}
+		return
+	}
+	// Nobody waits for the result of a queued prompt
+	go func() {
+		for range events {
+		}
+	}()
+	if len(queue) == 1 {
+		delete(a.queues, sessionID)
+	} else {
+		a.queues[sessionID] = queue[1:]
+	}
+	a.publishQueue(sessionID)
+}
+
+func (a *agent) publishQueue(sessionID string) {
+	a.Publish(pubsub.CreatedEvent, AgentEvent{
+		Type:      AgentEventTypeQueue,
+		SessionID: sessionID,
+	})
+}
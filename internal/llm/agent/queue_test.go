@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// queueScript answers the prompts "second" and "third". Each test scripts the
+// answer to "first", which takes long enough for the others to be queued.
+const queueScript = `
+responses:
+  - system: "generate a short title"
+    content: Queue
+  - match: "^second$"
+    content: two
+  - match: "^third$"
+    content: three
+`
+
+// waitIdle waits for the session to be done with its run and its queue.
+func waitIdle(t *testing.T, a *agent, sessionID string) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		return !a.IsSessionBusy(sessionID) && len(a.Queue(sessionID)) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// texts returns the text of the messages of the session with role.
+func texts(t *testing.T, messages message.Service, sessionID string, role message.MessageRole) []string {
+	t.Helper()
+	msgs, err := messages.List(context.Background(), sessionID)
+	require.NoError(t, err)
+	var texts []string
+	for _, msg := range msgs {
+		if msg.Role == role {
+			texts = append(texts, msg.Content().String())
+		}
+	}
+	return texts
+}
+
+func TestEnqueue(t *testing.T) {
+	a, sessions, messages := newMockCoder(t, queueScript+`
+  - match: "^first$"
+    content: one
+    delayMs: 100
+`)
+	ctx := context.Background()
+	sess, err := sessions.Create(ctx, "queue")
+	require.NoError(t, err)
+
+	queued, err := a.Enqueue(ctx, sess.ID, "first")
+	require.NoError(t, err)
+	assert.False(t, queued)
+	for _, content := range []string{"second", "third"} {
+		queued, err = a.Enqueue(ctx, sess.ID, content)
+		require.NoError(t, err)
+		assert.True(t, queued)
+	}
+	queue := a.Queue(sess.ID)
+	require.Len(t, queue, 2)
+	assert.Equal(t, "second", queue[0].Content)
+	assert.Equal(t, "third", queue[1].Content)
+	assert.False(t, a.QueueHeld(sess.ID))
+
+	waitIdle(t, a, sess.ID)
+	assert.Equal(t, []string{"first", "second", "third"}, texts(t, messages, sess.ID, message.User))
+	assert.Equal(t, []string{"one", "two", "three"}, texts(t, messages, sess.ID, message.Assistant))
+}
+
+func TestEnqueueAfterCancel(t *testing.T) {
+	a, sessions, messages := newMockCoder(t, queueScript+`
+  - match: "^first$"
+    deltas: ["o", "n", "e"]
+    delayMs: 1000
+`)
+	ctx := context.Background()
+	sess, err := sessions.Create(ctx, "queue")
+	require.NoError(t, err)
+
+	_, err = a.Enqueue(ctx, sess.ID, "first")
+	require.NoError(t, err)
+	queued, err := a.Enqueue(ctx, sess.ID, "second")
+	require.NoError(t, err)
+	require.True(t, queued)
+
+	require.Eventually(t, func() bool {
+		return len(texts(t, messages, sess.ID, message.Assistant)) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	// The next prompt runs once the current run is cancelled
+	a.Cancel(sess.ID)
+	waitIdle(t, a, sess.ID)
+	assert.Equal(t, []string{"first", "second"}, texts(t, messages, sess.ID, message.User))
+	assert.Contains(t, texts(t, messages, sess.ID, message.Assistant), "two")
+}
+
+func TestEnqueueAfterFailure(t *testing.T) {
+	a, sessions, messages := newMockCoder(t, queueScript+`
+  - match: "^first$"
+    error: invalid request
+    status: 400
+    delayMs: 100
+`)
+	ctx := context.Background()
+	sess, err := sessions.Create(ctx, "queue")
+	require.NoError(t, err)
+
+	_, err = a.Enqueue(ctx, sess.ID, "first")
+	require.NoError(t, err)
+	queued, err := a.Enqueue(ctx, sess.ID, "second")
+	require.NoError(t, err)
+	require.True(t, queued)
+
+	// The queue is held after the failed run
+	require.Eventually(t, func() bool { return !a.IsSessionBusy(sess.ID) }, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, a.QueueHeld(sess.ID))
+	require.Len(t, a.Queue(sess.ID), 1)
+	assert.Equal(t, []string{"first"}, texts(t, messages, sess.ID, message.User))
+
+	// and runs again, before the new prompt, once a prompt is sent
+	queued, err = a.Enqueue(ctx, sess.ID, "third")
+	require.NoError(t, err)
+	assert.True(t, queued)
+	waitIdle(t, a, sess.ID)
+	assert.False(t, a.QueueHeld(sess.ID))
+	assert.Equal(t, []string{"first", "second", "third"}, texts(t, messages, sess.ID, message.User))
+	assert.Equal(t, []string{"two", "three"}, texts(t, messages, sess.ID, message.Assistant)[1:])
+}
@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/message"
+)
+
+const elidedToolResult = "[output elided, re-run tool to see (%d characters)]"
+
+// pruneToolResults returns the conversation sent to the model with the
+// outputs of earlier tool calls elided according to the configured rules.
+func pruneToolResults(msgs []message.Message) []message.Message {
+	cfg := config.Get()
+	if cfg == nil || !cfg.ToolResultPruning.Enabled {
+		return msgs
+	}
+	return elideToolResults(msgs, cfg.ToolResultPruning)
+}
+
+// elideToolResults replaces the content of the tool results that are older or
+// larger than the rule of their tool allows with a short placeholder. The age
+// of a result is the number of user prompts that come after it, so results of
+// the current turn are never elided. Messages interjected in a run are part of
+// its turn and do not count. msgs is left untouched.
+func elideToolResults(msgs []message.Message, pruning config.ToolResultPruning) []message.Message {
+	var pruned []message.Message
+	age := 0
+	for i := len(msgs) - 1; i >= 0; i-- {
+		msg := msgs[i]
+		if msg.Role == message.User {
+			if !msg.IsInterjection() {
+				age++
+			}
+			continue
+		}
+		if age == 0 || msg.Role != message.Tool {
+			continue
+		}
+		var parts []message.ContentPart
+		for j, part := range msg.Parts {
+			result, ok := part.(message.ToolResult)
+			if !ok || !shouldElide(result, pruning.Rule(result.Name), age) {
+				continue
+			}
+			if parts == nil {
+				parts = append([]message.ContentPart(nil), msg.Parts...)
+			}
+			result.Content = fmt.Sprintf(elidedToolResult, len(result.Content))
+			result.Metadata = ""
+			parts[j] = result
+		}
+		if parts == nil {
+			continue
+		}
+		if pruned == nil {
+			pruned = append([]message.Message(nil), msgs...)
+		}
+		msg.Parts = parts
+		pruned[i] = msg
+	}
+	if pruned == nil {
+		return msgs
+	}
+	return pruned
+}
+
+func shouldElide(result message.ToolResult, rule config.ToolResultRule, age int) bool {
+	// Eliding short outputs would not save anything
+	if len(result.Content) <= len(elidedToolResult)+10 {
+		return false
+	}
+	if rule.MaxAge > 0 && age >= rule.MaxAge {
+		return true
+	}
+	return rule.MaxLength > 0 && len(result.Content) > rule.MaxLength
+}
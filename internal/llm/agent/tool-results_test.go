@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestElideToolResults(t *testing.T) {
+	t.Parallel()
+
+	output := strings.Repeat("x", 200)
+	turn := func(toolName, content string) []message.Message {
+		return []message.Message{
+			{Role: message.User, Parts: []message.ContentPart{message.TextContent{Text: "prompt"}}},
+			{Role: message.Assistant, Parts: []message.ContentPart{message.ToolCall{ID: "call", Name: toolName}}},
+			{Role: message.Tool, Parts: []message.ContentPart{message.ToolResult{ToolCallID: "call", Name: toolName, Content: content}}},
+		}
+	}
+	content := func(msg message.Message) string {
+		return msg.ToolResults()[0].Content
+	}
+
+	var msgs []message.Message
+	msgs = append(msgs, turn("view", output)...)
+	msgs = append(msgs, turn("bash", output)...)
+	msgs = append(msgs, turn("view", output+output)...)
+	msgs = append(msgs, turn("view", output+output)...)
+
+	pruning := config.ToolResultPruning{
+		Enabled: true,
+		Default: config.ToolResultRule{MaxLength: 300},
+		Tools: map[string]config.ToolResultRule{
+			"view": {MaxAge: 3},
+		},
+	}
+	pruned := elideToolResults(msgs, pruning)
+	require.Len(t, pruned, len(msgs))
+
+	assert.Contains(t, content(pruned[2]), "output elided", "view result three turns old")
+	assert.Equal(t, output, content(pruned[5]), "bash result under the default max length")
+	assert.Equal(t, output+output, content(pruned[8]), "view result has no max length")
+	assert.Equal(t, output+output, content(pruned[11]), "result of the current turn")
+
+	assert.Equal(t, output, content(msgs[2]), "original messages are untouched")
+
+	t.Run("short outputs are kept", func(t *testing.T) {
+		t.Parallel()
+		msgs := append(turn("view", "ok"), turn("view", "ok")...)
+		pruned := elideToolResults(msgs, config.ToolResultPruning{Enabled: true, Default: config.ToolResultRule{MaxAge: 1}})
+		assert.Equal(t, "ok", content(pruned[2]))
+	})
+
+	t.Run("interjections are part of the turn", func(t *testing.T) {
+		t.Parallel()
+		pruning := config.ToolResultPruning{Enabled: true, Default: config.ToolResultRule{MaxAge: 1}}
+		interjection := message.Message{Role: message.User, Parts: []message.ContentPart{message.Interjection{}, message.TextContent{Text: "steer"}}}
+		msgs := append(turn("view", output), interjection)
+		pruned := elideToolResults(msgs, pruning)
+		assert.Equal(t, output, content(pruned[2]), "result of the current turn")
+
+		msgs = append(msgs, turn("view", output)...)
+		pruned = elideToolResults(msgs, pruning)
+		assert.Contains(t, content(pruned[2]), "output elided", "result of the previous turn")
+	})
+}
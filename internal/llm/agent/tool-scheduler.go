@@ -0,0 +1,201 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/llm/tools"
+	"github.com/opencode-ai/opencode/internal/logging"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/opencode-ai/opencode/internal/permission"
+)
+
+// parallelSafeTools are the tools that never modify the workspace and never
+// ask the user for permission, so calls to them can run side by side. Any
+// other tool runs on its own, in the order the model requested it.
+var parallelSafeTools = map[string]bool{
+	tools.GlobToolName:        true,
+	tools.GrepToolName:        true,
+	tools.LSToolName:          true,
+	tools.SourcegraphToolName: true,
+	tools.ViewToolName:        true,
+	tools.DiagnosticsToolName: true,
+	AgentToolName:             true,
+}
+
+// isParallelSafe tells whether the call can run side by side with others.
+// Calls of the agent tool are only when they launch a new read-only task
+// agent: other agents may have tools that change the workspace, and a session
+// continued twice at once would get mixed up.
+func isParallelSafe(call message.ToolCall) bool {
+	if call.Name == AgentToolName {
+		var params AgentParams
+		if json.Unmarshal([]byte(call.Input), &params) != nil || params.Agent != "" || params.SessionID != "" {
+			return false
+		}
+		profile := config.AgentProfile(params.Profile)
+		if profile == "" {
+			profile = config.TaskProfiles()[0]
+		}
+		return profile == config.ProfileReadOnly
+	}
+	return parallelSafeTools[call.Name]
+}
+
+const toolCanceledContent = "Tool execution canceled by user"
+
+// runToolCalls executes the tool calls of a single assistant message and
+// returns one result per call, in the same order as the calls.
+//
+// Consecutive parallel-safe calls are grouped into a batch and run
+// concurrently, up to the configured tool concurrency. Every other call waits
+// for the running batch to finish and then runs alone. When the context is
+// cancelled or a permission request is denied, the calls that have not
+// started yet are marked as cancelled and ErrorPermissionDenied is returned
+// for the latter.
+func (a *agent) runToolCalls(ctx context.Context, toolCalls []message.ToolCall) ([]message.ToolResult, error) {
+	results := make([]message.ToolResult, len(toolCalls))
+
+	limit := 1
+	if cfg := config.Get(); cfg != nil && cfg.ToolConcurrency > 1 {
+		limit = cfg.ToolConcurrency
+	}
+
+	for start := 0; start < len(toolCalls); {
+		end := start + 1
+		if isParallelSafe(toolCalls[start]) {
+			for end < len(toolCalls) && isParallelSafe(toolCalls[end]) {
+				end++
+			}
+		}
+
+		if ctx.Err() != nil {
+			cancelToolCalls(results, toolCalls, start)
+			return results, ctx.Err()
+		}
+
+		var (
+			wg     sync.WaitGroup
+			mu     sync.Mutex
+			denied bool
+		)
+		sem := make(chan struct{}, limit)
+		for i := start; i < end; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer logging.RecoverPanic("agent.runToolCalls", func() {
+					results[i] = message.ToolResult{
+						ToolCallID: toolCalls[i].ID,
+						Name:       toolCalls[i].Name,
+						Content:    fmt.Sprintf("Tool %s panicked", toolCalls[i].Name),
+						IsError:    true,
+					}
+				})
+
+				select {
+				case sem <- struct{}{}:
+					defer func() { <-sem }()
+				case <-ctx.Done():
+					results[i] = canceledToolResult(toolCalls[i])
+					return
+				}
+
+				result, err := a.runToolCall(ctx, toolCalls[i])
+				results[i] = result
+				if errors.Is(err, permission.ErrorPermissionDenied) {
+					mu.Lock()
+					denied = true
+					mu.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		if denied {
+			cancelToolCalls(results, toolCalls, end)
+			return results, permission.ErrorPermissionDenied
+		}
+		start = end
+	}
+
+	if ctx.Err() != nil {
+		return results, ctx.Err()
+	}
+	return results, nil
+}
+
+// runToolCall runs a single tool call. The returned error is only set when
+// the call should stop the remaining ones, i.e. on a permission denial.
+func (a *agent) runToolCall(ctx context.Context, toolCall message.ToolCall) (message.ToolResult, error) {
+	if ctx.Err() != nil {
+		return canceledToolResult(toolCall), nil
+	}
+
+	var tool tools.BaseTool
+	for _, availableTool := range a.tools {
+		if availableTool.Info().Name == toolCall.Name {
+			tool = availableTool
+			break
+		}
+	}
+	if tool == nil {
+		return message.ToolResult{
+			ToolCallID: toolCall.ID,
+			Name:       toolCall.Name,
+			Content:    fmt.Sprintf("Tool not found: %s", toolCall.Name),
+			IsError:    true,
+		}, nil
+	}
+
+	toolResult, toolErr := tool.Run(ctx, tools.ToolCall{
+		ID:    toolCall.ID,
+		Name:  toolCall.Name,
+		Input: toolCall.Input,
+	})
+	if toolErr != nil {
+		if errors.Is(toolErr, permission.ErrorPermissionDenied) {
+			return message.ToolResult{
+				ToolCallID: toolCall.ID,
+				Name:       toolCall.Name,
+				Content:    "Permission denied",
+				IsError:    true,
+			}, toolErr
+		}
+		if errors.Is(toolErr, context.Canceled) {
+			return canceledToolResult(toolCall), nil
+		}
+		return message.ToolResult{
+			ToolCallID: toolCall.ID,
+			Name:       toolCall.Name,
+			Content:    toolErr.Error(),
+			IsError:    true,
+		}, nil
+	}
+	return message.ToolResult{
+		ToolCallID: toolCall.ID,
+		Name:       toolCall.Name,
+		Content:    toolResult.Content,
+		Metadata:   toolResult.Metadata,
+		IsError:    toolResult.IsError,
+	}, nil
+}
+
+func canceledToolResult(toolCall message.ToolCall) message.ToolResult {
+	return message.ToolResult{
+		ToolCallID: toolCall.ID,
+		Name:       toolCall.Name,
+		Content:    toolCanceledContent,
+		IsError:    true,
+	}
+}
+
+func cancelToolCalls(results []message.ToolResult, toolCalls []message.ToolCall, from int) {
+	for i := from; i < len(toolCalls); i++ {
+		results[i] = canceledToolResult(toolCalls[i])
+	}
+}
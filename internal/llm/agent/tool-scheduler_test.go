@@ -0,0 +1,268 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/llm/tools"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/opencode-ai/opencode/internal/permission"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubTool answers every call with the input it was given, after running
+// before when set.
+type stubTool struct {
+	name   string
+	before func(ctx context.Context, call tools.ToolCall) error
+}
+
+func (s stubTool) Info() tools.ToolInfo {
+	return tools.ToolInfo{Name: s.name}
+}
+
+func (s stubTool) Run(ctx context.Context, call tools.ToolCall) (tools.ToolResponse, error) {
+	if s.before != nil {
+		if err := s.before(ctx, call); err != nil {
+			return tools.ToolResponse{}, err
+		}
+	}
+	return tools.NewTextResponse(call.Input), nil
+}
+
+// setToolConcurrency sets the tool concurrency of the loaded config for the
+// duration of the test.
+func setToolConcurrency(t *testing.T, limit int) {
+	loadMockConfig(t, "responses: []")
+	cfg := config.Get()
+	previous := cfg.ToolConcurrency
+	cfg.ToolConcurrency = limit
+	t.Cleanup(func() { cfg.ToolConcurrency = previous })
+}
+
+func toolCall(name, input string) message.ToolCall {
+	return message.ToolCall{ID: "call_" + input, Name: name, Input: input}
+}
+
+func TestRunToolCalls(t *testing.T) {
+	setToolConcurrency(t, 4)
+
+	tests := []struct {
+		name  string
+		calls []message.ToolCall
+		// denied fails the permission request of the calls with that input,
+		// cancel cancels the context while running them.
+		denied string
+		cancel string
+		want   []string
+		ran    []string
+		err    error
+	}{
+		{
+			name: "mixed calls",
+			calls: []message.ToolCall{
+				toolCall(tools.ViewToolName, "a"),
+				toolCall(tools.GrepToolName, "b"),
+				toolCall(tools.WriteToolName, "c"),
+				toolCall(tools.ViewToolName, "d"),
+			},
+			want: []string{"a", "b", "c", "d"},
+			ran:  []string{"a", "b", "c", "d"},
+		},
+		{
+			name: "unknown tool",
+			calls: []message.ToolCall{
+				toolCall("missing", "a"),
+				toolCall(tools.ViewToolName, "b"),
+			},
+			want: []string{"Tool not found: missing", "b"},
+			ran:  []string{"b"},
+		},
+		{
+			name: "permission denied",
+			calls: []message.ToolCall{
+				toolCall(tools.ViewToolName, "a"),
+				toolCall(tools.BashToolName, "b"),
+				toolCall(tools.WriteToolName, "c"),
+				toolCall(tools.ViewToolName, "d"),
+			},
+			denied: "b",
+			want:   []string{"a", "Permission denied", toolCanceledContent, toolCanceledContent},
+			ran:    []string{"a", "b"},
+			err:    permission.ErrorPermissionDenied,
+		},
+		{
+			name: "context cancelled",
+			calls: []message.ToolCall{
+				toolCall(tools.ViewToolName, "a"),
+				toolCall(tools.WriteToolName, "b"),
+				toolCall(tools.ViewToolName, "c"),
+			},
+			cancel: "b",
+			want:   []string{"a", toolCanceledContent, toolCanceledContent},
+			ran:    []string{"a", "b"},
+			err:    context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var (
+				mu  sync.Mutex
+				ran []string
+			)
+			before := func(ctx context.Context, call tools.ToolCall) error {
+				mu.Lock()
+				ran = append(ran, call.Input)
+				mu.Unlock()
+				switch call.Input {
+				case tt.denied:
+					return permission.ErrorPermissionDenied
+				case tt.cancel:
+					cancel()
+					return ctx.Err()
+				}
+				return nil
+			}
+			a := &agent{}
+			for _, name := range []string{tools.ViewToolName, tools.GrepToolName, tools.WriteToolName, tools.BashToolName} {
+				a.tools = append(a.tools, stubTool{name: name, before: before})
+			}
+
+			results, err := a.runToolCalls(ctx, tt.calls)
+			assert.ErrorIs(t, err, tt.err)
+			require.Len(t, results, len(tt.calls))
+			contents := make([]string, len(results))
+			for i, result := range results {
+				assert.Equal(t, tt.calls[i].ID, result.ToolCallID)
+				contents[i] = result.Content
+			}
+			assert.Equal(t, tt.want, contents)
+			assert.ElementsMatch(t, tt.ran, ran)
+		})
+	}
+}
+
+func TestIsParallelSafe(t *testing.T) {
+	loadMockConfig(t, "responses: []")
+
+	tests := []struct {
+		call message.ToolCall
+		want bool
+	}{
+		{call: toolCall(tools.ViewToolName, `{"file_path":"a"}`), want: true},
+		{call: toolCall(tools.WriteToolName, `{"file_path":"a"}`), want: false},
+		{call: toolCall(AgentToolName, `{"prompt":"search"}`), want: true},
+		// User-defined agents may change the workspace
+		{call: toolCall(AgentToolName, `{"prompt":"review","agent":"reviewer"}`), want: false},
+		{call: toolCall(AgentToolName, `{`), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.call.Name+" "+tt.call.Input, func(t *testing.T) {
+			assert.Equal(t, tt.want, isParallelSafe(tt.call))
+		})
+	}
+}
+
+func TestRunToolCallsOrder(t *testing.T) {
+	setToolConcurrency(t, 4)
+
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	record := func(event string) {
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}
+	before := func(ctx context.Context, call tools.ToolCall) error {
+		record("start " + call.Input)
+		// The first calls of a batch finish last
+		if call.Input == "a" || call.Input == "d" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		record("end " + call.Input)
+		return nil
+	}
+	a := &agent{tools: []tools.BaseTool{
+		stubTool{name: tools.ViewToolName, before: before},
+		stubTool{name: tools.WriteToolName, before: before},
+	}}
+	calls := []message.ToolCall{
+		toolCall(tools.ViewToolName, "a"),
+		toolCall(tools.ViewToolName, "b"),
+		toolCall(tools.WriteToolName, "c"),
+		toolCall(tools.ViewToolName, "d"),
+		toolCall(tools.ViewToolName, "e"),
+	}
+	results, err := a.runToolCalls(context.Background(), calls)
+	require.NoError(t, err)
+	for i, result := range results {
+		assert.Equal(t, calls[i].Input, result.Content)
+	}
+
+	at := func(event string) int {
+		for i, e := range events {
+			if e == event {
+				return i
+			}
+		}
+		t.Fatalf("%q did not happen", event)
+		return -1
+	}
+	// The write call runs alone, between the batches around it
+	assert.Less(t, at("end a"), at("start c"))
+	assert.Less(t, at("end b"), at("start c"))
+	assert.Less(t, at("end c"), at("start d"))
+	assert.Less(t, at("end c"), at("start e"))
+	// The calls of a batch run side by side
+	assert.Less(t, at("start b"), at("end a"))
+	assert.Less(t, at("start e"), at("end d"))
+}
+
+func TestRunToolCallsConcurrency(t *testing.T) {
+	tests := []struct {
+		limit int
+		want  int64
+	}{
+		{limit: 1, want: 1},
+		{limit: 2, want: 2},
+		{limit: 8, want: 5},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("limit %d", tt.limit), func(t *testing.T) {
+			setToolConcurrency(t, tt.limit)
+
+			var running, peak atomic.Int64
+			before := func(ctx context.Context, call tools.ToolCall) error {
+				n := running.Add(1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+				running.Add(-1)
+				return nil
+			}
+			a := &agent{tools: []tools.BaseTool{stubTool{name: tools.ViewToolName, before: before}}}
+			var calls []message.ToolCall
+			for _, input := range []string{"a", "b", "c", "d", "e"} {
+				calls = append(calls, toolCall(tools.ViewToolName, input))
+			}
+			_, err := a.runToolCalls(context.Background(), calls)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, peak.Load())
+		})
+	}
+}
@@ -2,13 +2,19 @@ package agent
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
 
+	"github.com/opencode-ai/opencode/internal/config"
 	"github.com/opencode-ai/opencode/internal/history"
 	"github.com/opencode-ai/opencode/internal/llm/tools"
+	"github.com/opencode-ai/opencode/internal/logging"
 	"github.com/opencode-ai/opencode/internal/lsp"
 	"github.com/opencode-ai/opencode/internal/message"
 	"github.com/opencode-ai/opencode/internal/permission"
 	"github.com/opencode-ai/opencode/internal/session"
+	"github.com/opencode-ai/opencode/internal/usage"
 )
 
 func CoderAgentTools(
@@ -16,6 +22,7 @@ func CoderAgentTools(
 	sessions session.Service,
 	messages message.Service,
 	history history.Service,
+	usage usage.Service,
 	lspClients map[string]*lsp.Client,
 ) []tools.BaseTool {
 	ctx := context.Background()
@@ -35,11 +42,127 @@ func CoderAgentTools(
 			tools.NewViewTool(lspClients),
 			tools.NewPatchTool(lspClients, permissions, history),
 			tools.NewWriteTool(lspClients, permissions, history),
-			NewAgentTool(sessions, messages, lspClients),
+			NewAgentTool(permissions, sessions, messages, history, usage, lspClients),
 		}, otherTools...,
 	)
 }
 
+// CustomAgentTools returns the tools of a user-defined agent: the ones named in
+// its configuration, or the tools of the coder agent when it names none. Their
+// permission requests follow the permission policy of the agent. With the deny
+// policy, a call that needs permission fails on its own and the agent goes on.
+func CustomAgentTools(
+	agentName config.AgentName,
+	permissions permission.Service,
+	sessions session.Service,
+	messages message.Service,
+	history history.Service,
+	usage usage.Service,
+	lspClients map[string]*lsp.Client,
+) []tools.BaseTool {
+	agentCfg := config.Get().Agents[agentName]
+	permissions = permission.WithPolicy(permissions, agentCfg.Permissions)
+
+	allTools := withMCPPermissions(CoderAgentTools(permissions, sessions, messages, history, usage, lspClients), permissions)
+	if agentCfg.Permissions == config.PermissionDeny {
+		for i, tool := range allTools {
+			allTools[i] = deniedTool{tool}
+		}
+	}
+	if len(agentCfg.Tools) == 0 {
+		return allTools
+	}
+
+	var agentTools []tools.BaseTool
+	for _, name := range agentCfg.Tools {
+		idx := slices.IndexFunc(allTools, func(t tools.BaseTool) bool { return t.Info().Name == name })
+		if idx == -1 {
+			logging.Warn("Unknown tool configured for agent", "agent", agentName, "tool", name)
+			continue
+		}
+		agentTools = append(agentTools, allTools[idx])
+	}
+	return agentTools
+}
+
+// PlanAgentTools are the tools of the coder agent in plan mode, none of which
+// change the workspace.
+func PlanAgentTools(
+	permissions permission.Service,
+	lspClients map[string]*lsp.Client,
+) []tools.BaseTool {
+	planTools := append(TaskAgentTools(lspClients), tools.NewFetchTool(permissions))
+	if len(lspClients) > 0 {
+		planTools = append(planTools, tools.NewDiagnosticsTool(lspClients))
+	}
+	for _, tool := range GetMcpTools(context.Background(), permissions) {
+		if t, ok := tool.(*mcpTool); ok && t.readOnly() {
+			planTools = append(planTools, tool)
+		}
+	}
+	return planTools
+}
+
+// ProfileAgentTools returns the tools of a task agent launched with profile.
+func ProfileAgentTools(
+	profile config.AgentProfile,
+	permissions permission.Service,
+	sessions session.Service,
+	messages message.Service,
+	history history.Service,
+	usage usage.Service,
+	lspClients map[string]*lsp.Client,
+) []tools.BaseTool {
+	switch profile {
+	case config.ProfileEdit:
+		return append(
+			TaskAgentTools(lspClients),
+			tools.NewBashTool(permissions),
+			tools.NewEditTool(lspClients, permissions, history),
+			tools.NewPatchTool(lspClients, permissions, history),
+			tools.NewWriteTool(lspClients, permissions, history),
+		)
+	case config.ProfileFull:
+		return withoutAgentTool(withMCPPermissions(CoderAgentTools(permissions, sessions, messages, history, usage, lspClients), permissions))
+	default:
+		return TaskAgentTools(lspClients)
+	}
+}
+
+// deniedTool reports the calls denied by the permission policy of its agent as
+// failed calls. A denied permission otherwise ends the run, as when the user
+// denies it.
+type deniedTool struct {
+	tools.BaseTool
+}
+
+func (t deniedTool) Run(ctx context.Context, call tools.ToolCall) (tools.ToolResponse, error) {
+	response, err := t.BaseTool.Run(ctx, call)
+	if errors.Is(err, permission.ErrorPermissionDenied) {
+		return tools.NewTextErrorResponse(fmt.Sprintf("Permission denied: %s is not permitted for this agent", call.Name)), nil
+	}
+	return response, err
+}
+
+// withMCPPermissions makes the MCP tools ask for permission through
+// permissions. They are shared, and created with the default permissions.
+func withMCPPermissions(agentTools []tools.BaseTool, permissions permission.Service) []tools.BaseTool {
+	for i, tool := range agentTools {
+		if t, ok := tool.(*mcpTool); ok {
+			withPermissions := *t
+			withPermissions.permissions = permissions
+			agentTools[i] = &withPermissions
+		}
+	}
+	return agentTools
+}
+
+// withoutAgentTool removes the agent tool, as sub-agents do not launch agents
+// of their own.
+func withoutAgentTool(agentTools []tools.BaseTool) []tools.BaseTool {
+	return slices.DeleteFunc(agentTools, func(t tools.BaseTool) bool { return t.Info().Name == AgentToolName })
+}
+
 func TaskAgentTools(lspClients map[string]*lsp.Client) []tools.BaseTool {
 	return []tools.BaseTool{
 		tools.NewGlobTool(),
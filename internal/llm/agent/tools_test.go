@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/db"
+	"github.com/opencode-ai/opencode/internal/history"
+	"github.com/opencode-ai/opencode/internal/llm/tools"
+	"github.com/opencode-ai/opencode/internal/lsp"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/opencode-ai/opencode/internal/permission"
+	"github.com/opencode-ai/opencode/internal/session"
+	"github.com/opencode-ai/opencode/internal/usage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setReviewer sets the tools and the permission policy of the reviewer agent
+// of the loaded config for the duration of the test.
+func setReviewer(t *testing.T, agentTools []string, policy config.PermissionPolicy) {
+	cfg := config.Get()
+	previous := cfg.Agents["reviewer"]
+	reviewer := previous
+	reviewer.Tools = agentTools
+	reviewer.Permissions = policy
+	cfg.Agents["reviewer"] = reviewer
+	t.Cleanup(func() { cfg.Agents["reviewer"] = previous })
+}
+
+// reviewerTools returns the tools of the reviewer agent, with the services of
+// a temporary database.
+func reviewerTools(t *testing.T, permissions permission.Service) []tools.BaseTool {
+	conn, err := db.ConnectDir(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	q := db.New(conn)
+	return CustomAgentTools(
+		"reviewer",
+		permissions,
+		session.NewService(q, conn),
+		message.NewService(q),
+		history.NewService(q, conn),
+		usage.NewService(q),
+		map[string]*lsp.Client{},
+	)
+}
+
+func toolNames(agentTools []tools.BaseTool) []string {
+	names := make([]string, len(agentTools))
+	for i, tool := range agentTools {
+		names[i] = tool.Info().Name
+	}
+	return names
+}
+
+func TestCustomAgentTools(t *testing.T) {
+	loadMockConfig(t, "responses: []")
+	permissions := permission.NewPermissionService()
+
+	// Without a tool list the agent gets the tools of the coder agent
+	setReviewer(t, nil, "")
+	assert.Equal(t,
+		toolNames(CoderAgentTools(permissions, nil, nil, nil, nil, map[string]*lsp.Client{})),
+		toolNames(reviewerTools(t, permissions)),
+	)
+
+	// Unknown tools are left out
+	setReviewer(t, []string{tools.ViewToolName, "missing", tools.BashToolName}, "")
+	assert.Equal(t, []string{tools.ViewToolName, tools.BashToolName}, toolNames(reviewerTools(t, permissions)))
+}
+
+func TestCustomAgentToolsPermissions(t *testing.T) {
+	tests := []struct {
+		policy  config.PermissionPolicy
+		created bool
+		err     error
+		// notPermitted is set when the call fails without ending the run
+		notPermitted bool
+	}{
+		{policy: config.PermissionAsk, err: permission.ErrorPermissionDenied},
+		{policy: config.PermissionAllow, created: true},
+		{policy: config.PermissionDeny, notPermitted: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			workspace := loadMockConfig(t, "responses: []")
+			setReviewer(t, []string{tools.BashToolName}, tt.policy)
+			// Requests that reach the user are denied
+			permissions := permission.NewPermissionService()
+			requests := permissions.Subscribe(context.Background())
+			go func() {
+				for event := range requests {
+					permissions.Deny(event.Payload)
+				}
+			}()
+
+			agentTools := reviewerTools(t, permissions)
+			require.Len(t, agentTools, 1)
+			// The shell is shared by the tests, name the file by its full path
+			created := filepath.Join(workspace, "created.txt")
+			input, err := json.Marshal(tools.BashParams{Command: "touch " + created})
+			require.NoError(t, err)
+			ctx := context.WithValue(context.Background(), tools.SessionIDContextKey, "session")
+			ctx = context.WithValue(ctx, tools.MessageIDContextKey, "message")
+			response, err := agentTools[0].Run(ctx, tools.ToolCall{ID: "call", Name: tools.BashToolName, Input: string(input)})
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.notPermitted, response.IsError)
+			if tt.notPermitted {
+				assert.Contains(t, response.Content, "bash is not permitted for this agent")
+			}
+
+			_, err = os.Stat(created)
+			assert.Equal(t, tt.created, err == nil)
+		})
+	}
+}
+
+func TestCustomAgentDeniedCall(t *testing.T) {
+	workspace := loadMockConfig(t, `
+responses:
+  - system: "generate a short title"
+    content: Review
+  - match: "^fix it$"
+    toolCalls: [{name: bash, input: {command: "touch fixed.txt"}}]
+  - match: "not permitted for this agent"
+    content: I can only review, the fix is left to you.
+`)
+	setReviewer(t, []string{tools.ViewToolName, tools.BashToolName}, config.PermissionDeny)
+
+	conn, err := db.ConnectDir(t.TempDir())
+	require.NoError(t, err)
+	defer conn.Close()
+	q := db.New(conn)
+	sessions := session.NewService(q, conn)
+	messages := message.NewService(q)
+	usages := usage.NewService(q)
+	reviewer, err := NewAgent(
+		"reviewer",
+		sessions,
+		messages,
+		usages,
+		CustomAgentTools("reviewer", permission.NewPermissionService(), sessions, messages, history.NewService(q, conn), usages, map[string]*lsp.Client{}),
+		nil,
+	)
+	require.NoError(t, err)
+
+	// The run goes on after the denied call
+	ctx := context.Background()
+	sess, err := sessions.Create(ctx, "review")
+	require.NoError(t, err)
+	events, err := reviewer.Run(ctx, sess.ID, "fix it")
+	require.NoError(t, err)
+	result := <-events
+	require.NoError(t, result.Error)
+	assert.Equal(t, "I can only review, the fix is left to you.", result.Message.Content().String())
+	assert.NoFileExists(t, filepath.Join(workspace, "fixed.txt"))
+}
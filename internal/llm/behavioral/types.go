@@ -271,3 +271,23 @@ func GetEnhancementName(enhancement EnhancementType) string {
 		return "Unknown Enhancement"
 	}
 }
+
+// GetProblemTypeName returns the human-readable name of a problem type
+func GetProblemTypeName(problemType ProblemType) string {
+	switch problemType {
+	case TechnicalIssue:
+		return "Technical Issue"
+	case ProcessImprovement:
+		return "Process Improvement"
+	case DecisionMaking:
+		return "Decision Making"
+	case Troubleshooting:
+		return "Troubleshooting"
+	case CodeGeneration:
+		return "Code Generation"
+	case Analysis:
+		return "Analysis"
+	default:
+		return "General"
+	}
+}
@@ -0,0 +1,27 @@
+package models
+
+import "slices"
+
+// builtinProviders are the providers opencode has a client for. Any other
+// provider is declared in the config, and spoken to with the OpenAI client.
+var builtinProviders = []ModelProvider{
+	ProviderAnthropic,
+	ProviderAzure,
+	ProviderBedrock,
+	ProviderCopilot,
+	ProviderGemini,
+	ProviderGROQ,
+	ProviderLocal,
+	ProviderMock,
+	ProviderOllama,
+	ProviderOpenAI,
+	ProviderOpenRouter,
+	ProviderVertexAI,
+	ProviderXAI,
+}
+
+// IsBuiltinProvider tells whether provider is one of the providers opencode
+// has a client for, as opposed to a custom OpenAI-compatible provider.
+func IsBuiltinProvider(provider ModelProvider) bool {
+	return slices.Contains(builtinProviders, provider)
+}
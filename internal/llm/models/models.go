@@ -20,6 +20,9 @@ type Model struct {
 	DefaultMaxTokens    int64         `json:"default_max_tokens"`
 	CanReason           bool          `json:"can_reason"`
 	SupportsAttachments bool          `json:"supports_attachments"`
+	// ToolsUnsupported is set for the models without native function
+	// calling, whose tool calls are emulated
+	ToolsUnsupported bool `json:"tools_unsupported,omitempty"`
 }
 
 // Model IDs
@@ -32,6 +35,9 @@ const (
 	ProviderBedrock ModelProvider = "bedrock"
 	// ForTests
 	ProviderMock ModelProvider = "__mock"
+
+	// MockModel answers from the script of the mock provider
+	MockModel ModelID = "__mock"
 )
 
 // Providers in order of popularity
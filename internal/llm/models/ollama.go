@@ -0,0 +1,116 @@
+package models
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/opencode-ai/opencode/internal/logging"
+)
+
+const (
+	ProviderOllama ModelProvider = "ollama"
+
+	// OllamaDefaultURL is where Ollama listens unless told otherwise.
+	OllamaDefaultURL = "http://localhost:11434"
+
+	// ollamaDefaultContext is the context window assumed when the server
+	// does not tell it.
+	ollamaDefaultContext   = 4096
+	ollamaDefaultMaxTokens = 4096
+)
+
+type ollamaTags struct {
+	Models []struct {
+		Name  string `json:"name"`
+		Model string `json:"model"`
+	} `json:"models"`
+}
+
+type ollamaShow struct {
+	Template     string         `json:"template"`
+	Capabilities []string       `json:"capabilities"`
+	ModelInfo    map[string]any `json:"model_info"`
+}
+
+// OllamaModels lists the models served by the Ollama server at baseURL, with
+// the context length and capabilities the server reports for each of them.
+// Models the server cannot describe, such as partially pulled ones, are
+// skipped.
+func OllamaModels(ctx context.Context, baseURL string) ([]Model, error) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	var tags ollamaTags
+	if err := ollamaRequest(ctx, http.MethodGet, baseURL+"/api/tags", nil, &tags); err != nil {
+		return nil, err
+	}
+
+	var found []Model
+	for _, tag := range tags.Models {
+		name := cmp.Or(tag.Model, tag.Name)
+		var show ollamaShow
+		if err := ollamaRequest(ctx, http.MethodPost, baseURL+"/api/show", map[string]string{"model": name}, &show); err != nil {
+			logging.Warn("Failed to describe Ollama model, skipping",
+				"model", name,
+				"error", err)
+			continue
+		}
+		found = append(found, convertOllamaModel(name, show))
+	}
+	return found, nil
+}
+
+func convertOllamaModel(name string, show ollamaShow) Model {
+	contextWindow := int64(ollamaDefaultContext)
+	if arch, ok := show.ModelInfo["general.architecture"].(string); ok {
+		if length, ok := show.ModelInfo[arch+".context_length"].(float64); ok && length > 0 {
+			contextWindow = int64(length)
+		}
+	}
+
+	toolsSupported := slices.Contains(show.Capabilities, "tools")
+	// Servers older than the capabilities tell it by the prompt template
+	if show.Capabilities == nil {
+		toolsSupported = strings.Contains(show.Template, ".Tools")
+	}
+
+	return Model{
+		ID:                  ModelID("ollama." + name),
+		Name:                friendlyModelName(name),
+		Provider:            ProviderOllama,
+		APIModel:            name,
+		ContextWindow:       contextWindow,
+		DefaultMaxTokens:    min(ollamaDefaultMaxTokens, contextWindow/2),
+		CanReason:           slices.Contains(show.Capabilities, "thinking"),
+		SupportsAttachments: slices.Contains(show.Capabilities, "vision"),
+		ToolsUnsupported:    !toolsSupported,
+	}
+}
+
+func ollamaRequest(ctx context.Context, method, url string, body any, out any) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", method, url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOllamaModels(t *testing.T) {
+	t.Parallel()
+
+	shows := map[string]string{
+		"qwen3:8b": `{"capabilities": ["completion", "tools", "thinking"], "model_info": {"general.architecture": "qwen3", "qwen3.context_length": 40960}}`,
+		"llava:7b": `{"capabilities": ["completion", "vision"], "model_info": {"general.architecture": "llama"}}`,
+		"old:1b":   `{"template": "{{ if .Tools }}tools{{ end }}"}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			w.Write([]byte(`{"models": [{"name": "qwen3:8b", "model": "qwen3:8b"}, {"name": "broken:1b"}, {"name": "llava:7b"}, {"name": "old:1b"}]}`))
+		case "/api/show":
+			var req struct {
+				Model string `json:"model"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			show, ok := shows[req.Model]
+			if !ok {
+				http.Error(w, "model not found", http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(show))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	found, err := OllamaModels(context.Background(), server.URL+"/")
+	require.NoError(t, err)
+	// The model the server cannot describe is skipped
+	require.Len(t, found, 3)
+
+	qwen := found[0]
+	assert.Equal(t, ModelID("ollama.qwen3:8b"), qwen.ID)
+	assert.Equal(t, ProviderOllama, qwen.Provider)
+	assert.Equal(t, "qwen3:8b", qwen.APIModel)
+	assert.Equal(t, int64(40960), qwen.ContextWindow)
+	assert.True(t, qwen.CanReason)
+	assert.False(t, qwen.ToolsUnsupported)
+
+	llava := found[1]
+	assert.Equal(t, int64(ollamaDefaultContext), llava.ContextWindow, "no context length reported")
+	assert.True(t, llava.SupportsAttachments)
+	assert.True(t, llava.ToolsUnsupported)
+
+	assert.False(t, found[2].ToolsUnsupported, "tools in the template")
+
+	_, err = OllamaModels(context.Background(), server.URL+"/missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,22 @@
+package prompt
+
+import (
+	"fmt"
+
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/llm/models"
+	"github.com/opencode-ai/opencode/internal/logging"
+)
+
+// CustomAgentPrompt returns the system prompt of a user-defined agent: the
+// content of its prompt file followed by information about the environment.
+func CustomAgentPrompt(agentName config.AgentName, _ models.ModelProvider) string {
+	agentPrompt, err := config.AgentPrompt(agentName)
+	if err != nil {
+		logging.Error("Failed to load agent prompt", "agent", agentName, "error", err)
+	}
+	if agentPrompt == "" {
+		agentPrompt = "You are a helpful assistant"
+	}
+	return fmt.Sprintf("%s\n%s\n", agentPrompt, getEnvironmentInfo())
+}
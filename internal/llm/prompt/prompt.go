@@ -24,19 +24,32 @@ func GetAgentPrompt(agentName config.AgentName, provider models.ModelProvider) s
 	case config.AgentSummarizer:
 		basePrompt = SummarizerPrompt(provider)
 	default:
-		basePrompt = "You are a helpful assistant"
+		basePrompt = CustomAgentPrompt(agentName, provider)
 	}
 
 	// Add behavioral framework integration note
-	basePrompt += "\n\n## BEHAVIORAL FRAMEWORK STATUS\nThis agent is enhanced with the Practical Prompt Engineering Framework v1.6.0 for systematic thinking, quality validation, and adaptive optimization."
-
-	if agentName == config.AgentCoder || agentName == config.AgentTask {
-		// Add context from project-specific instruction files if they exist
-		contextContent := getContextFromPaths()
-		logging.Debug("Context content", "Context", contextContent)
-		if contextContent != "" {
-			return fmt.Sprintf("%s\n\n# Project-Specific Context\n Make sure to follow the instructions in the context below\n%s", basePrompt, contextContent)
-		}
+	basePrompt += behavioralFrameworkNote
+
+	if agentName == config.AgentCoder || agentName == config.AgentTask || !agentName.IsBuiltin() {
+		return withProjectContext(basePrompt)
+	}
+	return basePrompt
+}
+
+// GetPlanPrompt returns the system prompt of the coder agent in plan mode.
+func GetPlanPrompt(provider models.ModelProvider) string {
+	return withProjectContext(PlanPrompt(provider) + behavioralFrameworkNote)
+}
+
+const behavioralFrameworkNote = "\n\n## BEHAVIORAL FRAMEWORK STATUS\nThis agent is enhanced with the Practical Prompt Engineering Framework v1.6.0 for systematic thinking, quality validation, and adaptive optimization."
+
+// withProjectContext adds the context from project-specific instruction files
+// to basePrompt, if they exist.
+func withProjectContext(basePrompt string) string {
+	contextContent := getContextFromPaths()
+	logging.Debug("Context content", "Context", contextContent)
+	if contextContent != "" {
+		return fmt.Sprintf("%s\n\n# Project-Specific Context\n Make sure to follow the instructions in the context below\n%s", basePrompt, contextContent)
 	}
 	return basePrompt
 }
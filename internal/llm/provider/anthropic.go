@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"strings"
 	"time"
@@ -12,6 +11,7 @@ import (
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/bedrock"
 	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/anthropics/anthropic-sdk-go/packages/param"
 	"github.com/opencode-ai/opencode/internal/config"
 	"github.com/opencode-ai/opencode/internal/llm/models"
 	toolsPkg "github.com/opencode-ai/opencode/internal/llm/tools"
@@ -45,6 +45,9 @@ func newAnthropicClient(opts providerClientOptions) AnthropicClient {
 	if opts.apiKey != "" {
 		anthropicClientOptions = append(anthropicClientOptions, option.WithAPIKey(opts.apiKey))
 	}
+	if opts.transport != nil {
+		anthropicClientOptions = append(anthropicClientOptions, option.WithHTTPClient(opts.httpClient()))
+	}
 	if anthropicOpts.useBedrock {
 		anthropicClientOptions = append(anthropicClientOptions, bedrock.WithLoadDefaultConfig(context.Background()))
 	}
@@ -164,26 +167,45 @@ func (a *anthropicClient) preparedMessages(messages []anthropic.MessageParam, to
 	lastMessage := messages[len(messages)-1]
 	isUser := lastMessage.Role == anthropic.MessageParamRoleUser
 	messageContent := ""
-	temperature := anthropic.Float(0)
-	if isUser {
+	sampling := a.providerOptions.sampling
+	// Recent models take either a temperature or a top p, so neither is sent
+	// unless configured
+	var temperature param.Opt[float64]
+	if sampling.Temperature != nil {
+		temperature = anthropic.Float(*sampling.Temperature)
+	}
+	var budget int64
+	if a.providerOptions.model.CanReason {
+		budget = sampling.thinkingBudget(a.providerOptions.maxTokens)
+	}
+	if budget == 0 && isUser {
 		for _, m := range lastMessage.Content {
 			if m.OfText != nil && m.OfText.Text != "" {
 				messageContent = m.OfText.Text
 			}
 		}
 		if messageContent != "" && a.options.shouldThink != nil && a.options.shouldThink(messageContent) {
-			thinkingParam = anthropic.ThinkingConfigParamOfEnabled(int64(float64(a.providerOptions.maxTokens) * 0.8))
-			temperature = anthropic.Float(1)
+			budget = int64(float64(a.providerOptions.maxTokens) * 0.8)
 		}
 	}
+	var topP param.Opt[float64]
+	if budget > 0 {
+		// Thinking takes a temperature of 1 and no top p
+		thinkingParam = anthropic.ThinkingConfigParamOfEnabled(budget)
+		temperature = anthropic.Float(1)
+	} else if sampling.TopP != nil {
+		topP = anthropic.Float(*sampling.TopP)
+	}
 
 	return anthropic.MessageNewParams{
-		Model:       anthropic.Model(a.providerOptions.model.APIModel),
-		MaxTokens:   a.providerOptions.maxTokens,
-		Temperature: temperature,
-		Messages:    messages,
-		Tools:       tools,
-		Thinking:    thinkingParam,
+		Model:         anthropic.Model(a.providerOptions.model.APIModel),
+		MaxTokens:     a.providerOptions.maxTokens,
+		Temperature:   temperature,
+		TopP:          topP,
+		StopSequences: sampling.Stop,
+		Messages:      messages,
+		Tools:         tools,
+		Thinking:      thinkingParam,
 		System: []anthropic.TextBlockParam{
 			{
 				Text: a.providerOptions.systemMessage,
@@ -198,7 +220,7 @@ func (a *anthropicClient) preparedMessages(messages []anthropic.MessageParam, to
 func (a *anthropicClient) send(ctx context.Context, messages []message.Message, tools []toolsPkg.BaseTool) (resposne *ProviderResponse, err error) {
 	preparedMessages := a.preparedMessages(a.convertMessages(messages), a.convertTools(tools))
 	cfg := config.Get()
-	if cfg.Debug {
+	if cfg != nil && cfg.Debug {
 		jsonData, _ := json.Marshal(preparedMessages)
 		logging.Debug("Prepared messages", "messages", string(jsonData))
 	}
@@ -213,12 +235,12 @@ func (a *anthropicClient) send(ctx context.Context, messages []message.Message,
 		// If there is an error we are going to see if we can retry the call
 		if err != nil {
 			logging.Error("Error in Anthropic API call", "error", err)
-			retry, after, retryErr := a.shouldRetry(attempts, err)
+			retry, after, retryErr := a.providerOptions.retry.shouldRetry(attempts, err)
 			if retryErr != nil {
 				return nil, retryErr
 			}
 			if retry {
-				logging.WarnPersist(fmt.Sprintf("Retrying due to rate limit... attempt %d of %d", attempts, maxRetries), logging.PersistTimeArg, time.Millisecond*time.Duration(after+100))
+				logging.WarnPersist(a.providerOptions.retry.retryWarning(err, attempts, after), logging.PersistTimeArg, time.Millisecond*time.Duration(after+100))
 				select {
 				case <-ctx.Done():
 					return nil, ctx.Err()
@@ -250,7 +272,7 @@ func (a *anthropicClient) stream(ctx context.Context, messages []message.Message
 
 	var sessionId string
 	requestSeqId := (len(messages) + 1) / 2
-	if cfg.Debug {
+	if cfg != nil && cfg.Debug {
 		if sid, ok := ctx.Value(toolsPkg.SessionIDContextKey).(string); ok {
 			sessionId = sid
 		}
@@ -317,7 +339,7 @@ func (a *anthropicClient) stream(ctx context.Context, messages []message.Message
 								ToolCall: &message.ToolCall{
 									ID:       currentToolCallID,
 									Finished: false,
-									Input:    event.Delta.JSON.PartialJSON.Raw(),
+									Input:    event.Delta.PartialJSON,
 								},
 							}
 						}
@@ -361,14 +383,14 @@ func (a *anthropicClient) stream(ctx context.Context, messages []message.Message
 				return
 			}
 			// If there is an error we are going to see if we can retry the call
-			retry, after, retryErr := a.shouldRetry(attempts, err)
+			retry, after, retryErr := a.providerOptions.retry.shouldRetry(attempts, err)
 			if retryErr != nil {
 				eventChan <- ProviderEvent{Type: EventError, Error: retryErr}
 				close(eventChan)
 				return
 			}
 			if retry {
-				logging.WarnPersist(fmt.Sprintf("Retrying due to rate limit... attempt %d of %d", attempts, maxRetries), logging.PersistTimeArg, time.Millisecond*time.Duration(after+100))
+				eventChan <- a.providerOptions.retry.retryEvent(err, attempts, after)
 				select {
 				case <-ctx.Done():
 					// context cancelled
@@ -392,34 +414,6 @@ func (a *anthropicClient) stream(ctx context.Context, messages []message.Message
 	return eventChan
 }
 
-func (a *anthropicClient) shouldRetry(attempts int, err error) (bool, int64, error) {
-	var apierr *anthropic.Error
-	if !errors.As(err, &apierr) {
-		return false, 0, err
-	}
-
-	if apierr.StatusCode != 429 && apierr.StatusCode != 529 {
-		return false, 0, err
-	}
-
-	if attempts > maxRetries {
-		return false, 0, fmt.Errorf("maximum retry attempts reached for rate limit: %d retries", maxRetries)
-	}
-
-	retryMs := 0
-	retryAfterValues := apierr.Response.Header.Values("Retry-After")
-
-	backoffMs := 2000 * (1 << (attempts - 1))
-	jitterMs := int(float64(backoffMs) * 0.2)
-	retryMs = backoffMs + jitterMs
-	if len(retryAfterValues) > 0 {
-		if _, err := fmt.Sscanf(retryAfterValues[0], "%d", &retryMs); err == nil {
-			retryMs = retryMs * 1000
-		}
-	}
-	return true, int64(retryMs), nil
-}
-
 func (a *anthropicClient) toolCalls(msg anthropic.Message) []message.ToolCall {
 	var toolCalls []message.ToolCall
 
@@ -38,6 +38,10 @@ func newAzureClient(opts providerClientOptions) AzureClient {
 		reqOpts = append(reqOpts, azure.WithTokenCredential(cred))
 	}
 
+	if opts.transport != nil {
+		reqOpts = append(reqOpts, option.WithHTTPClient(opts.httpClient()))
+	}
+
 	base := &openaiClient{
 		providerOptions: opts,
 		client:          openai.NewClient(reqOpts...),
@@ -0,0 +1,366 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/logging"
+)
+
+// CassetteMode tells whether the HTTP traffic of the providers is recorded
+// into a cassette or replayed from one.
+type CassetteMode string
+
+const (
+	CassetteRecord CassetteMode = "record"
+	CassetteReplay CassetteMode = "replay"
+
+	redacted = "REDACTED"
+	// minSecretLength is the length under which an API key is taken for a
+	// placeholder, such as the ones of local servers, and left in place.
+	minSecretLength = 8
+)
+
+// Cassette holds HTTP exchanges with providers, to debug their streams and
+// to test the clients against them without network access.
+type Cassette struct {
+	Interactions []CassetteInteraction `json:"interactions"`
+}
+
+type CassetteInteraction struct {
+	Request  CassetteRequest  `json:"request"`
+	Response CassetteResponse `json:"response"`
+}
+
+type CassetteRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// CassetteResponse is a response as it came over the wire, streams included.
+type CassetteResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// LoadCassette reads a cassette file.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+	}
+	return &cassette, nil
+}
+
+// redactedHeaders are the headers that carry credentials.
+var redactedHeaders = []string{
+	"Api-Key",
+	"Authorization",
+	"Cookie",
+	"Proxy-Authorization",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Goog-Api-Key",
+}
+
+// redactedParams are the query parameters that carry credentials.
+var redactedParams = []string{"access_token", "api_key", "key", "token"}
+
+// secretFieldPattern matches the JSON fields that carry credentials, such as
+// the token Copilot exchanges the GitHub token for.
+var secretFieldPattern = regexp.MustCompile(`("(?:token|access_token|refresh_token|api_key)"\s*:\s*)"[^"]*"`)
+
+// Recorder is an http.RoundTripper that sends requests through its base
+// transport and writes each exchange, with its credentials redacted, to a
+// cassette file. Streamed responses are recorded as they are read.
+type Recorder struct {
+	path string
+	base http.RoundTripper
+
+	mu       sync.Mutex
+	cassette Cassette
+	secrets  []string
+}
+
+// NewRecorder returns a recorder writing to the cassette at path, sending
+// requests through base, or through the default transport when base is nil.
+func NewRecorder(path string, base http.RoundTripper) *Recorder {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Recorder{path: path, base: base}
+}
+
+// Redact makes the recorder replace secrets wherever they appear.
+func (r *Recorder) Redact(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, secret := range secrets {
+		if len(secret) >= minSecretLength {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := requestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := CassetteInteraction{
+		Request: CassetteRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: req.Header.Clone(),
+			Body:   string(body),
+		},
+		Response: CassetteResponse{
+			Status: resp.StatusCode,
+			Header: resp.Header.Clone(),
+		},
+	}
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		done: func(body []byte) {
+			interaction.Response.Body = string(body)
+			r.add(interaction)
+		},
+	}
+	return resp, nil
+}
+
+// add redacts interaction and writes the cassette with it.
+func (r *Recorder) add(interaction CassetteInteraction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, r.redact(interaction))
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(r.path), 0o755); err == nil {
+			err = os.WriteFile(r.path, data, 0o600)
+		}
+	}
+	if err != nil {
+		logging.Warn("Failed to write cassette", "path", r.path, "error", err)
+	}
+}
+
+// redact removes the credentials from interaction. The caller holds mu.
+func (r *Recorder) redact(interaction CassetteInteraction) CassetteInteraction {
+	text := func(s string) string {
+		for _, secret := range r.secrets {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+		return s
+	}
+	header := func(h http.Header) {
+		for _, name := range redactedHeaders {
+			if h.Get(name) != "" {
+				h.Set(name, redacted)
+			}
+		}
+		for name, values := range h {
+			for i, value := range values {
+				values[i] = text(value)
+			}
+			h[name] = values
+		}
+	}
+
+	if u, err := url.Parse(interaction.Request.URL); err == nil {
+		query := u.Query()
+		for _, name := range redactedParams {
+			if query.Has(name) {
+				query.Set(name, redacted)
+			}
+		}
+		u.RawQuery = query.Encode()
+		interaction.Request.URL = u.String()
+	}
+	interaction.Request.URL = text(interaction.Request.URL)
+	header(interaction.Request.Header)
+	header(interaction.Response.Header)
+	interaction.Request.Body = text(secretFieldPattern.ReplaceAllString(interaction.Request.Body, `$1"`+redacted+`"`))
+	interaction.Response.Body = text(secretFieldPattern.ReplaceAllString(interaction.Response.Body, `$1"`+redacted+`"`))
+	return interaction
+}
+
+// recordingBody keeps what is read of a response body, and hands it to done
+// once the body is read to its end or closed.
+type recordingBody struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	once sync.Once
+	done func([]byte)
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.once.Do(func() { b.done(b.buf.Bytes()) })
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	b.once.Do(func() { b.done(b.buf.Bytes()) })
+	return b.ReadCloser.Close()
+}
+
+// Replayer is an http.RoundTripper that answers requests from a cassette
+// instead of the network. Each interaction is replayed once: a request gets
+// the first one left with the same method, path and body, or else the first
+// one left with the same method and path. Hosts are not compared, so that
+// a cassette replays against any base URL.
+type Replayer struct {
+	cassette *Cassette
+
+	mu   sync.Mutex
+	used []bool
+}
+
+func NewReplayer(cassette *Cassette) *Replayer {
+	return &Replayer{
+		cassette: cassette,
+		used:     make([]bool, len(cassette.Interactions)),
+	}
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := requestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	interaction, ok := r.next(req, string(body))
+	if !ok {
+		return nil, fmt.Errorf("cassette has no response left for %s %s", req.Method, req.URL.Path)
+	}
+	header := interaction.Response.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Response.Status, http.StatusText(interaction.Response.Status)),
+		StatusCode:    interaction.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(interaction.Response.Body)),
+		ContentLength: int64(len(interaction.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (r *Replayer) next(req *http.Request, body string) (CassetteInteraction, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	candidates := make([]int, 0, len(r.cassette.Interactions))
+	for i, interaction := range r.cassette.Interactions {
+		recorded, err := url.Parse(interaction.Request.URL)
+		if r.used[i] || err != nil || interaction.Request.Method != req.Method || path.Clean(recorded.Path) != path.Clean(req.URL.Path) {
+			continue
+		}
+		if interaction.Request.Body == body {
+			r.used[i] = true
+			return interaction, true
+		}
+		candidates = append(candidates, i)
+	}
+	if len(candidates) == 0 {
+		return CassetteInteraction{}, false
+	}
+	r.used[candidates[0]] = true
+	return r.cassette.Interactions[candidates[0]], true
+}
+
+// requestBody returns the body of req, leaving it to be sent.
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+var (
+	cassettesMu sync.Mutex
+	// cassettes holds the transport of each cassette, so that every provider
+	// records into, or replays from, the same one.
+	cassettes = map[string]http.RoundTripper{}
+)
+
+// cassetteTransport returns the transport of the cassette configured, or
+// nil when none is.
+func cassetteTransport() (http.RoundTripper, error) {
+	cfg := config.Get()
+	if cfg == nil || cfg.Cassette.Mode == "" {
+		return nil, nil
+	}
+	path := cfg.Cassette.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cfg.WorkingDir, path)
+	}
+
+	cassettesMu.Lock()
+	defer cassettesMu.Unlock()
+	if transport, ok := cassettes[path]; ok {
+		return transport, nil
+	}
+	var transport http.RoundTripper
+	switch CassetteMode(cfg.Cassette.Mode) {
+	case CassetteRecord:
+		transport = NewRecorder(path, nil)
+	case CassetteReplay:
+		cassette, err := LoadCassette(path)
+		if err != nil {
+			return nil, err
+		}
+		transport = NewReplayer(cassette)
+	default:
+		return nil, fmt.Errorf("unknown cassette mode: %s", cfg.Cassette.Mode)
+	}
+	cassettes[path] = transport
+	return transport, nil
+}
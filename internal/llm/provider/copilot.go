@@ -1,6 +1,7 @@
 package provider
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
@@ -94,7 +95,8 @@ func newCopilotClient(opts providerClientOptions) CopilotClient {
 
 	// Create HTTP client for token exchange
 	httpClient := &http.Client{
-		Timeout: 30 * time.Second,
+		Timeout:   30 * time.Second,
+		Transport: opts.transport,
 	}
 
 	var bearerToken string
@@ -161,6 +163,9 @@ func newCopilotClient(opts providerClientOptions) CopilotClient {
 		option.WithBaseURL(baseURL),
 		option.WithAPIKey(bearerToken), // Use bearer token as API key
 	}
+	if opts.transport != nil {
+		openaiClientOptions = append(openaiClientOptions, option.WithHTTPClient(opts.httpClient()))
+	}
 
 	// Add GitHub Copilot specific headers
 	openaiClientOptions = append(openaiClientOptions,
@@ -287,9 +292,10 @@ func (c *copilotClient) preparedParams(messages []openai.ChatCompletionMessagePa
 		Tools:    tools,
 	}
 
+	applyOpenAISampling(&params, c.providerOptions.sampling)
 	if c.providerOptions.model.CanReason == true {
 		params.MaxCompletionTokens = openai.Int(c.providerOptions.maxTokens)
-		switch c.options.reasoningEffort {
+		switch cmp.Or(c.providerOptions.sampling.ReasoningEffort, c.options.reasoningEffort) {
 		case "low":
 			params.ReasoningEffort = shared.ReasoningEffortLow
 		case "medium":
@@ -311,7 +317,7 @@ func (c *copilotClient) send(ctx context.Context, messages []message.Message, to
 	cfg := config.Get()
 	var sessionId string
 	requestSeqId := (len(messages) + 1) / 2
-	if cfg.Debug {
+	if cfg != nil && cfg.Debug {
 		// jsonData, _ := json.Marshal(params)
 		// logging.Debug("Prepared messages", "messages", string(jsonData))
 		if sid, ok := ctx.Value(toolsPkg.SessionIDContextKey).(string); ok {
@@ -341,7 +347,7 @@ func (c *copilotClient) send(ctx context.Context, messages []message.Message, to
 				return nil, retryErr
 			}
 			if retry {
-				logging.WarnPersist(fmt.Sprintf("Retrying due to rate limit... attempt %d of %d", attempts, maxRetries), logging.PersistTimeArg, time.Millisecond*time.Duration(after+100))
+				logging.WarnPersist(c.providerOptions.retry.retryWarning(err, attempts, after), logging.PersistTimeArg, time.Millisecond*time.Duration(after+100))
 				select {
 				case <-ctx.Done():
 					return nil, ctx.Err()
@@ -382,7 +388,7 @@ func (c *copilotClient) stream(ctx context.Context, messages []message.Message,
 	cfg := config.Get()
 	var sessionId string
 	requestSeqId := (len(messages) + 1) / 2
-	if cfg.Debug {
+	if cfg != nil && cfg.Debug {
 		if sid, ok := ctx.Value(toolsPkg.SessionIDContextKey).(string); ok {
 			sessionId = sid
 		}
@@ -414,11 +420,12 @@ func (c *copilotClient) stream(ctx context.Context, messages []message.Message,
 			var currentToolCallId string
 			var currentToolCall openai.ChatCompletionMessageToolCall
 			var msgToolCalls []openai.ChatCompletionMessageToolCall
+			var callStream toolCallStream
 			for copilotStream.Next() {
 				chunk := copilotStream.Current()
 				acc.AddChunk(chunk)
 
-				if cfg.Debug {
+				if cfg != nil && cfg.Debug {
 					logging.AppendToStreamSessionLogJson(sessionId, requestSeqId, chunk)
 				}
 
@@ -430,6 +437,9 @@ func (c *copilotClient) stream(ctx context.Context, messages []message.Message,
 						}
 						currentContent += choice.Delta.Content
 					}
+					for _, event := range callStream.events(choice.Delta.ToolCalls) {
+						eventChan <- event
+					}
 				}
 
 				if c.isAnthropicModel() {
@@ -481,7 +491,10 @@ func (c *copilotClient) stream(ctx context.Context, messages []message.Message,
 
 			err := copilotStream.Err()
 			if err == nil || errors.Is(err, io.EOF) {
-				if cfg.Debug {
+				for _, event := range callStream.stop() {
+					eventChan <- event
+				}
+				if cfg != nil && cfg.Debug {
 					respFilepath := logging.WriteChatResponseJson(sessionId, requestSeqId, acc.ChatCompletion)
 					logging.Debug("Chat completion response", "filepath", respFilepath)
 				}
@@ -514,14 +527,8 @@ func (c *copilotClient) stream(ctx context.Context, messages []message.Message,
 				close(eventChan)
 				return
 			}
-			// shouldRetry is not catching the max retries...
-			// TODO: Figure out why
-			if attempts > maxRetries {
-				logging.Warn("Maximum retry attempts reached for rate limit", "attempts", attempts, "max_retries", maxRetries)
-				retry = false
-			}
 			if retry {
-				logging.WarnPersist(fmt.Sprintf("Retrying due to rate limit... attempt %d of %d (paused for %d ms)", attempts, maxRetries, after), logging.PersistTimeArg, time.Millisecond*time.Duration(after+100))
+				eventChan <- c.providerOptions.retry.retryEvent(err, attempts, after)
 				select {
 				case <-ctx.Done():
 					// context cancelled
@@ -587,30 +594,7 @@ func (c *copilotClient) shouldRetry(attempts int, err error) (bool, int64, error
 	}
 	logging.Debug("Copilot API Error", "status", apierr.StatusCode, "headers", apierr.Response.Header, "body", apierr.RawJSON())
 
-	if apierr.StatusCode != 429 && apierr.StatusCode != 500 {
-		return false, 0, err
-	}
-
-	if apierr.StatusCode == 500 {
-		logging.Warn("Copilot API returned 500 error, retrying", "error", err)
-	}
-
-	if attempts > maxRetries {
-		return false, 0, fmt.Errorf("maximum retry attempts reached for rate limit: %d retries", maxRetries)
-	}
-
-	retryMs := 0
-	retryAfterValues := apierr.Response.Header.Values("Retry-After")
-
-	backoffMs := 2000 * (1 << (attempts - 1))
-	jitterMs := int(float64(backoffMs) * 0.2)
-	retryMs = backoffMs + jitterMs
-	if len(retryAfterValues) > 0 {
-		if _, err := fmt.Sscanf(retryAfterValues[0], "%d", &retryMs); err == nil {
-			retryMs = retryMs * 1000
-		}
-	}
-	return true, int64(retryMs), nil
+	return c.providerOptions.retry.shouldRetry(attempts, err)
 }
 
 func (c *copilotClient) toolCalls(completion openai.ChatCompletion) []message.ToolCall {
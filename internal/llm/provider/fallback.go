@@ -0,0 +1,189 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencode-ai/opencode/internal/llm/models"
+	"github.com/opencode-ai/opencode/internal/llm/tools"
+	"github.com/opencode-ai/opencode/internal/logging"
+	"github.com/opencode-ai/opencode/internal/message"
+)
+
+// toolCallIDPattern matches the tool call IDs that every provider accepts.
+var toolCallIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,40}$`)
+
+// fallbackCooldown is how long the calls keep going to a fallback before the
+// first provider is tried again.
+const fallbackCooldown = 5 * time.Minute
+
+// fallbackProvider sends messages to the first of its providers, and moves on
+// to the next one when a provider fails in a way another model may not: the
+// retries are exhausted, the API key is rejected or the conversation does not
+// fit in the context window. The next calls go straight to the fallback until
+// the cooldown is over, so that each step of the tool loop does not wait for
+// the failing provider again.
+type fallbackProvider struct {
+	providers []Provider
+
+	mu         sync.Mutex
+	current    int
+	fellBackAt time.Time
+}
+
+// NewFallbackProvider returns a provider that falls back to the next of
+// providers when one fails. It acts as the first one otherwise.
+func NewFallbackProvider(providers ...Provider) Provider {
+	if len(providers) == 1 {
+		return providers[0]
+	}
+	return &fallbackProvider{providers: providers}
+}
+
+func (p *fallbackProvider) Model() models.Model {
+	return p.providers[0].Model()
+}
+
+// first returns the index of the provider a call starts with at now.
+func (p *fallbackProvider) first(now time.Time) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.current > 0 && now.Sub(p.fellBackAt) >= fallbackCooldown {
+		logging.Info("Fallback cooldown over, trying the first model again", "model", p.providers[0].Model().Name)
+		p.current = 0
+	}
+	return p.current
+}
+
+// fallBack makes the calls start with the next-th provider until the cooldown
+// is over, and warns the user that the previous one failed with err.
+func (p *fallbackProvider) fallBack(next int, err error, now time.Time) {
+	p.mu.Lock()
+	p.current = next
+	p.fellBackAt = now
+	p.mu.Unlock()
+	logging.WarnPersist(fmt.Sprintf("%s failed, falling back to %s: %v",
+		p.providers[next-1].Model().Name, p.providers[next].Model().Name, err))
+}
+
+func (p *fallbackProvider) SendMessages(ctx context.Context, messages []message.Message, tools []tools.BaseTool) (*ProviderResponse, error) {
+	var err error
+	for i := p.first(time.Now()); i < len(p.providers); i++ {
+		provider := p.providers[i]
+		var response *ProviderResponse
+		response, err = provider.SendMessages(ctx, convertHistory(messages, provider.Model()), tools)
+		if err == nil {
+			response.Model = provider.Model()
+			return response, nil
+		}
+		if !shouldFallback(err) || i == len(p.providers)-1 {
+			return nil, err
+		}
+		p.fallBack(i+1, err, time.Now())
+	}
+	return nil, err
+}
+
+func (p *fallbackProvider) StreamResponse(ctx context.Context, messages []message.Message, tools []tools.BaseTool) <-chan ProviderEvent {
+	eventChan := make(chan ProviderEvent)
+	go func() {
+		defer close(eventChan)
+		for i := p.first(time.Now()); i < len(p.providers); i++ {
+			provider := p.providers[i]
+			var failed error
+			// Once part of the answer is out, it is too late to fall back
+			answering := false
+			for event := range provider.StreamResponse(ctx, convertHistory(messages, provider.Model()), tools) {
+				switch event.Type {
+				case EventError:
+					if !answering && i < len(p.providers)-1 && shouldFallback(event.Error) {
+						failed = event.Error
+						continue
+					}
+				case EventContentDelta, EventThinkingDelta, EventToolUseStart, EventToolUseDelta, EventToolUseStop:
+					answering = true
+				case EventComplete:
+					event.Response.Model = provider.Model()
+				}
+				eventChan <- event
+			}
+			if failed == nil {
+				return
+			}
+			p.fallBack(i+1, failed, time.Now())
+		}
+	}()
+	return eventChan
+}
+
+// shouldFallback tells whether err may not happen with another model.
+func shouldFallback(err error) bool {
+	if errors.Is(err, ErrMaxRetries) {
+		return true
+	}
+	status, _, ok := apiErrorStatus(err)
+	if !ok {
+		return false
+	}
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		return isContextLengthError(err)
+	}
+	return false
+}
+
+func isContextLengthError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"context length", "context_length", "context window", "prompt is too long", "too many tokens", "input token count"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// convertHistory adapts a conversation that may have been held with other
+// models to model: attachments are left out when the model does not support
+// them, and tool call IDs that some providers reject are replaced.
+func convertHistory(messages []message.Message, model models.Model) []message.Message {
+	converted := make([]message.Message, len(messages))
+	for i, msg := range messages {
+		parts := make([]message.ContentPart, 0, len(msg.Parts))
+		for _, part := range msg.Parts {
+			switch p := part.(type) {
+			case message.BinaryContent, message.ImageURLContent:
+				if !model.SupportsAttachments {
+					continue
+				}
+			case message.ToolCall:
+				p.ID = toolCallID(p.ID)
+				part = p
+			case message.ToolResult:
+				p.ToolCallID = toolCallID(p.ToolCallID)
+				part = p
+			}
+			parts = append(parts, part)
+		}
+		msg.Parts = parts
+		converted[i] = msg
+	}
+	return converted
+}
+
+func toolCallID(id string) string {
+	if toolCallIDPattern.MatchString(id) {
+		return id
+	}
+	sum := sha256.Sum256([]byte(id))
+	return "call_" + hex.EncodeToString(sum[:12])
+}
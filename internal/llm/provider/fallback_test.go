@@ -0,0 +1,201 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/opencode-ai/opencode/internal/llm/models"
+	"github.com/opencode-ai/opencode/internal/llm/tools"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProvider streams events, and fails SendMessages with err.
+type fakeProvider struct {
+	model    models.Model
+	events   []ProviderEvent
+	err      error
+	received []message.Message
+}
+
+func (p *fakeProvider) SendMessages(ctx context.Context, messages []message.Message, tools []tools.BaseTool) (*ProviderResponse, error) {
+	p.received = messages
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &ProviderResponse{Content: "ok"}, nil
+}
+
+func (p *fakeProvider) StreamResponse(ctx context.Context, messages []message.Message, tools []tools.BaseTool) <-chan ProviderEvent {
+	p.received = messages
+	eventChan := make(chan ProviderEvent, len(p.events))
+	for _, event := range p.events {
+		eventChan <- event
+	}
+	close(eventChan)
+	return eventChan
+}
+
+func (p *fakeProvider) Model() models.Model {
+	return p.model
+}
+
+func collect(events <-chan ProviderEvent) []ProviderEvent {
+	var collected []ProviderEvent
+	for event := range events {
+		collected = append(collected, event)
+	}
+	return collected
+}
+
+func TestFallbackProvider(t *testing.T) {
+	t.Parallel()
+
+	exhausted := fmt.Errorf("%w for status 429: %d attempts", ErrMaxRetries, defaultMaxAttempts)
+	complete := ProviderEvent{Type: EventComplete, Response: &ProviderResponse{Content: "hi"}}
+
+	t.Run("falls back when retries are exhausted", func(t *testing.T) {
+		t.Parallel()
+		primary := &fakeProvider{model: models.Model{ID: "primary"}, events: []ProviderEvent{{Type: EventContentStart}, {Type: EventError, Error: exhausted}}}
+		fallback := &fakeProvider{model: models.Model{ID: "fallback"}, events: []ProviderEvent{{Type: EventContentDelta, Content: "hi"}, complete}}
+		p := NewFallbackProvider(primary, fallback)
+		assert.Equal(t, models.ModelID("primary"), p.Model().ID)
+
+		events := collect(p.StreamResponse(context.Background(), nil, nil))
+		require.Len(t, events, 3)
+		assert.Equal(t, EventContentStart, events[0].Type)
+		assert.Equal(t, EventContentDelta, events[1].Type)
+		assert.Equal(t, models.ModelID("fallback"), events[2].Response.Model.ID)
+	})
+
+	t.Run("keeps the error once answering", func(t *testing.T) {
+		t.Parallel()
+		primary := &fakeProvider{model: models.Model{ID: "primary"}, events: []ProviderEvent{{Type: EventContentDelta, Content: "h"}, {Type: EventError, Error: exhausted}}}
+		fallback := &fakeProvider{model: models.Model{ID: "fallback"}, events: []ProviderEvent{complete}}
+
+		events := collect(NewFallbackProvider(primary, fallback).StreamResponse(context.Background(), nil, nil))
+		require.Len(t, events, 2)
+		assert.ErrorIs(t, events[1].Error, ErrMaxRetries)
+		assert.Nil(t, fallback.received)
+	})
+
+	t.Run("keeps other errors", func(t *testing.T) {
+		t.Parallel()
+		primary := &fakeProvider{model: models.Model{ID: "primary"}, err: errors.New("boom")}
+		fallback := &fakeProvider{model: models.Model{ID: "fallback"}}
+
+		_, err := NewFallbackProvider(primary, fallback).SendMessages(context.Background(), nil, nil)
+		assert.EqualError(t, err, "boom")
+	})
+
+	t.Run("sticks to the fallback until the cooldown is over", func(t *testing.T) {
+		t.Parallel()
+		primary := &fakeProvider{model: models.Model{ID: "primary"}, err: exhausted}
+		fallback := &fakeProvider{model: models.Model{ID: "fallback"}}
+		p := NewFallbackProvider(primary, fallback)
+
+		_, err := p.SendMessages(context.Background(), nil, nil)
+		require.NoError(t, err)
+		primary.received, fallback.received = nil, nil
+		response, err := p.SendMessages(context.Background(), []message.Message{{Role: message.User}}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, models.ModelID("fallback"), response.Model.ID)
+		assert.Nil(t, primary.received, "primary not tried again")
+
+		fp := p.(*fallbackProvider)
+		assert.Equal(t, 1, fp.first(time.Now().Add(fallbackCooldown/2)))
+		assert.Equal(t, 0, fp.first(time.Now().Add(fallbackCooldown)))
+	})
+
+	t.Run("converts the history", func(t *testing.T) {
+		t.Parallel()
+		primary := &fakeProvider{model: models.Model{ID: "primary"}, err: exhausted}
+		fallback := &fakeProvider{model: models.Model{ID: "fallback"}}
+		longID := "call_0123456789abcdef0123456789abcdef0123"
+		history := []message.Message{
+			{Role: message.User, Parts: []message.ContentPart{message.TextContent{Text: "look"}, message.BinaryContent{Path: "a.png"}}},
+			{Role: message.Assistant, Parts: []message.ContentPart{message.ToolCall{ID: longID, Name: "view"}}},
+			{Role: message.Tool, Parts: []message.ContentPart{message.ToolResult{ToolCallID: longID}}},
+		}
+
+		response, err := NewFallbackProvider(primary, fallback).SendMessages(context.Background(), history, nil)
+		require.NoError(t, err)
+		assert.Equal(t, models.ModelID("fallback"), response.Model.ID)
+
+		received := fallback.received
+		assert.Len(t, received[0].Parts, 1, "attachment left out")
+		callID := received[1].ToolCalls()[0].ID
+		assert.Regexp(t, toolCallIDPattern, callID)
+		assert.Equal(t, callID, received[2].ToolResults()[0].ToolCallID)
+		assert.Equal(t, longID, history[1].ToolCalls()[0].ID, "history is untouched")
+	})
+}
+
+func TestShouldFallback(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"retries exhausted", fmt.Errorf("%w for status 529: 8 attempts", ErrMaxRetries), true},
+		{"unauthorized", &mockError{StatusCode: http.StatusUnauthorized, Message: "invalid x-api-key"}, true},
+		{"forbidden", &mockError{StatusCode: http.StatusForbidden, Message: "no access to model"}, true},
+		{"prompt too long", &mockError{StatusCode: http.StatusBadRequest, Message: "prompt is too long: 210000 tokens > 200000 maximum"}, true},
+		{"context length exceeded", &mockError{StatusCode: http.StatusBadRequest, Message: "This model's maximum context length is 128000 tokens"}, true},
+		{"request too large", &mockError{StatusCode: http.StatusRequestEntityTooLarge, Message: "input token count exceeds the maximum"}, true},
+		{"other bad request", &mockError{StatusCode: http.StatusBadRequest, Message: "messages: text content blocks must be non-empty"}, false},
+		{"context length with another status", &mockError{StatusCode: http.StatusInternalServerError, Message: "context length"}, false},
+		{"not an API error", errors.New("context window exceeded"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, shouldFallback(tt.err))
+		})
+	}
+}
+
+func TestConvertHistory(t *testing.T) {
+	t.Parallel()
+
+	longID := "toolu_vrtx_0123456789abcdef0123456789abcdef"
+	history := []message.Message{
+		{Role: message.User, Parts: []message.ContentPart{
+			message.TextContent{Text: "look"},
+			message.BinaryContent{Path: "a.png"},
+			message.ImageURLContent{URL: "https://example.com/b.png"},
+		}},
+		{Role: message.Assistant, Parts: []message.ContentPart{
+			message.ToolCall{ID: "call_1", Name: "view"},
+			message.ToolCall{ID: longID, Name: "ls"},
+			message.ToolCall{ID: "call.2", Name: "grep"},
+		}},
+		{Role: message.Tool, Parts: []message.ContentPart{
+			message.ToolResult{ToolCallID: "call_1"},
+			message.ToolResult{ToolCallID: longID},
+			message.ToolResult{ToolCallID: "call.2"},
+		}},
+	}
+
+	converted := convertHistory(history, models.Model{SupportsAttachments: true})
+	assert.Len(t, converted[0].Parts, 3, "attachments kept")
+	calls, results := converted[1].ToolCalls(), converted[2].ToolResults()
+	assert.Equal(t, "call_1", calls[0].ID, "valid ID kept")
+	for i := range calls {
+		assert.Regexp(t, toolCallIDPattern, calls[i].ID)
+		assert.Equal(t, calls[i].ID, results[i].ToolCallID)
+	}
+	assert.NotEqual(t, calls[1].ID, calls[2].ID)
+	assert.Equal(t, calls, convertHistory(history, models.Model{})[1].ToolCalls(), "IDs are stable")
+
+	converted = convertHistory(history, models.Model{})
+	assert.Equal(t, []message.ContentPart{message.TextContent{Text: "look"}}, converted[0].Parts, "attachments left out")
+	assert.Len(t, history[0].Parts, 3, "history is untouched")
+}
@@ -3,9 +3,6 @@ package provider
 import (
 	"context"
 	"encoding/json"
-	"errors"
-	"fmt"
-	"io"
 	"strings"
 	"time"
 
@@ -37,7 +34,7 @@ func newGeminiClient(opts providerClientOptions) GeminiClient {
 		o(&geminiOpts)
 	}
 
-	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{APIKey: opts.apiKey, Backend: genai.BackendGeminiAPI})
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{APIKey: opts.apiKey, Backend: genai.BackendGeminiAPI, HTTPClient: opts.httpClient()})
 	if err != nil {
 		logging.Error("Failed to create Gemini client", "error", err)
 		return nil
@@ -165,28 +162,51 @@ func (g *geminiClient) finishReason(reason genai.FinishReason) message.FinishRea
 	}
 }
 
+// generateConfig returns the config of a call with tools, its sampling and
+// thinking parameters included.
+func (g *geminiClient) generateConfig(tools []tools.BaseTool) *genai.GenerateContentConfig {
+	sampling := g.providerOptions.sampling
+	config := &genai.GenerateContentConfig{
+		MaxOutputTokens: int32(g.providerOptions.maxTokens),
+		SystemInstruction: &genai.Content{
+			Parts: []*genai.Part{{Text: g.providerOptions.systemMessage}},
+		},
+		StopSequences: sampling.Stop,
+	}
+	if sampling.Temperature != nil {
+		config.Temperature = genai.Ptr(float32(*sampling.Temperature))
+	}
+	if sampling.TopP != nil {
+		config.TopP = genai.Ptr(float32(*sampling.TopP))
+	}
+	if g.providerOptions.model.CanReason {
+		budget := sampling.thinkingBudget(g.providerOptions.maxTokens)
+		if budget > 0 || sampling.IncludeThoughts {
+			config.ThinkingConfig = &genai.ThinkingConfig{IncludeThoughts: sampling.IncludeThoughts}
+			if budget > 0 {
+				config.ThinkingConfig.ThinkingBudget = genai.Ptr(int32(budget))
+			}
+		}
+	}
+	if len(tools) > 0 {
+		config.Tools = g.convertTools(tools)
+	}
+	return config
+}
+
 func (g *geminiClient) send(ctx context.Context, messages []message.Message, tools []tools.BaseTool) (*ProviderResponse, error) {
 	// Convert messages
 	geminiMessages := g.convertMessages(messages)
 
 	cfg := config.Get()
-	if cfg.Debug {
+	if cfg != nil && cfg.Debug {
 		jsonData, _ := json.Marshal(geminiMessages)
 		logging.Debug("Prepared messages", "messages", string(jsonData))
 	}
 
 	history := geminiMessages[:len(geminiMessages)-1] // All but last message
 	lastMsg := geminiMessages[len(geminiMessages)-1]
-	config := &genai.GenerateContentConfig{
-		MaxOutputTokens: int32(g.providerOptions.maxTokens),
-		SystemInstruction: &genai.Content{
-			Parts: []*genai.Part{{Text: g.providerOptions.systemMessage}},
-		},
-	}
-	if len(tools) > 0 {
-		config.Tools = g.convertTools(tools)
-	}
-	chat, _ := g.client.Chats.Create(ctx, g.providerOptions.model.APIModel, config, history)
+	chat, _ := g.client.Chats.Create(ctx, g.providerOptions.model.APIModel, g.generateConfig(tools), history)
 
 	attempts := 0
 	for {
@@ -200,12 +220,12 @@ func (g *geminiClient) send(ctx context.Context, messages []message.Message, too
 		resp, err := chat.SendMessage(ctx, lastMsgParts...)
 		// If there is an error we are going to see if we can retry the call
 		if err != nil {
-			retry, after, retryErr := g.shouldRetry(attempts, err)
+			retry, after, retryErr := g.providerOptions.retry.shouldRetry(attempts, err)
 			if retryErr != nil {
 				return nil, retryErr
 			}
 			if retry {
-				logging.WarnPersist(fmt.Sprintf("Retrying due to rate limit... attempt %d of %d", attempts, maxRetries), logging.PersistTimeArg, time.Millisecond*time.Duration(after+100))
+				logging.WarnPersist(g.providerOptions.retry.retryWarning(err, attempts, after), logging.PersistTimeArg, time.Millisecond*time.Duration(after+100))
 				select {
 				case <-ctx.Done():
 					return nil, ctx.Err()
@@ -221,6 +241,8 @@ func (g *geminiClient) send(ctx context.Context, messages []message.Message, too
 		if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil {
 			for _, part := range resp.Candidates[0].Content.Parts {
 				switch {
+				case part.Thought:
+					// Thoughts are not part of the answer
 				case part.Text != "":
 					content = string(part.Text)
 				case part.FunctionCall != nil:
@@ -258,23 +280,14 @@ func (g *geminiClient) stream(ctx context.Context, messages []message.Message, t
 	geminiMessages := g.convertMessages(messages)
 
 	cfg := config.Get()
-	if cfg.Debug {
+	if cfg != nil && cfg.Debug {
 		jsonData, _ := json.Marshal(geminiMessages)
 		logging.Debug("Prepared messages", "messages", string(jsonData))
 	}
 
 	history := geminiMessages[:len(geminiMessages)-1] // All but last message
 	lastMsg := geminiMessages[len(geminiMessages)-1]
-	config := &genai.GenerateContentConfig{
-		MaxOutputTokens: int32(g.providerOptions.maxTokens),
-		SystemInstruction: &genai.Content{
-			Parts: []*genai.Part{{Text: g.providerOptions.systemMessage}},
-		},
-	}
-	if len(tools) > 0 {
-		config.Tools = g.convertTools(tools)
-	}
-	chat, _ := g.client.Chats.Create(ctx, g.providerOptions.model.APIModel, config, history)
+	chat, _ := g.client.Chats.Create(ctx, g.providerOptions.model.APIModel, g.generateConfig(tools), history)
 
 	attempts := 0
 	eventChan := make(chan ProviderEvent)
@@ -282,6 +295,7 @@ func (g *geminiClient) stream(ctx context.Context, messages []message.Message, t
 	go func() {
 		defer close(eventChan)
 
+	attempt:
 		for {
 			attempts++
 
@@ -298,13 +312,13 @@ func (g *geminiClient) stream(ctx context.Context, messages []message.Message, t
 			}
 			for resp, err := range chat.SendMessageStream(ctx, lastMsgParts...) {
 				if err != nil {
-					retry, after, retryErr := g.shouldRetry(attempts, err)
+					retry, after, retryErr := g.providerOptions.retry.shouldRetry(attempts, err)
 					if retryErr != nil {
 						eventChan <- ProviderEvent{Type: EventError, Error: retryErr}
 						return
 					}
 					if retry {
-						logging.WarnPersist(fmt.Sprintf("Retrying due to rate limit... attempt %d of %d", attempts, maxRetries), logging.PersistTimeArg, time.Millisecond*time.Duration(after+100))
+						eventChan <- g.providerOptions.retry.retryEvent(err, attempts, after)
 						select {
 						case <-ctx.Done():
 							if ctx.Err() != nil {
@@ -313,7 +327,7 @@ func (g *geminiClient) stream(ctx context.Context, messages []message.Message, t
 
 							return
 						case <-time.After(time.Duration(after) * time.Millisecond):
-							break
+							continue attempt
 						}
 					} else {
 						eventChan <- ProviderEvent{Type: EventError, Error: err}
@@ -326,6 +340,10 @@ func (g *geminiClient) stream(ctx context.Context, messages []message.Message, t
 				if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil {
 					for _, part := range resp.Candidates[0].Content.Parts {
 						switch {
+						case part.Thought:
+							if part.Text != "" {
+								eventChan <- ProviderEvent{Type: EventThinkingDelta, Thinking: part.Text}
+							}
 						case part.Text != "":
 							delta := string(part.Text)
 							if delta != "" {
@@ -356,6 +374,9 @@ func (g *geminiClient) stream(ctx context.Context, messages []message.Message, t
 
 							if isNew {
 								toolCalls = append(toolCalls, newCall)
+								// Function calls come whole, without deltas
+								eventChan <- ProviderEvent{Type: EventToolUseStart, ToolCall: &newCall}
+								eventChan <- ProviderEvent{Type: EventToolUseStop, ToolCall: &message.ToolCall{ID: id}}
 							}
 						}
 					}
@@ -391,38 +412,6 @@ func (g *geminiClient) stream(ctx context.Context, messages []message.Message, t
 	return eventChan
 }
 
-func (g *geminiClient) shouldRetry(attempts int, err error) (bool, int64, error) {
-	// Check if error is a rate limit error
-	if attempts > maxRetries {
-		return false, 0, fmt.Errorf("maximum retry attempts reached for rate limit: %d retries", maxRetries)
-	}
-
-	// Gemini doesn't have a standard error type we can check against
-	// So we'll check the error message for rate limit indicators
-	if errors.Is(err, io.EOF) {
-		return false, 0, err
-	}
-
-	errMsg := err.Error()
-	isRateLimit := false
-
-	// Check for common rate limit error messages
-	if contains(errMsg, "rate limit", "quota exceeded", "too many requests") {
-		isRateLimit = true
-	}
-
-	if !isRateLimit {
-		return false, 0, err
-	}
-
-	// Calculate backoff with jitter
-	backoffMs := 2000 * (1 << (attempts - 1))
-	jitterMs := int(float64(backoffMs) * 0.2)
-	retryMs := backoffMs + jitterMs
-
-	return true, int64(retryMs), nil
-}
-
 func (g *geminiClient) toolCalls(resp *genai.GenerateContentResponse) []message.ToolCall {
 	var toolCalls []message.ToolCall
 
@@ -543,12 +532,3 @@ func mapJSONTypeToGenAI(jsonType string) genai.Type {
 		return genai.TypeString // Default to string for unknown types
 	}
 }
-
-func contains(s string, substrs ...string) bool {
-	for _, substr := range substrs {
-		if strings.Contains(strings.ToLower(s), strings.ToLower(substr)) {
-			return true
-		}
-	}
-	return false
-}
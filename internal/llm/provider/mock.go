@@ -0,0 +1,380 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opencode-ai/opencode/internal/llm/tools"
+	"github.com/opencode-ai/opencode/internal/message"
+	"gopkg.in/yaml.v3"
+)
+
+// MockScript is what the mock provider answers, for tests and for reproducing
+// sessions offline. Each response is given once: a response with a match goes
+// to the first call that matches it, and the calls that match none go through
+// the responses without a match in order.
+type MockScript struct {
+	Responses []MockResponse `json:"responses" yaml:"responses"`
+}
+
+// MockResponse is one scripted answer of the model.
+type MockResponse struct {
+	// Match is a regular expression the prompt of the call must match: the
+	// text of the last message, or the results it carries when it holds
+	// tool results.
+	Match string `json:"match,omitempty" yaml:"match,omitempty"`
+	// System is a regular expression the system prompt must match, to
+	// script the calls of one agent among others, such as the title one.
+	System string `json:"system,omitempty" yaml:"system,omitempty"`
+
+	Thinking string `json:"thinking,omitempty" yaml:"thinking,omitempty"`
+	// Content is streamed in the pieces of Deltas when they are given, and
+	// whole otherwise.
+	Content   string         `json:"content,omitempty" yaml:"content,omitempty"`
+	Deltas    []string       `json:"deltas,omitempty" yaml:"deltas,omitempty"`
+	ToolCalls []MockToolCall `json:"toolCalls,omitempty" yaml:"toolCalls,omitempty"`
+	Usage     MockUsage      `json:"usage,omitzero" yaml:"usage,omitempty"`
+	// FinishReason defaults to tool_use when there are tool calls, and to
+	// end_turn otherwise.
+	FinishReason message.FinishReason `json:"finishReason,omitempty" yaml:"finishReason,omitempty"`
+
+	// Error fails the call once what comes before it is streamed, with
+	// Status as its HTTP status when set, so that it can be retried or fall
+	// back like the errors of real providers.
+	Error  string `json:"error,omitempty" yaml:"error,omitempty"`
+	Status int    `json:"status,omitempty" yaml:"status,omitempty"`
+
+	// DelayMs is waited before each event, to test what happens while a
+	// response streams.
+	DelayMs int64 `json:"delayMs,omitempty" yaml:"delayMs,omitempty"`
+}
+
+// MockToolCall is a scripted tool call, whose ID is generated when not given.
+type MockToolCall struct {
+	ID    string `json:"id,omitempty" yaml:"id,omitempty"`
+	Name  string `json:"name" yaml:"name"`
+	Input any    `json:"input,omitempty" yaml:"input,omitempty"`
+}
+
+type MockUsage struct {
+	InputTokens         int64 `json:"inputTokens,omitempty" yaml:"inputTokens,omitempty"`
+	OutputTokens        int64 `json:"outputTokens,omitempty" yaml:"outputTokens,omitempty"`
+	CacheCreationTokens int64 `json:"cacheCreationTokens,omitempty" yaml:"cacheCreationTokens,omitempty"`
+	CacheReadTokens     int64 `json:"cacheReadTokens,omitempty" yaml:"cacheReadTokens,omitempty"`
+}
+
+// LoadMockScript reads a script from a YAML file, when its extension says so,
+// or from a JSON file.
+func LoadMockScript(path string) (*MockScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mock script: %w", err)
+	}
+	var script MockScript
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &script)
+	default:
+		err = json.Unmarshal(data, &script)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse mock script %s: %w", path, err)
+	}
+	return &script, nil
+}
+
+// mockError is a scripted error with an HTTP status.
+type mockError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *mockError) Error() string {
+	return fmt.Sprintf("mock: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+type mockOptions struct {
+	script *MockScript
+}
+
+type MockOption func(*mockOptions)
+
+// mockClient answers from a script. Each client goes through the script on
+// its own, so the calls of an agent do not depend on those of the others.
+type mockClient struct {
+	providerOptions providerClientOptions
+	script          *MockScript
+	matchers        []mockMatcher
+	err             error
+
+	mu   sync.Mutex
+	used []bool
+}
+
+type MockClient ProviderClient
+
+type mockMatcher struct {
+	prompt *regexp.Regexp
+	system *regexp.Regexp
+}
+
+func (m mockMatcher) any() bool {
+	return m.prompt != nil || m.system != nil
+}
+
+func (m mockMatcher) matches(prompt, system string) bool {
+	return (m.prompt == nil || m.prompt.MatchString(prompt)) &&
+		(m.system == nil || m.system.MatchString(system))
+}
+
+func newMockClient(opts providerClientOptions) MockClient {
+	mockOpts := mockOptions{}
+	for _, o := range opts.mockOptions {
+		o(&mockOpts)
+	}
+	client := &mockClient{
+		providerOptions: opts,
+		script:          mockOpts.script,
+	}
+	if client.script == nil {
+		client.err = errors.New("mock provider has no script")
+		return client
+	}
+	client.used = make([]bool, len(client.script.Responses))
+	client.matchers = make([]mockMatcher, len(client.script.Responses))
+	for i, response := range client.script.Responses {
+		var err error
+		if client.matchers[i].prompt, err = compileMatch(response.Match); err != nil {
+			client.err = fmt.Errorf("invalid match of mock response %d: %w", i+1, err)
+			return client
+		}
+		if client.matchers[i].system, err = compileMatch(response.System); err != nil {
+			client.err = fmt.Errorf("invalid system of mock response %d: %w", i+1, err)
+			return client
+		}
+	}
+	return client
+}
+
+func compileMatch(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+// next takes the response to the call with messages out of the script.
+func (m *mockClient) next(messages []message.Message) (MockResponse, error) {
+	if m.err != nil {
+		return MockResponse{}, m.err
+	}
+	prompt := mockPrompt(messages)
+	system := m.providerOptions.systemMessage
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, matcher := range m.matchers {
+		if !m.used[i] && matcher.any() && matcher.matches(prompt, system) {
+			m.used[i] = true
+			return m.script.Responses[i], nil
+		}
+	}
+	for i, matcher := range m.matchers {
+		if !m.used[i] && !matcher.any() {
+			m.used[i] = true
+			return m.script.Responses[i], nil
+		}
+	}
+	return MockResponse{}, fmt.Errorf("mock script has no response left for: %q", prompt)
+}
+
+// mockPrompt returns the text responses are matched against.
+func mockPrompt(messages []message.Message) string {
+	if len(messages) == 0 {
+		return ""
+	}
+	last := messages[len(messages)-1]
+	if last.Role != message.Tool {
+		return last.Content().String()
+	}
+	results := make([]string, 0, len(last.ToolResults()))
+	for _, result := range last.ToolResults() {
+		results = append(results, result.Content)
+	}
+	return strings.Join(results, "\n")
+}
+
+func (r MockResponse) err() error {
+	if r.Error == "" && r.Status == 0 {
+		return nil
+	}
+	if r.Status == 0 {
+		return errors.New(r.Error)
+	}
+	return &mockError{StatusCode: r.Status, Message: r.Error}
+}
+
+func (r MockResponse) deltas() []string {
+	if len(r.Deltas) > 0 {
+		return r.Deltas
+	}
+	if r.Content != "" {
+		return []string{r.Content}
+	}
+	return nil
+}
+
+func (r MockResponse) toolCalls() ([]message.ToolCall, error) {
+	toolCalls := make([]message.ToolCall, 0, len(r.ToolCalls))
+	for _, call := range r.ToolCalls {
+		input := "{}"
+		if call.Input != nil {
+			data, err := json.Marshal(call.Input)
+			if err != nil {
+				return nil, fmt.Errorf("invalid input of mock tool call %s: %w", call.Name, err)
+			}
+			input = string(data)
+		}
+		id := call.ID
+		if id == "" {
+			id = "call_" + uuid.New().String()
+		}
+		toolCalls = append(toolCalls, message.ToolCall{
+			ID:       id,
+			Name:     call.Name,
+			Input:    input,
+			Type:     "function",
+			Finished: true,
+		})
+	}
+	return toolCalls, nil
+}
+
+func (r MockResponse) response(toolCalls []message.ToolCall) *ProviderResponse {
+	finishReason := r.FinishReason
+	if finishReason == "" {
+		finishReason = message.FinishReasonEndTurn
+		if len(toolCalls) > 0 {
+			finishReason = message.FinishReasonToolUse
+		}
+	}
+	return &ProviderResponse{
+		Content:   strings.Join(r.deltas(), ""),
+		ToolCalls: toolCalls,
+		Usage: TokenUsage{
+			InputTokens:         r.Usage.InputTokens,
+			OutputTokens:        r.Usage.OutputTokens,
+			CacheCreationTokens: r.Usage.CacheCreationTokens,
+			CacheReadTokens:     r.Usage.CacheReadTokens,
+		},
+		FinishReason: finishReason,
+	}
+}
+
+func (m *mockClient) send(ctx context.Context, messages []message.Message, tools []tools.BaseTool) (*ProviderResponse, error) {
+	response, err := m.next(messages)
+	if err != nil {
+		return nil, err
+	}
+	if err := sleepContext(ctx, time.Duration(response.DelayMs)*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if err := response.err(); err != nil {
+		return nil, err
+	}
+	toolCalls, err := response.toolCalls()
+	if err != nil {
+		return nil, err
+	}
+	return response.response(toolCalls), nil
+}
+
+func (m *mockClient) stream(ctx context.Context, messages []message.Message, tools []tools.BaseTool) <-chan ProviderEvent {
+	eventChan := make(chan ProviderEvent)
+
+	go func() {
+		defer close(eventChan)
+
+		response, err := m.next(messages)
+		if err != nil {
+			eventChan <- ProviderEvent{Type: EventError, Error: err}
+			return
+		}
+		toolCalls, err := response.toolCalls()
+		if err != nil {
+			eventChan <- ProviderEvent{Type: EventError, Error: err}
+			return
+		}
+
+		delay := time.Duration(response.DelayMs) * time.Millisecond
+		// emit sends event after the delay, and tells whether the stream
+		// goes on
+		emit := func(event ProviderEvent) bool {
+			if err := sleepContext(ctx, delay); err != nil {
+				eventChan <- ProviderEvent{Type: EventError, Error: err}
+				return false
+			}
+			eventChan <- event
+			return true
+		}
+
+		if !emit(ProviderEvent{Type: EventContentStart}) {
+			return
+		}
+		if response.Thinking != "" && !emit(ProviderEvent{Type: EventThinkingDelta, Thinking: response.Thinking}) {
+			return
+		}
+		for _, delta := range response.deltas() {
+			if !emit(ProviderEvent{Type: EventContentDelta, Content: delta}) {
+				return
+			}
+		}
+		if err := response.err(); err != nil {
+			emit(ProviderEvent{Type: EventError, Error: err})
+			return
+		}
+		for _, call := range toolCalls {
+			start := message.ToolCall{ID: call.ID, Name: call.Name, Type: call.Type}
+			if !emit(ProviderEvent{Type: EventToolUseStart, ToolCall: &start}) ||
+				!emit(ProviderEvent{Type: EventToolUseDelta, ToolCall: &message.ToolCall{ID: call.ID, Input: call.Input}}) ||
+				!emit(ProviderEvent{Type: EventToolUseStop, ToolCall: &message.ToolCall{ID: call.ID}}) {
+				return
+			}
+		}
+		if !emit(ProviderEvent{Type: EventContentStop}) {
+			return
+		}
+		emit(ProviderEvent{Type: EventComplete, Response: response.response(toolCalls)})
+	}()
+
+	return eventChan
+}
+
+// sleepContext waits for d, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// WithMockScript sets the script the mock provider answers from.
+func WithMockScript(script *MockScript) MockOption {
+	return func(options *mockOptions) {
+		options.script = script
+	}
+}
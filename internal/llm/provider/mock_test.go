@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencode-ai/opencode/internal/llm/models"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMockProvider(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "script.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+responses:
+  - deltas: ["Hello", " there"]
+    usage: {inputTokens: 10, outputTokens: 2}
+  - match: "list the files"
+    thinking: Looking around
+    toolCalls:
+      - id: call_1
+        name: ls
+        input: {path: "."}
+  - match: "^a\\.go$"
+    error: overloaded
+    status: 529
+  - system: "title"
+    content: A title
+`), 0o644))
+	script, err := LoadMockScript(path)
+	require.NoError(t, err)
+
+	prompt := func(text string) []message.Message {
+		return []message.Message{{Role: message.User, Parts: []message.ContentPart{message.TextContent{Text: text}}}}
+	}
+	newMock := func(system string) Provider {
+		p, err := NewProvider(models.ProviderMock,
+			WithModel(models.Model{ID: models.MockModel, Provider: models.ProviderMock}),
+			WithSystemMessage(system),
+			WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+			WithMockOptions(WithMockScript(script)),
+		)
+		require.NoError(t, err)
+		return p
+	}
+
+	t.Run("matched responses come first", func(t *testing.T) {
+		t.Parallel()
+
+		p := newMock("you are a coder")
+		events := collect(p.StreamResponse(context.Background(), prompt("please list the files"), nil))
+		types := make([]EventType, 0, len(events))
+		for _, event := range events {
+			types = append(types, event.Type)
+		}
+		assert.Equal(t, []EventType{
+			EventContentStart, EventThinkingDelta,
+			EventToolUseStart, EventToolUseDelta, EventToolUseStop,
+			EventContentStop, EventComplete,
+		}, types)
+		response := events[len(events)-1].Response
+		require.Len(t, response.ToolCalls, 1)
+		assert.Equal(t, message.ToolCall{ID: "call_1", Name: "ls", Input: `{"path":"."}`, Type: "function", Finished: true}, response.ToolCalls[0])
+		assert.Equal(t, message.FinishReasonToolUse, response.FinishReason)
+
+		// The tool result matches the error
+		history := []message.Message{{Role: message.Tool, Parts: []message.ContentPart{message.ToolResult{ToolCallID: "call_1", Content: "a.go"}}}}
+		_, err := p.SendMessages(context.Background(), history, nil)
+		var mockErr *mockError
+		require.ErrorAs(t, err, &mockErr)
+		assert.Equal(t, 529, mockErr.StatusCode)
+
+		// Then the responses without a match, in order
+		response, err = p.SendMessages(context.Background(), prompt("hi"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "Hello there", response.Content)
+		assert.Equal(t, TokenUsage{InputTokens: 10, OutputTokens: 2}, response.Usage)
+		assert.Equal(t, message.FinishReasonEndTurn, response.FinishReason)
+
+		_, err = p.SendMessages(context.Background(), prompt("hi"), nil)
+		assert.ErrorContains(t, err, "no response left")
+	})
+
+	t.Run("each provider goes through the script", func(t *testing.T) {
+		t.Parallel()
+
+		p := newMock("generate a title")
+		response, err := p.SendMessages(context.Background(), prompt("please list the files"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "call_1", response.ToolCalls[0].ID)
+		response, err = p.SendMessages(context.Background(), prompt("please list the files"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "A title", response.Content)
+	})
+
+	t.Run("errors stream after the content", func(t *testing.T) {
+		t.Parallel()
+
+		p, err := NewProvider(models.ProviderMock,
+			WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+			WithMockOptions(WithMockScript(&MockScript{Responses: []MockResponse{
+				{Content: "partial", Error: "connection reset"},
+				{Error: "bad request", Status: http.StatusBadRequest},
+			}})),
+		)
+		require.NoError(t, err)
+		events := collect(p.StreamResponse(context.Background(), prompt("hi"), nil))
+		require.Len(t, events, 3)
+		assert.Equal(t, "partial", events[1].Content)
+		assert.EqualError(t, events[2].Error, "connection reset")
+
+		_, err = p.SendMessages(context.Background(), prompt("hi"), nil)
+		status, _, ok := apiErrorStatus(err)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+}
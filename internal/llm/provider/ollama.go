@@ -0,0 +1,408 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/llm/models"
+	"github.com/opencode-ai/opencode/internal/llm/tools"
+	"github.com/opencode-ai/opencode/internal/logging"
+	"github.com/opencode-ai/opencode/internal/message"
+)
+
+type ollamaOptions struct {
+	baseURL string
+}
+
+type OllamaOption func(*ollamaOptions)
+
+// ollamaClient speaks Ollama's native chat API, which unlike its OpenAI
+// compatible one takes the context window with each request.
+type ollamaClient struct {
+	providerOptions providerClientOptions
+	options         ollamaOptions
+	client          *http.Client
+}
+
+type OllamaClient ProviderClient
+
+func newOllamaClient(opts providerClientOptions) OllamaClient {
+	ollamaOpts := ollamaOptions{
+		baseURL: models.OllamaDefaultURL,
+	}
+	for _, o := range opts.ollamaOptions {
+		o(&ollamaOpts)
+	}
+	ollamaOpts.baseURL = strings.TrimSuffix(ollamaOpts.baseURL, "/")
+
+	return &ollamaClient{
+		providerOptions: opts,
+		options:         ollamaOpts,
+		client:          &http.Client{Transport: opts.transport},
+	}
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []ollamaTool    `json:"tools,omitempty"`
+	Stream   bool            `json:"stream"`
+	Think    bool            `json:"think,omitempty"`
+	Options  map[string]any  `json:"options,omitempty"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Images    [][]byte         `json:"images,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description"`
+		Parameters  map[string]any `json:"parameters"`
+	} `json:"function"`
+}
+
+type ollamaChatResponse struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int64         `json:"prompt_eval_count"`
+	EvalCount       int64         `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// ollamaError is an error status returned by an Ollama server.
+type ollamaError struct {
+	StatusCode int
+	Message    string
+	Header     http.Header
+}
+
+func (e *ollamaError) Error() string {
+	return fmt.Sprintf("ollama: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (o *ollamaClient) convertMessages(messages []message.Message) []ollamaMessage {
+	ollamaMessages := []ollamaMessage{{Role: "system", Content: o.providerOptions.systemMessage}}
+	for _, msg := range messages {
+		switch msg.Role {
+		case message.User:
+			userMsg := ollamaMessage{Role: "user", Content: msg.Content().String()}
+			for _, binaryContent := range msg.BinaryContent() {
+				userMsg.Images = append(userMsg.Images, binaryContent.Data)
+			}
+			ollamaMessages = append(ollamaMessages, userMsg)
+		case message.Assistant:
+			assistantMsg := ollamaMessage{Role: "assistant", Content: msg.Content().String()}
+			for _, call := range msg.ToolCalls() {
+				var toolCall ollamaToolCall
+				toolCall.Function.Name = call.Name
+				toolCall.Function.Arguments = json.RawMessage(call.Input)
+				if !json.Valid(toolCall.Function.Arguments) {
+					toolCall.Function.Arguments = json.RawMessage("{}")
+				}
+				assistantMsg.ToolCalls = append(assistantMsg.ToolCalls, toolCall)
+			}
+			ollamaMessages = append(ollamaMessages, assistantMsg)
+		case message.Tool:
+			for _, result := range msg.ToolResults() {
+				ollamaMessages = append(ollamaMessages, ollamaMessage{
+					Role:     "tool",
+					Content:  result.Content,
+					ToolName: result.Name,
+				})
+			}
+		}
+	}
+	return ollamaMessages
+}
+
+func (o *ollamaClient) convertTools(tools []tools.BaseTool) []ollamaTool {
+	ollamaTools := make([]ollamaTool, 0, len(tools))
+	for _, tool := range tools {
+		info := tool.Info()
+		var ollamaTool ollamaTool
+		ollamaTool.Type = "function"
+		ollamaTool.Function.Name = info.Name
+		ollamaTool.Function.Description = info.Description
+		ollamaTool.Function.Parameters = map[string]any{
+			"type":       "object",
+			"properties": info.Parameters,
+			"required":   info.Required,
+		}
+		ollamaTools = append(ollamaTools, ollamaTool)
+	}
+	return ollamaTools
+}
+
+func (o *ollamaClient) preparedRequest(messages []message.Message, tools []tools.BaseTool, stream bool) ollamaChatRequest {
+	model := o.providerOptions.model
+	req := ollamaChatRequest{
+		Model:    model.APIModel,
+		Messages: o.convertMessages(messages),
+		Stream:   stream,
+		Think:    model.CanReason,
+		Options: map[string]any{
+			// Ollama loads models with a small context unless told otherwise
+			"num_ctx":     model.ContextWindow,
+			"num_predict": o.providerOptions.maxTokens,
+		},
+	}
+	sampling := o.providerOptions.sampling
+	if sampling.Temperature != nil {
+		req.Options["temperature"] = *sampling.Temperature
+	}
+	if sampling.TopP != nil {
+		req.Options["top_p"] = *sampling.TopP
+	}
+	if len(sampling.Stop) > 0 {
+		req.Options["stop"] = sampling.Stop
+	}
+	// Models without tool support reject the request when it has tools
+	if len(tools) > 0 && !model.ToolsUnsupported {
+		req.Tools = o.convertTools(tools)
+	}
+	return req
+}
+
+func (o *ollamaClient) finishReason(reason string) message.FinishReason {
+	switch reason {
+	case "stop":
+		return message.FinishReasonEndTurn
+	case "length":
+		return message.FinishReasonMaxTokens
+	default:
+		return message.FinishReasonUnknown
+	}
+}
+
+// chat posts req and returns the response body, which holds one JSON object
+// per line when streaming.
+func (o *ollamaClient) chat(ctx context.Context, req ollamaChatRequest) (io.ReadCloser, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if cfg := config.Get(); cfg != nil && cfg.Debug {
+		logging.Debug("Prepared messages", "messages", string(data))
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.options.baseURL+"/api/chat", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	res, err := o.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		var body struct {
+			Error string `json:"error"`
+		}
+		raw, _ := io.ReadAll(res.Body)
+		if json.Unmarshal(raw, &body) != nil || body.Error == "" {
+			body.Error = strings.TrimSpace(string(raw))
+		}
+		return nil, &ollamaError{StatusCode: res.StatusCode, Message: body.Error, Header: res.Header}
+	}
+	return res.Body, nil
+}
+
+func (o *ollamaClient) send(ctx context.Context, messages []message.Message, tools []tools.BaseTool) (*ProviderResponse, error) {
+	req := o.preparedRequest(messages, tools, false)
+	attempts := 0
+	for {
+		attempts++
+		body, err := o.chat(ctx, req)
+		// If there is an error we are going to see if we can retry the call
+		if err != nil {
+			retry, after, retryErr := o.providerOptions.retry.shouldRetry(attempts, err)
+			if retryErr != nil {
+				return nil, retryErr
+			}
+			if retry {
+				logging.WarnPersist(o.providerOptions.retry.retryWarning(err, attempts, after), logging.PersistTimeArg, time.Millisecond*time.Duration(after+100))
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(time.Duration(after) * time.Millisecond):
+					continue
+				}
+			}
+			return nil, retryErr
+		}
+
+		var resp ollamaChatResponse
+		err = json.NewDecoder(body).Decode(&resp)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.Error != "" {
+			return nil, errors.New(resp.Error)
+		}
+
+		toolCalls := o.toolCalls(resp.Message)
+		finishReason := o.finishReason(resp.DoneReason)
+		if len(toolCalls) > 0 {
+			finishReason = message.FinishReasonToolUse
+		}
+		return &ProviderResponse{
+			Content:      resp.Message.Content,
+			ToolCalls:    toolCalls,
+			Usage:        o.usage(resp),
+			FinishReason: finishReason,
+		}, nil
+	}
+}
+
+func (o *ollamaClient) stream(ctx context.Context, messages []message.Message, tools []tools.BaseTool) <-chan ProviderEvent {
+	req := o.preparedRequest(messages, tools, true)
+	eventChan := make(chan ProviderEvent)
+
+	go func() {
+		defer close(eventChan)
+
+		attempts := 0
+		var body io.ReadCloser
+		for {
+			attempts++
+			var err error
+			body, err = o.chat(ctx, req)
+			if err == nil {
+				break
+			}
+			// If there is an error we are going to see if we can retry the call
+			retry, after, retryErr := o.providerOptions.retry.shouldRetry(attempts, err)
+			if retryErr != nil {
+				eventChan <- ProviderEvent{Type: EventError, Error: retryErr}
+				return
+			}
+			if !retry {
+				eventChan <- ProviderEvent{Type: EventError, Error: err}
+				return
+			}
+			eventChan <- o.providerOptions.retry.retryEvent(err, attempts, after)
+			select {
+			case <-ctx.Done():
+				eventChan <- ProviderEvent{Type: EventError, Error: ctx.Err()}
+				return
+			case <-time.After(time.Duration(after) * time.Millisecond):
+			}
+		}
+		defer body.Close()
+
+		eventChan <- ProviderEvent{Type: EventContentStart}
+
+		currentContent := ""
+		var toolCalls []message.ToolCall
+		decoder := json.NewDecoder(body)
+		for {
+			var chunk ollamaChatResponse
+			if err := decoder.Decode(&chunk); err != nil {
+				if ctx.Err() != nil {
+					err = ctx.Err()
+				} else if errors.Is(err, io.EOF) {
+					err = io.ErrUnexpectedEOF
+				}
+				eventChan <- ProviderEvent{Type: EventError, Error: err}
+				return
+			}
+			if chunk.Error != "" {
+				eventChan <- ProviderEvent{Type: EventError, Error: errors.New(chunk.Error)}
+				return
+			}
+
+			if chunk.Message.Thinking != "" {
+				eventChan <- ProviderEvent{Type: EventThinkingDelta, Thinking: chunk.Message.Thinking}
+			}
+			if chunk.Message.Content != "" {
+				eventChan <- ProviderEvent{Type: EventContentDelta, Content: chunk.Message.Content}
+				currentContent += chunk.Message.Content
+			}
+			// Tool calls come whole, without deltas
+			for _, call := range o.toolCalls(chunk.Message) {
+				toolCalls = append(toolCalls, call)
+				eventChan <- ProviderEvent{Type: EventToolUseStart, ToolCall: &call}
+				eventChan <- ProviderEvent{Type: EventToolUseStop, ToolCall: &message.ToolCall{ID: call.ID}}
+			}
+
+			if chunk.Done {
+				eventChan <- ProviderEvent{Type: EventContentStop}
+				finishReason := o.finishReason(chunk.DoneReason)
+				if len(toolCalls) > 0 {
+					finishReason = message.FinishReasonToolUse
+				}
+				eventChan <- ProviderEvent{
+					Type: EventComplete,
+					Response: &ProviderResponse{
+						Content:      currentContent,
+						ToolCalls:    toolCalls,
+						Usage:        o.usage(chunk),
+						FinishReason: finishReason,
+					},
+				}
+				return
+			}
+		}
+	}()
+
+	return eventChan
+}
+
+// toolCalls returns the tool calls of msg, with the IDs Ollama does not give.
+func (o *ollamaClient) toolCalls(msg ollamaMessage) []message.ToolCall {
+	var toolCalls []message.ToolCall
+	for _, call := range msg.ToolCalls {
+		input := string(call.Function.Arguments)
+		if input == "" || input == "null" {
+			input = "{}"
+		}
+		toolCalls = append(toolCalls, message.ToolCall{
+			ID:       "call_" + uuid.New().String(),
+			Name:     call.Function.Name,
+			Input:    input,
+			Type:     "function",
+			Finished: true,
+		})
+	}
+	return toolCalls
+}
+
+func (o *ollamaClient) usage(resp ollamaChatResponse) TokenUsage {
+	return TokenUsage{
+		InputTokens:  resp.PromptEvalCount,
+		OutputTokens: resp.EvalCount,
+	}
+}
+
+// WithOllamaBaseURL sets the URL of the Ollama server.
+func WithOllamaBaseURL(baseURL string) OllamaOption {
+	return func(options *ollamaOptions) {
+		options.baseURL = baseURL
+	}
+}
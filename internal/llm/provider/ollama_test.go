@@ -0,0 +1,116 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opencode-ai/opencode/internal/llm/models"
+	"github.com/opencode-ai/opencode/internal/llm/tools"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubTool struct {
+	info tools.ToolInfo
+}
+
+func (s stubTool) Info() tools.ToolInfo {
+	return s.info
+}
+
+func (s stubTool) Run(ctx context.Context, params tools.ToolCall) (tools.ToolResponse, error) {
+	return tools.NewTextResponse(""), nil
+}
+
+func TestOllamaClientStream(t *testing.T) {
+	t.Parallel()
+
+	var received ollamaChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/chat", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Write([]byte(`{"message": {"role": "assistant", "thinking": "Hmm"}, "done": false}
+{"message": {"role": "assistant", "content": "Let me look"}, "done": false}
+{"message": {"role": "assistant", "content": "", "tool_calls": [{"function": {"name": "view", "arguments": {"file_path": "a.go"}}}]}, "done": false}
+{"message": {"role": "assistant", "content": ""}, "done": true, "done_reason": "stop", "prompt_eval_count": 12, "eval_count": 5}
+`))
+	}))
+	defer server.Close()
+
+	temperature := 0.2
+	model := models.Model{ID: "ollama.qwen3:8b", APIModel: "qwen3:8b", ContextWindow: 40960, CanReason: true}
+	p, err := NewProvider(models.ProviderOllama,
+		WithModel(model),
+		WithMaxTokens(1000),
+		WithSystemMessage("be brief"),
+		WithSampling(Sampling{Temperature: &temperature, Stop: []string{"</answer>"}}),
+		WithOllamaOptions(WithOllamaBaseURL(server.URL)),
+	)
+	require.NoError(t, err)
+
+	history := []message.Message{
+		{Role: message.User, Parts: []message.ContentPart{message.TextContent{Text: "read a.go"}}},
+		{Role: message.Assistant, Parts: []message.ContentPart{message.ToolCall{ID: "1", Name: "ls", Input: `{"path": "."}`}}},
+		{Role: message.Tool, Parts: []message.ContentPart{message.ToolResult{ToolCallID: "1", Name: "ls", Content: "a.go"}}},
+	}
+	view := stubTool{tools.ToolInfo{Name: "view", Parameters: map[string]any{"file_path": map[string]any{"type": "string"}}, Required: []string{"file_path"}}}
+	events := collect(p.StreamResponse(context.Background(), history, []tools.BaseTool{view}))
+
+	assert.Equal(t, "qwen3:8b", received.Model)
+	assert.True(t, received.Think)
+	assert.EqualValues(t, 40960, received.Options["num_ctx"])
+	assert.EqualValues(t, 1000, received.Options["num_predict"])
+	assert.EqualValues(t, 0.2, received.Options["temperature"])
+	assert.Equal(t, []any{"</answer>"}, received.Options["stop"])
+	assert.NotContains(t, received.Options, "top_p")
+	require.Len(t, received.Messages, 4)
+	assert.Equal(t, "system", received.Messages[0].Role)
+	assert.JSONEq(t, `{"path": "."}`, string(received.Messages[2].ToolCalls[0].Function.Arguments))
+	assert.Equal(t, ollamaMessage{Role: "tool", Content: "a.go", ToolName: "ls"}, received.Messages[3])
+	require.Len(t, received.Tools, 1)
+	assert.Equal(t, "view", received.Tools[0].Function.Name)
+
+	var types []EventType
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []EventType{EventContentStart, EventThinkingDelta, EventContentDelta, EventToolUseStart, EventToolUseStop, EventContentStop, EventComplete}, types)
+	assert.JSONEq(t, `{"file_path": "a.go"}`, events[3].ToolCall.Input)
+	response := events[6].Response
+	assert.Equal(t, "Let me look", response.Content)
+	assert.Equal(t, message.FinishReasonToolUse, response.FinishReason)
+	assert.Equal(t, TokenUsage{InputTokens: 12, OutputTokens: 5}, response.Usage)
+}
+
+func TestOllamaClientErrors(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error": "server busy"}`))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "registry.ollama.ai/library/gemma:2b does not support tools"}`))
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(models.ProviderOllama, WithOllamaOptions(WithOllamaBaseURL(server.URL)))
+	require.NoError(t, err)
+	events := collect(p.StreamResponse(context.Background(), nil, nil))
+
+	require.Len(t, events, 2)
+	assert.Equal(t, EventWarning, events[0].Type, "retried")
+	var ollamaErr *ollamaError
+	require.ErrorAs(t, events[1].Error, &ollamaErr)
+	assert.Equal(t, http.StatusBadRequest, ollamaErr.StatusCode)
+	assert.Contains(t, ollamaErr.Error(), "does not support tools")
+}
@@ -1,11 +1,12 @@
 package provider
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
+	"slices"
 	"time"
 
 	"github.com/openai/openai-go"
@@ -50,6 +51,9 @@ func newOpenAIClient(opts providerClientOptions) OpenAIClient {
 	if openaiOpts.baseURL != "" {
 		openaiClientOptions = append(openaiClientOptions, option.WithBaseURL(openaiOpts.baseURL))
 	}
+	if opts.transport != nil {
+		openaiClientOptions = append(openaiClientOptions, option.WithHTTPClient(opts.httpClient()))
+	}
 
 	if openaiOpts.extraHeaders != nil {
 		for key, value := range openaiOpts.extraHeaders {
@@ -166,9 +170,10 @@ func (o *openaiClient) preparedParams(messages []openai.ChatCompletionMessagePar
 		Tools:    tools,
 	}
 
+	applyOpenAISampling(&params, o.providerOptions.sampling)
 	if o.providerOptions.model.CanReason == true {
 		params.MaxCompletionTokens = openai.Int(o.providerOptions.maxTokens)
-		switch o.options.reasoningEffort {
+		switch cmp.Or(o.providerOptions.sampling.ReasoningEffort, o.options.reasoningEffort) {
 		case "low":
 			params.ReasoningEffort = shared.ReasoningEffortLow
 		case "medium":
@@ -185,10 +190,24 @@ func (o *openaiClient) preparedParams(messages []openai.ChatCompletionMessagePar
 	return params
 }
 
+// applyOpenAISampling sets the sampling parameters of params, the reasoning
+// effort aside.
+func applyOpenAISampling(params *openai.ChatCompletionNewParams, sampling Sampling) {
+	if sampling.Temperature != nil {
+		params.Temperature = openai.Float(*sampling.Temperature)
+	}
+	if sampling.TopP != nil {
+		params.TopP = openai.Float(*sampling.TopP)
+	}
+	if len(sampling.Stop) > 0 {
+		params.Stop = openai.ChatCompletionNewParamsStopUnion{OfChatCompletionNewsStopArray: sampling.Stop}
+	}
+}
+
 func (o *openaiClient) send(ctx context.Context, messages []message.Message, tools []tools.BaseTool) (response *ProviderResponse, err error) {
 	params := o.preparedParams(o.convertMessages(messages), o.convertTools(tools))
 	cfg := config.Get()
-	if cfg.Debug {
+	if cfg != nil && cfg.Debug {
 		jsonData, _ := json.Marshal(params)
 		logging.Debug("Prepared messages", "messages", string(jsonData))
 	}
@@ -201,12 +220,12 @@ func (o *openaiClient) send(ctx context.Context, messages []message.Message, too
 		)
 		// If there is an error we are going to see if we can retry the call
 		if err != nil {
-			retry, after, retryErr := o.shouldRetry(attempts, err)
+			retry, after, retryErr := o.providerOptions.retry.shouldRetry(attempts, err)
 			if retryErr != nil {
 				return nil, retryErr
 			}
 			if retry {
-				logging.WarnPersist(fmt.Sprintf("Retrying due to rate limit... attempt %d of %d", attempts, maxRetries), logging.PersistTimeArg, time.Millisecond*time.Duration(after+100))
+				logging.WarnPersist(o.providerOptions.retry.retryWarning(err, attempts, after), logging.PersistTimeArg, time.Millisecond*time.Duration(after+100))
 				select {
 				case <-ctx.Done():
 					return nil, ctx.Err()
@@ -245,7 +264,7 @@ func (o *openaiClient) stream(ctx context.Context, messages []message.Message, t
 	}
 
 	cfg := config.Get()
-	if cfg.Debug {
+	if cfg != nil && cfg.Debug {
 		jsonData, _ := json.Marshal(params)
 		logging.Debug("Prepared messages", "messages", string(jsonData))
 	}
@@ -264,6 +283,7 @@ func (o *openaiClient) stream(ctx context.Context, messages []message.Message, t
 			acc := openai.ChatCompletionAccumulator{}
 			currentContent := ""
 			toolCalls := make([]message.ToolCall, 0)
+			var callStream toolCallStream
 
 			for openaiStream.Next() {
 				chunk := openaiStream.Current()
@@ -277,11 +297,17 @@ func (o *openaiClient) stream(ctx context.Context, messages []message.Message, t
 						}
 						currentContent += choice.Delta.Content
 					}
+					for _, event := range callStream.events(choice.Delta.ToolCalls) {
+						eventChan <- event
+					}
 				}
 			}
 
 			err := openaiStream.Err()
 			if err == nil || errors.Is(err, io.EOF) {
+				for _, event := range callStream.stop() {
+					eventChan <- event
+				}
 				// Stream completed successfully
 				finishReason := o.finishReason(string(acc.ChatCompletion.Choices[0].FinishReason))
 				if len(acc.ChatCompletion.Choices[0].Message.ToolCalls) > 0 {
@@ -305,14 +331,14 @@ func (o *openaiClient) stream(ctx context.Context, messages []message.Message, t
 			}
 
 			// If there is an error we are going to see if we can retry the call
-			retry, after, retryErr := o.shouldRetry(attempts, err)
+			retry, after, retryErr := o.providerOptions.retry.shouldRetry(attempts, err)
 			if retryErr != nil {
 				eventChan <- ProviderEvent{Type: EventError, Error: retryErr}
 				close(eventChan)
 				return
 			}
 			if retry {
-				logging.WarnPersist(fmt.Sprintf("Retrying due to rate limit... attempt %d of %d", attempts, maxRetries), logging.PersistTimeArg, time.Millisecond*time.Duration(after+100))
+				eventChan <- o.providerOptions.retry.retryEvent(err, attempts, after)
 				select {
 				case <-ctx.Done():
 					// context cancelled
@@ -334,34 +360,6 @@ func (o *openaiClient) stream(ctx context.Context, messages []message.Message, t
 	return eventChan
 }
 
-func (o *openaiClient) shouldRetry(attempts int, err error) (bool, int64, error) {
-	var apierr *openai.Error
-	if !errors.As(err, &apierr) {
-		return false, 0, err
-	}
-
-	if apierr.StatusCode != 429 && apierr.StatusCode != 500 {
-		return false, 0, err
-	}
-
-	if attempts > maxRetries {
-		return false, 0, fmt.Errorf("maximum retry attempts reached for rate limit: %d retries", maxRetries)
-	}
-
-	retryMs := 0
-	retryAfterValues := apierr.Response.Header.Values("Retry-After")
-
-	backoffMs := 2000 * (1 << (attempts - 1))
-	jitterMs := int(float64(backoffMs) * 0.2)
-	retryMs = backoffMs + jitterMs
-	if len(retryAfterValues) > 0 {
-		if _, err := fmt.Sscanf(retryAfterValues[0], "%d", &retryMs); err == nil {
-			retryMs = retryMs * 1000
-		}
-	}
-	return true, int64(retryMs), nil
-}
-
 func (o *openaiClient) toolCalls(completion openai.ChatCompletion) []message.ToolCall {
 	var toolCalls []message.ToolCall
 
@@ -381,6 +379,57 @@ func (o *openaiClient) toolCalls(completion openai.ChatCompletion) []message.Too
 	return toolCalls
 }
 
+// toolCallStream turns the tool call chunks of a streamed completion into tool
+// use events. Only the first chunk of a call has its ID, the next ones refer
+// to the call by its index.
+type toolCallStream struct {
+	ids     map[int64]string
+	started []string
+}
+
+func (s *toolCallStream) events(deltas []openai.ChatCompletionChunkChoiceDeltaToolCall) []ProviderEvent {
+	var events []ProviderEvent
+	for _, delta := range deltas {
+		id := delta.ID
+		if id == "" {
+			id = s.ids[delta.Index]
+		} else if !slices.Contains(s.started, id) {
+			if s.ids == nil {
+				s.ids = make(map[int64]string)
+			}
+			s.ids[delta.Index] = id
+			s.started = append(s.started, id)
+			events = append(events, ProviderEvent{
+				Type: EventToolUseStart,
+				ToolCall: &message.ToolCall{
+					ID:   id,
+					Name: delta.Function.Name,
+					Type: "function",
+				},
+			})
+		}
+		if id != "" && delta.Function.Arguments != "" {
+			events = append(events, ProviderEvent{
+				Type: EventToolUseDelta,
+				ToolCall: &message.ToolCall{
+					ID:    id,
+					Input: delta.Function.Arguments,
+				},
+			})
+		}
+	}
+	return events
+}
+
+// stop returns the events ending the calls started so far.
+func (s *toolCallStream) stop() []ProviderEvent {
+	events := make([]ProviderEvent, len(s.started))
+	for i, id := range s.started {
+		events[i] = ProviderEvent{Type: EventToolUseStop, ToolCall: &message.ToolCall{ID: id}}
+	}
+	return events
+}
+
 func (o *openaiClient) usage(completion openai.ChatCompletion) TokenUsage {
 	cachedTokens := completion.Usage.PromptTokensDetails.CachedTokens
 	inputTokens := completion.Usage.PromptTokens - cachedTokens
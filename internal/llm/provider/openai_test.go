@@ -0,0 +1,116 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openai/openai-go"
+	"github.com/opencode-ai/opencode/internal/llm/models"
+	"github.com/opencode-ai/opencode/internal/llm/tools"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToolCallStream(t *testing.T) {
+	t.Parallel()
+
+	call := func(index int64, id, name, arguments string) openai.ChatCompletionChunkChoiceDeltaToolCall {
+		return openai.ChatCompletionChunkChoiceDeltaToolCall{
+			Index:    index,
+			ID:       id,
+			Function: openai.ChatCompletionChunkChoiceDeltaToolCallFunction{Name: name, Arguments: arguments},
+		}
+	}
+	chunks := [][]openai.ChatCompletionChunkChoiceDeltaToolCall{
+		{call(0, "call_a", "view", "")},
+		{call(0, "", "", `{"file_`)},
+		// The second call starts before the first one is done
+		{call(1, "call_b", "ls", `{"pa`)},
+		{call(0, "", "", `path":"a.go"}`), call(1, "", "", `th":"."}`)},
+		// Some providers repeat the ID on every chunk
+		{call(1, "call_b", "", "")},
+		// Chunks of unknown calls are dropped
+		{call(2, "", "", `{}`)},
+	}
+
+	var stream toolCallStream
+	var events []ProviderEvent
+	for _, chunk := range chunks {
+		events = append(events, stream.events(chunk)...)
+	}
+	events = append(events, stream.stop()...)
+
+	starts := map[string]string{}
+	inputs := map[string]string{}
+	var stops []string
+	for _, event := range events {
+		switch event.Type {
+		case EventToolUseStart:
+			assert.NotContains(t, starts, event.ToolCall.ID, "started twice")
+			starts[event.ToolCall.ID] = event.ToolCall.Name
+		case EventToolUseDelta:
+			require.Contains(t, starts, event.ToolCall.ID, "delta before start")
+			inputs[event.ToolCall.ID] += event.ToolCall.Input
+		case EventToolUseStop:
+			stops = append(stops, event.ToolCall.ID)
+		}
+	}
+	assert.Equal(t, map[string]string{"call_a": "view", "call_b": "ls"}, starts)
+	assert.Equal(t, map[string]string{"call_a": `{"file_path":"a.go"}`, "call_b": `{"path":"."}`}, inputs)
+	assert.Equal(t, []string{"call_a", "call_b"}, stops)
+}
+
+func TestOpenAIClientStreamInterleavedToolCalls(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"id":"c1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"view","arguments":""}}]},"finish_reason":null}]}
+
+data: {"id":"c1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"ls","arguments":"{\"pa"}}]},"finish_reason":null}]}
+
+data: {"id":"c1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"file_path\":"}},{"index":1,"function":{"arguments":"th\":\".\"}"}}]},"finish_reason":null}]}
+
+data: {"id":"c1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"a.go\"}"}}]},"finish_reason":null}]}
+
+data: {"id":"c1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}
+
+data: [DONE]
+
+`))
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(models.ProviderOpenAI,
+		WithAPIKey("test"),
+		WithModel(models.Model{ID: "gpt-4o", APIModel: "gpt-4o"}),
+		WithMaxTokens(1000),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+		WithOpenAIOptions(WithOpenAIBaseURL(server.URL)),
+	)
+	require.NoError(t, err)
+	history := []message.Message{{Role: message.User, Parts: []message.ContentPart{message.TextContent{Text: "look around"}}}}
+	view := stubTool{tools.ToolInfo{Name: "view", Parameters: map[string]any{"file_path": map[string]any{"type": "string"}}}}
+	ls := stubTool{tools.ToolInfo{Name: "ls", Parameters: map[string]any{"path": map[string]any{"type": "string"}}}}
+	events := collect(p.StreamResponse(context.Background(), history, []tools.BaseTool{view, ls}))
+
+	inputs := map[string]string{}
+	for _, event := range events {
+		if event.Type == EventToolUseDelta {
+			inputs[event.ToolCall.ID] += event.ToolCall.Input
+		}
+	}
+	assert.Equal(t, map[string]string{"call_a": `{"file_path":"a.go"}`, "call_b": `{"path":"."}`}, inputs)
+
+	last := events[len(events)-1]
+	require.Equal(t, EventComplete, last.Type, "error: %v", last.Error)
+	assert.Equal(t, message.FinishReasonToolUse, last.Response.FinishReason)
+	require.Len(t, last.Response.ToolCalls, 2)
+	assert.Equal(t, "call_a", last.Response.ToolCalls[0].ID)
+	assert.JSONEq(t, `{"file_path":"a.go"}`, last.Response.ToolCalls[0].Input)
+	assert.Equal(t, "call_b", last.Response.ToolCalls[1].ID)
+	assert.JSONEq(t, `{"path":"."}`, last.Response.ToolCalls[1].Input)
+}
@@ -2,17 +2,24 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"time"
 
+	"github.com/opencode-ai/opencode/internal/config"
 	"github.com/opencode-ai/opencode/internal/llm/models"
 	"github.com/opencode-ai/opencode/internal/llm/tools"
+	"github.com/opencode-ai/opencode/internal/logging"
 	"github.com/opencode-ai/opencode/internal/message"
 )
 
 type EventType string
 
-const maxRetries = 8
+// ErrMaxRetries is returned once a call failed as many times in a row as its
+// retry policy allows.
+var ErrMaxRetries = errors.New("maximum retry attempts reached")
 
 const (
 	EventContentStart  EventType = "content_start"
@@ -39,6 +46,9 @@ type ProviderResponse struct {
 	ToolCalls    []message.ToolCall
 	Usage        TokenUsage
 	FinishReason message.FinishReason
+	// Model is the model that answered, when it is not the model of the
+	// provider.
+	Model models.Model
 }
 
 type ProviderEvent struct {
@@ -49,6 +59,11 @@ type ProviderEvent struct {
 	Response *ProviderResponse
 	ToolCall *message.ToolCall
 	Error    error
+
+	// Warning describes an EventWarning, which lasts for Wait when it is
+	// about a wait.
+	Warning string
+	Wait    time.Duration
 }
 type Provider interface {
 	SendMessages(ctx context.Context, messages []message.Message, tools []tools.BaseTool) (*ProviderResponse, error)
@@ -63,12 +78,20 @@ type providerClientOptions struct {
 	model         models.Model
 	maxTokens     int64
 	systemMessage string
+	sampling      Sampling
+	retry         RetryPolicy
+	rateLimiter   *rateLimiter
+	// transport sends the HTTP requests of the client, nil meaning the
+	// default one
+	transport http.RoundTripper
 
 	anthropicOptions []AnthropicOption
 	openaiOptions    []OpenAIOption
 	geminiOptions    []GeminiOption
 	bedrockOptions   []BedrockOption
 	copilotOptions   []CopilotOption
+	ollamaOptions    []OllamaOption
+	mockOptions      []MockOption
 }
 
 type ProviderClientOption func(*providerClientOptions)
@@ -84,10 +107,28 @@ type baseProvider[C ProviderClient] struct {
 }
 
 func NewProvider(providerName models.ModelProvider, opts ...ProviderClientOption) (Provider, error) {
-	clientOptions := providerClientOptions{}
+	clientOptions := providerClientOptions{retry: DefaultRetryPolicy()}
 	for _, o := range opts {
 		o(&clientOptions)
 	}
+	clientOptions.retry = clientOptions.retry.withDefaults()
+	if clientOptions.transport == nil {
+		transport, err := cassetteTransport()
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.transport = transport
+	}
+	if recorder, ok := clientOptions.transport.(*Recorder); ok {
+		recorder.Redact(clientOptions.apiKey)
+	}
+	if clientOptions.model.ToolsUnsupported {
+		return newToolEmulationProvider(providerName, clientOptions)
+	}
+	return newProvider(providerName, clientOptions)
+}
+
+func newProvider(providerName models.ModelProvider, clientOptions providerClientOptions) (Provider, error) {
 	switch providerName {
 	case models.ProviderCopilot:
 		return &baseProvider[CopilotClient]{
@@ -160,13 +201,55 @@ func NewProvider(providerName models.ModelProvider, opts ...ProviderClientOption
 			options: clientOptions,
 			client:  newOpenAIClient(clientOptions),
 		}, nil
+	case models.ProviderOllama:
+		// The configured server unless the options name one
+		if cfg := config.Get(); cfg != nil && cfg.Providers[models.ProviderOllama].BaseURL != "" {
+			clientOptions.ollamaOptions = append([]OllamaOption{
+				WithOllamaBaseURL(cfg.Providers[models.ProviderOllama].BaseURL),
+			}, clientOptions.ollamaOptions...)
+		}
+		return &baseProvider[OllamaClient]{
+			options: clientOptions,
+			client:  newOllamaClient(clientOptions),
+		}, nil
 	case models.ProviderMock:
-		// TODO: implement mock client for test
-		panic("not implemented")
+		// The configured script unless the options give one
+		if cfg := config.Get(); cfg != nil && cfg.Providers[models.ProviderMock].Script != "" {
+			script, err := LoadMockScript(cfg.Providers[models.ProviderMock].Script)
+			if err != nil {
+				return nil, err
+			}
+			clientOptions.mockOptions = append([]MockOption{WithMockScript(script)}, clientOptions.mockOptions...)
+		}
+		return &baseProvider[MockClient]{
+			options: clientOptions,
+			client:  newMockClient(clientOptions),
+		}, nil
+	}
+	if cfg := config.Get(); cfg != nil && !models.IsBuiltinProvider(providerName) {
+		if providerCfg, ok := cfg.Providers[providerName]; ok {
+			clientOptions.openaiOptions = append(clientOptions.openaiOptions,
+				WithOpenAIBaseURL(providerCfg.BaseURL),
+				WithOpenAIExtraHeaders(providerCfg.Headers),
+			)
+			return &baseProvider[OpenAIClient]{
+				options: clientOptions,
+				client:  newOpenAIClient(clientOptions),
+			}, nil
+		}
 	}
 	return nil, fmt.Errorf("provider not supported: %s", providerName)
 }
 
+// httpClient returns the client the SDKs send their requests with, nil leaving
+// them to their default one.
+func (o providerClientOptions) httpClient() *http.Client {
+	if o.transport == nil {
+		return nil
+	}
+	return &http.Client{Transport: o.transport}
+}
+
 func (p *baseProvider[C]) cleanMessages(messages []message.Message) (cleaned []message.Message) {
 	for _, msg := range messages {
 		// The message has no content
@@ -178,9 +261,25 @@ func (p *baseProvider[C]) cleanMessages(messages []message.Message) (cleaned []m
 	return
 }
 
+// estimateTokens roughly estimates how many tokens a call with messages
+// takes, for the rate limiter.
+func (p *baseProvider[C]) estimateTokens(messages []message.Message) int64 {
+	return message.EstimateTokens(messages) + int64(len(p.options.systemMessage)/4)
+}
+
 func (p *baseProvider[C]) SendMessages(ctx context.Context, messages []message.Message, tools []tools.BaseTool) (*ProviderResponse, error) {
 	messages = p.cleanMessages(messages)
-	return p.client.send(ctx, messages, tools)
+	call, err := p.options.rateLimiter.wait(ctx, p.estimateTokens(messages), func(wait time.Duration) {
+		logging.WarnPersist(rateLimitWarning(wait), logging.PersistTimeArg, wait)
+	})
+	if err != nil {
+		return nil, err
+	}
+	response, err := p.client.send(ctx, messages, tools)
+	if err == nil {
+		p.options.rateLimiter.record(call, response.Usage)
+	}
+	return response, err
 }
 
 func (p *baseProvider[C]) Model() models.Model {
@@ -189,7 +288,27 @@ func (p *baseProvider[C]) Model() models.Model {
 
 func (p *baseProvider[C]) StreamResponse(ctx context.Context, messages []message.Message, tools []tools.BaseTool) <-chan ProviderEvent {
 	messages = p.cleanMessages(messages)
-	return p.client.stream(ctx, messages, tools)
+	if p.options.rateLimiter == nil {
+		return p.client.stream(ctx, messages, tools)
+	}
+	eventChan := make(chan ProviderEvent)
+	go func() {
+		defer close(eventChan)
+		call, err := p.options.rateLimiter.wait(ctx, p.estimateTokens(messages), func(wait time.Duration) {
+			eventChan <- ProviderEvent{Type: EventWarning, Warning: rateLimitWarning(wait), Wait: wait}
+		})
+		if err != nil {
+			eventChan <- ProviderEvent{Type: EventError, Error: err}
+			return
+		}
+		for event := range p.client.stream(ctx, messages, tools) {
+			if event.Type == EventComplete {
+				p.options.rateLimiter.record(call, event.Response.Usage)
+			}
+			eventChan <- event
+		}
+	}()
+	return eventChan
 }
 
 func WithAPIKey(apiKey string) ProviderClientOption {
@@ -216,6 +335,30 @@ func WithSystemMessage(systemMessage string) ProviderClientOption {
 	}
 }
 
+// WithRetryPolicy sets how failed calls are retried. Fields left unset keep
+// their default.
+func WithRetryPolicy(retry RetryPolicy) ProviderClientOption {
+	return func(options *providerClientOptions) {
+		options.retry = retry
+	}
+}
+
+// WithRateLimit caps the calls made to providerName by all the providers
+// created with the option.
+func WithRateLimit(providerName models.ModelProvider, limit RateLimit) ProviderClientOption {
+	return func(options *providerClientOptions) {
+		options.rateLimiter = sharedRateLimiter(providerName, limit)
+	}
+}
+
+// WithHTTPTransport sends the HTTP requests of the provider through
+// transport, such as a Recorder or a Replayer.
+func WithHTTPTransport(transport http.RoundTripper) ProviderClientOption {
+	return func(options *providerClientOptions) {
+		options.transport = transport
+	}
+}
+
 func WithAnthropicOptions(anthropicOptions ...AnthropicOption) ProviderClientOption {
 	return func(options *providerClientOptions) {
 		options.anthropicOptions = anthropicOptions
@@ -245,3 +388,15 @@ func WithCopilotOptions(copilotOptions ...CopilotOption) ProviderClientOption {
 		options.copilotOptions = copilotOptions
 	}
 }
+
+func WithOllamaOptions(ollamaOptions ...OllamaOption) ProviderClientOption {
+	return func(options *providerClientOptions) {
+		options.ollamaOptions = ollamaOptions
+	}
+}
+
+func WithMockOptions(mockOptions ...MockOption) ProviderClientOption {
+	return func(options *providerClientOptions) {
+		options.mockOptions = mockOptions
+	}
+}
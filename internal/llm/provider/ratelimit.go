@@ -0,0 +1,128 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/opencode-ai/opencode/internal/llm/models"
+)
+
+// rateLimitWindow is the span over which the calls to a provider are counted.
+const rateLimitWindow = time.Minute
+
+// RateLimit caps the calls made to a provider, 0 meaning no cap.
+type RateLimit struct {
+	RequestsPerMinute int
+	TokensPerMinute   int64
+}
+
+var (
+	rateLimitersMu sync.Mutex
+	// rateLimiters holds the limiter of each provider, so that every agent
+	// and sub-agent using a provider counts against the same limits.
+	rateLimiters = map[models.ModelProvider]*rateLimiter{}
+)
+
+// rateLimiter holds calls to a provider back while the calls made over the
+// last rateLimitWindow reach its limits.
+type rateLimiter struct {
+	mu    sync.Mutex
+	limit RateLimit
+	calls []*limitedCall
+}
+
+type limitedCall struct {
+	at     time.Time
+	tokens int64
+}
+
+// sharedRateLimiter returns the limiter of providerName, set to limit, or nil
+// when limit caps nothing.
+func sharedRateLimiter(providerName models.ModelProvider, limit RateLimit) *rateLimiter {
+	if limit.RequestsPerMinute <= 0 && limit.TokensPerMinute <= 0 {
+		return nil
+	}
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+	l, ok := rateLimiters[providerName]
+	if !ok {
+		l = &rateLimiter{}
+		rateLimiters[providerName] = l
+	}
+	l.mu.Lock()
+	l.limit = limit
+	l.mu.Unlock()
+	return l
+}
+
+// wait blocks until a call of about tokens tokens fits in the limits, calling
+// onWait with the time left each time it has to wait, and records the call.
+// A nil limiter never waits.
+func (l *rateLimiter) wait(ctx context.Context, tokens int64, onWait func(time.Duration)) (*limitedCall, error) {
+	if l == nil {
+		return nil, nil
+	}
+	for {
+		l.mu.Lock()
+		call, wait := l.reserve(time.Now(), tokens)
+		l.mu.Unlock()
+		if call != nil {
+			return call, nil
+		}
+		onWait(wait)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// reserve records a call of tokens tokens made at now, or returns how long
+// to wait before it fits in the limits. The caller holds mu.
+func (l *rateLimiter) reserve(now time.Time, tokens int64) (*limitedCall, time.Duration) {
+	start := 0
+	for start < len(l.calls) && now.Sub(l.calls[start].at) >= rateLimitWindow {
+		start++
+	}
+	l.calls = l.calls[start:]
+
+	var wait time.Duration
+	if rpm := l.limit.RequestsPerMinute; rpm > 0 && len(l.calls) >= rpm {
+		wait = l.calls[len(l.calls)-rpm].at.Add(rateLimitWindow).Sub(now)
+	}
+	if tpm := l.limit.TokensPerMinute; tpm > 0 {
+		var used int64
+		for _, call := range l.calls {
+			used += call.tokens
+		}
+		// A call over the limit on its own only waits for the others
+		for i := 0; i < len(l.calls) && used+tokens > tpm; i++ {
+			used -= l.calls[i].tokens
+			wait = max(wait, l.calls[i].at.Add(rateLimitWindow).Sub(now))
+		}
+	}
+	if wait > 0 {
+		return nil, wait
+	}
+	call := &limitedCall{at: now, tokens: tokens}
+	l.calls = append(l.calls, call)
+	return call, 0
+}
+
+// record replaces the estimated tokens of call by those the provider
+// reported.
+func (l *rateLimiter) record(call *limitedCall, usage TokenUsage) {
+	if l == nil || call == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	call.tokens = usage.InputTokens + usage.OutputTokens + usage.CacheCreationTokens + usage.CacheReadTokens
+}
+
+func rateLimitWarning(wait time.Duration) string {
+	return fmt.Sprintf("Client rate limit reached, sending in %s", wait.Round(time.Second))
+}
@@ -0,0 +1,199 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go"
+	"google.golang.org/genai"
+)
+
+const (
+	defaultMaxAttempts = 8
+	defaultBaseBackoff = 2 * time.Second
+	defaultMaxBackoff  = time.Minute
+	defaultJitter      = 0.2
+)
+
+// defaultRetryStatusCodes are the statuses of the calls that are retried
+// unless configured otherwise: rate limits, server errors and overloads.
+var defaultRetryStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusServiceUnavailable,
+	529, // Anthropic's overloaded_error
+}
+
+// RetryPolicy decides whether, and after how long, a failed call to a provider
+// is tried again.
+type RetryPolicy struct {
+	// MaxAttempts is how many times a call is tried at most, the first one
+	// included.
+	MaxAttempts int
+	// BaseBackoff is the wait before the first retry, doubled before each
+	// next one up to MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Jitter is the fraction of the backoff that is added at random, so
+	// agents that were limited together do not retry together.
+	Jitter float64
+	// RetryAfter makes the wait the provider asks for with the Retry-After
+	// header take precedence over the backoff.
+	RetryAfter  bool
+	StatusCodes []int
+}
+
+// DefaultRetryPolicy returns the policy used when none is configured.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: defaultMaxAttempts,
+		BaseBackoff: defaultBaseBackoff,
+		MaxBackoff:  defaultMaxBackoff,
+		Jitter:      defaultJitter,
+		RetryAfter:  true,
+		StatusCodes: defaultRetryStatusCodes,
+	}
+}
+
+// withDefaults fills in the fields of p that are not set.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	defaults := DefaultRetryPolicy()
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaults.MaxAttempts
+	}
+	if p.BaseBackoff <= 0 {
+		p.BaseBackoff = defaults.BaseBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaults.MaxBackoff
+	}
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	}
+	if len(p.StatusCodes) == 0 {
+		p.StatusCodes = defaults.StatusCodes
+	}
+	return p
+}
+
+// shouldRetry tells whether a call that failed with err on its attempts-th
+// try is tried again, and in how many milliseconds. Once the attempts are
+// used up it returns an error wrapping ErrMaxRetries.
+func (p RetryPolicy) shouldRetry(attempts int, err error) (bool, int64, error) {
+	status, header, ok := apiErrorStatus(err)
+	if !ok || !slices.Contains(p.StatusCodes, status) {
+		return false, 0, err
+	}
+	if attempts >= p.MaxAttempts {
+		return false, 0, fmt.Errorf("%w for status %d: %d attempts", ErrMaxRetries, status, p.MaxAttempts)
+	}
+	if p.RetryAfter {
+		// The provider cannot make the agent wait longer than the max backoff
+		if after, ok := retryAfter(header, time.Now()); ok {
+			return true, min(after, p.MaxBackoff).Milliseconds(), nil
+		}
+	}
+	return true, p.backoff(attempts).Milliseconds(), nil
+}
+
+// backoff returns the wait before the retry that follows the attempts-th try.
+func (p RetryPolicy) backoff(attempts int) time.Duration {
+	backoff := p.MaxBackoff
+	// Past 32 doublings any base is over the max
+	if attempts <= 32 {
+		backoff = min(p.BaseBackoff<<(attempts-1), p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff += time.Duration(rand.Float64() * p.Jitter * float64(backoff))
+	}
+	return backoff
+}
+
+// retryWarning describes to the user the retry of a call that failed with
+// err.
+func (p RetryPolicy) retryWarning(err error, attempts int, after int64) string {
+	wait := (time.Duration(after) * time.Millisecond).Round(time.Second)
+	status, _, _ := apiErrorStatus(err)
+	return fmt.Sprintf("%s, retrying in %s (attempt %d of %d)", retryReason(status), wait, attempts+1, p.MaxAttempts)
+}
+
+// retryReason describes the status a call is retried for.
+func retryReason(status int) string {
+	switch status {
+	case http.StatusTooManyRequests:
+		return "Rate limited"
+	case http.StatusServiceUnavailable:
+		return "Provider unavailable"
+	case 529:
+		return "Provider overloaded"
+	case http.StatusInternalServerError:
+		return "Provider error"
+	default:
+		return fmt.Sprintf("Request failed with status %d", status)
+	}
+}
+
+// retryEvent is the warning streamed while a call that failed with err waits
+// to be retried.
+func (p RetryPolicy) retryEvent(err error, attempts int, after int64) ProviderEvent {
+	return ProviderEvent{
+		Type:    EventWarning,
+		Warning: p.retryWarning(err, attempts, after),
+		Wait:    time.Duration(after) * time.Millisecond,
+	}
+}
+
+// apiErrorStatus returns the HTTP status and headers of the response err
+// came with, if it is an error of one of the provider SDKs.
+func apiErrorStatus(err error) (int, http.Header, bool) {
+	var anthropicErr *anthropic.Error
+	var openaiErr *openai.Error
+	var geminiErr genai.APIError
+	var ollamaErr *ollamaError
+	var mockErr *mockError
+	switch {
+	case errors.As(err, &anthropicErr):
+		return anthropicErr.StatusCode, responseHeader(anthropicErr.Response), true
+	case errors.As(err, &openaiErr):
+		return openaiErr.StatusCode, responseHeader(openaiErr.Response), true
+	case errors.As(err, &geminiErr):
+		return geminiErr.Code, nil, true
+	case errors.As(err, &ollamaErr):
+		return ollamaErr.StatusCode, ollamaErr.Header, true
+	case errors.As(err, &mockErr):
+		return mockErr.StatusCode, nil, true
+	}
+	return 0, nil, false
+}
+
+func responseHeader(resp *http.Response) http.Header {
+	if resp == nil {
+		return nil
+	}
+	return resp.Header
+}
+
+// retryAfter parses the wait asked for by the retry-after-ms or Retry-After
+// header, the latter holding either seconds or a date.
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	if ms, err := strconv.ParseFloat(header.Get("retry-after-ms"), 64); err == nil && ms >= 0 {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,107 @@
+package provider
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	apiError := func(status int, header http.Header) error {
+		return &openai.Error{StatusCode: status, Response: &http.Response{StatusCode: status, Header: header}}
+	}
+	policy := RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Second, MaxBackoff: 3 * time.Second, RetryAfter: true}.withDefaults()
+	assert.Equal(t, defaultRetryStatusCodes, policy.StatusCodes)
+
+	retry, after, err := policy.shouldRetry(1, apiError(http.StatusTooManyRequests, nil))
+	require.NoError(t, err)
+	assert.True(t, retry)
+	assert.GreaterOrEqual(t, after, int64(1000))
+	assert.LessOrEqual(t, after, int64(1200), "jitter")
+
+	_, after, _ = policy.shouldRetry(2, apiError(http.StatusTooManyRequests, http.Header{"Retry-After": {"2"}}))
+	assert.Equal(t, int64(2000), after, "Retry-After wins")
+	_, after, _ = policy.shouldRetry(2, apiError(http.StatusTooManyRequests, http.Header{"Retry-After": {"3600"}}))
+	assert.Equal(t, int64(3000), after, "Retry-After capped")
+
+	retry, _, err = policy.shouldRetry(1, apiError(http.StatusBadRequest, nil))
+	assert.False(t, retry)
+	assert.NotErrorIs(t, err, ErrMaxRetries)
+
+	_, _, err = policy.shouldRetry(3, apiError(http.StatusTooManyRequests, nil))
+	assert.ErrorIs(t, err, ErrMaxRetries)
+
+	policy.Jitter = 0
+	assert.Equal(t, 3*time.Second, policy.backoff(3), "capped")
+	assert.Equal(t, 3*time.Second, policy.backoff(100), "no overflow")
+	assert.Equal(t, "Rate limited, retrying in 12s (attempt 2 of 3)", policy.retryWarning(apiError(http.StatusTooManyRequests, nil), 1, 12000))
+	assert.Equal(t, "Provider unavailable, retrying in 2s (attempt 3 of 3)", policy.retryWarning(apiError(http.StatusServiceUnavailable, nil), 2, 2000))
+	assert.Equal(t, "Provider overloaded, retrying in 2s (attempt 2 of 3)", policy.retryWarning(apiError(529, nil), 1, 2000))
+	assert.Equal(t, "Provider error, retrying in 2s (attempt 2 of 3)", policy.retryWarning(apiError(http.StatusInternalServerError, nil), 1, 2000))
+	assert.Equal(t, "Request failed with status 502, retrying in 2s (attempt 2 of 3)", policy.retryWarning(apiError(http.StatusBadGateway, nil), 1, 2000))
+}
+
+func TestRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+		ok     bool
+	}{
+		{"seconds", http.Header{"Retry-After": {"7"}}, 7 * time.Second, true},
+		{"milliseconds", http.Header{"Retry-After-Ms": {"1500"}, "Retry-After": {"2"}}, 1500 * time.Millisecond, true},
+		{"date", http.Header{"Retry-After": {"Wed, 01 Jan 2025 12:00:30 GMT"}}, 30 * time.Second, true},
+		{"past date", http.Header{"Retry-After": {"Wed, 01 Jan 2025 11:00:00 GMT"}}, 0, true},
+		{"invalid", http.Header{"Retry-After": {"soon"}}, 0, false},
+		{"missing", nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, ok := retryAfter(tt.header, now)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	fill := func(limit RateLimit) (*rateLimiter, *limitedCall) {
+		l := &rateLimiter{limit: limit}
+		first, _ := l.reserve(now, 400)
+		require.NotNil(t, first)
+		call, _ := l.reserve(now.Add(10*time.Second), 400)
+		require.NotNil(t, call)
+		return l, first
+	}
+
+	l, _ := fill(RateLimit{RequestsPerMinute: 2})
+	call, wait := l.reserve(now.Add(20*time.Second), 100)
+	assert.Nil(t, call)
+	assert.Equal(t, 40*time.Second, wait, "requests per minute")
+	call, _ = l.reserve(now.Add(time.Minute), 100)
+	assert.NotNil(t, call, "first call out of the window")
+
+	l, first := fill(RateLimit{TokensPerMinute: 1000})
+	call, wait = l.reserve(now.Add(20*time.Second), 500)
+	assert.Nil(t, call)
+	assert.Equal(t, 40*time.Second, wait, "tokens per minute")
+	l.record(first, TokenUsage{InputTokens: 50, OutputTokens: 50})
+	call, _ = l.reserve(now.Add(20*time.Second), 500)
+	assert.NotNil(t, call, "fits with the actual usage")
+
+	call, _ = (&rateLimiter{limit: RateLimit{TokensPerMinute: 10}}).reserve(now, 100)
+	assert.NotNil(t, call, "a call over the limit alone goes through")
+}
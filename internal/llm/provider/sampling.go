@@ -0,0 +1,50 @@
+package provider
+
+import "github.com/opencode-ai/opencode/internal/config"
+
+// Sampling holds the sampling and reasoning parameters an agent calls its
+// model with. Fields left unset keep the defaults of the provider.
+type Sampling struct {
+	Temperature *float64
+	TopP        *float64
+	Stop        []string
+	// ReasoningEffort is low, medium or high, for the models that reason.
+	ReasoningEffort string
+	// BudgetTokens is how many tokens Anthropic and Gemini models may think
+	// with, taking precedence over ReasoningEffort.
+	BudgetTokens int64
+	// IncludeThoughts streams the thoughts of Gemini models.
+	IncludeThoughts bool
+}
+
+// thinkingBudget returns the thinking budget of a call of maxTokens, or 0
+// when s asks for no thinking or the call has no room for it.
+func (s Sampling) thinkingBudget(maxTokens int64) int64 {
+	budget := s.BudgetTokens
+	if budget <= 0 {
+		var share float64
+		switch s.ReasoningEffort {
+		case "low":
+			share = 0.25
+		case "medium":
+			share = 0.5
+		case "high":
+			share = 0.8
+		default:
+			return 0
+		}
+		budget = max(int64(float64(maxTokens)*share), config.MinThinkingBudget)
+	}
+	// The budget is part of the max tokens
+	if budget >= maxTokens {
+		return 0
+	}
+	return budget
+}
+
+// WithSampling sets the sampling and reasoning parameters of the calls.
+func WithSampling(sampling Sampling) ProviderClientOption {
+	return func(options *providerClientOptions) {
+		options.sampling = sampling
+	}
+}
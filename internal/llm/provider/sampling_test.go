@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/llm/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genai"
+)
+
+func TestSamplingThinkingBudget(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		sampling  Sampling
+		maxTokens int64
+		want      int64
+	}{
+		{name: "no reasoning", sampling: Sampling{}, maxTokens: 8000, want: 0},
+		{name: "budget", sampling: Sampling{BudgetTokens: 2048, ReasoningEffort: "high"}, maxTokens: 8000, want: 2048},
+		{name: "low effort", sampling: Sampling{ReasoningEffort: "low"}, maxTokens: 8000, want: 2000},
+		{name: "medium effort", sampling: Sampling{ReasoningEffort: "medium"}, maxTokens: 8000, want: 4000},
+		{name: "high effort", sampling: Sampling{ReasoningEffort: "high"}, maxTokens: 8000, want: 6400},
+		{name: "minimum budget", sampling: Sampling{ReasoningEffort: "low"}, maxTokens: 2000, want: config.MinThinkingBudget},
+		{name: "no room to think", sampling: Sampling{ReasoningEffort: "low"}, maxTokens: 1000, want: 0},
+		{name: "budget over max tokens", sampling: Sampling{BudgetTokens: 8000}, maxTokens: 8000, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, tt.sampling.thinkingBudget(tt.maxTokens))
+		})
+	}
+}
+
+func TestAnthropicSampling(t *testing.T) {
+	t.Parallel()
+
+	temperature, topP := 0.3, 0.9
+	messages := []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock("hi"))}
+	prepare := func(model models.Model, sampling Sampling) anthropic.MessageNewParams {
+		client := newAnthropicClient(providerClientOptions{model: model, maxTokens: 8000, sampling: sampling}).(*anthropicClient)
+		return client.preparedMessages(messages, nil)
+	}
+
+	params := prepare(models.Model{APIModel: "claude"}, Sampling{Temperature: &temperature, TopP: &topP, Stop: []string{"END"}, BudgetTokens: 2048})
+	assert.Equal(t, temperature, params.Temperature.Value)
+	assert.Equal(t, topP, params.TopP.Value)
+	assert.Equal(t, []string{"END"}, params.StopSequences)
+	// The model cannot reason
+	assert.Nil(t, params.Thinking.OfEnabled)
+
+	// Thinking takes a temperature of 1 and no top p
+	params = prepare(models.Model{APIModel: "claude", CanReason: true}, Sampling{Temperature: &temperature, TopP: &topP, BudgetTokens: 2048})
+	require.NotNil(t, params.Thinking.OfEnabled)
+	assert.Equal(t, int64(2048), params.Thinking.OfEnabled.BudgetTokens)
+	assert.Equal(t, 1.0, params.Temperature.Value)
+	assert.False(t, params.TopP.Valid())
+
+	params = prepare(models.Model{APIModel: "claude", CanReason: true}, Sampling{})
+	assert.Nil(t, params.Thinking.OfEnabled)
+	assert.False(t, params.Temperature.Valid())
+	assert.False(t, params.TopP.Valid())
+
+	// A configured top p goes out without a temperature
+	params = prepare(models.Model{APIModel: "claude"}, Sampling{TopP: &topP})
+	assert.False(t, params.Temperature.Valid())
+	assert.Equal(t, topP, params.TopP.Value)
+}
+
+func TestGeminiSampling(t *testing.T) {
+	t.Parallel()
+
+	temperature := 0.5
+	client := &geminiClient{providerOptions: providerClientOptions{
+		model:     models.Model{APIModel: "gemini", CanReason: true},
+		maxTokens: 8000,
+		sampling:  Sampling{Temperature: &temperature, Stop: []string{"END"}, ReasoningEffort: "low", IncludeThoughts: true},
+	}}
+	config := client.generateConfig(nil)
+	assert.Equal(t, genai.Ptr[float32](0.5), config.Temperature)
+	assert.Nil(t, config.TopP)
+	assert.Equal(t, []string{"END"}, config.StopSequences)
+	assert.Equal(t, &genai.ThinkingConfig{IncludeThoughts: true, ThinkingBudget: genai.Ptr[int32](2000)}, config.ThinkingConfig)
+
+	client.providerOptions.model.CanReason = false
+	assert.Nil(t, client.generateConfig(nil).ThinkingConfig)
+}
@@ -0,0 +1,320 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/opencode-ai/opencode/internal/llm/models"
+	"github.com/opencode-ai/opencode/internal/llm/tools"
+	"github.com/opencode-ai/opencode/internal/message"
+)
+
+const (
+	toolCallOpen      = "<tool_call>"
+	toolCallClose     = "</tool_call>"
+	toolResponseOpen  = "<tool_response"
+	toolResponseClose = "</tool_response>"
+)
+
+// toolEmulationProvider lets models without native function calling use
+// tools: the tools are described in the system prompt, the model writes its
+// calls as JSON in <tool_call> blocks, and the calls are parsed out of its
+// text into the events of native tool calls. Past calls and their results are
+// sent back as text.
+type toolEmulationProvider struct {
+	providerName models.ModelProvider
+	options      providerClientOptions
+	// plain answers the calls without tools
+	plain Provider
+
+	mu sync.Mutex
+	// withTools holds a provider with the tools in its system prompt per
+	// set of tools
+	withTools map[string]Provider
+}
+
+func newToolEmulationProvider(providerName models.ModelProvider, options providerClientOptions) (Provider, error) {
+	// The providers built from the options may append to these
+	options.openaiOptions = slices.Clip(options.openaiOptions)
+	options.ollamaOptions = slices.Clip(options.ollamaOptions)
+	options.mockOptions = slices.Clip(options.mockOptions)
+	plain, err := newProvider(providerName, options)
+	if err != nil {
+		return nil, err
+	}
+	return &toolEmulationProvider{
+		providerName: providerName,
+		options:      options,
+		plain:        plain,
+		withTools:    make(map[string]Provider),
+	}, nil
+}
+
+func (p *toolEmulationProvider) Model() models.Model {
+	return p.options.model
+}
+
+// provider returns the provider to send a call with tools to.
+func (p *toolEmulationProvider) provider(tools []tools.BaseTool) (Provider, error) {
+	if len(tools) == 0 {
+		return p.plain, nil
+	}
+	prompt := toolPrompt(tools)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if provider, ok := p.withTools[prompt]; ok {
+		return provider, nil
+	}
+	options := p.options
+	options.systemMessage = strings.TrimSpace(options.systemMessage + "\n\n" + prompt)
+	provider, err := newProvider(p.providerName, options)
+	if err != nil {
+		return nil, err
+	}
+	p.withTools[prompt] = provider
+	return provider, nil
+}
+
+func (p *toolEmulationProvider) SendMessages(ctx context.Context, messages []message.Message, tools []tools.BaseTool) (*ProviderResponse, error) {
+	provider, err := p.provider(tools)
+	if err != nil {
+		return nil, err
+	}
+	response, err := provider.SendMessages(ctx, emulatedHistory(messages), nil)
+	if err != nil || len(tools) == 0 {
+		return response, err
+	}
+	var parser toolCallParser
+	parser.write(response.Content)
+	parser.flush()
+	response.Content = parser.text
+	response.ToolCalls = parser.calls
+	if len(parser.calls) > 0 {
+		response.FinishReason = message.FinishReasonToolUse
+	}
+	return response, nil
+}
+
+func (p *toolEmulationProvider) StreamResponse(ctx context.Context, messages []message.Message, tools []tools.BaseTool) <-chan ProviderEvent {
+	provider, err := p.provider(tools)
+	if err != nil {
+		eventChan := make(chan ProviderEvent, 1)
+		eventChan <- ProviderEvent{Type: EventError, Error: err}
+		close(eventChan)
+		return eventChan
+	}
+	events := provider.StreamResponse(ctx, emulatedHistory(messages), nil)
+	if len(tools) == 0 {
+		return events
+	}
+
+	eventChan := make(chan ProviderEvent)
+	go func() {
+		defer close(eventChan)
+		var parser toolCallParser
+		for event := range events {
+			switch event.Type {
+			case EventContentDelta:
+				parser.events = nil
+				parser.write(event.Content)
+				for _, parsed := range parser.events {
+					eventChan <- parsed
+				}
+				continue
+			case EventContentStop, EventComplete:
+				parser.events = nil
+				parser.flush()
+				for _, parsed := range parser.events {
+					eventChan <- parsed
+				}
+				if event.Type == EventComplete {
+					event.Response.Content = parser.text
+					event.Response.ToolCalls = parser.calls
+					if len(parser.calls) > 0 {
+						event.Response.FinishReason = message.FinishReasonToolUse
+					}
+				}
+			}
+			eventChan <- event
+		}
+	}()
+	return eventChan
+}
+
+// toolCallParser splits the text of a model into its own text and the tool
+// calls it writes, as the text streams in. Text that may be the start of a
+// tag is held back until it is known not to be.
+type toolCallParser struct {
+	// pending is the text not parsed yet
+	pending string
+	inCall  bool
+	// done is set once the model starts making up tool responses, which are
+	// dropped with everything after them
+	done bool
+
+	text   string
+	calls  []message.ToolCall
+	events []ProviderEvent
+}
+
+func (t *toolCallParser) write(text string) {
+	if t.done {
+		return
+	}
+	t.pending += text
+	for {
+		if t.inCall {
+			end := strings.Index(t.pending, toolCallClose)
+			if end == -1 {
+				return
+			}
+			t.call(t.pending[:end])
+			t.pending = t.pending[end+len(toolCallClose):]
+			t.inCall = false
+			continue
+		}
+
+		start := strings.Index(t.pending, toolCallOpen)
+		if response := strings.Index(t.pending, toolResponseOpen); response != -1 && (start == -1 || response < start) {
+			t.content(t.pending[:response])
+			t.pending = ""
+			t.done = true
+			return
+		}
+		if start == -1 {
+			keep := max(partialTagLength(t.pending, toolCallOpen), partialTagLength(t.pending, toolResponseOpen))
+			t.content(t.pending[:len(t.pending)-keep])
+			t.pending = t.pending[len(t.pending)-keep:]
+			return
+		}
+		t.content(t.pending[:start])
+		t.pending = t.pending[start+len(toolCallOpen):]
+		t.inCall = true
+	}
+}
+
+// flush parses what is left once the text is complete, a call the model did
+// not close included.
+func (t *toolCallParser) flush() {
+	if t.inCall {
+		t.call(t.pending)
+		t.inCall = false
+	} else if !t.done {
+		t.content(t.pending)
+	}
+	t.pending = ""
+}
+
+func (t *toolCallParser) content(text string) {
+	// Leave out the blank lines around the calls
+	if len(t.calls) > 0 && strings.TrimSpace(text) == "" {
+		return
+	}
+	if text == "" {
+		return
+	}
+	t.text += text
+	t.events = append(t.events, ProviderEvent{Type: EventContentDelta, Content: text})
+}
+
+func (t *toolCallParser) call(body string) {
+	var parsed struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	body = strings.TrimSpace(body)
+	// Some models fence the JSON
+	body = strings.TrimPrefix(body, "```json")
+	body = strings.TrimPrefix(body, "```")
+	body = strings.TrimSuffix(body, "```")
+	if err := json.Unmarshal([]byte(body), &parsed); err != nil || parsed.Name == "" {
+		// Keep what could not be parsed as text, the model sees its mistake
+		t.content(toolCallOpen + body + toolCallClose)
+		return
+	}
+	input := string(parsed.Arguments)
+	if input == "" || input == "null" {
+		input = "{}"
+	}
+	call := message.ToolCall{
+		ID:       "call_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Name:     parsed.Name,
+		Input:    input,
+		Type:     "function",
+		Finished: true,
+	}
+	t.calls = append(t.calls, call)
+	t.events = append(t.events,
+		ProviderEvent{Type: EventToolUseStart, ToolCall: &call},
+		ProviderEvent{Type: EventToolUseStop, ToolCall: &message.ToolCall{ID: call.ID}},
+	)
+}
+
+// partialTagLength returns the length of the longest end of text that tag
+// starts with.
+func partialTagLength(text, tag string) int {
+	for n := min(len(text), len(tag)-1); n > 0; n-- {
+		if strings.HasSuffix(text, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// toolPrompt describes tools, and how to call them, for the system prompt.
+func toolPrompt(tools []tools.BaseTool) string {
+	var b strings.Builder
+	b.WriteString("# Tools\n\n")
+	b.WriteString("You can call the tools below. To call one, write its name and arguments as JSON in a block like this one, then stop:\n\n")
+	b.WriteString(toolCallOpen + "\n{\"name\": \"tool_name\", \"arguments\": {\"parameter\": \"value\"}}\n" + toolCallClose + "\n\n")
+	b.WriteString("You can call several tools at once, each in its own block. The results come back in the next message, in " + toolResponseOpen + "> blocks. Never write these yourself. Only call the tools listed here, with arguments that match their parameters.\n")
+	for _, tool := range tools {
+		info := tool.Info()
+		parameters, _ := json.Marshal(map[string]any{
+			"type":       "object",
+			"properties": info.Parameters,
+			"required":   info.Required,
+		})
+		fmt.Fprintf(&b, "\n## %s\n\n%s\n\nParameters: %s\n", info.Name, strings.TrimSpace(info.Description), parameters)
+	}
+	return b.String()
+}
+
+// emulatedHistory writes the tool calls and results of messages as text, for
+// models that would not take them otherwise.
+func emulatedHistory(messages []message.Message) []message.Message {
+	converted := make([]message.Message, 0, len(messages))
+	for _, msg := range messages {
+		switch msg.Role {
+		case message.Assistant:
+			calls := msg.ToolCalls()
+			if len(calls) == 0 {
+				break
+			}
+			var b strings.Builder
+			b.WriteString(msg.Content().String())
+			for _, call := range calls {
+				input := call.Input
+				if !json.Valid([]byte(input)) {
+					input = "{}"
+				}
+				fmt.Fprintf(&b, "\n%s\n{\"name\": %q, \"arguments\": %s}\n%s", toolCallOpen, call.Name, input, toolCallClose)
+			}
+			msg.Parts = []message.ContentPart{message.TextContent{Text: strings.TrimSpace(b.String())}}
+		case message.Tool:
+			var b strings.Builder
+			for _, result := range msg.ToolResults() {
+				fmt.Fprintf(&b, "%s name=%q>\n%s\n%s\n", toolResponseOpen, result.Name, result.Content, toolResponseClose)
+			}
+			msg.Role = message.User
+			msg.Parts = []message.ContentPart{message.TextContent{Text: strings.TrimSpace(b.String())}}
+		}
+		converted = append(converted, msg)
+	}
+	return converted
+}
@@ -0,0 +1,102 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opencode-ai/opencode/internal/llm/models"
+	"github.com/opencode-ai/opencode/internal/llm/tools"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToolCallParser(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		chunks []string
+		text   string
+		calls  []string
+	}{
+		{"text only", []string{"Hello <b>", "world</b>"}, "Hello <b>world</b>", nil},
+		{"split tags", []string{"Let me look.\n<tool", `_call>{"name": "view", "arguments": {"file_path": "a.go"}}</tool_`, "call>\n"}, "Let me look.\n", []string{`view {"file_path": "a.go"}`}},
+		{"several calls", []string{"<tool_call>\n{\"name\": \"ls\"}\n</tool_call>\n<tool_call>```json\n{\"name\": \"view\", \"arguments\": {}}\n```</tool_call>"}, "", []string{"ls {}", "view {}"}},
+		{"unclosed call", []string{`<tool_call>{"name": "ls", "arguments": {"path": "."}}`}, "", []string{`ls {"path": "."}`}},
+		{"invalid call", []string{"<tool_call>oops</tool_call>"}, "<tool_call>oops</tool_call>", nil},
+		{"made up response", []string{`<tool_call>{"name": "ls"}</tool_call><tool_res`, "ponse>a.go</tool_response> Done"}, "", []string{"ls {}"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var parser toolCallParser
+			for _, chunk := range tt.chunks {
+				parser.write(chunk)
+			}
+			parser.flush()
+			assert.Equal(t, tt.text, parser.text)
+			var calls []string
+			for _, call := range parser.calls {
+				calls = append(calls, call.Name+" "+call.Input)
+			}
+			assert.Equal(t, tt.calls, calls)
+		})
+	}
+}
+
+func TestToolEmulationProvider(t *testing.T) {
+	t.Parallel()
+
+	var received ollamaChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		for _, chunk := range []string{"Reading it.\n<tool_", `call>{"name": "view", "arguments": {"file_path": "a.go"}}`, "</tool_call>"} {
+			content, _ := json.Marshal(chunk)
+			w.Write([]byte(`{"message": {"role": "assistant", "content": ` + string(content) + `}, "done": false}` + "\n"))
+		}
+		w.Write([]byte(`{"message": {"role": "assistant", "content": ""}, "done": true, "done_reason": "stop"}` + "\n"))
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(models.ProviderOllama,
+		WithModel(models.Model{APIModel: "gemma:2b", ToolsUnsupported: true}),
+		WithSystemMessage("be brief"),
+		WithOllamaOptions(WithOllamaBaseURL(server.URL)),
+	)
+	require.NoError(t, err)
+
+	history := []message.Message{
+		{Role: message.User, Parts: []message.ContentPart{message.TextContent{Text: "list"}}},
+		{Role: message.Assistant, Parts: []message.ContentPart{message.ToolCall{ID: "1", Name: "ls", Input: `{"path": "."}`}}},
+		{Role: message.Tool, Parts: []message.ContentPart{message.ToolResult{ToolCallID: "1", Name: "ls", Content: "a.go"}}},
+	}
+	view := stubTool{tools.ToolInfo{Name: "view", Description: "Reads a file", Parameters: map[string]any{"file_path": map[string]any{"type": "string"}}}}
+	events := collect(p.StreamResponse(context.Background(), history, []tools.BaseTool{view}))
+
+	assert.Empty(t, received.Tools)
+	system := received.Messages[0].Content
+	assert.True(t, strings.HasPrefix(system, "be brief\n\n# Tools"))
+	assert.Contains(t, system, "## view\n\nReads a file")
+	assert.Equal(t, "assistant", received.Messages[2].Role)
+	assert.Contains(t, received.Messages[2].Content, `{"name": "ls", "arguments": {"path": "."}}`)
+	assert.Empty(t, received.Messages[2].ToolCalls)
+	assert.Equal(t, "user", received.Messages[3].Role)
+	assert.Contains(t, received.Messages[3].Content, "<tool_response name=\"ls\">\na.go\n</tool_response>")
+
+	var types []EventType
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []EventType{EventContentStart, EventContentDelta, EventToolUseStart, EventToolUseStop, EventContentStop, EventComplete}, types)
+	assert.Equal(t, "view", events[2].ToolCall.Name)
+	response := events[5].Response
+	assert.Equal(t, "Reading it.\n", response.Content)
+	require.Len(t, response.ToolCalls, 1)
+	assert.JSONEq(t, `{"file_path": "a.go"}`, response.ToolCalls[0].Input)
+	assert.Equal(t, message.FinishReasonToolUse, response.FinishReason)
+}
@@ -17,9 +17,10 @@ func newVertexAIClient(opts providerClientOptions) VertexAIClient {
 	}
 
 	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
-		Project:  os.Getenv("VERTEXAI_PROJECT"),
-		Location: os.Getenv("VERTEXAI_LOCATION"),
-		Backend:  genai.BackendVertexAI,
+		Project:    os.Getenv("VERTEXAI_PROJECT"),
+		Location:   os.Getenv("VERTEXAI_LOCATION"),
+		Backend:    genai.BackendVertexAI,
+		HTTPClient: opts.httpClient(),
 	})
 	if err != nil {
 		logging.Error("Failed to create VertexAI client", "error", err)
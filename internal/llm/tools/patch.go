@@ -316,7 +316,13 @@ func (p *patchTool) Run(ctx context.Context, call ToolCall) (ToolResponse, error
 		file, err := p.files.GetByPathAndSession(ctx, absPath, sessionID)
 		if err != nil && change.Type != diff.ActionAdd {
 			// If not adding a file, create history entry for existing file
-			_, err = p.files.Create(ctx, sessionID, absPath, oldContent)
+			_, err = p.files.Create(ctx, sessionID, messageID, absPath, oldContent)
+			if err != nil {
+				logging.Debug("Error creating file history", "error", err)
+			}
+		} else if err != nil {
+			// New files start from an empty initial version, like in the edit tool
+			_, err = p.files.CreateNew(ctx, sessionID, messageID, absPath)
 			if err != nil {
 				logging.Debug("Error creating file history", "error", err)
 			}
@@ -324,7 +330,7 @@ func (p *patchTool) Run(ctx context.Context, call ToolCall) (ToolResponse, error
 
 		if err == nil && change.Type != diff.ActionAdd && file.Content != oldContent {
 			// User manually changed content, store intermediate version
-			_, err = p.files.CreateVersion(ctx, sessionID, absPath, oldContent)
+			_, err = p.files.CreateVersion(ctx, sessionID, messageID, absPath, oldContent)
 			if err != nil {
 				logging.Debug("Error creating file history version", "error", err)
 			}
@@ -332,9 +338,9 @@ func (p *patchTool) Run(ctx context.Context, call ToolCall) (ToolResponse, error
 
 		// Store new version
 		if change.Type == diff.ActionDelete {
-			_, err = p.files.CreateVersion(ctx, sessionID, absPath, "")
+			_, err = p.files.CreateVersion(ctx, sessionID, messageID, absPath, "")
 		} else {
-			_, err = p.files.CreateVersion(ctx, sessionID, absPath, newContent)
+			_, err = p.files.CreateVersion(ctx, sessionID, messageID, absPath, newContent)
 		}
 		if err != nil {
 			logging.Debug("Error creating file history version", "error", err)
@@ -26,6 +26,7 @@ const (
 	FinishReasonCanceled         FinishReason = "canceled"
 	FinishReasonError            FinishReason = "error"
 	FinishReasonPermissionDenied FinishReason = "permission_denied"
+	FinishReasonBudgetExceeded   FinishReason = "budget_exceeded"
 
 	// Should never happen
 	FinishReasonUnknown FinishReason = "unknown"
@@ -108,6 +109,12 @@ type Finish struct {
 
 func (Finish) isPart() {}
 
+// Interjection marks a user message sent while the agent was running. It is
+// part of that run rather than the prompt of a new one.
+type Interjection struct{}
+
+func (Interjection) isPart() {}
+
 type Message struct {
 	ID        string
 	Role      MessageRole
@@ -176,6 +183,16 @@ func (m *Message) ToolResults() []ToolResult {
 	return toolResults
 }
 
+// IsInterjection tells whether the message was sent to a run in progress.
+func (m *Message) IsInterjection() bool {
+	for _, part := range m.Parts {
+		if _, ok := part.(Interjection); ok {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *Message) IsFinished() bool {
 	for _, part := range m.Parts {
 		if _, ok := part.(Finish); ok {
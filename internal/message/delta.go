@@ -0,0 +1,161 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/opencode-ai/opencode/internal/logging"
+	"github.com/opencode-ai/opencode/internal/pubsub"
+)
+
+const (
+	// deltaFlushInterval is how long deltas of a message are kept in
+	// memory at most before the message is saved.
+	deltaFlushInterval = 500 * time.Millisecond
+	// deltaFlushSize is how much text piles up at most before the message
+	// is saved.
+	deltaFlushSize = 16 * 1024
+)
+
+type DeltaKind string
+
+const (
+	DeltaContent   DeltaKind = "content"
+	DeltaReasoning DeltaKind = "reasoning"
+	DeltaToolInput DeltaKind = "tool_input"
+)
+
+// Delta is text appended to a message while it streams in. Offset is the
+// length of the text it was appended to: subscribers apply deltas to their
+// copy of the message only when it matches, so deltas that arrive twice, out
+// of order or after the message was saved again are ignored.
+type Delta struct {
+	MessageID  string
+	SessionID  string
+	Kind       DeltaKind
+	ToolCallID string
+	Offset     int
+	Text       string
+}
+
+// Apply appends the delta to msg and reports whether it did, which is not the
+// case when msg is behind or ahead of the delta.
+func (d Delta) Apply(msg *Message) bool {
+	if msg.ID != d.MessageID || msg.deltaTarget(d.Kind, d.ToolCallID) != d.Offset {
+		return false
+	}
+	// The parts may be shared with other copies of the message
+	msg.Parts = slices.Clone(msg.Parts)
+	d.appendTo(msg)
+	return true
+}
+
+func (d Delta) appendTo(msg *Message) {
+	switch d.Kind {
+	case DeltaContent:
+		msg.AppendContent(d.Text)
+	case DeltaReasoning:
+		msg.AppendReasoningContent(d.Text)
+	case DeltaToolInput:
+		msg.AppendToolCallInput(d.ToolCallID, d.Text)
+	}
+}
+
+// deltaTarget returns the length of the text deltas of the kind are appended
+// to.
+func (m *Message) deltaTarget(kind DeltaKind, toolCallID string) int {
+	switch kind {
+	case DeltaContent:
+		return len(m.Content().Text)
+	case DeltaReasoning:
+		return len(m.ReasoningContent().Thinking)
+	case DeltaToolInput:
+		for _, call := range m.ToolCalls() {
+			if call.ID == toolCallID {
+				return len(call.Input)
+			}
+		}
+	}
+	return 0
+}
+
+// pendingMessage is a message with deltas that are not saved yet.
+type pendingMessage struct {
+	msg   Message
+	size  int
+	timer *time.Timer
+}
+
+// AppendDelta appends delta to msg and publishes it to the delta subscribers.
+// The message itself is saved, and published, once deltaFlushInterval passed
+// or deltaFlushSize bytes came in since the first delta that is not saved, or
+// when it is updated.
+func (s *service) AppendDelta(ctx context.Context, msg *Message, delta Delta) error {
+	delta.MessageID = msg.ID
+	delta.SessionID = msg.SessionID
+	delta.Offset = msg.deltaTarget(delta.Kind, delta.ToolCallID)
+	delta.appendTo(msg)
+	s.deltas.Publish(pubsub.UpdatedEvent, delta)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pending[msg.ID]
+	if !ok {
+		id := msg.ID
+		p = &pendingMessage{timer: time.AfterFunc(deltaFlushInterval, func() { s.flushPending(id) })}
+		s.pending[msg.ID] = p
+	}
+	// Keep a copy to save, msg is still written to by the caller
+	p.msg = *msg
+	p.msg.Parts = slices.Clone(msg.Parts)
+	p.size += len(delta.Text)
+	if p.size < deltaFlushSize {
+		return nil
+	}
+	p.timer.Stop()
+	delete(s.pending, msg.ID)
+	return s.save(ctx, *msg)
+}
+
+// SubscribeDeltas returns the deltas appended to messages, as they come in.
+func (s *service) SubscribeDeltas(ctx context.Context) <-chan pubsub.Event[Delta] {
+	return s.deltas.Subscribe(ctx)
+}
+
+// Flush saves the messages with deltas that are not saved yet.
+func (s *service) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for id, p := range s.pending {
+		p.timer.Stop()
+		delete(s.pending, id)
+		errs = append(errs, s.save(ctx, p.msg))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *service) flushPending(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pending[id]
+	if !ok {
+		// Saved meanwhile
+		return
+	}
+	delete(s.pending, id)
+	if err := s.save(context.Background(), p.msg); err != nil {
+		logging.Error("Failed to save streamed message", "id", id, "error", err)
+	}
+}
+
+// dropPending forgets the deltas of a message that is deleted. The caller
+// holds mu.
+func (s *service) dropPending(id string) {
+	if p, ok := s.pending[id]; ok {
+		p.timer.Stop()
+		delete(s.pending, id)
+	}
+}
@@ -0,0 +1,29 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeltaApply(t *testing.T) {
+	t.Parallel()
+
+	msg := Message{ID: "msg", Parts: []ContentPart{TextContent{Text: "Hello"}, ToolCall{ID: "call", Input: `{"a"`}}}
+	shared := msg
+
+	assert.True(t, Delta{MessageID: "msg", Kind: DeltaContent, Offset: 5, Text: ", world"}.Apply(&msg))
+	assert.Equal(t, "Hello, world", msg.Content().Text)
+	assert.Equal(t, "Hello", shared.Content().Text, "other copies are untouched")
+
+	assert.False(t, Delta{MessageID: "msg", Kind: DeltaContent, Offset: 5, Text: ", world"}.Apply(&msg), "applied already")
+	assert.False(t, Delta{MessageID: "msg", Kind: DeltaContent, Offset: 20, Text: "!"}.Apply(&msg), "earlier delta missing")
+	assert.False(t, Delta{MessageID: "other", Kind: DeltaContent, Offset: 12, Text: "!"}.Apply(&msg), "other message")
+	assert.Equal(t, "Hello, world", msg.Content().Text)
+
+	assert.True(t, Delta{MessageID: "msg", Kind: DeltaToolInput, ToolCallID: "call", Offset: 4, Text: `: 1}`}.Apply(&msg))
+	assert.Equal(t, `{"a": 1}`, msg.ToolCalls()[0].Input)
+
+	assert.True(t, Delta{MessageID: "msg", Kind: DeltaReasoning, Text: "Hmm"}.Apply(&msg), "first reasoning delta")
+	assert.Equal(t, "Hmm", msg.ReasoningContent().Thinking)
+}
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -27,17 +29,32 @@ type Service interface {
 	List(ctx context.Context, sessionID string) ([]Message, error)
 	Delete(ctx context.Context, id string) error
 	DeleteSessionMessages(ctx context.Context, sessionID string) error
+	Rewind(ctx context.Context, sessionID, messageID string) error
+	// AppendDelta appends streamed text to msg. Deltas are published right
+	// away, but the message is only saved from time to time; call Update
+	// once it is done.
+	AppendDelta(ctx context.Context, msg *Message, delta Delta) error
+	SubscribeDeltas(ctx context.Context) <-chan pubsub.Event[Delta]
+	// Flush saves the messages with deltas that are not saved yet.
+	Flush(ctx context.Context) error
 }
 
 type service struct {
 	*pubsub.Broker[Message]
 	q db.Querier
+
+	deltas *pubsub.Broker[Delta]
+	// mu guards pending and orders the saves of messages being streamed.
+	mu      sync.Mutex
+	pending map[string]*pendingMessage
 }
 
 func NewService(q db.Querier) Service {
 	return &service{
-		Broker: pubsub.NewBroker[Message](),
-		q:      q,
+		Broker:  pubsub.NewBroker[Message](),
+		q:       q,
+		deltas:  pubsub.NewBroker[Delta](),
+		pending: make(map[string]*pendingMessage),
 	}
 }
 
@@ -46,6 +63,9 @@ func (s *service) Delete(ctx context.Context, id string) error {
 	if err != nil {
 		return err
 	}
+	s.mu.Lock()
+	s.dropPending(message.ID)
+	s.mu.Unlock()
 	err = s.q.DeleteMessage(ctx, message.ID)
 	if err != nil {
 		return err
@@ -98,7 +118,37 @@ func (s *service) DeleteSessionMessages(ctx context.Context, sessionID string) e
 	return nil
 }
 
+// Rewind deletes the given message and every message that came after it in
+// the session, so the conversation continues from the message before it.
+func (s *service) Rewind(ctx context.Context, sessionID, messageID string) error {
+	messages, err := s.List(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	idx := slices.IndexFunc(messages, func(m Message) bool { return m.ID == messageID })
+	if idx == -1 {
+		return fmt.Errorf("message %s not found in session %s", messageID, sessionID)
+	}
+	// Delete from the end so the session never shows a reply without the
+	// message it answers.
+	for i := len(messages) - 1; i >= idx; i-- {
+		if err := s.Delete(ctx, messages[i].ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *service) Update(ctx context.Context, message Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The message replaces the deltas that are not saved yet
+	s.dropPending(message.ID)
+	return s.save(ctx, message)
+}
+
+// save writes message to the database and publishes it. The caller holds mu.
+func (s *service) save(ctx context.Context, message Message) error {
 	parts, err := marshallParts(message.Parts)
 	if err != nil {
 		return err
@@ -112,11 +162,14 @@ func (s *service) Update(ctx context.Context, message Message) error {
 		ID:         message.ID,
 		Parts:      string(parts),
 		FinishedAt: finishedAt,
+		Model:      sql.NullString{String: string(message.Model), Valid: true},
 	})
 	if err != nil {
 		return err
 	}
 	message.UpdatedAt = time.Now().Unix()
+	// Subscribers must not see later changes made by the caller
+	message.Parts = slices.Clone(message.Parts)
 	s.Publish(pubsub.UpdatedEvent, message)
 	return nil
 }
@@ -163,13 +216,14 @@ func (s *service) fromDBItem(item db.Message) (Message, error) {
 type partType string
 
 const (
-	reasoningType  partType = "reasoning"
-	textType       partType = "text"
-	imageURLType   partType = "image_url"
-	binaryType     partType = "binary"
-	toolCallType   partType = "tool_call"
-	toolResultType partType = "tool_result"
-	finishType     partType = "finish"
+	reasoningType    partType = "reasoning"
+	textType         partType = "text"
+	imageURLType     partType = "image_url"
+	binaryType       partType = "binary"
+	toolCallType     partType = "tool_call"
+	toolResultType   partType = "tool_result"
+	finishType       partType = "finish"
+	interjectionType partType = "interjection"
 )
 
 type partWrapper struct {
@@ -198,6 +252,8 @@ func marshallParts(parts []ContentPart) ([]byte, error) {
 			typ = toolResultType
 		case Finish:
 			typ = finishType
+		case Interjection:
+			typ = interjectionType
 		default:
 			return nil, fmt.Errorf("unknown part type: %T", part)
 		}
@@ -271,6 +327,8 @@ func unmarshallParts(data []byte) ([]ContentPart, error) {
 				return nil, err
 			}
 			parts = append(parts, part)
+		case interjectionType:
+			parts = append(parts, Interjection{})
 		default:
 			return nil, fmt.Errorf("unknown part type: %s", wrapper.Type)
 		}
@@ -0,0 +1,30 @@
+package message
+
+const (
+	// charsPerToken is a rough average used to estimate token counts.
+	charsPerToken = 4
+	// imageTokens is a rough estimate of what an attached image costs.
+	imageTokens = 1500
+)
+
+// EstimateTokens roughly estimates how many tokens msgs take up in a prompt.
+func EstimateTokens(msgs []Message) int64 {
+	var chars, tokens int64
+	for _, msg := range msgs {
+		for _, part := range msg.Parts {
+			switch p := part.(type) {
+			case TextContent:
+				chars += int64(len(p.Text))
+			case ReasoningContent:
+				chars += int64(len(p.Thinking))
+			case ToolCall:
+				chars += int64(len(p.Name) + len(p.Input))
+			case ToolResult:
+				chars += int64(len(p.Name) + len(p.Content))
+			case BinaryContent, ImageURLContent:
+				tokens += imageTokens
+			}
+		}
+	}
+	return tokens + chars/charsPerToken
+}
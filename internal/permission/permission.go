@@ -14,22 +14,29 @@ import (
 var ErrorPermissionDenied = errors.New("permission denied")
 
 type CreatePermissionRequest struct {
-	SessionID   string `json:"session_id"`
-	ToolName    string `json:"tool_name"`
-	Description string `json:"description"`
-	Action      string `json:"action"`
-	Params      any    `json:"params"`
-	Path        string `json:"path"`
+	SessionID       string `json:"session_id"`
+	ParentSessionID string `json:"parent_session_id,omitempty"`
+	Agent           string `json:"agent,omitempty"`
+	ToolName        string `json:"tool_name"`
+	Description     string `json:"description"`
+	Action          string `json:"action"`
+	Params          any    `json:"params"`
+	Path            string `json:"path"`
 }
 
+// PermissionRequest is a request of a tool waiting for the user's answer.
+// ParentSessionID and Agent are only set when a sub-agent asks, to the session
+// that launched it and a description of the sub-agent.
 type PermissionRequest struct {
-	ID          string `json:"id"`
-	SessionID   string `json:"session_id"`
-	ToolName    string `json:"tool_name"`
-	Description string `json:"description"`
-	Action      string `json:"action"`
-	Params      any    `json:"params"`
-	Path        string `json:"path"`
+	ID              string `json:"id"`
+	SessionID       string `json:"session_id"`
+	ParentSessionID string `json:"parent_session_id,omitempty"`
+	Agent           string `json:"agent,omitempty"`
+	ToolName        string `json:"tool_name"`
+	Description     string `json:"description"`
+	Action          string `json:"action"`
+	Params          any    `json:"params"`
+	Path            string `json:"path"`
 }
 
 type Service interface {
@@ -72,7 +79,9 @@ func (s *permissionService) Deny(permission PermissionRequest) {
 }
 
 func (s *permissionService) Request(opts CreatePermissionRequest) bool {
-	if slices.Contains(s.autoApproveSessions, opts.SessionID) {
+	// Sub-agents of an auto-approved session are auto-approved as well
+	if slices.Contains(s.autoApproveSessions, opts.SessionID) ||
+		(opts.ParentSessionID != "" && slices.Contains(s.autoApproveSessions, opts.ParentSessionID)) {
 		return true
 	}
 	dir := filepath.Dir(opts.Path)
@@ -80,13 +89,15 @@ func (s *permissionService) Request(opts CreatePermissionRequest) bool {
 		dir = config.WorkingDirectory()
 	}
 	permission := PermissionRequest{
-		ID:          uuid.New().String(),
-		Path:        dir,
-		SessionID:   opts.SessionID,
-		ToolName:    opts.ToolName,
-		Description: opts.Description,
-		Action:      opts.Action,
-		Params:      opts.Params,
+		ID:              uuid.New().String(),
+		Path:            dir,
+		SessionID:       opts.SessionID,
+		ParentSessionID: opts.ParentSessionID,
+		Agent:           opts.Agent,
+		ToolName:        opts.ToolName,
+		Description:     opts.Description,
+		Action:          opts.Action,
+		Params:          opts.Params,
 	}
 
 	for _, p := range s.sessionPermissions {
@@ -117,3 +128,51 @@ func NewPermissionService() Service {
 		sessionPermissions: make([]PermissionRequest, 0),
 	}
 }
+
+// policyService answers permission requests according to a policy instead of
+// asking the user.
+type policyService struct {
+	Service
+	policy config.PermissionPolicy
+}
+
+func (s *policyService) Request(opts CreatePermissionRequest) bool {
+	return s.policy == config.PermissionAllow
+}
+
+// WithPolicy returns a service that answers the requests according to policy.
+// Requests are passed on to s when the policy is to ask the user.
+func WithPolicy(s Service, policy config.PermissionPolicy) Service {
+	if policy == "" || policy == config.PermissionAsk {
+		return s
+	}
+	return &policyService{
+		Service: s,
+		policy:  policy,
+	}
+}
+
+// subAgentService marks the permission requests of a sub-agent with the
+// session that launched it.
+type subAgentService struct {
+	Service
+	parentSessionID string
+	agent           string
+}
+
+func (s *subAgentService) Request(opts CreatePermissionRequest) bool {
+	opts.ParentSessionID = s.parentSessionID
+	opts.Agent = s.agent
+	return s.Service.Request(opts)
+}
+
+// ForSubAgent returns a service for the tools of a sub-agent launched from
+// parentSessionID. Its requests say which agent is asking, and are approved
+// automatically when the parent session is.
+func ForSubAgent(s Service, parentSessionID, agent string) Service {
+	return &subAgentService{
+		Service:         s,
+		parentSessionID: parentSessionID,
+		agent:           agent,
+	}
+}
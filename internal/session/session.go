@@ -3,30 +3,49 @@ package session
 import (
 	"context"
 	"database/sql"
+	"fmt"
 
 	"github.com/google/uuid"
+	"github.com/opencode-ai/opencode/internal/config"
 	"github.com/opencode-ai/opencode/internal/db"
+	"github.com/opencode-ai/opencode/internal/message"
 	"github.com/opencode-ai/opencode/internal/pubsub"
 )
 
+// Session is a conversation with the agent. PromptTokens, CompletionTokens
+// and Cost are the totals of the provider calls recorded in the usage ledger
+// and are not changed by Save. ContextTokens is the size of the conversation
+// as of the last call.
+//
+// Once the session is compacted, the conversation starts with the summary in
+// SummaryMessageID, followed by the messages from SummaryKeepMessageID up to
+// the summary, which were kept verbatim, and the messages after the summary.
+//
+// Agent is the agent that runs a sub-agent session, and is empty for the
+// sessions run by the coder agent.
 type Session struct {
-	ID               string
-	ParentSessionID  string
-	Title            string
-	MessageCount     int64
-	PromptTokens     int64
-	CompletionTokens int64
-	SummaryMessageID string
-	Cost             float64
-	CreatedAt        int64
-	UpdatedAt        int64
+	ID                   string
+	ParentSessionID      string
+	Title                string
+	MessageCount         int64
+	PromptTokens         int64
+	CompletionTokens     int64
+	ContextTokens        int64
+	SummaryMessageID     string
+	SummaryKeepMessageID string
+	ForkMessageID        string
+	Agent                config.AgentName
+	Cost                 float64
+	CreatedAt            int64
+	UpdatedAt            int64
 }
 
 type Service interface {
 	pubsub.Suscriber[Session]
 	Create(ctx context.Context, title string) (Session, error)
 	CreateTitleSession(ctx context.Context, parentSessionID string) (Session, error)
-	CreateTaskSession(ctx context.Context, toolCallID, parentSessionID, title string) (Session, error)
+	CreateTaskSession(ctx context.Context, toolCallID, parentSessionID string, agent config.AgentName, title string) (Session, error)
+	Fork(ctx context.Context, sessionID, messageID string) (Session, error)
 	Get(ctx context.Context, id string) (Session, error)
 	List(ctx context.Context) ([]Session, error)
 	Save(ctx context.Context, session Session) (Session, error)
@@ -35,7 +54,8 @@ type Service interface {
 
 type service struct {
 	*pubsub.Broker[Session]
-	q db.Querier
+	db *sql.DB
+	q  *db.Queries
 }
 
 func (s *service) Create(ctx context.Context, title string) (Session, error) {
@@ -51,11 +71,12 @@ func (s *service) Create(ctx context.Context, title string) (Session, error) {
 	return session, nil
 }
 
-func (s *service) CreateTaskSession(ctx context.Context, toolCallID, parentSessionID, title string) (Session, error) {
+func (s *service) CreateTaskSession(ctx context.Context, toolCallID, parentSessionID string, agent config.AgentName, title string) (Session, error) {
 	dbSession, err := s.q.CreateSession(ctx, db.CreateSessionParams{
 		ID:              toolCallID,
 		ParentSessionID: sql.NullString{String: parentSessionID, Valid: true},
 		Title:           title,
+		Agent:           sql.NullString{String: string(agent), Valid: true},
 	})
 	if err != nil {
 		return Session{}, err
@@ -79,6 +100,146 @@ func (s *service) CreateTitleSession(ctx context.Context, parentSessionID string
 	return session, nil
 }
 
+// Fork creates a new session that branches off sessionID at messageID. The
+// messages up to and including messageID are copied into the new session,
+// together with the tool results that answer it, and so are the file history
+// versions recorded up to that point. The original session is left untouched,
+// and the fork is created as a whole or not at all.
+func (s *service) Fork(ctx context.Context, sessionID, messageID string) (Session, error) {
+	parent, err := s.Get(ctx, sessionID)
+	if err != nil {
+		return Session{}, err
+	}
+	dbMessages, err := s.q.ListMessagesBySession(ctx, sessionID)
+	if err != nil {
+		return Session{}, err
+	}
+
+	end := -1
+	for i, dbMessage := range dbMessages {
+		if dbMessage.ID == messageID {
+			end = i + 1
+			break
+		}
+	}
+	if end == -1 {
+		return Session{}, fmt.Errorf("message %s not found in session %s", messageID, sessionID)
+	}
+	// Keep the tool results of the fork message, otherwise the tool calls in
+	// it would be left unanswered.
+	for end < len(dbMessages) && dbMessages[end].Role == string(message.Tool) {
+		end++
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	qtx := s.q.WithTx(tx)
+
+	dbSession, err := qtx.CreateSession(ctx, db.CreateSessionParams{
+		ID:              uuid.New().String(),
+		ParentSessionID: sql.NullString{String: parent.ID, Valid: true},
+		Title:           parent.Title + " (fork)",
+		ForkMessageID:   sql.NullString{String: messageID, Valid: true},
+	})
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to fork session: %w", err)
+	}
+	forked := s.fromDBItem(dbSession)
+
+	if err := copyForkHistory(ctx, qtx, parent, forked, dbMessages[:end], dbMessages[end:]); err != nil {
+		return Session{}, fmt.Errorf("failed to fork session: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return Session{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// Reload the session to pick up the message count and summary.
+	session, err := s.Get(ctx, forked.ID)
+	if err != nil {
+		return Session{}, err
+	}
+	s.Publish(pubsub.CreatedEvent, session)
+	return session, nil
+}
+
+func copyForkHistory(ctx context.Context, q *db.Queries, parent, forked Session, kept, dropped []db.Message) error {
+	summaryMessageID, summaryKeepMessageID := "", ""
+	// copies maps the IDs of the kept messages to the IDs of their copies
+	copies := make(map[string]string, len(kept))
+	for _, dbMessage := range kept {
+		copied, err := q.CopyMessage(ctx, db.CopyMessageParams{
+			ID:         uuid.New().String(),
+			SessionID:  forked.ID,
+			Role:       dbMessage.Role,
+			Parts:      dbMessage.Parts,
+			Model:      dbMessage.Model,
+			CreatedAt:  dbMessage.CreatedAt,
+			UpdatedAt:  dbMessage.UpdatedAt,
+			FinishedAt: dbMessage.FinishedAt,
+		})
+		if err != nil {
+			return err
+		}
+		copies[dbMessage.ID] = copied.ID
+		switch dbMessage.ID {
+		case parent.SummaryMessageID:
+			summaryMessageID = copied.ID
+		case parent.SummaryKeepMessageID:
+			summaryKeepMessageID = copied.ID
+		}
+	}
+
+	// File versions recorded by a message that is not part of the fork belong
+	// to the part of the conversation that was left out. The versions that
+	// were not recorded by a message of the session are placed by time.
+	dbFiles, err := q.ListFilesBySession(ctx, parent.ID)
+	if err != nil {
+		return err
+	}
+	droppedIDs := make(map[string]bool, len(dropped))
+	for _, dbMessage := range dropped {
+		droppedIDs[dbMessage.ID] = true
+	}
+	for _, dbFile := range dbFiles {
+		copiedMessageID, isKept := copies[dbFile.MessageID.String]
+		if !isKept && (droppedIDs[dbFile.MessageID.String] ||
+			len(dropped) > 0 && dbFile.CreatedAt >= dropped[0].CreatedAt) {
+			continue
+		}
+		_, err := q.CopyFile(ctx, db.CopyFileParams{
+			ID:        uuid.New().String(),
+			SessionID: forked.ID,
+			Path:      dbFile.Path,
+			Content:   dbFile.Content,
+			Version:   dbFile.Version,
+			CreatedAt: dbFile.CreatedAt,
+			UpdatedAt: dbFile.UpdatedAt,
+			MessageID: sql.NullString{String: copiedMessageID, Valid: isKept},
+			IsNew:     dbFile.IsNew,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if summaryMessageID == "" {
+		return nil
+	}
+	_, err = q.UpdateSession(ctx, db.UpdateSessionParams{
+		ID:               forked.ID,
+		Title:            forked.Title,
+		SummaryMessageID: sql.NullString{String: summaryMessageID, Valid: true},
+		SummaryKeepMessageID: sql.NullString{
+			String: summaryKeepMessageID,
+			Valid:  summaryKeepMessageID != "",
+		},
+	})
+	return err
+}
+
 func (s *service) Delete(ctx context.Context, id string) error {
 	session, err := s.Get(ctx, id)
 	if err != nil {
@@ -102,15 +263,17 @@ func (s *service) Get(ctx context.Context, id string) (Session, error) {
 
 func (s *service) Save(ctx context.Context, session Session) (Session, error) {
 	dbSession, err := s.q.UpdateSession(ctx, db.UpdateSessionParams{
-		ID:               session.ID,
-		Title:            session.Title,
-		PromptTokens:     session.PromptTokens,
-		CompletionTokens: session.CompletionTokens,
+		ID:    session.ID,
+		Title: session.Title,
 		SummaryMessageID: sql.NullString{
 			String: session.SummaryMessageID,
 			Valid:  session.SummaryMessageID != "",
 		},
-		Cost: session.Cost,
+		SummaryKeepMessageID: sql.NullString{
+			String: session.SummaryKeepMessageID,
+			Valid:  session.SummaryKeepMessageID != "",
+		},
+		ContextTokens: session.ContextTokens,
 	})
 	if err != nil {
 		return Session{}, err
@@ -134,23 +297,28 @@ func (s *service) List(ctx context.Context) ([]Session, error) {
 
 func (s service) fromDBItem(item db.Session) Session {
 	return Session{
-		ID:               item.ID,
-		ParentSessionID:  item.ParentSessionID.String,
-		Title:            item.Title,
-		MessageCount:     item.MessageCount,
-		PromptTokens:     item.PromptTokens,
-		CompletionTokens: item.CompletionTokens,
-		SummaryMessageID: item.SummaryMessageID.String,
-		Cost:             item.Cost,
-		CreatedAt:        item.CreatedAt,
-		UpdatedAt:        item.UpdatedAt,
+		ID:                   item.ID,
+		ParentSessionID:      item.ParentSessionID.String,
+		Title:                item.Title,
+		MessageCount:         item.MessageCount,
+		PromptTokens:         item.PromptTokens,
+		CompletionTokens:     item.CompletionTokens,
+		ContextTokens:        item.ContextTokens,
+		SummaryMessageID:     item.SummaryMessageID.String,
+		SummaryKeepMessageID: item.SummaryKeepMessageID.String,
+		ForkMessageID:        item.ForkMessageID.String,
+		Agent:                config.AgentName(item.Agent.String),
+		Cost:                 item.Cost,
+		CreatedAt:            item.CreatedAt,
+		UpdatedAt:            item.UpdatedAt,
 	}
 }
 
-func NewService(q db.Querier) Service {
+func NewService(q *db.Queries, db *sql.DB) Service {
 	broker := pubsub.NewBroker[Session]()
 	return &service{
-		broker,
-		q,
+		Broker: broker,
+		db:     db,
+		q:      q,
 	}
 }
@@ -0,0 +1,115 @@
+package session
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opencode-ai/opencode/internal/db"
+	"github.com/opencode-ai/opencode/internal/history"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFork(t *testing.T) {
+	conn, err := db.ConnectDir(t.TempDir())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx := context.Background()
+	q := db.New(conn)
+	sessions := NewService(q, conn)
+	messages := message.NewService(q)
+	files := history.NewService(q, conn)
+
+	parent, err := sessions.Create(ctx, "parent")
+	require.NoError(t, err)
+	say := func(role message.MessageRole, parts ...message.ContentPart) message.Message {
+		msg, err := messages.Create(ctx, parent.ID, message.CreateMessageParams{Role: role, Parts: parts})
+		require.NoError(t, err)
+		return msg
+	}
+	record := func(msg message.Message, path, content string) {
+		_, err := files.CreateVersion(ctx, parent.ID, msg.ID, path, content)
+		require.NoError(t, err)
+	}
+
+	// All of it happens within the same second
+	summary := say(message.Assistant, message.TextContent{Text: "summary"})
+	say(message.User, message.TextContent{Text: "write a"})
+	call := say(message.Assistant, message.ToolCall{ID: "call_1", Name: "write", Input: "{}"})
+	record(call, "a.go", "one")
+	say(message.Tool, message.ToolResult{ToolCallID: "call_1", Name: "write", Content: "ok"})
+	say(message.User, message.TextContent{Text: "write b"})
+	later := say(message.Assistant, message.ToolCall{ID: "call_2", Name: "write", Input: "{}"})
+	record(later, "a.go", "two")
+	record(later, "b.go", "bee")
+	parent.SummaryMessageID = summary.ID
+	parent, err = sessions.Save(ctx, parent)
+	require.NoError(t, err)
+
+	fork, err := sessions.Fork(ctx, parent.ID, call.ID)
+	require.NoError(t, err)
+	assert.Equal(t, parent.ID, fork.ParentSessionID)
+	assert.Equal(t, call.ID, fork.ForkMessageID)
+
+	// The tool result of the fork message comes along
+	copied, err := messages.List(ctx, fork.ID)
+	require.NoError(t, err)
+	require.Len(t, copied, 4)
+	assert.Equal(t, []message.MessageRole{message.Assistant, message.User, message.Assistant, message.Tool},
+		[]message.MessageRole{copied[0].Role, copied[1].Role, copied[2].Role, copied[3].Role})
+	assert.Equal(t, "write a", copied[1].Content().String())
+	assert.Equal(t, copied[0].ID, fork.SummaryMessageID)
+	assert.EqualValues(t, 4, fork.MessageCount)
+
+	// Only the versions recorded by the kept messages are copied, and they
+	// point at the copies
+	forkFiles, err := files.ListBySession(ctx, fork.ID)
+	require.NoError(t, err)
+	require.Len(t, forkFiles, 1)
+	assert.Equal(t, "a.go", forkFiles[0].Path)
+	assert.Equal(t, "one", forkFiles[0].Content)
+	assert.Equal(t, copied[2].ID, forkFiles[0].MessageID)
+
+	parentMessages, err := messages.List(ctx, parent.ID)
+	require.NoError(t, err)
+	assert.Len(t, parentMessages, 6)
+	parentFiles, err := files.ListBySession(ctx, parent.ID)
+	require.NoError(t, err)
+	assert.Len(t, parentFiles, 3)
+}
+
+func TestForkFailure(t *testing.T) {
+	conn, err := db.ConnectDir(t.TempDir())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx := context.Background()
+	q := db.New(conn)
+	sessions := NewService(q, conn)
+	messages := message.NewService(q)
+
+	parent, err := sessions.Create(ctx, "parent")
+	require.NoError(t, err)
+	prompt, err := messages.Create(ctx, parent.ID, message.CreateMessageParams{Role: message.User, Parts: []message.ContentPart{message.TextContent{Text: "hi"}}})
+	require.NoError(t, err)
+
+	_, err = sessions.Fork(ctx, parent.ID, "missing")
+	assert.ErrorContains(t, err, "not found")
+
+	// Copying the file history fails after the session and its messages
+	// were created, none of which is kept
+	_, err = conn.ExecContext(ctx, "DROP TABLE files")
+	require.NoError(t, err)
+	_, err = sessions.Fork(ctx, parent.ID, prompt.ID)
+	assert.ErrorContains(t, err, "failed to fork session")
+
+	listed, err := sessions.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, parent.ID, listed[0].ID)
+	var count int
+	require.NoError(t, conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM messages").Scan(&count))
+	assert.Equal(t, 1, count)
+}
@@ -17,6 +17,28 @@ import (
 type SendMsg struct {
 	Text        string
 	Attachments []message.Attachment
+
+	// EditMessageID is set when the text replaces an earlier user message,
+	// in which case the messages from it onwards are dropped.
+	EditMessageID string
+	// KeepBranch keeps the dropped messages in a forked session.
+	KeepBranch bool
+	// QueuedPromptID is set when the text replaces a queued prompt.
+	QueuedPromptID string
+	// Interject sends the text to the current run of the agent instead of
+	// queueing it.
+	Interject bool
+}
+
+// QueueChangedMsg reports that the prompt queue of a session changed.
+type QueueChangedMsg struct {
+	SessionID string
+}
+
+// EditMessageMsg starts editing an earlier user message, or stops editing
+// when Message is empty.
+type EditMessageMsg struct {
+	Message message.Message
 }
 
 type SessionSelectedMsg = session.Session
@@ -25,6 +47,12 @@ type SessionClearedMsg struct{}
 
 type EditorFocusMsg bool
 
+// PlanModeMsg reports that plan mode was switched on or off.
+type PlanModeMsg bool
+
+// AgentSwitchedMsg reports that the coder agent now acts as another agent.
+type AgentSwitchedMsg config.AgentName
+
 func header(width int) string {
 	return lipgloss.JoinVertical(
 		lipgloss.Top,
@@ -138,4 +166,3 @@ func cwd(width int) string {
 		Width(width).
 		Render(cwd)
 }
-
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"slices"
 	"strings"
 	"unicode"
@@ -31,11 +32,26 @@ type editorCmp struct {
 	textarea    textarea.Model
 	attachments []message.Attachment
 	deleteMode  bool
+
+	// editingMsgID is the earlier user message the editor content replaces
+	editingMsgID string
+	keepBranch   bool
+
+	// editingQueuedID is the queued prompt the editor content replaces
+	editingQueuedID string
+	queued          int
+	// interjected counts the queued messages that wait for the current run
+	// rather than run after it
+	interjected int
+	// queueHeld is set when the queued prompts wait after a failed run
+	queueHeld bool
 }
 
 type EditorKeyMaps struct {
 	Send       key.Binding
 	OpenEditor key.Binding
+	KeepBranch key.Binding
+	Interject  key.Binding
 }
 
 type bluredEditorKeyMaps struct {
@@ -58,6 +74,14 @@ var editorMaps = EditorKeyMaps{
 		key.WithKeys("ctrl+e"),
 		key.WithHelp("ctrl+e", "open editor"),
 	),
+	KeepBranch: key.NewBinding(
+		key.WithKeys("ctrl+b"),
+		key.WithHelp("ctrl+b", "keep edited messages as a branch"),
+	),
+	Interject: key.NewBinding(
+		key.WithKeys("ctrl+g"),
+		key.WithHelp("ctrl+g", "send message to the running agent"),
+	),
 }
 
 var DeleteKeyMaps = DeleteAttachmentKeyMaps{
@@ -119,23 +143,39 @@ func (m *editorCmp) Init() tea.Cmd {
 	return textarea.Blink
 }
 
-func (m *editorCmp) send() tea.Cmd {
-	if m.app.CoderAgent.IsSessionBusy(m.session.ID) {
+// send sends the editor content. With interject, a new prompt is sent to the
+// current run of the agent rather than queued.
+func (m *editorCmp) send(interject bool) tea.Cmd {
+	// Prompts sent while the agent works are queued, but earlier messages
+	// can only be replaced once it is done
+	if m.editingMsgID != "" && m.app.CoderAgent.IsSessionBusy(m.session.ID) {
 		return util.ReportWarn("Agent is working, please wait...")
 	}
 
 	value := m.textarea.Value()
 	m.textarea.Reset()
 	attachments := m.attachments
+	editingMsgID, keepBranch := m.editingMsgID, m.keepBranch
+	editingQueuedID := m.editingQueuedID
 
 	m.attachments = nil
+	m.editingMsgID = ""
+	m.keepBranch = false
+	m.editingQueuedID = ""
 	if value == "" {
+		if editingMsgID != "" {
+			return util.CmdHandler(EditMessageMsg{})
+		}
 		return nil
 	}
 	return tea.Batch(
 		util.CmdHandler(SendMsg{
-			Text:        value,
-			Attachments: attachments,
+			Text:           value,
+			Attachments:    attachments,
+			EditMessageID:  editingMsgID,
+			KeepBranch:     keepBranch,
+			QueuedPromptID: editingQueuedID,
+			Interject:      interject && editingMsgID == "" && editingQueuedID == "",
 		}),
 	)
 }
@@ -154,8 +194,34 @@ func (m *editorCmp) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case SessionSelectedMsg:
 		if msg.ID != m.session.ID {
 			m.session = msg
+			m.editingMsgID = ""
+			m.editingQueuedID = ""
+			m.refreshQueue()
 		}
 		return m, nil
+	case SessionClearedMsg:
+		m.queued = 0
+		m.interjected = 0
+		m.queueHeld = false
+		m.editingQueuedID = ""
+	case QueueChangedMsg:
+		if msg.SessionID == m.session.ID {
+			m.refreshQueue()
+		}
+		return m, nil
+	case EditMessageMsg:
+		m.startEditing(msg.Message)
+		return m, nil
+	case dialog.QueuedPromptEditMsg:
+		if m.editingMsgID != "" {
+			// Stop editing the earlier message
+			m.editingMsgID = ""
+			cmd = util.CmdHandler(EditMessageMsg{})
+		}
+		m.editingQueuedID = msg.Prompt.ID
+		m.textarea.SetValue(msg.Prompt.Content)
+		m.attachments = msg.Prompt.Attachments
+		return m, cmd
 	case dialog.AttachmentAddedMsg:
 		if len(m.attachments) >= maxAttachments {
 			logging.ErrorPersist(fmt.Sprintf("cannot add more than %d images", maxAttachments))
@@ -185,17 +251,31 @@ func (m *editorCmp) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		}
 		if key.Matches(msg, messageKeys.PageUp) || key.Matches(msg, messageKeys.PageDown) ||
-			key.Matches(msg, messageKeys.HalfPageUp) || key.Matches(msg, messageKeys.HalfPageDown) {
+			key.Matches(msg, messageKeys.HalfPageUp) || key.Matches(msg, messageKeys.HalfPageDown) ||
+			key.Matches(msg, messageKeys.EditPrevious) {
+			return m, nil
+		}
+		if key.Matches(msg, editorMaps.KeepBranch) && m.editingMsgID != "" {
+			m.keepBranch = !m.keepBranch
 			return m, nil
 		}
 		if key.Matches(msg, editorMaps.OpenEditor) {
-			if m.app.CoderAgent.IsSessionBusy(m.session.ID) {
-				return m, util.ReportWarn("Agent is working, please wait...")
-			}
 			return m, m.openEditor()
 		}
 		if key.Matches(msg, DeleteKeyMaps.Escape) {
 			m.deleteMode = false
+			if m.editingMsgID != "" {
+				m.textarea.Reset()
+				m.attachments = nil
+				return m, util.CmdHandler(EditMessageMsg{})
+			}
+			if m.editingQueuedID != "" {
+				// The prompt stays queued as it was
+				m.editingQueuedID = ""
+				m.textarea.Reset()
+				m.attachments = nil
+				return m, nil
+			}
 			return m, nil
 		}
 		// Hanlde Enter key
@@ -207,15 +287,42 @@ func (m *editorCmp) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 			} else {
 				// Otherwise, send the message
-				return m, m.send()
+				return m, m.send(false)
 			}
 		}
+		if m.textarea.Focused() && key.Matches(msg, editorMaps.Interject) {
+			return m, m.send(true)
+		}
 
 	}
 	m.textarea, cmd = m.textarea.Update(msg)
 	return m, cmd
 }
 
+// startEditing loads an earlier user message into the editor, or clears the
+// editor when msg is empty.
+func (m *editorCmp) startEditing(msg message.Message) {
+	if msg.ID == m.editingMsgID {
+		return
+	}
+	m.editingMsgID = msg.ID
+	m.keepBranch = false
+	m.textarea.Reset()
+	m.attachments = nil
+	if msg.ID == "" {
+		return
+	}
+	m.textarea.SetValue(msg.Content().String())
+	for _, bc := range msg.BinaryContent() {
+		m.attachments = append(m.attachments, message.Attachment{
+			FilePath: bc.Path,
+			FileName: filepath.Base(bc.Path),
+			MimeType: bc.MIMEType,
+			Content:  bc.Data,
+		})
+	}
+}
+
 func (m *editorCmp) View() string {
 	t := theme.CurrentTheme()
 
@@ -225,14 +332,98 @@ func (m *editorCmp) View() string {
 		Bold(true).
 		Foreground(t.Primary())
 
-	if len(m.attachments) == 0 {
+	var header []string
+	if m.editingMsgID != "" {
+		header = append(header, m.editingContent())
+	}
+	if m.editingQueuedID != "" || m.queued > 0 {
+		header = append(header, m.queueContent())
+	}
+	if len(m.attachments) > 0 {
+		header = append(header, m.attachmentsContent())
+	}
+	if len(header) == 0 {
+		m.textarea.SetHeight(m.height)
 		return lipgloss.JoinHorizontal(lipgloss.Top, style.Render(">"), m.textarea.View())
 	}
-	m.textarea.SetHeight(m.height - 1)
+	m.textarea.SetHeight(m.height - len(header))
 	return lipgloss.JoinVertical(lipgloss.Top,
-		m.attachmentsContent(),
-		lipgloss.JoinHorizontal(lipgloss.Top, style.Render(">"),
-			m.textarea.View()),
+		append(header,
+			lipgloss.JoinHorizontal(lipgloss.Top, style.Render(">"),
+				m.textarea.View()),
+		)...,
+	)
+}
+
+func (m *editorCmp) editingContent() string {
+	t := theme.CurrentTheme()
+	baseStyle := styles.BaseStyle()
+
+	later := "later messages will be dropped"
+	if m.keepBranch {
+		later = "later messages will be kept in a forked session"
+	}
+	return lipgloss.JoinHorizontal(
+		lipgloss.Left,
+		baseStyle.Foreground(t.Primary()).Bold(true).Padding(0, 0, 0, 1).Render("Editing prompt"),
+		baseStyle.Foreground(t.TextMuted()).Render(" · "+later+" · "),
+		baseStyle.Foreground(t.Text()).Bold(true).Render("ctrl+b"),
+		baseStyle.Foreground(t.TextMuted()).Render(" toggle, "),
+		baseStyle.Foreground(t.Text()).Bold(true).Render("esc"),
+		baseStyle.Foreground(t.TextMuted()).Render(" cancel"),
+	)
+}
+
+// refreshQueue reads the state of the prompt queue of the session.
+func (m *editorCmp) refreshQueue() {
+	queue := m.app.CoderAgent.Queue(m.session.ID)
+	m.queued = len(queue)
+	m.interjected = 0
+	for _, prompt := range queue {
+		if prompt.Interjection {
+			m.interjected++
+		}
+	}
+	m.queueHeld = m.app.CoderAgent.QueueHeld(m.session.ID)
+}
+
+func (m *editorCmp) queueContent() string {
+	t := theme.CurrentTheme()
+	baseStyle := styles.BaseStyle()
+
+	if m.editingQueuedID != "" {
+		return lipgloss.JoinHorizontal(
+			lipgloss.Left,
+			baseStyle.Foreground(t.Primary()).Bold(true).Padding(0, 0, 0, 1).Render("Editing queued prompt"),
+			baseStyle.Foreground(t.TextMuted()).Render(" · "),
+			baseStyle.Foreground(t.Text()).Bold(true).Render("esc"),
+			baseStyle.Foreground(t.TextMuted()).Render(" cancel"),
+		)
+	}
+	var counts []string
+	if prompts := m.queued - m.interjected; prompts == 1 {
+		counts = append(counts, "1 prompt queued")
+	} else if prompts > 1 {
+		counts = append(counts, fmt.Sprintf("%d prompts queued", prompts))
+	}
+	if m.interjected == 1 {
+		counts = append(counts, "1 message for the running agent")
+	} else if m.interjected > 1 {
+		counts = append(counts, fmt.Sprintf("%d messages for the running agent", m.interjected))
+	}
+	queued := strings.Join(counts, ", ")
+	when := " · sent when the agent is done · "
+	if m.interjected == m.queued {
+		when = " · read after the current tool calls · "
+	} else if m.queueHeld {
+		when = " · held after the failed run, sent with the next prompt · "
+	}
+	return lipgloss.JoinHorizontal(
+		lipgloss.Left,
+		baseStyle.Foreground(t.Warning()).Bold(true).Padding(0, 0, 0, 1).Render(queued),
+		baseStyle.Foreground(t.TextMuted()).Render(when),
+		baseStyle.Foreground(t.Text()).Bold(true).Render("ctrl+q"),
+		baseStyle.Foreground(t.TextMuted()).Render(" edit queue"),
 	)
 }
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"slices"
 
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/spinner"
@@ -32,6 +33,7 @@ type messagesCmp struct {
 	messages      []message.Message
 	uiMessages    []uiMessage
 	currentMsgID  string
+	editingMsgID  string
 	cachedContent map[string]cacheItem
 	spinner       spinner.Model
 	rendering     bool
@@ -44,6 +46,7 @@ type MessageKeys struct {
 	PageUp       key.Binding
 	HalfPageUp   key.Binding
 	HalfPageDown key.Binding
+	EditPrevious key.Binding
 }
 
 var messageKeys = MessageKeys{
@@ -63,6 +66,10 @@ var messageKeys = MessageKeys{
 		key.WithKeys("ctrl+d", "ctrl+d"),
 		key.WithHelp("ctrl+d", "½ page down"),
 	),
+	EditPrevious: key.NewBinding(
+		key.WithKeys("ctrl+p"),
+		key.WithHelp("ctrl+p", "edit previous prompt"),
+	),
 }
 
 func (m *messagesCmp) Init() tea.Cmd {
@@ -85,10 +92,24 @@ func (m *messagesCmp) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.session = session.Session{}
 		m.messages = make([]message.Message, 0)
 		m.currentMsgID = ""
+		m.editingMsgID = ""
 		m.rendering = false
 		return m, nil
 
+	case EditMessageMsg:
+		if msg.Message.ID != m.editingMsgID {
+			delete(m.cachedContent, m.editingMsgID)
+			delete(m.cachedContent, msg.Message.ID)
+			m.editingMsgID = msg.Message.ID
+			m.renderView()
+			m.scrollToMessage(m.editingMsgID)
+		}
+		return m, nil
+
 	case tea.KeyMsg:
+		if key.Matches(msg, messageKeys.EditPrevious) {
+			return m, m.editPrevious()
+		}
 		if key.Matches(msg, messageKeys.PageUp) || key.Matches(msg, messageKeys.PageDown) ||
 			key.Matches(msg, messageKeys.HalfPageUp) || key.Matches(msg, messageKeys.HalfPageDown) {
 			u, cmd := m.viewport.Update(msg)
@@ -107,6 +128,25 @@ func (m *messagesCmp) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.renderView()
 			}
 		}
+	case pubsub.Event[message.Delta]:
+		if msg.Payload.SessionID != m.session.ID {
+			break
+		}
+		for i := len(m.messages) - 1; i >= 0; i-- {
+			if m.messages[i].ID != msg.Payload.MessageID {
+				continue
+			}
+			// Deltas lost or already part of the message are caught up with
+			// when the message is saved again
+			if msg.Payload.Apply(&m.messages[i]) {
+				delete(m.cachedContent, m.messages[i].ID)
+				m.renderView()
+				if i == len(m.messages)-1 {
+					m.viewport.GotoBottom()
+				}
+			}
+			break
+		}
 	case pubsub.Event[message.Message]:
 		needsRerender := false
 		if msg.Type == pubsub.CreatedEvent {
@@ -150,6 +190,23 @@ func (m *messagesCmp) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					break
 				}
 			}
+		} else if msg.Type == pubsub.DeletedEvent && msg.Payload.SessionID == m.session.ID {
+			for i, v := range m.messages {
+				if v.ID == msg.Payload.ID {
+					m.messages = slices.Delete(m.messages, i, i+1)
+					delete(m.cachedContent, v.ID)
+					if m.editingMsgID == v.ID {
+						m.editingMsgID = ""
+					}
+					m.currentMsgID = ""
+					if len(m.messages) > 0 {
+						m.currentMsgID = m.messages[len(m.messages)-1].ID
+						delete(m.cachedContent, m.currentMsgID)
+					}
+					needsRerender = true
+					break
+				}
+			}
 		}
 		if needsRerender {
 			m.renderView()
@@ -201,7 +258,7 @@ func (m *messagesCmp) renderView() {
 			}
 			userMsg := renderUserMessage(
 				msg,
-				msg.ID == m.currentMsgID,
+				msg.ID == m.currentMsgID || msg.ID == m.editingMsgID,
 				m.width,
 				pos,
 			)
@@ -262,6 +319,37 @@ func (m *messagesCmp) renderView() {
 	)
 }
 
+// editPrevious selects the user message before the one being edited, or the
+// last one when nothing is being edited yet.
+func (m *messagesCmp) editPrevious() tea.Cmd {
+	if m.IsAgentWorking() {
+		return util.ReportWarn("Agent is working, please wait...")
+	}
+	end := len(m.messages)
+	if idx := slices.IndexFunc(m.messages, func(msg message.Message) bool { return msg.ID == m.editingMsgID }); idx != -1 {
+		end = idx
+	}
+	for i := end - 1; i >= 0; i-- {
+		if m.messages[i].Role == message.User {
+			return util.CmdHandler(EditMessageMsg{Message: m.messages[i]})
+		}
+	}
+	return nil
+}
+
+func (m *messagesCmp) scrollToMessage(id string) {
+	// Cached messages keep the position they were first rendered at, so
+	// count the lines instead.
+	offset := 0
+	for _, v := range m.uiMessages {
+		if v.ID == id {
+			m.viewport.SetYOffset(offset)
+			return
+		}
+		offset += v.height + 1 // + 1 for spacing
+	}
+}
+
 func (m *messagesCmp) View() string {
 	baseStyle := styles.BaseStyle()
 
@@ -443,6 +531,7 @@ func (m *messagesCmp) SetSession(session session.Session) tea.Cmd {
 		return nil
 	}
 	m.session = session
+	m.editingMsgID = ""
 	messages, err := m.app.Messages.List(context.Background(), session.ID)
 	if err != nil {
 		return util.ReportError(err)
@@ -465,6 +554,7 @@ func (m *messagesCmp) BindingKeys() []key.Binding {
 		m.viewport.KeyMap.PageUp,
 		m.viewport.KeyMap.HalfPageUp,
 		m.viewport.KeyMap.HalfPageDown,
+		messageKeys.EditPrevious,
 	}
 }
 
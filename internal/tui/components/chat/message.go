@@ -163,6 +163,12 @@ func renderAssistantMessage(
 				Foreground(t.TextMuted()).
 				Render(fmt.Sprintf(" %s (%s)", models.SupportedModels[msg.Model].Name, "permission denied")),
 			)
+		case message.FinishReasonBudgetExceeded:
+			info = append(info, baseStyle.
+				Width(width-1).
+				Foreground(t.TextMuted()).
+				Render(fmt.Sprintf(" %s (%s)", models.SupportedModels[msg.Model].Name, "budget exceeded")),
+			)
 		}
 	}
 	if content != "" || (finished && finishData.Reason == message.FinishReasonEndTurn) {
@@ -438,6 +444,78 @@ func truncateHeight(content string, height int) string {
 	return content
 }
 
+// renderToolInputPreview renders the end of the file content or patch a tool
+// call is writing while its input streams in.
+func renderToolInputPreview(toolCall message.ToolCall, width int) string {
+	var key, lang string
+	switch toolCall.Name {
+	case tools.WriteToolName:
+		key = "content"
+	case tools.EditToolName:
+		key = "new_string"
+	case tools.PatchToolName:
+		key, lang = "patch_text", "diff"
+	default:
+		return ""
+	}
+	content, ok := partialJSONString(toolCall.Input, key)
+	if !ok || content == "" {
+		return ""
+	}
+	if lang == "" {
+		filePath, _ := partialJSONString(toolCall.Input, "file_path")
+		lang = strings.ToLower(strings.TrimPrefix(filepath.Ext(filePath), "."))
+	}
+	lines := strings.Split(content, "\n")
+	if len(lines) > maxResultHeight {
+		lines = lines[len(lines)-maxResultHeight:]
+	}
+	return styles.ForceReplaceBackgroundWithLipgloss(
+		toMarkdown(fmt.Sprintf("```%s\n%s\n```", lang, strings.Join(lines, "\n")), true, width),
+		theme.CurrentTheme().Background(),
+	)
+}
+
+// partialJSONString returns the value of the string field key of the JSON
+// object input, which may be cut off anywhere, as it is while a tool call
+// streams in. A value that is cut off is returned up to where input ends.
+func partialJSONString(input, key string) (string, bool) {
+	dec := json.NewDecoder(strings.NewReader(input))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return "", false
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", false
+		}
+		if name, _ := tok.(string); name != key {
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return "", false
+			}
+			continue
+		}
+		offset := dec.InputOffset()
+		var value string
+		if err := dec.Decode(&value); err == nil {
+			return value, true
+		}
+		rest := strings.TrimLeft(input[offset:], " \t\r\n:")
+		if !strings.HasPrefix(rest, `"`) {
+			return "", false
+		}
+		// Drop what is left of an escape sequence that is cut off
+		for trim := 0; trim <= min(len(rest)-1, 12); trim++ {
+			if err := json.Unmarshal([]byte(rest[:len(rest)-trim]+`"`), &value); err == nil {
+				return value, true
+			}
+		}
+		return "", false
+	}
+	return "", false
+}
+
 func renderToolResponse(toolCall message.ToolCall, response message.ToolResult, width int) string {
 	t := theme.CurrentTheme()
 	baseStyle := styles.BaseStyle()
@@ -454,6 +532,10 @@ func renderToolResponse(toolCall message.ToolCall, response message.ToolResult,
 	resultContent := truncateHeight(response.Content, maxResultHeight)
 	switch toolCall.Name {
 	case agent.AgentToolName:
+		// The session ID is there for the model to continue the session
+		if i := strings.LastIndex(resultContent, "\n\n<agent_session_id>"); i != -1 {
+			resultContent = resultContent[:i]
+		}
 		return styles.ForceReplaceBackgroundWithLipgloss(
 			toMarkdown(resultContent, false, width),
 			t.Background(),
@@ -563,12 +645,20 @@ func renderToolMessage(
 		// Get a brief description of what the tool is doing
 		toolAction := getToolAction(toolCall.Name)
 
+		if filePath, ok := partialJSONString(toolCall.Input, "file_path"); ok && filePath != "" {
+			toolAction = fmt.Sprintf("%s %s", toolAction, removeWorkingDirPrefix(filePath))
+		}
+
 		progressText := baseStyle.
 			Width(width - 2 - lipgloss.Width(toolNameText)).
 			Foreground(t.TextMuted()).
 			Render(fmt.Sprintf("%s", toolAction))
 
-		content := style.Render(lipgloss.JoinHorizontal(lipgloss.Left, toolNameText, progressText))
+		content := lipgloss.JoinHorizontal(lipgloss.Left, toolNameText, progressText)
+		if preview := renderToolInputPreview(toolCall, width-2); preview != "" {
+			content = lipgloss.JoinVertical(lipgloss.Left, content, preview)
+		}
+		content = style.Render(content)
 		toolMsg := uiMessage{
 			messageType: toolMessageType,
 			position:    position,
@@ -611,9 +701,15 @@ func renderToolMessage(
 	}
 
 	if toolCall.Name == agent.AgentToolName {
-		taskMessages, _ := messagesService.List(context.Background(), toolCall.ID)
+		var params agent.AgentParams
+		json.Unmarshal([]byte(toolCall.Input), &params)
+		taskSessionID := toolCall.ID
+		if params.SessionID != "" {
+			taskSessionID = params.SessionID
+		}
+		taskMessages, _ := messagesService.List(context.Background(), taskSessionID)
 		toolCalls := []message.ToolCall{}
-		for _, v := range taskMessages {
+		for _, v := range taskTurn(taskMessages, params.Prompt) {
 			toolCalls = append(toolCalls, v.ToolCalls()...)
 		}
 		for _, call := range toolCalls {
@@ -646,6 +742,28 @@ func renderToolMessage(
 	return toolMsg
 }
 
+// taskTurn returns the messages of the agent session turn that answered
+// prompt. A session continued by later agent calls has one turn per call.
+func taskTurn(taskMessages []message.Message, prompt string) []message.Message {
+	start := -1
+	for i, msg := range taskMessages {
+		if msg.Role == message.User && msg.Content().String() == prompt {
+			start = i
+		}
+	}
+	if start == -1 {
+		return taskMessages
+	}
+	end := len(taskMessages)
+	for i := start + 1; i < len(taskMessages); i++ {
+		if taskMessages[i].Role == message.User {
+			end = i
+			break
+		}
+	}
+	return taskMessages[start:end]
+}
+
 // Helper function to format the time difference between two Unix timestamps
 func formatTimestampDiff(start, end int64) string {
 	diffSeconds := float64(end-start) / 1000.0 // Convert to seconds
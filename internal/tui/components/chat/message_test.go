@@ -0,0 +1,37 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartialJSONString(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		input string
+		key   string
+		want  string
+		ok    bool
+	}{
+		{"complete", `{"file_path": "/a.go", "content": "package a\n"}`, "content", "package a\n", true},
+		{"cut off value", `{"file_path": "/a.go", "content": "package a\n\nfunc`, "content", "package a\n\nfunc", true},
+		{"cut off escape", `{"content": "a\`, "content", "a", true},
+		{"cut off unicode escape", `{"content": "caf\u00`, "content", "caf", true},
+		{"key in a value", `{"file_path": "\"content\": \"x\"", "content": "y`, "content", "y", true},
+		{"value not started", `{"content": `, "content", "", false},
+		{"cut off key", `{"file_path": "/a.go", "cont`, "content", "", false},
+		{"not a string", `{"content": 12}`, "content", "", false},
+		{"empty", ``, "content", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, ok := partialJSONString(tt.input, tt.key)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
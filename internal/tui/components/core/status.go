@@ -29,6 +29,8 @@ type statusCmp struct {
 	messageTTL time.Duration
 	lspClients map[string]*lsp.Client
 	session    session.Session
+	planMode   bool
+	agentName  config.AgentName
 }
 
 // clearMessageCmd is a command that clears status messages after a timeout
@@ -51,6 +53,10 @@ func (m statusCmp) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.session = msg
 	case chat.SessionClearedMsg:
 		m.session = session.Session{}
+	case chat.PlanModeMsg:
+		m.planMode = bool(msg)
+	case chat.AgentSwitchedMsg:
+		m.agentName = config.AgentName(msg)
 	case pubsub.Event[session.Session]:
 		if msg.Type == pubsub.UpdatedEvent {
 			if m.session.ID == msg.Payload.ID {
@@ -118,7 +124,7 @@ func formatTokensAndCost(tokens, contextWindow int64, cost float64) string {
 
 func (m statusCmp) View() string {
 	t := theme.CurrentTheme()
-	modelID := config.Get().Agents[config.AgentCoder].Model
+	modelID := config.Get().Agents[m.agentName].Model
 	model := models.SupportedModels[modelID]
 
 	// Initialize the help widget
@@ -126,7 +132,7 @@ func (m statusCmp) View() string {
 
 	tokenInfoWidth := 0
 	if m.session.ID != "" {
-		totalTokens := m.session.PromptTokens + m.session.CompletionTokens
+		totalTokens := m.session.ContextTokens
 		tokens := formatTokensAndCost(totalTokens, model.ContextWindow, m.session.Cost)
 		tokensStyle := styles.Padded().
 			Background(t.Text()).
@@ -271,16 +277,28 @@ func (m statusCmp) model() string {
 
 	cfg := config.Get()
 
-	coder, ok := cfg.Agents[config.AgentCoder]
+	agent, ok := cfg.Agents[m.agentName]
 	if !ok {
 		return "Unknown"
 	}
-	model := models.SupportedModels[coder.Model]
+	model := models.SupportedModels[agent.Model]
 
-	return styles.Padded().
+	name := model.Name
+	if m.agentName != config.AgentCoder {
+		name = fmt.Sprintf("%s (%s)", m.agentName, model.Name)
+	}
+	modelName := styles.Padded().
 		Background(t.Secondary()).
 		Foreground(t.Background()).
-		Render(model.Name)
+		Render(name)
+	if !m.planMode {
+		return modelName
+	}
+	return styles.Padded().
+		Background(t.Accent()).
+		Foreground(t.Background()).
+		Bold(true).
+		Render("PLAN") + modelName
 }
 
 func NewStatusCmp(lspClients map[string]*lsp.Client) StatusCmp {
@@ -289,5 +307,6 @@ func NewStatusCmp(lspClients map[string]*lsp.Client) StatusCmp {
 	return &statusCmp{
 		messageTTL: 10 * time.Second,
 		lspClients: lspClients,
+		agentName:  config.AgentCoder,
 	}
 }
@@ -33,9 +33,12 @@ type CloseModelDialogMsg struct{}
 type ModelDialog interface {
 	tea.Model
 	layout.Bindings
+	// SetAgent selects the agent whose model is shown as selected.
+	SetAgent(agentName config.AgentName)
 }
 
 type modelDialogCmp struct {
+	agentName          config.AgentName
 	models             []models.Model
 	provider           models.ModelProvider
 	availableProviders []models.ModelProvider
@@ -268,9 +271,14 @@ func (m *modelDialogCmp) BindingKeys() []key.Binding {
 	return layout.KeyMapToSlice(modelKeys)
 }
 
+func (m *modelDialogCmp) SetAgent(agentName config.AgentName) {
+	m.agentName = agentName
+	m.setupModels()
+}
+
 func (m *modelDialogCmp) setupModels() {
 	cfg := config.Get()
-	modelInfo := GetSelectedModel(cfg)
+	modelInfo := GetSelectedModel(cfg, m.agentName)
 	m.availableProviders = getEnabledProviders(cfg)
 	m.hScrollPossible = len(m.availableProviders) > 1
 
@@ -280,9 +288,9 @@ func (m *modelDialogCmp) setupModels() {
 	m.setupModelsForProvider(m.provider)
 }
 
-func GetSelectedModel(cfg *config.Config) models.Model {
+func GetSelectedModel(cfg *config.Config, agentName config.AgentName) models.Model {
 
-	agentCfg := cfg.Agents[config.AgentCoder]
+	agentCfg := cfg.Agents[agentName]
 	selectedModelId := agentCfg.Model
 	return models.SupportedModels[selectedModelId]
 }
@@ -324,7 +332,7 @@ func findProviderIndex(providers []models.ModelProvider, provider models.ModelPr
 
 func (m *modelDialogCmp) setupModelsForProvider(provider models.ModelProvider) {
 	cfg := config.Get()
-	agentCfg := cfg.Agents[config.AgentCoder]
+	agentCfg := cfg.Agents[m.agentName]
 	selectedModelId := agentCfg.Model
 
 	m.provider = provider
@@ -369,5 +377,7 @@ func getModelsForProvider(provider models.ModelProvider) []models.Model {
 }
 
 func NewModelDialogCmp() ModelDialog {
-	return &modelDialogCmp{}
+	return &modelDialogCmp{
+		agentName: config.AgentCoder,
+	}
 }
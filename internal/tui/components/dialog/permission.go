@@ -225,6 +225,19 @@ func (p *permissionDialogCmp) renderHeader() string {
 		baseStyle.Render(strings.Repeat(" ", p.width)),
 	}
 
+	// Requests of sub-agents say which agent and session they come from
+	if p.permission.Agent != "" {
+		agentKey := baseStyle.Foreground(t.TextMuted()).Bold(true).Render("Agent")
+		agentValue := baseStyle.
+			Foreground(t.Warning()).
+			Width(p.width - lipgloss.Width(agentKey)).
+			Render(fmt.Sprintf(": %s, session %s", p.permission.Agent, p.permission.SessionID))
+		headerParts = append(headerParts,
+			lipgloss.JoinHorizontal(lipgloss.Left, agentKey, agentValue),
+			baseStyle.Render(strings.Repeat(" ", p.width)),
+		)
+	}
+
 	// Add tool-specific header information
 	switch p.permission.ToolName {
 	case tools.BashToolName:
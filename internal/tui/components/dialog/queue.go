@@ -0,0 +1,231 @@
+package dialog
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/opencode-ai/opencode/internal/llm/agent"
+	"github.com/opencode-ai/opencode/internal/tui/layout"
+	"github.com/opencode-ai/opencode/internal/tui/styles"
+	"github.com/opencode-ai/opencode/internal/tui/theme"
+	"github.com/opencode-ai/opencode/internal/tui/util"
+)
+
+// QueuedPromptEditMsg is sent to load a queued prompt into the editor
+type QueuedPromptEditMsg struct {
+	Prompt agent.QueuedPrompt
+}
+
+// QueuedPromptRemoveMsg is sent to drop a prompt from the queue
+type QueuedPromptRemoveMsg struct {
+	Prompt agent.QueuedPrompt
+}
+
+// CancelRunRequestMsg is sent when the user cancels a run while prompts are
+// queued, to ask what to do with them
+type CancelRunRequestMsg struct {
+	SessionID string
+}
+
+// CancelRunMsg is sent when the user confirms cancelling the run
+type CancelRunMsg struct {
+	SessionID string
+	KeepQueue bool
+}
+
+// ClosePromptQueueDialogMsg is sent when the prompt queue dialog is closed
+type ClosePromptQueueDialogMsg struct{}
+
+// PromptQueueDialog interface for the dialog that shows the queued prompts of
+// a session, or asks what to do with them when cancelling a run
+type PromptQueueDialog interface {
+	tea.Model
+	layout.Bindings
+	SetPrompts(prompts []agent.QueuedPrompt)
+	SetCancelling(sessionID string, prompts []agent.QueuedPrompt)
+}
+
+type promptQueueDialogCmp struct {
+	prompts     []agent.QueuedPrompt
+	selectedIdx int
+	width       int
+
+	// cancelling is set while asking whether to keep the queue of sessionID
+	cancelling bool
+	sessionID  string
+}
+
+type promptQueueKeyMap struct {
+	Up     key.Binding
+	Down   key.Binding
+	Edit   key.Binding
+	Remove key.Binding
+	Keep   key.Binding
+	Drop   key.Binding
+	Escape key.Binding
+}
+
+var promptQueueKeys = promptQueueKeyMap{
+	Up: key.NewBinding(
+		key.WithKeys("up", "k"),
+		key.WithHelp("↑/k", "previous prompt"),
+	),
+	Down: key.NewBinding(
+		key.WithKeys("down", "j"),
+		key.WithHelp("↓/j", "next prompt"),
+	),
+	Edit: key.NewBinding(
+		key.WithKeys("enter", "e"),
+		key.WithHelp("enter/e", "edit prompt"),
+	),
+	Remove: key.NewBinding(
+		key.WithKeys("d", "delete"),
+		key.WithHelp("d", "remove prompt"),
+	),
+	Keep: key.NewBinding(
+		key.WithKeys("enter", "y"),
+		key.WithHelp("enter/y", "cancel and keep the queue"),
+	),
+	Drop: key.NewBinding(
+		key.WithKeys("d"),
+		key.WithHelp("d", "cancel and drop the queue"),
+	),
+	Escape: key.NewBinding(
+		key.WithKeys("esc"),
+		key.WithHelp("esc", "close"),
+	),
+}
+
+func (q *promptQueueDialogCmp) Init() tea.Cmd {
+	return nil
+}
+
+func (q *promptQueueDialogCmp) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		q.width = msg.Width
+	case tea.KeyMsg:
+		if q.cancelling {
+			switch {
+			case key.Matches(msg, promptQueueKeys.Keep):
+				q.cancelling = false
+				return q, util.CmdHandler(CancelRunMsg{SessionID: q.sessionID, KeepQueue: true})
+			case key.Matches(msg, promptQueueKeys.Drop):
+				q.cancelling = false
+				return q, util.CmdHandler(CancelRunMsg{SessionID: q.sessionID})
+			case key.Matches(msg, promptQueueKeys.Escape):
+				q.cancelling = false
+				return q, util.CmdHandler(ClosePromptQueueDialogMsg{})
+			}
+			return q, nil
+		}
+		switch {
+		case key.Matches(msg, promptQueueKeys.Up):
+			if q.selectedIdx > 0 {
+				q.selectedIdx--
+			}
+		case key.Matches(msg, promptQueueKeys.Down):
+			if q.selectedIdx < len(q.prompts)-1 {
+				q.selectedIdx++
+			}
+		case key.Matches(msg, promptQueueKeys.Edit):
+			if len(q.prompts) > 0 {
+				return q, util.CmdHandler(QueuedPromptEditMsg{Prompt: q.prompts[q.selectedIdx]})
+			}
+		case key.Matches(msg, promptQueueKeys.Remove):
+			if len(q.prompts) > 0 {
+				return q, util.CmdHandler(QueuedPromptRemoveMsg{Prompt: q.prompts[q.selectedIdx]})
+			}
+		case key.Matches(msg, promptQueueKeys.Escape):
+			return q, util.CmdHandler(ClosePromptQueueDialogMsg{})
+		}
+	}
+	return q, nil
+}
+
+func (q *promptQueueDialogCmp) View() string {
+	t := theme.CurrentTheme()
+	baseStyle := styles.BaseStyle()
+
+	width := max(40, min(80, q.width-15))
+	var title string
+	var lines []string
+	if q.cancelling {
+		title = "Cancel Run"
+		lines = append(lines,
+			baseStyle.Width(width).Render(fmt.Sprintf("%d queued prompt(s) run after this one:", len(q.prompts))),
+			baseStyle.Width(width).Render(""),
+		)
+	} else {
+		title = "Queued Prompts"
+	}
+
+	for i, prompt := range q.prompts {
+		text, _, _ := strings.Cut(strings.TrimSpace(prompt.Content), "\n")
+		// Interjected messages are read by the current run, unless it ends
+		// first
+		if prompt.Interjection {
+			text = "(to the running agent) " + text
+		}
+		itemStyle := baseStyle.Width(width).MaxHeight(1).Padding(0, 1)
+		if i == q.selectedIdx && !q.cancelling {
+			itemStyle = itemStyle.
+				Background(t.Primary()).
+				Foreground(t.Background()).
+				Bold(true)
+		}
+		lines = append(lines, itemStyle.Render(fmt.Sprintf("%d. %s", i+1, text)))
+	}
+
+	var help string
+	if q.cancelling {
+		help = "enter keep them · d drop them · esc do not cancel"
+	} else {
+		help = "enter edit · d remove · esc close"
+	}
+	lines = append(lines,
+		baseStyle.Width(width).Render(""),
+		baseStyle.Width(width).Foreground(t.TextMuted()).Render(help),
+	)
+
+	content := lipgloss.JoinVertical(
+		lipgloss.Left,
+		append([]string{
+			baseStyle.Foreground(t.Primary()).Bold(true).Width(width).Render(title),
+			baseStyle.Width(width).Render(""),
+		}, lines...)...,
+	)
+
+	return baseStyle.Padding(1, 2).
+		Border(lipgloss.RoundedBorder()).
+		BorderBackground(t.Background()).
+		BorderForeground(t.TextMuted()).
+		Width(lipgloss.Width(content) + 4).
+		Render(content)
+}
+
+func (q *promptQueueDialogCmp) BindingKeys() []key.Binding {
+	if q.cancelling {
+		return []key.Binding{promptQueueKeys.Keep, promptQueueKeys.Drop, promptQueueKeys.Escape}
+	}
+	return []key.Binding{promptQueueKeys.Up, promptQueueKeys.Down, promptQueueKeys.Edit, promptQueueKeys.Remove, promptQueueKeys.Escape}
+}
+
+func (q *promptQueueDialogCmp) SetPrompts(prompts []agent.QueuedPrompt) {
+	q.prompts = prompts
+	q.selectedIdx = min(q.selectedIdx, max(0, len(prompts)-1))
+}
+
+func (q *promptQueueDialogCmp) SetCancelling(sessionID string, prompts []agent.QueuedPrompt) {
+	q.prompts = prompts
+	q.cancelling = true
+	q.sessionID = sessionID
+}
+
+// NewPromptQueueDialogCmp creates a new prompt queue dialog
+func NewPromptQueueDialogCmp() PromptQueueDialog {
+	return &promptQueueDialogCmp{}
+}
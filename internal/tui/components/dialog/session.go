@@ -1,9 +1,14 @@
 package dialog
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/charmbracelet/bubbles/key"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/opencode-ai/opencode/internal/app"
+	"github.com/opencode-ai/opencode/internal/message"
 	"github.com/opencode-ai/opencode/internal/session"
 	"github.com/opencode-ai/opencode/internal/tui/layout"
 	"github.com/opencode-ai/opencode/internal/tui/styles"
@@ -19,12 +24,39 @@ type SessionSelectedMsg struct {
 // CloseSessionDialogMsg is sent when the session dialog is closed
 type CloseSessionDialogMsg struct{}
 
+// ForkSessionRequestMsg is sent when the user wants to fork a session and the
+// dialog needs the session messages to offer the fork points
+type ForkSessionRequestMsg struct {
+	Session session.Session
+}
+
+// ForkSessionMsg is sent when a fork point has been selected
+type ForkSessionMsg struct {
+	Session   session.Session
+	MessageID string
+}
+
+// AgentSessionSelectedMsg is sent when the session of a sub-agent is selected
+// to view its transcript
+type AgentSessionSelectedMsg struct {
+	Session session.Session
+}
+
 // SessionDialog interface for the session switching dialog
 type SessionDialog interface {
 	tea.Model
 	layout.Bindings
 	SetSessions(sessions []session.Session)
 	SetSelectedSession(sessionID string)
+	SetForkMessages(sess session.Session, messages []message.Message)
+	SetAgentSessions(agentSessions []app.AgentSession)
+}
+
+// forkPoint is a user prompt the session can be forked after. The fork keeps
+// the prompt and the response to it, up to messageID.
+type forkPoint struct {
+	messageID string
+	prompt    string
 }
 
 type sessionDialogCmp struct {
@@ -33,6 +65,15 @@ type sessionDialogCmp struct {
 	width             int
 	height            int
 	selectedSessionID string
+
+	forking      bool
+	forkSession  session.Session
+	forkPoints   []forkPoint
+	forkPointIdx int
+
+	showingAgents bool
+	agentSessions []app.AgentSession
+	agentIdx      int
 }
 
 type sessionKeyMap struct {
@@ -40,6 +81,7 @@ type sessionKeyMap struct {
 	Down   key.Binding
 	Enter  key.Binding
 	Escape key.Binding
+	Fork   key.Binding
 	J      key.Binding
 	K      key.Binding
 }
@@ -61,6 +103,10 @@ var sessionKeys = sessionKeyMap{
 		key.WithKeys("esc"),
 		key.WithHelp("esc", "close"),
 	),
+	Fork: key.NewBinding(
+		key.WithKeys("f"),
+		key.WithHelp("f", "fork session"),
+	),
 	J: key.NewBinding(
 		key.WithKeys("j"),
 		key.WithHelp("j", "next session"),
@@ -78,6 +124,12 @@ func (s *sessionDialogCmp) Init() tea.Cmd {
 func (s *sessionDialogCmp) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if s.forking {
+			return s, s.updateForkPoints(msg)
+		}
+		if s.showingAgents {
+			return s, s.updateAgentSessions(msg)
+		}
 		switch {
 		case key.Matches(msg, sessionKeys.Up) || key.Matches(msg, sessionKeys.K):
 			if s.selectedIdx > 0 {
@@ -95,6 +147,12 @@ func (s *sessionDialogCmp) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					Session: s.sessions[s.selectedIdx],
 				})
 			}
+		case key.Matches(msg, sessionKeys.Fork):
+			if len(s.sessions) > 0 {
+				return s, util.CmdHandler(ForkSessionRequestMsg{
+					Session: s.sessions[s.selectedIdx],
+				})
+			}
 		case key.Matches(msg, sessionKeys.Escape):
 			return s, util.CmdHandler(CloseSessionDialogMsg{})
 		}
@@ -105,10 +163,79 @@ func (s *sessionDialogCmp) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return s, nil
 }
 
+func (s *sessionDialogCmp) updateForkPoints(msg tea.KeyMsg) tea.Cmd {
+	switch {
+	case key.Matches(msg, sessionKeys.Up) || key.Matches(msg, sessionKeys.K):
+		if s.forkPointIdx > 0 {
+			s.forkPointIdx--
+		}
+	case key.Matches(msg, sessionKeys.Down) || key.Matches(msg, sessionKeys.J):
+		if s.forkPointIdx < len(s.forkPoints)-1 {
+			s.forkPointIdx++
+		}
+	case key.Matches(msg, sessionKeys.Enter):
+		if len(s.forkPoints) > 0 {
+			s.forking = false
+			return util.CmdHandler(ForkSessionMsg{
+				Session:   s.forkSession,
+				MessageID: s.forkPoints[s.forkPointIdx].messageID,
+			})
+		}
+	case key.Matches(msg, sessionKeys.Escape):
+		// Go back to the session list
+		s.forking = false
+	}
+	return nil
+}
+
+func (s *sessionDialogCmp) updateAgentSessions(msg tea.KeyMsg) tea.Cmd {
+	switch {
+	case key.Matches(msg, sessionKeys.Up) || key.Matches(msg, sessionKeys.K):
+		if s.agentIdx > 0 {
+			s.agentIdx--
+		}
+	case key.Matches(msg, sessionKeys.Down) || key.Matches(msg, sessionKeys.J):
+		if s.agentIdx < len(s.agentSessions)-1 {
+			s.agentIdx++
+		}
+	case key.Matches(msg, sessionKeys.Enter):
+		if len(s.agentSessions) > 0 {
+			return util.CmdHandler(AgentSessionSelectedMsg{
+				Session: s.agentSessions[s.agentIdx].Session,
+			})
+		}
+	case key.Matches(msg, sessionKeys.Escape):
+		return util.CmdHandler(CloseSessionDialogMsg{})
+	}
+	return nil
+}
+
 func (s *sessionDialogCmp) View() string {
 	t := theme.CurrentTheme()
 	baseStyle := styles.BaseStyle()
-	
+
+	if s.forking {
+		prompts := make([]string, len(s.forkPoints))
+		for i, point := range s.forkPoints {
+			prompts[i] = point.prompt
+		}
+		return s.renderList("Fork After Prompt", prompts, s.forkPointIdx)
+	}
+
+	if s.showingAgents {
+		labels := make([]string, len(s.agentSessions))
+		for i, agentSession := range s.agentSessions {
+			labels[i] = agentSession.Prompt
+			if agentSession.Agent != "" {
+				labels[i] = fmt.Sprintf("[%s] %s", agentSession.Agent, labels[i])
+			}
+			if agentSession.Calls > 1 {
+				labels[i] += fmt.Sprintf(" (%d runs)", agentSession.Calls)
+			}
+		}
+		return s.renderList("Agent Sessions", labels, s.agentIdx)
+	}
+
 	if len(s.sessions) == 0 {
 		return baseStyle.Padding(1, 2).
 			Border(lipgloss.RoundedBorder()).
@@ -118,48 +245,58 @@ func (s *sessionDialogCmp) View() string {
 			Render("No sessions available")
 	}
 
-	// Calculate max width needed for session titles
+	titles := make([]string, len(s.sessions))
+	for i, sess := range s.sessions {
+		titles[i] = sess.Title
+	}
+	return s.renderList("Switch Session", titles, s.selectedIdx)
+}
+
+func (s *sessionDialogCmp) renderList(header string, items []string, selectedIdx int) string {
+	t := theme.CurrentTheme()
+	baseStyle := styles.BaseStyle()
+
+	// Calculate max width needed for the items
 	maxWidth := 40 // Minimum width
-	for _, sess := range s.sessions {
-		if len(sess.Title) > maxWidth-4 { // Account for padding
-			maxWidth = len(sess.Title) + 4
+	for _, item := range items {
+		if len(item) > maxWidth-4 { // Account for padding
+			maxWidth = len(item) + 4
 		}
 	}
 
 	maxWidth = max(30, min(maxWidth, s.width-15)) // Limit width to avoid overflow
 
 	// Limit height to avoid taking up too much screen space
-	maxVisibleSessions := min(10, len(s.sessions))
+	maxVisibleItems := min(10, len(items))
 
-	// Build the session list
-	sessionItems := make([]string, 0, maxVisibleSessions)
+	// Build the list
+	listItems := make([]string, 0, maxVisibleItems)
 	startIdx := 0
 
-	// If we have more sessions than can be displayed, adjust the start index
-	if len(s.sessions) > maxVisibleSessions {
+	// If we have more items than can be displayed, adjust the start index
+	if len(items) > maxVisibleItems {
 		// Center the selected item when possible
-		halfVisible := maxVisibleSessions / 2
-		if s.selectedIdx >= halfVisible && s.selectedIdx < len(s.sessions)-halfVisible {
-			startIdx = s.selectedIdx - halfVisible
-		} else if s.selectedIdx >= len(s.sessions)-halfVisible {
-			startIdx = len(s.sessions) - maxVisibleSessions
+		halfVisible := maxVisibleItems / 2
+		if selectedIdx >= halfVisible && selectedIdx < len(items)-halfVisible {
+			startIdx = selectedIdx - halfVisible
+		} else if selectedIdx >= len(items)-halfVisible {
+			startIdx = len(items) - maxVisibleItems
 		}
 	}
 
-	endIdx := min(startIdx+maxVisibleSessions, len(s.sessions))
+	endIdx := min(startIdx+maxVisibleItems, len(items))
 
 	for i := startIdx; i < endIdx; i++ {
-		sess := s.sessions[i]
 		itemStyle := baseStyle.Width(maxWidth)
 
-		if i == s.selectedIdx {
+		if i == selectedIdx {
 			itemStyle = itemStyle.
 				Background(t.Primary()).
 				Foreground(t.Background()).
 				Bold(true)
 		}
 
-		sessionItems = append(sessionItems, itemStyle.Padding(0, 1).Render(sess.Title))
+		listItems = append(listItems, itemStyle.Padding(0, 1).Render(items[i]))
 	}
 
 	title := baseStyle.
@@ -167,13 +304,13 @@ func (s *sessionDialogCmp) View() string {
 		Bold(true).
 		Width(maxWidth).
 		Padding(0, 1).
-		Render("Switch Session")
+		Render(header)
 
 	content := lipgloss.JoinVertical(
 		lipgloss.Left,
 		title,
 		baseStyle.Width(maxWidth).Render(""),
-		baseStyle.Width(maxWidth).Render(lipgloss.JoinVertical(lipgloss.Left, sessionItems...)),
+		baseStyle.Width(maxWidth).Render(lipgloss.JoinVertical(lipgloss.Left, listItems...)),
 		baseStyle.Width(maxWidth).Render(""),
 	)
 
@@ -191,6 +328,8 @@ func (s *sessionDialogCmp) BindingKeys() []key.Binding {
 
 func (s *sessionDialogCmp) SetSessions(sessions []session.Session) {
 	s.sessions = sessions
+	s.forking = false
+	s.showingAgents = false
 
 	// If we have a selected session ID, find its index
 	if s.selectedSessionID != "" {
@@ -220,6 +359,38 @@ func (s *sessionDialogCmp) SetSelectedSession(sessionID string) {
 	}
 }
 
+func (s *sessionDialogCmp) SetForkMessages(sess session.Session, messages []message.Message) {
+	s.forkSession = sess
+	s.forkPoints = s.forkPoints[:0]
+	for i, msg := range messages {
+		if msg.Role != message.User {
+			continue
+		}
+		// The fork point of a prompt is the last message before the next prompt
+		end := len(messages) - 1
+		for j := i + 1; j < len(messages); j++ {
+			if messages[j].Role == message.User {
+				end = j - 1
+				break
+			}
+		}
+		prompt, _, _ := strings.Cut(strings.TrimSpace(msg.Content().String()), "\n")
+		s.forkPoints = append(s.forkPoints, forkPoint{
+			messageID: messages[end].ID,
+			prompt:    prompt,
+		})
+	}
+	s.forkPointIdx = max(0, len(s.forkPoints)-1)
+	s.forking = len(s.forkPoints) > 0
+}
+
+func (s *sessionDialogCmp) SetAgentSessions(agentSessions []app.AgentSession) {
+	s.agentSessions = agentSessions
+	s.agentIdx = max(0, len(agentSessions)-1)
+	s.forking = false
+	s.showingAgents = true
+}
+
 // NewSessionDialogCmp creates a new session switching dialog
 func NewSessionDialogCmp() SessionDialog {
 	return &sessionDialogCmp{
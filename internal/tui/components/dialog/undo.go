@@ -0,0 +1,238 @@
+package dialog
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/opencode-ai/opencode/internal/app"
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/diff"
+	"github.com/opencode-ai/opencode/internal/tui/layout"
+	"github.com/opencode-ai/opencode/internal/tui/styles"
+	"github.com/opencode-ai/opencode/internal/tui/theme"
+	"github.com/opencode-ai/opencode/internal/tui/util"
+)
+
+// UndoTurn is a turn offered in the undo dialog with the changes undoing it
+// would make
+type UndoTurn struct {
+	Turn    app.Turn
+	Reverts []app.FileRevert
+}
+
+// UndoConfirmedMsg is sent when the user confirms undoing a turn
+type UndoConfirmedMsg struct {
+	Turn UndoTurn
+}
+
+// CloseUndoDialogMsg is sent when the undo dialog is closed
+type CloseUndoDialogMsg struct{}
+
+// UndoDialog interface for the undo dialog
+type UndoDialog interface {
+	tea.Model
+	layout.Bindings
+	SetTurns(turns []UndoTurn)
+}
+
+type undoDialogCmp struct {
+	turns       []UndoTurn
+	selectedIdx int
+	windowSize  tea.WindowSizeMsg
+	width       int
+	height      int
+	viewport    viewport.Model
+	diffCache   map[int]string
+}
+
+type undoKeyMap struct {
+	Previous key.Binding
+	Next     key.Binding
+	Confirm  key.Binding
+	Escape   key.Binding
+}
+
+var undoKeys = undoKeyMap{
+	Previous: key.NewBinding(
+		key.WithKeys("left", "h"),
+		key.WithHelp("←/h", "previous turn"),
+	),
+	Next: key.NewBinding(
+		key.WithKeys("right", "l"),
+		key.WithHelp("→/l", "next turn"),
+	),
+	Confirm: key.NewBinding(
+		key.WithKeys("enter", "y"),
+		key.WithHelp("enter/y", "undo turn"),
+	),
+	Escape: key.NewBinding(
+		key.WithKeys("esc", "n"),
+		key.WithHelp("esc/n", "close"),
+	),
+}
+
+func (u *undoDialogCmp) Init() tea.Cmd {
+	return u.viewport.Init()
+}
+
+func (u *undoDialogCmp) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		u.windowSize = msg
+		u.width = int(float64(msg.Width) * 0.8)
+		u.height = int(float64(msg.Height) * 0.8)
+		u.diffCache = make(map[int]string)
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, undoKeys.Previous):
+			if u.selectedIdx > 0 {
+				u.selectedIdx--
+				u.viewport.GotoTop()
+			}
+			return u, nil
+		case key.Matches(msg, undoKeys.Next):
+			if u.selectedIdx < len(u.turns)-1 {
+				u.selectedIdx++
+				u.viewport.GotoTop()
+			}
+			return u, nil
+		case key.Matches(msg, undoKeys.Confirm):
+			if len(u.turns) > 0 && len(u.turns[u.selectedIdx].Reverts) > 0 {
+				return u, util.CmdHandler(UndoConfirmedMsg{Turn: u.turns[u.selectedIdx]})
+			}
+			return u, nil
+		case key.Matches(msg, undoKeys.Escape):
+			return u, util.CmdHandler(CloseUndoDialogMsg{})
+		default:
+			// Pass other keys to viewport
+			vp, cmd := u.viewport.Update(msg)
+			u.viewport = vp
+			return u, cmd
+		}
+	}
+	return u, nil
+}
+
+func (u *undoDialogCmp) renderHeader() string {
+	t := theme.CurrentTheme()
+	baseStyle := styles.BaseStyle()
+
+	turn := u.turns[u.selectedIdx]
+	prompt, _, _ := strings.Cut(strings.TrimSpace(turn.Turn.Prompt.Content().String()), "\n")
+
+	promptKey := baseStyle.Foreground(t.TextMuted()).Bold(true).Render("Prompt")
+	promptValue := baseStyle.
+		Foreground(t.Text()).
+		Width(u.width - 4 - lipgloss.Width(promptKey)).
+		MaxHeight(1).
+		Render(": " + prompt)
+
+	parts := []string{
+		lipgloss.JoinHorizontal(lipgloss.Left, promptKey, promptValue),
+		baseStyle.Render(strings.Repeat(" ", u.width-4)),
+	}
+	if len(turn.Reverts) == 0 {
+		parts = append(parts, baseStyle.Foreground(t.TextMuted()).Width(u.width-4).Render("No file changes to undo"))
+	}
+	for _, revert := range turn.Reverts {
+		action := baseStyle.Foreground(t.Warning()).Render("restore ")
+		if revert.Delete {
+			action = baseStyle.Foreground(t.Error()).Render("delete  ")
+		}
+		path := strings.TrimPrefix(strings.TrimPrefix(revert.Path, config.WorkingDirectory()), "/")
+		stats := baseStyle.Foreground(t.TextMuted()).Render(fmt.Sprintf(" (+%d -%d)", revert.Additions, revert.Removals))
+		parts = append(parts, baseStyle.Width(u.width-4).Render(
+			lipgloss.JoinHorizontal(lipgloss.Left, action, baseStyle.Foreground(t.Text()).Render(path), stats),
+		))
+	}
+	parts = append(parts, baseStyle.Render(strings.Repeat(" ", u.width-4)))
+	return lipgloss.JoinVertical(lipgloss.Left, parts...)
+}
+
+func (u *undoDialogCmp) renderDiff() string {
+	if cached, ok := u.diffCache[u.selectedIdx]; ok {
+		return cached
+	}
+	var diffs []string
+	for _, revert := range u.turns[u.selectedIdx].Reverts {
+		formatted, err := diff.FormatDiff(revert.Diff, diff.WithTotalWidth(u.viewport.Width))
+		if err != nil {
+			formatted = fmt.Sprintf("Error formatting diff: %v", err)
+		}
+		diffs = append(diffs, formatted)
+	}
+	content := lipgloss.JoinVertical(lipgloss.Left, diffs...)
+	u.diffCache[u.selectedIdx] = content
+	return content
+}
+
+func (u *undoDialogCmp) View() string {
+	t := theme.CurrentTheme()
+	baseStyle := styles.BaseStyle()
+
+	if len(u.turns) == 0 {
+		return baseStyle.Padding(1, 2).
+			Border(lipgloss.RoundedBorder()).
+			BorderBackground(t.Background()).
+			BorderForeground(t.TextMuted()).
+			Width(40).
+			Render("No turns to undo")
+	}
+
+	title := baseStyle.
+		Bold(true).
+		Width(u.width - 4).
+		Foreground(t.Primary()).
+		Render(fmt.Sprintf("Undo Turn %d/%d", u.selectedIdx+1, len(u.turns)))
+	header := u.renderHeader()
+	help := baseStyle.
+		Foreground(t.TextMuted()).
+		Width(u.width - 4).
+		Render("←/→ choose turn · enter undo · esc close")
+
+	u.viewport.Width = u.width - 4
+	u.viewport.Height = max(0, u.height-lipgloss.Height(title)-lipgloss.Height(header)-lipgloss.Height(help)-3)
+	u.viewport.SetContent(u.renderDiff())
+
+	content := lipgloss.JoinVertical(
+		lipgloss.Top,
+		title,
+		baseStyle.Render(strings.Repeat(" ", u.width-4)),
+		header,
+		lipgloss.NewStyle().Background(t.Background()).Render(u.viewport.View()),
+		help,
+	)
+
+	return baseStyle.
+		Padding(1, 0, 0, 1).
+		Border(lipgloss.RoundedBorder()).
+		BorderBackground(t.Background()).
+		BorderForeground(t.TextMuted()).
+		Width(u.width).
+		Height(u.height).
+		Render(content)
+}
+
+func (u *undoDialogCmp) BindingKeys() []key.Binding {
+	return layout.KeyMapToSlice(undoKeys)
+}
+
+// SetTurns sets the turns that can be undone and selects the last one
+func (u *undoDialogCmp) SetTurns(turns []UndoTurn) {
+	u.turns = turns
+	u.selectedIdx = max(0, len(turns)-1)
+	u.diffCache = make(map[int]string)
+	u.viewport.GotoTop()
+}
+
+// NewUndoDialogCmp creates a new undo dialog
+func NewUndoDialogCmp() UndoDialog {
+	return &undoDialogCmp{
+		viewport:  viewport.New(0, 0),
+		diffCache: make(map[int]string),
+	}
+}
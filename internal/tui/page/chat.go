@@ -2,6 +2,7 @@ package page
 
 import (
 	"context"
+	"errors"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/key"
@@ -9,6 +10,7 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	"github.com/opencode-ai/opencode/internal/app"
 	"github.com/opencode-ai/opencode/internal/completions"
+	"github.com/opencode-ai/opencode/internal/llm/agent"
 	"github.com/opencode-ai/opencode/internal/message"
 	"github.com/opencode-ai/opencode/internal/session"
 	"github.com/opencode-ai/opencode/internal/tui/components/chat"
@@ -67,6 +69,26 @@ func (p *chatPage) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case dialog.CompletionDialogCloseMsg:
 		p.showCompletionDialog = false
 	case chat.SendMsg:
+		if msg.EditMessageID != "" {
+			cmd := p.resendMessage(msg)
+			if cmd != nil {
+				return p, cmd
+			}
+			break
+		}
+		if msg.QueuedPromptID != "" && p.app.CoderAgent.UpdateQueued(p.session.ID, msg.QueuedPromptID, msg.Text, msg.Attachments...) {
+			return p, util.ReportInfo("Queued prompt updated")
+		}
+		if msg.Interject && p.session.ID != "" {
+			err := p.app.CoderAgent.Interject(context.Background(), p.session.ID, msg.Text, msg.Attachments...)
+			if err == nil {
+				return p, util.ReportInfo("Message sent, the agent reads it after the current tool calls")
+			}
+			// The run is over, the message is sent as a new prompt
+			if !errors.Is(err, agent.ErrSessionIdle) {
+				return p, util.ReportError(err)
+			}
+		}
 		cmd := p.sendMessage(msg.Text, msg.Attachments)
 		if cmd != nil {
 			return p, cmd
@@ -76,7 +98,7 @@ func (p *chatPage) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if p.app.CoderAgent.IsBusy() {
 			return p, util.ReportWarn("Agent is busy, please wait before executing a command...")
 		}
-		
+
 		// Process the command content with arguments if any
 		content := msg.Content
 		if msg.Args != nil {
@@ -86,7 +108,7 @@ func (p *chatPage) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				content = strings.ReplaceAll(content, placeholder, value)
 			}
 		}
-		
+
 		// Handle custom command execution
 		cmd := p.sendMessage(content, nil)
 		if cmd != nil {
@@ -112,7 +134,13 @@ func (p *chatPage) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				util.CmdHandler(chat.SessionClearedMsg{}),
 			)
 		case key.Matches(msg, keyMap.Cancel):
-			if p.session.ID != "" {
+			// While the agent is idle the editor gets the key, to stop
+			// editing a message
+			if p.session.ID != "" && p.app.CoderAgent.IsSessionBusy(p.session.ID) {
+				// Ask what to do with the queued prompts first
+				if len(p.app.CoderAgent.Queue(p.session.ID)) > 0 {
+					return p, util.CmdHandler(dialog.CancelRunRequestMsg{SessionID: p.session.ID})
+				}
 				// Cancel the current session's generation process
 				// This allows users to interrupt long-running operations
 				p.app.CoderAgent.Cancel(p.session.ID)
@@ -168,7 +196,35 @@ func (p *chatPage) sendMessage(text string, attachments []message.Attachment) te
 		cmds = append(cmds, util.CmdHandler(chat.SessionSelectedMsg(session)))
 	}
 
-	_, err := p.app.CoderAgent.Run(context.Background(), p.session.ID, text, attachments...)
+	queued, err := p.app.CoderAgent.Enqueue(context.Background(), p.session.ID, text, attachments...)
+	if err != nil {
+		return util.ReportError(err)
+	}
+	if queued {
+		cmds = append(cmds, util.ReportInfo("Prompt queued, it is sent when the agent is done"))
+	}
+	return tea.Batch(cmds...)
+}
+
+// resendMessage replaces an earlier user message with the edited text and
+// regenerates the conversation from there.
+func (p *chatPage) resendMessage(msg chat.SendMsg) tea.Cmd {
+	var cmds []tea.Cmd
+	if msg.KeepBranch {
+		messages, err := p.app.Messages.List(context.Background(), p.session.ID)
+		if err != nil {
+			return util.ReportError(err)
+		}
+		if len(messages) > 0 {
+			branch, err := p.app.Sessions.Fork(context.Background(), p.session.ID, messages[len(messages)-1].ID)
+			if err != nil {
+				return util.ReportError(err)
+			}
+			cmds = append(cmds, util.ReportInfo("Previous messages kept in session: "+branch.Title))
+		}
+	}
+
+	_, err := p.app.CoderAgent.RunFrom(context.Background(), p.session.ID, msg.EditMessageID, msg.Text, msg.Attachments...)
 	if err != nil {
 		return util.ReportError(err)
 	}
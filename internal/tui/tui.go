@@ -33,10 +33,25 @@ type keyMap struct {
 	Filepicker    key.Binding
 	Models        key.Binding
 	SwitchTheme   key.Binding
+	Undo          key.Binding
+	PlanMode      key.Binding
+	PromptQueue   key.Binding
 }
 
 type startCompactSessionMsg struct{}
 
+type showUndoDialogMsg struct{}
+
+type togglePlanModeMsg struct{}
+
+type executePlanMsg struct{}
+
+type showAgentSessionsMsg struct{}
+
+// executePlanPrompt is sent to hand the plan proposed in plan mode to a
+// normal run of the agent.
+const executePlanPrompt = "Implement the plan you proposed above."
+
 const (
 	quitKey = "q"
 )
@@ -78,6 +93,21 @@ var keys = keyMap{
 		key.WithKeys("ctrl+t"),
 		key.WithHelp("ctrl+t", "switch theme"),
 	),
+
+	Undo: key.NewBinding(
+		key.WithKeys("ctrl+z"),
+		key.WithHelp("ctrl+z", "undo file changes of a turn"),
+	),
+
+	PlanMode: key.NewBinding(
+		key.WithKeys("shift+tab"),
+		key.WithHelp("shift+tab", "toggle plan mode"),
+	),
+
+	PromptQueue: key.NewBinding(
+		key.WithKeys("ctrl+q"),
+		key.WithHelp("ctrl+q", "edit queued prompts"),
+	),
 }
 
 var helpEsc = key.NewBinding(
@@ -104,6 +134,9 @@ type appModel struct {
 	status          core.StatusCmp
 	app             *app.App
 	selectedSession session.Session
+	// transcriptParent is the session the agent transcript shown in the chat
+	// was launched from, empty when no transcript is shown.
+	transcriptParent session.Session
 
 	showPermissions bool
 	permissions     dialog.PermissionDialogCmp
@@ -136,6 +169,12 @@ type appModel struct {
 	showMultiArgumentsDialog bool
 	multiArgumentsDialog     dialog.MultiArgumentsDialogCmp
 
+	showUndoDialog bool
+	undoDialog     dialog.UndoDialog
+
+	showPromptQueueDialog bool
+	promptQueueDialog     dialog.PromptQueueDialog
+
 	isCompacting      bool
 	compactingMessage string
 }
@@ -147,6 +186,12 @@ func (a appModel) Init() tea.Cmd {
 	cmds = append(cmds, cmd)
 	cmd = a.status.Init()
 	cmds = append(cmds, cmd)
+	if a.app.CoderAgent.PlanMode() {
+		cmds = append(cmds, util.CmdHandler(chat.PlanModeMsg(true)))
+	}
+	if agentName := a.app.CoderAgent.AgentName(); agentName != config.AgentCoder {
+		cmds = append(cmds, util.CmdHandler(chat.AgentSwitchedMsg(agentName)))
+	}
 	cmd = a.quit.Init()
 	cmds = append(cmds, cmd)
 	cmd = a.help.Init()
@@ -163,6 +208,8 @@ func (a appModel) Init() tea.Cmd {
 	cmds = append(cmds, cmd)
 	cmd = a.themeDialog.Init()
 	cmds = append(cmds, cmd)
+	cmd = a.undoDialog.Init()
+	cmds = append(cmds, cmd)
 
 	// Check if we should show the init dialog
 	cmds = append(cmds, func() tea.Msg {
@@ -212,6 +259,14 @@ func (a appModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		a.filepicker = filepicker.(dialog.FilepickerCmp)
 		cmds = append(cmds, filepickerCmd)
 
+		undo, undoCmd := a.undoDialog.Update(msg)
+		a.undoDialog = undo.(dialog.UndoDialog)
+		cmds = append(cmds, undoCmd)
+
+		promptQueue, promptQueueCmd := a.promptQueueDialog.Update(msg)
+		a.promptQueueDialog = promptQueue.(dialog.PromptQueueDialog)
+		cmds = append(cmds, promptQueueCmd)
+
 		a.initDialog.SetSize(msg.Width, msg.Height)
 
 		if a.showMultiArgumentsDialog {
@@ -303,6 +358,81 @@ func (a appModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		a.showCommandDialog = false
 		return a, nil
 
+	case showUndoDialogMsg:
+		return a, a.openUndoDialog()
+
+	case togglePlanModeMsg:
+		return a, a.setPlanMode(!a.app.CoderAgent.PlanMode())
+
+	case executePlanMsg:
+		if a.selectedSession.ID == "" {
+			return a, util.ReportWarn("No plan to execute")
+		}
+		if err := a.app.CoderAgent.SetPlanMode(false); err != nil {
+			return a, util.ReportError(err)
+		}
+		return a, tea.Batch(util.CmdHandler(chat.PlanModeMsg(false)), util.CmdHandler(chat.SendMsg{Text: executePlanPrompt}))
+
+	case dialog.CloseUndoDialogMsg:
+		a.showUndoDialog = false
+		return a, nil
+
+	case dialog.ClosePromptQueueDialogMsg:
+		a.showPromptQueueDialog = false
+		return a, nil
+
+	case dialog.QueuedPromptEditMsg:
+		// The editor loads the prompt
+		a.showPromptQueueDialog = false
+
+	case dialog.QueuedPromptRemoveMsg:
+		a.app.CoderAgent.RemoveQueued(a.selectedSession.ID, msg.Prompt.ID)
+		return a, nil
+
+	case dialog.CancelRunRequestMsg:
+		a.promptQueueDialog.SetCancelling(msg.SessionID, a.app.CoderAgent.Queue(msg.SessionID))
+		a.showPromptQueueDialog = true
+		return a, nil
+
+	case dialog.CancelRunMsg:
+		a.showPromptQueueDialog = false
+		if !msg.KeepQueue {
+			a.app.CoderAgent.ClearQueue(msg.SessionID)
+		}
+		a.app.CoderAgent.Cancel(msg.SessionID)
+		return a, nil
+
+	case showAgentSessionsMsg:
+		return a, a.openAgentSessions()
+
+	case dialog.AgentSessionSelectedMsg:
+		a.showSessionDialog = false
+		if a.transcriptParent.ID == "" {
+			a.transcriptParent = a.selectedSession
+		}
+		return a, tea.Batch(
+			util.CmdHandler(chat.SessionSelectedMsg(msg.Session)),
+			util.ReportInfo("Viewing the agent transcript, press esc to go back"),
+		)
+
+	case chat.SendMsg:
+		if a.transcriptParent.ID != "" {
+			return a, util.ReportWarn("Agent transcripts are read-only, press esc to go back")
+		}
+
+	case chat.SessionClearedMsg:
+		a.transcriptParent = session.Session{}
+
+	case dialog.UndoConfirmedMsg:
+		a.showUndoDialog = false
+		if a.app.CoderAgent.IsSessionBusy(a.selectedSession.ID) {
+			return a, util.ReportWarn("Agent is working, please wait...")
+		}
+		if err := a.app.Undo(context.Background(), a.selectedSession.ID, msg.Turn.Reverts); err != nil {
+			return a, util.ReportError(err)
+		}
+		return a, util.ReportInfo(fmt.Sprintf("Reverted %d file(s)", len(msg.Turn.Reverts)))
+
 	case startCompactSessionMsg:
 		// Start compacting the current session
 		a.isCompacting = true
@@ -322,6 +452,12 @@ func (a appModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case pubsub.Event[agent.AgentEvent]:
 		payload := msg.Payload
+		if payload.Type == agent.AgentEventTypeQueue {
+			if a.showPromptQueueDialog && payload.SessionID == a.selectedSession.ID {
+				a.refreshPromptQueue()
+			}
+			return a, util.CmdHandler(chat.QueueChangedMsg{SessionID: payload.SessionID})
+		}
 		if payload.Error != nil {
 			a.isCompacting = false
 			return a, util.ReportError(payload.Error)
@@ -329,15 +465,12 @@ func (a appModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		a.compactingMessage = payload.Progress
 
-		if payload.Done && payload.Type == agent.AgentEventTypeSummarize {
-			a.isCompacting = false
-			return a, util.ReportInfo("Session summarization complete")
-		} else if payload.Done && payload.Type == agent.AgentEventTypeResponse && a.selectedSession.ID != "" {
-			model := a.app.CoderAgent.Model()
-			contextWindow := model.ContextWindow
-			tokens := a.selectedSession.CompletionTokens + a.selectedSession.PromptTokens
-			if (tokens >= int64(float64(contextWindow)*0.95)) && config.Get().AutoCompact {
-				return a, util.CmdHandler(startCompactSessionMsg{})
+		// The agent also compacts the session on its own when the context
+		// window fills up
+		if payload.Type == agent.AgentEventTypeSummarize {
+			a.isCompacting = !payload.Done
+			if payload.Done {
+				return a, util.ReportInfo("Session summarization complete")
 			}
 		}
 		// Continue listening for events
@@ -359,7 +492,7 @@ func (a appModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case dialog.ModelSelectedMsg:
 		a.showModelDialog = false
 
-		model, err := a.app.CoderAgent.Update(config.AgentCoder, msg.Model.ID)
+		model, err := a.app.CoderAgent.Update(a.app.CoderAgent.AgentName(), msg.Model.ID)
 		if err != nil {
 			return a, util.ReportError(err)
 		}
@@ -394,6 +527,9 @@ func (a appModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case chat.SessionSelectedMsg:
 		a.selectedSession = msg
 		a.sessionDialog.SetSelectedSession(msg.ID)
+		if msg.ParentSessionID != a.transcriptParent.ID || msg.ForkMessageID != "" {
+			a.transcriptParent = session.Session{}
+		}
 
 	case pubsub.Event[session.Session]:
 		if msg.Type == pubsub.UpdatedEvent && msg.Payload.ID == a.selectedSession.ID {
@@ -406,6 +542,31 @@ func (a appModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return a, nil
 
+	case dialog.ForkSessionRequestMsg:
+		messages, err := a.app.Messages.List(context.Background(), msg.Session.ID)
+		if err != nil {
+			return a, util.ReportError(err)
+		}
+		a.sessionDialog.SetForkMessages(msg.Session, messages)
+		if len(messages) == 0 {
+			return a, util.ReportWarn("Session has no messages to fork from")
+		}
+		return a, nil
+
+	case dialog.ForkSessionMsg:
+		a.showSessionDialog = false
+		forked, err := a.app.Sessions.Fork(context.Background(), msg.Session.ID, msg.MessageID)
+		if err != nil {
+			return a, util.ReportError(err)
+		}
+		if a.currentPage == page.ChatPage {
+			return a, tea.Batch(
+				util.CmdHandler(chat.SessionSelectedMsg(forked)),
+				util.ReportInfo("Forked session: "+forked.Title),
+			)
+		}
+		return a, nil
+
 	case dialog.CommandSelectedMsg:
 		a.showCommandDialog = false
 		// Execute the command handler if available
@@ -473,6 +634,9 @@ func (a appModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if a.showMultiArgumentsDialog {
 				a.showMultiArgumentsDialog = false
 			}
+			if a.showPromptQueueDialog {
+				a.showPromptQueueDialog = false
+			}
 			return a, nil
 		case key.Matches(msg, keys.SwitchSession):
 			if a.currentPage == page.ChatPage && !a.showQuit && !a.showPermissions && !a.showCommandDialog {
@@ -506,10 +670,31 @@ func (a appModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return a, nil
 			}
 			if a.currentPage == page.ChatPage && !a.showQuit && !a.showPermissions && !a.showSessionDialog && !a.showCommandDialog {
+				a.modelDialog.SetAgent(a.app.CoderAgent.AgentName())
 				a.showModelDialog = true
 				return a, nil
 			}
 			return a, nil
+		case key.Matches(msg, keys.Undo):
+			if a.currentPage == page.ChatPage && !a.showQuit && !a.showPermissions && !a.showSessionDialog && !a.showCommandDialog {
+				return a, a.openUndoDialog()
+			}
+			return a, nil
+		case key.Matches(msg, keys.PromptQueue):
+			if a.currentPage == page.ChatPage && !a.showQuit && !a.showPermissions && !a.showSessionDialog && !a.showCommandDialog && !a.showUndoDialog {
+				prompts := a.app.CoderAgent.Queue(a.selectedSession.ID)
+				if len(prompts) == 0 {
+					return a, util.ReportWarn("No queued prompts")
+				}
+				a.promptQueueDialog.SetPrompts(prompts)
+				a.showPromptQueueDialog = true
+			}
+			return a, nil
+		case key.Matches(msg, keys.PlanMode):
+			if a.currentPage == page.ChatPage && !a.showQuit && !a.showPermissions && !a.showSessionDialog && !a.showCommandDialog {
+				return a, a.setPlanMode(!a.app.CoderAgent.PlanMode())
+			}
+			return a, nil
 		case key.Matches(msg, keys.SwitchTheme):
 			if !a.showQuit && !a.showPermissions && !a.showSessionDialog && !a.showCommandDialog {
 				// Show theme switcher dialog
@@ -548,6 +733,15 @@ func (a appModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if a.currentPage == page.LogsPage {
 					return a, a.moveToPage(page.ChatPage)
 				}
+				if a.transcriptParent.ID != "" && !a.showPermissions && !a.showSessionDialog && !a.showCommandDialog &&
+					!a.showModelDialog && !a.showThemeDialog && !a.showMultiArgumentsDialog && !a.showUndoDialog && !a.showPromptQueueDialog {
+					// Go back from the agent transcript to the session
+					parent, err := a.app.Sessions.Get(context.Background(), a.transcriptParent.ID)
+					if err != nil {
+						return a, util.ReportError(err)
+					}
+					return a, util.CmdHandler(chat.SessionSelectedMsg(parent))
+				}
 			}
 		case key.Matches(msg, keys.Logs):
 			return a, a.moveToPage(page.LogsPage)
@@ -606,6 +800,26 @@ func (a appModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 	}
 
+	if a.showUndoDialog {
+		d, undoCmd := a.undoDialog.Update(msg)
+		a.undoDialog = d.(dialog.UndoDialog)
+		cmds = append(cmds, undoCmd)
+		// Only block key messages send all other messages down
+		if _, ok := msg.(tea.KeyMsg); ok {
+			return a, tea.Batch(cmds...)
+		}
+	}
+
+	if a.showPromptQueueDialog {
+		d, promptQueueCmd := a.promptQueueDialog.Update(msg)
+		a.promptQueueDialog = d.(dialog.PromptQueueDialog)
+		cmds = append(cmds, promptQueueCmd)
+		// Only block key messages send all other messages down
+		if _, ok := msg.(tea.KeyMsg); ok {
+			return a, tea.Batch(cmds...)
+		}
+	}
+
 	if a.showSessionDialog {
 		d, sessionCmd := a.sessionDialog.Update(msg)
 		a.sessionDialog = d.(dialog.SessionDialog)
@@ -824,6 +1038,36 @@ func (a appModel) View() string {
 		)
 	}
 
+	if a.showUndoDialog {
+		overlay := a.undoDialog.View()
+		row := lipgloss.Height(appView) / 2
+		row -= lipgloss.Height(overlay) / 2
+		col := lipgloss.Width(appView) / 2
+		col -= lipgloss.Width(overlay) / 2
+		appView = layout.PlaceOverlay(
+			col,
+			row,
+			overlay,
+			appView,
+			true,
+		)
+	}
+
+	if a.showPromptQueueDialog {
+		overlay := a.promptQueueDialog.View()
+		row := lipgloss.Height(appView) / 2
+		row -= lipgloss.Height(overlay) / 2
+		col := lipgloss.Width(appView) / 2
+		col -= lipgloss.Width(overlay) / 2
+		appView = layout.PlaceOverlay(
+			col,
+			row,
+			overlay,
+			appView,
+			true,
+		)
+	}
+
 	if a.showModelDialog {
 		overlay := a.modelDialog.View()
 		row := lipgloss.Height(appView) / 2
@@ -898,22 +1142,116 @@ func (a appModel) View() string {
 	return appView
 }
 
+// switchAgent makes the coder agent act as another agent.
+func (a *appModel) switchAgent(agentName config.AgentName) tea.Cmd {
+	if a.app.CoderAgent.AgentName() == agentName {
+		return nil
+	}
+	if err := a.app.SwitchAgent(agentName); err != nil {
+		return util.ReportError(err)
+	}
+	return tea.Batch(
+		util.CmdHandler(chat.AgentSwitchedMsg(agentName)),
+		util.ReportInfo(fmt.Sprintf("Switched to the %s agent", agentName)),
+	)
+}
+
+// setPlanMode switches plan mode of the coder agent on or off.
+func (a *appModel) setPlanMode(enabled bool) tea.Cmd {
+	if a.app.CoderAgent.PlanMode() == enabled {
+		return nil
+	}
+	if err := a.app.CoderAgent.SetPlanMode(enabled); err != nil {
+		return util.ReportError(err)
+	}
+	info := util.ReportInfo("Plan mode on: the agent proposes a plan without changing anything")
+	if !enabled {
+		info = util.ReportInfo("Plan mode off: use the Execute Plan command to implement the plan")
+	}
+	return tea.Batch(util.CmdHandler(chat.PlanModeMsg(enabled)), info)
+}
+
+// openUndoDialog works out what undoing each turn of the current session
+// would change and shows the undo dialog.
+func (a *appModel) openUndoDialog() tea.Cmd {
+	if a.selectedSession.ID == "" {
+		return util.ReportWarn("No active session to undo")
+	}
+	if a.app.CoderAgent.IsSessionBusy(a.selectedSession.ID) {
+		return util.ReportWarn("Agent is working, please wait...")
+	}
+	ctx := context.Background()
+	turns, err := a.app.Turns(ctx, a.selectedSession.ID)
+	if err != nil {
+		return util.ReportError(err)
+	}
+	if len(turns) == 0 {
+		return util.ReportWarn("Nothing to undo")
+	}
+	undoTurns := make([]dialog.UndoTurn, len(turns))
+	for i, turn := range turns {
+		reverts, err := a.app.PlanUndo(ctx, a.selectedSession.ID, turn)
+		if err != nil {
+			return util.ReportError(err)
+		}
+		undoTurns[i] = dialog.UndoTurn{Turn: turn, Reverts: reverts}
+	}
+	a.undoDialog.SetTurns(undoTurns)
+	a.showUndoDialog = true
+	return nil
+}
+
+// refreshPromptQueue shows the current queue of the session in the prompt
+// queue dialog, and closes it once the queue is empty.
+func (a *appModel) refreshPromptQueue() {
+	prompts := a.app.CoderAgent.Queue(a.selectedSession.ID)
+	if len(prompts) == 0 {
+		a.showPromptQueueDialog = false
+		return
+	}
+	a.promptQueueDialog.SetPrompts(prompts)
+}
+
+// openAgentSessions lists the agents launched in the current session, or in
+// the session the shown transcript belongs to, to pick a transcript to view.
+func (a *appModel) openAgentSessions() tea.Cmd {
+	sessionID := a.selectedSession.ID
+	if a.transcriptParent.ID != "" {
+		sessionID = a.transcriptParent.ID
+	}
+	if sessionID == "" {
+		return util.ReportWarn("No active session")
+	}
+	agentSessions, err := a.app.AgentSessions(context.Background(), sessionID)
+	if err != nil {
+		return util.ReportError(err)
+	}
+	if len(agentSessions) == 0 {
+		return util.ReportWarn("No agents were launched in this session")
+	}
+	a.sessionDialog.SetAgentSessions(agentSessions)
+	a.showSessionDialog = true
+	return nil
+}
+
 func New(app *app.App) tea.Model {
 	startPage := page.ChatPage
 	model := &appModel{
-		currentPage:   startPage,
-		loadedPages:   make(map[page.PageID]bool),
-		status:        core.NewStatusCmp(app.LSPClients),
-		help:          dialog.NewHelpCmp(),
-		quit:          dialog.NewQuitCmp(),
-		sessionDialog: dialog.NewSessionDialogCmp(),
-		commandDialog: dialog.NewCommandDialogCmp(),
-		modelDialog:   dialog.NewModelDialogCmp(),
-		permissions:   dialog.NewPermissionDialogCmp(),
-		initDialog:    dialog.NewInitDialogCmp(),
-		themeDialog:   dialog.NewThemeDialogCmp(),
-		app:           app,
-		commands:      []dialog.Command{},
+		currentPage:       startPage,
+		loadedPages:       make(map[page.PageID]bool),
+		status:            core.NewStatusCmp(app.LSPClients),
+		help:              dialog.NewHelpCmp(),
+		quit:              dialog.NewQuitCmp(),
+		sessionDialog:     dialog.NewSessionDialogCmp(),
+		commandDialog:     dialog.NewCommandDialogCmp(),
+		modelDialog:       dialog.NewModelDialogCmp(),
+		permissions:       dialog.NewPermissionDialogCmp(),
+		initDialog:        dialog.NewInitDialogCmp(),
+		themeDialog:       dialog.NewThemeDialogCmp(),
+		undoDialog:        dialog.NewUndoDialogCmp(),
+		promptQueueDialog: dialog.NewPromptQueueDialogCmp(),
+		app:               app,
+		commands:          []dialog.Command{},
 		pages: map[page.PageID]tea.Model{
 			page.ChatPage: page.NewChatPage(app),
 			page.LogsPage: page.NewLogsPage(),
@@ -951,6 +1289,56 @@ If there are Cursor rules (in .cursor/rules/ or .cursorrules) or Copilot rules (
 			}
 		},
 	})
+	model.RegisterCommand(dialog.Command{
+		ID:          "undo",
+		Title:       "Undo Turn",
+		Description: "Restore the files changed during an agent turn",
+		Handler: func(cmd dialog.Command) tea.Cmd {
+			return func() tea.Msg {
+				return showUndoDialogMsg{}
+			}
+		},
+	})
+	model.RegisterCommand(dialog.Command{
+		ID:          "agent-sessions",
+		Title:       "View Agent Sessions",
+		Description: "Open the transcript of an agent launched in the current session",
+		Handler: func(cmd dialog.Command) tea.Cmd {
+			return util.CmdHandler(showAgentSessionsMsg{})
+		},
+	})
+	model.RegisterCommand(dialog.Command{
+		ID:          "plan",
+		Title:       "Toggle Plan Mode",
+		Description: "Only investigate and propose a plan, without changing anything",
+		Handler: func(cmd dialog.Command) tea.Cmd {
+			return util.CmdHandler(togglePlanModeMsg{})
+		},
+	})
+	model.RegisterCommand(dialog.Command{
+		ID:          "execute-plan",
+		Title:       "Execute Plan",
+		Description: "Leave plan mode and implement the plan in the current session",
+		Handler: func(cmd dialog.Command) tea.Cmd {
+			return util.CmdHandler(executePlanMsg{})
+		},
+	})
+	if customAgents := config.CustomAgents(); len(customAgents) > 0 {
+		for _, agentName := range append([]config.AgentName{config.AgentCoder}, customAgents...) {
+			description := config.Get().Agents[agentName].Description
+			if agentName == config.AgentCoder {
+				description = "The default agent, with all tools"
+			}
+			model.RegisterCommand(dialog.Command{
+				ID:          "agent:" + string(agentName),
+				Title:       fmt.Sprintf("Switch to %s Agent", agentName),
+				Description: description,
+				Handler: func(cmd dialog.Command) tea.Cmd {
+					return model.switchAgent(agentName)
+				},
+			})
+		}
+	}
 	// Load custom commands
 	customCommands, err := dialog.LoadCustomCommands()
 	if err != nil {
@@ -0,0 +1,96 @@
+package usage
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// GroupBy is what usage is grouped by in a report.
+type GroupBy string
+
+const (
+	GroupByDay      GroupBy = "day"
+	GroupBySession  GroupBy = "session"
+	GroupByModel    GroupBy = "model"
+	GroupByProvider GroupBy = "provider"
+	GroupByAgent    GroupBy = "agent"
+)
+
+// GroupByValues lists the supported groupings.
+var GroupByValues = []GroupBy{GroupByDay, GroupBySession, GroupByModel, GroupByProvider, GroupByAgent}
+
+// Summary adds up the calls that share the same key.
+type Summary struct {
+	Key                 string  `json:"key"`
+	Calls               int     `json:"calls"`
+	InputTokens         int64   `json:"input_tokens"`
+	OutputTokens        int64   `json:"output_tokens"`
+	CacheCreationTokens int64   `json:"cache_creation_tokens"`
+	CacheReadTokens     int64   `json:"cache_read_tokens"`
+	Cost                float64 `json:"cost"`
+}
+
+func (s *Summary) add(u Usage) {
+	s.Calls++
+	s.InputTokens += u.InputTokens
+	s.OutputTokens += u.OutputTokens
+	s.CacheCreationTokens += u.CacheCreationTokens
+	s.CacheReadTokens += u.CacheReadTokens
+	s.Cost += u.Cost
+}
+
+// Summarize groups usages by groupBy. Days are in local time and sorted in
+// chronological order, every other grouping is sorted by cost, highest first.
+func Summarize(usages []Usage, groupBy GroupBy) ([]Summary, error) {
+	var key func(Usage) string
+	switch groupBy {
+	case GroupByDay:
+		key = func(u Usage) string { return time.Unix(u.CreatedAt, 0).Format(time.DateOnly) }
+	case GroupBySession:
+		key = func(u Usage) string { return u.SessionID }
+	case GroupByModel:
+		key = func(u Usage) string { return string(u.Model) }
+	case GroupByProvider:
+		key = func(u Usage) string { return string(u.Provider) }
+	case GroupByAgent:
+		key = func(u Usage) string { return string(u.Agent) }
+	default:
+		return nil, fmt.Errorf("unknown grouping %q", groupBy)
+	}
+
+	var summaries []Summary
+	index := make(map[string]int)
+	for _, u := range usages {
+		k := key(u)
+		i, ok := index[k]
+		if !ok {
+			i = len(summaries)
+			index[k] = i
+			summaries = append(summaries, Summary{Key: k})
+		}
+		summaries[i].add(u)
+	}
+
+	if groupBy == GroupByDay {
+		slices.SortFunc(summaries, func(a, b Summary) int { return cmp.Compare(a.Key, b.Key) })
+	} else {
+		slices.SortStableFunc(summaries, func(a, b Summary) int { return cmp.Compare(b.Cost, a.Cost) })
+	}
+	return summaries, nil
+}
+
+// Total adds up all the summaries.
+func Total(summaries []Summary) Summary {
+	total := Summary{Key: "total"}
+	for _, s := range summaries {
+		total.Calls += s.Calls
+		total.InputTokens += s.InputTokens
+		total.OutputTokens += s.OutputTokens
+		total.CacheCreationTokens += s.CacheCreationTokens
+		total.CacheReadTokens += s.CacheReadTokens
+		total.Cost += s.Cost
+	}
+	return total
+}
@@ -0,0 +1,58 @@
+package usage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarize(t *testing.T) {
+	t.Parallel()
+
+	day1 := time.Date(2025, 6, 1, 12, 0, 0, 0, time.Local).Unix()
+	day2 := time.Date(2025, 6, 2, 12, 0, 0, 0, time.Local).Unix()
+	usages := []Usage{
+		{SessionID: "a", Agent: config.AgentCoder, InputTokens: 100, OutputTokens: 10, Cost: 1, CreatedAt: day2},
+		{SessionID: "a", Agent: config.AgentTask, InputTokens: 50, CacheReadTokens: 20, Cost: 2, CreatedAt: day1},
+		{SessionID: "b", Agent: config.AgentCoder, CacheCreationTokens: 5, Cost: 0.5, CreatedAt: day1},
+	}
+
+	t.Run("by day", func(t *testing.T) {
+		t.Parallel()
+		summaries, err := Summarize(usages, GroupByDay)
+		require.NoError(t, err)
+		require.Len(t, summaries, 2)
+		assert.Equal(t, "2025-06-01", summaries[0].Key)
+		assert.Equal(t, 2, summaries[0].Calls)
+		assert.Equal(t, 2.5, summaries[0].Cost)
+		assert.Equal(t, "2025-06-02", summaries[1].Key)
+	})
+
+	t.Run("by agent sorted by cost", func(t *testing.T) {
+		t.Parallel()
+		summaries, err := Summarize(usages, GroupByAgent)
+		require.NoError(t, err)
+		require.Len(t, summaries, 2)
+		assert.Equal(t, Summary{Key: "task", Calls: 1, InputTokens: 50, CacheReadTokens: 20, Cost: 2}, summaries[0])
+		assert.Equal(t, Summary{Key: "coder", Calls: 2, InputTokens: 100, OutputTokens: 10, CacheCreationTokens: 5, Cost: 1.5}, summaries[1])
+	})
+
+	t.Run("total", func(t *testing.T) {
+		t.Parallel()
+		summaries, err := Summarize(usages, GroupBySession)
+		require.NoError(t, err)
+		total := Total(summaries)
+		assert.Equal(t, 3, total.Calls)
+		assert.Equal(t, int64(150), total.InputTokens)
+		assert.Equal(t, 3.5, total.Cost)
+	})
+
+	t.Run("unknown grouping", func(t *testing.T) {
+		t.Parallel()
+		_, err := Summarize(usages, GroupBy("week"))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,133 @@
+package usage
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/db"
+	"github.com/opencode-ai/opencode/internal/llm/models"
+)
+
+// Usage is what a single provider call consumed.
+type Usage struct {
+	ID        string
+	SessionID string
+	// MessageID is the message the call produced, if any.
+	MessageID           string
+	Model               models.ModelID
+	Provider            models.ModelProvider
+	Agent               config.AgentName
+	InputTokens         int64
+	OutputTokens        int64
+	CacheCreationTokens int64
+	CacheReadTokens     int64
+	Cost                float64
+	CreatedAt           int64
+}
+
+type CreateUsageParams struct {
+	SessionID           string
+	MessageID           string
+	Model               models.Model
+	Agent               config.AgentName
+	InputTokens         int64
+	OutputTokens        int64
+	CacheCreationTokens int64
+	CacheReadTokens     int64
+	Cost                float64
+}
+
+type Service interface {
+	Create(ctx context.Context, params CreateUsageParams) (Usage, error)
+	ListBySession(ctx context.Context, sessionID string) ([]Usage, error)
+	List(ctx context.Context, from, to time.Time) ([]Usage, error)
+}
+
+type service struct {
+	q db.Querier
+}
+
+func NewService(q db.Querier) Service {
+	return &service{
+		q: q,
+	}
+}
+
+// Create records a provider call. The totals of the session, and of its
+// parent for sub-agent sessions, are updated along with it.
+func (s *service) Create(ctx context.Context, params CreateUsageParams) (Usage, error) {
+	dbUsage, err := s.q.CreateUsage(ctx, db.CreateUsageParams{
+		ID:        uuid.New().String(),
+		SessionID: params.SessionID,
+		MessageID: sql.NullString{
+			String: params.MessageID,
+			Valid:  params.MessageID != "",
+		},
+		Model:               string(params.Model.ID),
+		Provider:            string(params.Model.Provider),
+		Agent:               string(params.Agent),
+		InputTokens:         params.InputTokens,
+		OutputTokens:        params.OutputTokens,
+		CacheCreationTokens: params.CacheCreationTokens,
+		CacheReadTokens:     params.CacheReadTokens,
+		Cost:                params.Cost,
+	})
+	if err != nil {
+		return Usage{}, err
+	}
+	return s.fromDBItem(dbUsage), nil
+}
+
+func (s *service) ListBySession(ctx context.Context, sessionID string) ([]Usage, error) {
+	dbUsages, err := s.q.ListUsageBySession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	usages := make([]Usage, len(dbUsages))
+	for i, dbUsage := range dbUsages {
+		usages[i] = s.fromDBItem(dbUsage)
+	}
+	return usages, nil
+}
+
+// List returns the calls made from from up to, but not including, to. A zero
+// to means no upper bound.
+func (s *service) List(ctx context.Context, from, to time.Time) ([]Usage, error) {
+	toTime := int64(math.MaxInt64)
+	if !to.IsZero() {
+		toTime = to.Unix()
+	}
+	dbUsages, err := s.q.ListUsage(ctx, db.ListUsageParams{
+		FromTime: from.Unix(),
+		ToTime:   toTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	usages := make([]Usage, len(dbUsages))
+	for i, dbUsage := range dbUsages {
+		usages[i] = s.fromDBItem(dbUsage)
+	}
+	return usages, nil
+}
+
+func (s *service) fromDBItem(item db.Usage) Usage {
+	return Usage{
+		ID:                  item.ID,
+		SessionID:           item.SessionID,
+		MessageID:           item.MessageID.String,
+		Model:               models.ModelID(item.Model),
+		Provider:            models.ModelProvider(item.Provider),
+		Agent:               config.AgentName(item.Agent),
+		InputTokens:         item.InputTokens,
+		OutputTokens:        item.OutputTokens,
+		CacheCreationTokens: item.CacheCreationTokens,
+		CacheReadTokens:     item.CacheReadTokens,
+		Cost:                item.Cost,
+		CreatedAt:           item.CreatedAt,
+	}
+}
@@ -0,0 +1,87 @@
+package usage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/opencode-ai/opencode/internal/config"
+	"github.com/opencode-ai/opencode/internal/db"
+	"github.com/opencode-ai/opencode/internal/llm/models"
+	"github.com/opencode-ai/opencode/internal/message"
+	"github.com/opencode-ai/opencode/internal/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceUpdatesSessionTotals(t *testing.T) {
+	conn, err := db.ConnectDir(t.TempDir())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx := context.Background()
+	q := db.New(conn)
+	sessions := session.NewService(q, conn)
+	messages := message.NewService(q)
+	usages := NewService(q)
+
+	parent, err := sessions.Create(ctx, "parent")
+	require.NoError(t, err)
+	task, err := sessions.CreateTaskSession(ctx, "call_1", parent.ID, config.AgentTask, "task")
+	require.NoError(t, err)
+	prompt, err := messages.Create(ctx, parent.ID, message.CreateMessageParams{Role: message.User, Parts: []message.ContentPart{message.TextContent{Text: "hi"}}})
+	require.NoError(t, err)
+	fork, err := sessions.Fork(ctx, parent.ID, prompt.ID)
+	require.NoError(t, err)
+
+	model := models.Model{ID: "claude-3.7-sonnet", Provider: models.ProviderAnthropic}
+	record := func(sessionID string, agent config.AgentName, input, output, cacheCreation, cacheRead int64, cost float64) {
+		_, err := usages.Create(ctx, CreateUsageParams{
+			SessionID:           sessionID,
+			MessageID:           prompt.ID,
+			Model:               model,
+			Agent:               agent,
+			InputTokens:         input,
+			OutputTokens:        output,
+			CacheCreationTokens: cacheCreation,
+			CacheReadTokens:     cacheRead,
+			Cost:                cost,
+		})
+		require.NoError(t, err)
+	}
+	record(parent.ID, config.AgentCoder, 100, 10, 20, 30, 0.5)
+	record(parent.ID, config.AgentCoder, 200, 20, 0, 0, 0.25)
+	// Sub-agent calls add to their parent too, fork calls do not
+	record(task.ID, config.AgentTask, 50, 5, 0, 0, 0.125)
+	record(fork.ID, config.AgentCoder, 1000, 100, 0, 0, 4)
+
+	type totals struct {
+		prompt, completion int64
+		cost               float64
+	}
+	totalsOf := func(id string) totals {
+		s, err := sessions.Get(ctx, id)
+		require.NoError(t, err)
+		return totals{s.PromptTokens, s.CompletionTokens, s.Cost}
+	}
+	assert.Equal(t, totals{100 + 20 + 30 + 200 + 50, 10 + 20 + 5, 0.875}, totalsOf(parent.ID))
+	assert.Equal(t, totals{50, 5, 0.125}, totalsOf(task.ID))
+	assert.Equal(t, totals{1000, 100, 4}, totalsOf(fork.ID))
+
+	listed, err := usages.ListBySession(ctx, parent.ID)
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+	assert.Equal(t, model.ID, listed[0].Model)
+	assert.Equal(t, models.ProviderAnthropic, listed[0].Provider)
+	assert.Equal(t, config.AgentCoder, listed[0].Agent)
+	assert.Equal(t, prompt.ID, listed[0].MessageID)
+
+	// Rows outlive their session
+	require.NoError(t, sessions.Delete(ctx, task.ID))
+	all, err := usages.List(ctx, time.Now().Add(-time.Hour), time.Time{})
+	require.NoError(t, err)
+	assert.Len(t, all, 4)
+	none, err := usages.List(ctx, time.Now().Add(-time.Hour), time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, none)
+}